@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/franela/goreq"
+	"github.com/gin-gonic/gin"
+	"log"
+	"sync"
+	"time"
+)
+
+var errNoTokenFilter = errors.New("Select the tokens by app, agent or task.")
+
+// An issuedToken records the accessor of a token gatekeeper issued, along with
+// the task it was issued to, so that the token can be revoked later.
+type issuedToken struct {
+	Accessor  string    `json:"accessor"`
+	TaskId    string    `json:"task_id"`
+	TaskName  string    `json:"task_name"`
+	AppId     string    `json:"app_id"`
+	AgentId   string    `json:"agent_id,omitempty"`
+	Vault     string    `json:"vault,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Issued    time.Time `json:"issued"`
+}
+
+// A tokenFilter selects issued tokens by the app, agent or task they were
+// issued to. Every field that is set must match.
+type tokenFilter struct {
+	AppId   string
+	AgentId string
+	TaskId  string
+}
+
+func (f tokenFilter) empty() bool {
+	return f.AppId == "" && f.AgentId == "" && f.TaskId == ""
+}
+
+func (f tokenFilter) matches(t issuedToken) bool {
+	return (f.AppId == "" || f.AppId == t.AppId) &&
+		(f.AgentId == "" || f.AgentId == t.AgentId) &&
+		(f.TaskId == "" || f.TaskId == t.TaskId)
+}
+
+// The tokens issued within ACCESSOR_RETENTION, by accessor. They are also
+// written to the state store, if there is one, so that they can still be
+// revoked after a restart.
+var issuedTokens = struct {
+	sync.RWMutex
+	m map[string]issuedToken
+}{m: make(map[string]issuedToken)}
+
+func recordIssuedToken(t issuedToken) {
+	issuedTokens.Lock()
+	issuedTokens.m[t.Accessor] = t
+	issuedTokens.Unlock()
+	if store != nil {
+		if err := store.SaveIssuedToken(t); err != nil {
+			log.Printf("Failed to persist the accessor of the token of %s: %v", t.TaskId, err)
+		}
+	}
+}
+
+func forgetIssuedTokens(accessors []string) {
+	issuedTokens.Lock()
+	for _, accessor := range accessors {
+		delete(issuedTokens.m, accessor)
+	}
+	issuedTokens.Unlock()
+	if store != nil {
+		if err := store.DeleteIssuedTokens(accessors); err != nil {
+			log.Printf("Failed to delete revoked accessors from the state store: %v", err)
+		}
+	}
+}
+
+// restoreIssuedTokens loads the accessors persisted by a previous run of
+// gatekeeper.
+func restoreIssuedTokens(s stateStore) (int, error) {
+	tokens, err := s.LoadIssuedTokens(time.Now().Add(-config.AccessorRetention))
+	if err != nil {
+		return 0, err
+	}
+	issuedTokens.Lock()
+	for _, t := range tokens {
+		issuedTokens.m[t.Accessor] = t
+	}
+	issuedTokens.Unlock()
+	return len(tokens), nil
+}
+
+// findIssuedTokens returns the tokens issued within ACCESSOR_RETENTION that
+// match the filter, and forgets the older ones.
+func findIssuedTokens(filter tokenFilter) []issuedToken {
+	cutoff := time.Now().Add(-config.AccessorRetention)
+	var found []issuedToken
+	issuedTokens.Lock()
+	for accessor, t := range issuedTokens.m {
+		if t.Issued.Before(cutoff) {
+			delete(issuedTokens.m, accessor)
+		} else if filter.matches(t) {
+			found = append(found, t)
+		}
+	}
+	issuedTokens.Unlock()
+	return found
+}
+
+// A failedRevocation is a token that could not be revoked.
+type failedRevocation struct {
+	issuedToken
+	Error string `json:"error"`
+}
+
+// revokeIssuedTokens revokes the tokens that match the filter with revoke,
+// and forgets them. Tokens vault no longer knows of have expired or were
+// revoked already, and are forgotten as well.
+func revokeIssuedTokens(filter tokenFilter, revoke func(issuedToken) error) ([]issuedToken, []failedRevocation) {
+	revoked := []issuedToken{}
+	failed := []failedRevocation{}
+	var forget []string
+	for _, t := range findIssuedTokens(filter) {
+		err := revoke(t)
+		if e, ok := err.(vaultError); ok && e.Code == 400 {
+			// vault rejects accessors of tokens that no longer exist
+			forget = append(forget, t.Accessor)
+			continue
+		}
+		if err != nil {
+			failed = append(failed, failedRevocation{t, err.Error()})
+			continue
+		}
+		revoked = append(revoked, t)
+		forget = append(forget, t.Accessor)
+	}
+	if len(forget) > 0 {
+		forgetIssuedTokens(forget)
+	}
+	return revoked, failed
+}
+
+// revokeAccessor revokes the token with the given accessor, on the vault
+// backend it was created with.
+func revokeAccessor(t issuedToken) error {
+	state.RLock()
+	token := state.Token
+	state.RUnlock()
+	pol := &policy{Vault: t.Vault, Namespace: t.Namespace}
+	_, err := pol.withVault(token, func(backend *vaultBackend, token string, namespace string) (string, error) {
+		r, err := VaultRequest{
+			Request: goreq.Request{
+				Uri:             backend.path("/v1/auth/token/revoke-accessor", ""),
+				Method:          "POST",
+				Body:            map[string]string{"accessor": t.Accessor},
+				ContentType:     "application/json",
+				MaxRedirects:    10,
+				RedirectHeaders: true,
+			}.WithHeader("X-Vault-Token", token),
+			Namespace: namespace,
+		}.Do()
+		if err != nil {
+			return "", err
+		}
+		defer r.Body.Close()
+		switch r.StatusCode {
+		case 200, 204:
+			return "", nil
+		default:
+			var e vaultError
+			e.Code = r.StatusCode
+			if err := r.Body.FromJsonTo(&e); err != nil {
+				e.Errors = []string{"communication error."}
+			}
+			return "", e
+		}
+	})
+	return err
+}
+
+func tokenFilterQuery(c *gin.Context) tokenFilter {
+	return tokenFilter{AppId: c.Query("app"), AgentId: c.Query("agent"), TaskId: c.Query("task")}
+}
+
+// ListTokens lists the tokens issued to the tasks of an app, on an agent, or
+// to a task.
+func ListTokens(c *gin.Context) {
+	filter := tokenFilterQuery(c)
+	if filter.empty() {
+		c.JSON(400, struct {
+			Status string `json:"status"`
+			Ok     bool   `json:"ok"`
+			Error  string `json:"error"`
+		}{string(state.Status), false, errNoTokenFilter.Error()})
+		return
+	}
+	tokens := findIssuedTokens(filter)
+	if tokens == nil {
+		tokens = []issuedToken{}
+	}
+	c.JSON(200, struct {
+		Status string        `json:"status"`
+		Ok     bool          `json:"ok"`
+		Tokens []issuedToken `json:"tokens"`
+	}{string(state.Status), true, tokens})
+}
+
+// RevokeTokens revokes the tokens issued to the tasks of an app, on an agent,
+// or to a task, for example when an agent is compromised.
+func RevokeTokens(c *gin.Context) {
+	filter := tokenFilterQuery(c)
+	if filter.empty() {
+		c.JSON(400, struct {
+			Status string `json:"status"`
+			Ok     bool   `json:"ok"`
+			Error  string `json:"error"`
+		}{string(state.Status), false, errNoTokenFilter.Error()})
+		return
+	}
+	revoked, failed := revokeIssuedTokens(filter, revokeAccessor)
+	log.Printf("Revoked %d tokens (app: '%s', agent: '%s', task: '%s') on request of %s, %d failed.", len(revoked), filter.AppId, filter.AgentId, filter.TaskId, c.Request.RemoteAddr, len(failed))
+	resp := struct {
+		Status  string             `json:"status"`
+		Ok      bool               `json:"ok"`
+		Error   string             `json:"error,omitempty"`
+		Revoked []issuedToken      `json:"revoked"`
+		Failed  []failedRevocation `json:"failed"`
+	}{Status: string(state.Status), Ok: len(failed) == 0, Revoked: revoked, Failed: failed}
+	code := 200
+	if len(failed) > 0 {
+		code = 500
+		resp.Error = fmt.Sprintf("Failed to revoke %d of the tokens.", len(failed))
+	}
+	c.JSON(code, resp)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func resetIssuedTokens() {
+	issuedTokens.Lock()
+	issuedTokens.m = make(map[string]issuedToken)
+	issuedTokens.Unlock()
+}
+
+func TestRevokeIssuedTokens(t *testing.T) {
+	defer resetIssuedTokens()
+	defer func(prev stateStore) { store = prev }(store)
+	store = nil
+	now := time.Now()
+	for _, token := range []issuedToken{
+		{Accessor: "a1", TaskId: "frontend.web.1", TaskName: "frontend.web", AppId: "/web/frontend", AgentId: "agent-1", Issued: now},
+		{Accessor: "a2", TaskId: "frontend.web.2", TaskName: "frontend.web", AppId: "/web/frontend", AgentId: "agent-2", Issued: now},
+		{Accessor: "a3", TaskId: "db.1", TaskName: "db", AppId: "/db", AgentId: "agent-1", Issued: now},
+		{Accessor: "a4", TaskId: "cache.1", TaskName: "cache", AppId: "/cache", AgentId: "agent-1", Issued: now},
+		{Accessor: "a5", TaskId: "queue.1", TaskName: "queue", AppId: "/queue", AgentId: "agent-1", Issued: now},
+		{Accessor: "old", TaskId: "old.1", TaskName: "old", AppId: "/old", AgentId: "agent-1", Issued: now.Add(-config.AccessorRetention - time.Minute)},
+	} {
+		recordIssuedToken(token)
+	}
+
+	if found := findIssuedTokens(tokenFilter{AppId: "/web/frontend"}); len(found) != 2 {
+		t.Errorf("Expected 2 tokens of the app, got %d.", len(found))
+	}
+	if found := findIssuedTokens(tokenFilter{AppId: "/web/frontend", AgentId: "agent-2"}); len(found) != 1 || found[0].Accessor != "a2" {
+		t.Errorf("Expected only the token of the app on the agent, got %v.", found)
+	}
+
+	revoked, failed := revokeIssuedTokens(tokenFilter{AgentId: "agent-1"}, func(token issuedToken) error {
+		switch token.Accessor {
+		case "a4":
+			return vaultError{Code: 400, Errors: []string{"invalid accessor"}}
+		case "a5":
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if len(revoked) != 2 {
+		t.Errorf("Expected the 2 live tokens on the agent to be revoked, got %v.", revoked)
+	}
+	if len(failed) != 1 || failed[0].Accessor != "a5" || failed[0].Error != "connection refused" {
+		t.Errorf("Expected the revocation of a5 to fail, got %v.", failed)
+	}
+	remaining := findIssuedTokens(tokenFilter{AgentId: "agent-1"})
+	if len(remaining) != 1 || remaining[0].Accessor != "a5" {
+		t.Errorf("Expected only the token that failed to be revoked to be kept, got %v.", remaining)
+	}
+}
+
+func TestIssuedTokensStateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := openBoltStore(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	defer resetIssuedTokens()
+	defer func(prev stateStore) { store = prev }(store)
+	store = s
+	now := time.Now()
+	recordIssuedToken(issuedToken{Accessor: "a1", TaskId: "web.1", AppId: "/web", AgentId: "agent-1", Issued: now})
+	recordIssuedToken(issuedToken{Accessor: "a2", TaskId: "web.2", AppId: "/web", AgentId: "agent-1", Issued: now})
+	recordIssuedToken(issuedToken{Accessor: "old", TaskId: "web.0", AppId: "/web", AgentId: "agent-1", Issued: now.Add(-config.AccessorRetention - time.Minute)})
+	forgetIssuedTokens([]string{"a2"})
+	resetIssuedTokens()
+
+	if n, err := restoreIssuedTokens(s); err != nil || n != 1 {
+		t.Fatalf("Expected 1 restored accessor, got %d (%v).", n, err)
+	}
+	if found := findIssuedTokens(tokenFilter{AppId: "/web"}); len(found) != 1 || found[0].TaskId != "web.1" || !found[0].Issued.Equal(now) {
+		t.Errorf("Expected the token of web.1 to be restored, got %v.", found)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errAdminDisabled = errors.New("Admin API is disabled. Set ADMIN_TOKEN or ADMIN_CLIENT_NAMES to enable it.")
+var errAdminUnauthorized = errors.New("Invalid or missing admin token.")
+
+// adminToken extracts the admin token from either the X-Gatekeeper-Token header,
+// a bearer Authorization header or, for the forms of the status page, the
+// admin_token form value.
+func adminToken(c *gin.Context) string {
+	if token := c.Request.Header.Get("X-Gatekeeper-Token"); token != "" {
+		return token
+	}
+	if auth := c.Request.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return c.Request.PostFormValue("admin_token")
+	}
+	return ""
+}
+
+func adminEnabled() bool {
+	return config.AdminToken != "" || config.AdminClientNames != ""
+}
+
+// Whether the request was made with a verified client certificate whose common
+// name or one of its DNS names is listed in ADMIN_CLIENT_NAMES.
+func adminClientCertificate(c *gin.Context) bool {
+	if config.AdminClientNames == "" || c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+		return false
+	}
+	cert := c.Request.TLS.VerifiedChains[0][0]
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, allowed := range strings.Split(config.AdminClientNames, ",") {
+		allowed = strings.TrimSpace(allowed)
+		for _, name := range names {
+			if allowed != "" && name == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AdminAuth guards the admin API. Requests must present the shared secret
+// configured with ADMIN_TOKEN, or a client certificate for one of the names in
+// ADMIN_CLIENT_NAMES, otherwise they are rejected.
+func AdminAuth(c *gin.Context) {
+	if !adminEnabled() {
+		c.JSON(403, struct {
+			Status string `json:"status"`
+			Ok     bool   `json:"ok"`
+			Error  string `json:"error"`
+		}{string(state.Status), false, errAdminDisabled.Error()})
+		c.Abort()
+		return
+	}
+	if adminClientCertificate(c) {
+		c.Next()
+		return
+	}
+	if config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(adminToken(c)), []byte(config.AdminToken)) != 1 {
+		log.Printf("Rejected admin request to %s from %s. Reason: %v", c.Request.URL.Path, c.Request.RemoteAddr, errAdminUnauthorized)
+		c.JSON(401, struct {
+			Status string `json:"status"`
+			Ok     bool   `json:"ok"`
+			Error  string `json:"error"`
+		}{string(state.Status), false, errAdminUnauthorized.Error()})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// SealAuth guards sealing and unsealing. Once the admin API is enabled they
+// require admin authentication, otherwise they are left open as they always
+// have been.
+func SealAuth(c *gin.Context) {
+	if !adminEnabled() {
+		c.Next()
+		return
+	}
+	AdminAuth(c)
+}
+
+// AdminStatus reports the seal state, number of loaded policies and uptime of
+// gatekeeper, along with whether it can reach vault and the mesos master.
+func AdminStatus(c *gin.Context) {
+	state.RLock()
+	status := state.Status
+	started := state.Started
+	state.RUnlock()
+	numPolicies := len(activePolicies.Get())
+
+	var checks struct {
+		Vault healthCheck `json:"vault"`
+		Mesos healthCheck `json:"mesos"`
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		checks.Vault = newHealthCheck(checkVaultHealth())
+	}()
+	go func() {
+		defer wg.Done()
+		checks.Mesos = newHealthCheck(checkMesosMaster())
+	}()
+	wg.Wait()
+
+	c.JSON(200, struct {
+		Status   string      `json:"status"`
+		Ok       bool        `json:"ok"`
+		Policies int         `json:"policies"`
+		Started  time.Time   `json:"started"`
+		Uptime   string      `json:"uptime"`
+		Version  string      `json:"version"`
+		Checks   interface{} `json:"checks"`
+	}{string(status), true, numPolicies, started, time.Now().Sub(started).String(), gitNearestTag, checks})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSealAuth(t *testing.T) {
+	defer func(token, names string) {
+		config.AdminToken = token
+		config.AdminClientNames = names
+	}(config.AdminToken, config.AdminClientNames)
+
+	r := gin.New()
+	r.POST("/seal", SealAuth, func(c *gin.Context) { c.String(200, "sealed") })
+	seal := func(req *http.Request) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	clientCert := func(names ...string) *tls.ConnectionState {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: names[0]}, DNSNames: names[1:]}
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+
+	config.AdminToken = ""
+	config.AdminClientNames = ""
+	if code := seal(httptest.NewRequest("POST", "/seal", nil)); code != 200 {
+		t.Errorf("Expected sealing to be open while the admin API is disabled, got status code %d.", code)
+	}
+
+	config.AdminToken = "secret"
+	config.AdminClientNames = "ops, deploy.example.com"
+	if code := seal(httptest.NewRequest("POST", "/seal", nil)); code != 401 {
+		t.Errorf("Expected unauthenticated request to be rejected, got status code %d.", code)
+	}
+
+	req := httptest.NewRequest("POST", "/seal", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	if code := seal(req); code != 200 {
+		t.Errorf("Expected request with the admin token to be allowed, got status code %d.", code)
+	}
+
+	req = httptest.NewRequest("POST", "/seal", strings.NewReader(url.Values{"admin_token": {"secret"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if code := seal(req); code != 200 {
+		t.Errorf("Expected form with the admin token to be allowed, got status code %d.", code)
+	}
+
+	for names, expected := range map[string]int{"ops": 200, "web|deploy.example.com": 200, "web": 401} {
+		req = httptest.NewRequest("POST", "/seal", nil)
+		req.TLS = clientCert(strings.Split(names, "|")...)
+		if code := seal(req); code != expected {
+			t.Errorf("Expected client certificate for '%s' to get status code %d, got %d.", names, expected, code)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"time"
+)
+
+var errNotAttested = errors.New("None of the attestors could verify the identity of the task.")
+
+// An attestationRequest is what a task presents to prove its identity when
+// requesting a token.
+type attestationRequest struct {
+	TaskId     string
+	RemoteAddr string
+	// nil unless the request was made over TLS
+	TLS *tls.ConnectionState
+	// The context of the request, which spans are started in. May be nil.
+	Context context.Context
+	// The signature of the agent of the task, if the request was signed.
+	Signature requestSignature
+	// The tenant the request was made to, nil outside of a tenant.
+	Tenant *tenant
+}
+
+func (r attestationRequest) context() context.Context {
+	if r.Context == nil {
+		return context.Background()
+	}
+	return r.Context
+}
+
+// An Attestor establishes the identity of the task making a token request.
+// VerifyTask returns the metadata of the task, of which at least the Id and the
+// Name (which policies are matched by) must be set. It returns errNotAttested if
+// it cannot vouch for the request either way, so that the next attestor is
+// tried.
+type Attestor interface {
+	VerifyTask(request attestationRequest) (mesosTask, error)
+	Name() string
+}
+
+// The attestors configured with ATTESTORS, in the order they are tried.
+var attestors = []Attestor{mesosAttestor{}}
+
+// The attestor of the given name.
+func configuredAttestor(name string) (Attestor, error) {
+	switch name {
+	case "mesos":
+		return mesosAttestor{}, nil
+	case "spiffe":
+		if config.TlsClientAuth == "none" {
+			return nil, errors.New("The spiffe attestor requires TLS_CLIENT_AUTH to be 'verify' or 'require'.")
+		}
+		return newSpiffeAttestor(config.SpiffeTrustDomain, config.SpiffeTaskName)
+	default:
+		return nil, fmt.Errorf("Unknown attestor '%s'.", name)
+	}
+}
+
+// Builds the attestors from a comma separated list of names, in the order they
+// should be tried.
+func newAttestors(names string) ([]Attestor, error) {
+	var list []Attestor
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		attestor, err := configuredAttestor(name)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, attestor)
+	}
+	if len(list) == 0 {
+		return nil, errors.New("No attestors are configured.")
+	}
+	return list, nil
+}
+
+// attestTask tries each of the attestors in turn, and returns the task along
+// with the name of the attestor that verified it. An attestor that rejects the
+// task ends the search, only errNotAttested and errNoSuchTask fall through.
+func attestTask(request attestationRequest) (mesosTask, string, error) {
+	err := errNotAttested
+	for _, attestor := range attestors {
+		var task mesosTask
+		task, err = attestor.VerifyTask(request)
+		if err != errNotAttested && err != errNoSuchTask {
+			return task, attestor.Name(), err
+		}
+	}
+	return mesosTask{}, "", err
+}
+
+// mesosAttestor verifies that the task exists on the mesos master.
+type mesosAttestor struct{}
+
+func (mesosAttestor) Name() string {
+	return "mesos"
+}
+
+func (mesosAttestor) VerifyTask(request attestationRequest) (mesosTask, error) {
+	/*
+		The task can start, but the task's framework may have not reported
+		that it is RUNNING back to mesos. In this case, the task will still
+		be STAGING and have a statuses length of 0.
+
+		This is a network race, so we just sleep and try again.
+	*/
+	gMT := func(ctx context.Context, taskId string) (mesosTask, error) {
+		task, err := getMesosTask(ctx, taskId)
+		for i := time.Duration(0); i < 3 && err == nil && len(task.Statuses) == 0; i++ {
+			select {
+			case <-time.After((500 + 250*i) * time.Millisecond):
+			case <-ctx.Done():
+				return task, ctx.Err()
+			}
+			task, err = getMesosTask(ctx, taskId)
+		}
+		return task, err
+	}
+
+	// TODO: Remove this when we can incorporate Mesos in testing environment
+	if request.TaskId == state.testingTaskId && state.testingTaskId != "" {
+		gMT = func(ctx context.Context, taskId string) (mesosTask, error) {
+			return mesosTask{
+				Statuses: []struct {
+					State     string  `json:"state"`
+					Timestamp float64 `json:"timestamp"`
+				}{{"RUNNING", float64(time.Now().UnixNano()) / float64(1000000000)}},
+				Id:   taskId,
+				Name: "Test",
+			}, nil
+		}
+	}
+	ctx, span := tracer.Start(request.context(), "gatekeeper.mesos_task_lookup", trace.WithAttributes(attribute.String("gatekeeper.task_id", request.TaskId)))
+	task, err := gMT(ctx, request.TaskId)
+	endSpan(span, err)
+	if err != nil {
+		return mesosTask{}, err
+	}
+	if len(task.Statuses) == 0 {
+		return task, errTaskNotFresh
+	}
+	return task, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+type staticAttestor struct {
+	name  string
+	tasks map[string]mesosTask
+	err   error
+}
+
+func (a staticAttestor) Name() string {
+	return a.name
+}
+
+func (a staticAttestor) VerifyTask(request attestationRequest) (mesosTask, error) {
+	if a.err != nil {
+		return mesosTask{}, a.err
+	}
+	if task, ok := a.tasks[request.TaskId]; ok {
+		return task, nil
+	}
+	return mesosTask{}, errNotAttested
+}
+
+func TestAttestTask(t *testing.T) {
+	defer func(prev []Attestor) { attestors = prev }(attestors)
+	errRejected := errors.New("rejected")
+	attestors = []Attestor{
+		staticAttestor{name: "first", tasks: map[string]mesosTask{"web.1": {Id: "web.1", Name: "web"}}},
+		staticAttestor{name: "second", tasks: map[string]mesosTask{"api.1": {Id: "api.1", Name: "api"}}},
+	}
+
+	if task, name, err := attestTask(attestationRequest{TaskId: "api.1"}); err != nil || name != "second" || task.Name != "api" {
+		t.Errorf("Expected the second attestor to verify the task, got '%s' from '%s' (%v).", task.Name, name, err)
+	}
+	if _, _, err := attestTask(attestationRequest{TaskId: "db.1"}); err != errNotAttested {
+		t.Errorf("Expected an unknown task not to be attested, got %v.", err)
+	}
+
+	attestors = append([]Attestor{staticAttestor{name: "strict", err: errRejected}}, attestors...)
+	if _, name, err := attestTask(attestationRequest{TaskId: "web.1"}); err != errRejected || name != "strict" {
+		t.Errorf("Expected the rejection of the first attestor to end the search, got %v from '%s'.", err, name)
+	}
+}
+
+func TestNewAttestors(t *testing.T) {
+	if list, err := newAttestors("mesos"); err != nil || len(list) != 1 || list[0].Name() != "mesos" {
+		t.Errorf("Expected the mesos attestor, got %v (%v).", list, err)
+	}
+	if _, err := newAttestors("mesos,nomad"); err == nil {
+		t.Error("Expected an unknown attestor to be rejected.")
+	}
+	if _, err := newAttestors(" , "); err == nil {
+		t.Error("Expected an empty list of attestors to be rejected.")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	auditIssued      = "issued"
+	auditChecked     = "checked"
+	auditDenied      = "denied"
+	auditFailed      = "failed"
+	auditInvalid     = "invalid"
+	auditSealed      = "sealed"
+	auditRateLimited = "rate_limited"
+)
+
+var errNoAuditSink = errors.New("No audit file or syslog specified.")
+
+// An auditEvent records the outcome of a single token request.
+type auditEvent struct {
+	Time        time.Time `json:"time"`
+	Tenant      string    `json:"tenant,omitempty"`
+	TaskId      string    `json:"task_id,omitempty"`
+	TaskName    string    `json:"task_name,omitempty"`
+	Attestor    string    `json:"attestor,omitempty"`
+	PolicyKey   string    `json:"policy_key,omitempty"`
+	Policies    []string  `json:"policies,omitempty"`
+	SecretPaths []string  `json:"secret_paths,omitempty"`
+	Ttl         int       `json:"ttl,omitempty"`
+	RemoteAddr  string    `json:"remote_addr"`
+	DryRun      bool      `json:"dry_run,omitempty"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// auditLog appends token request events as json lines to a file and/or syslog.
+type auditLog struct {
+	sync.Mutex
+	path   string
+	file   *os.File
+	syslog io.Writer
+}
+
+// The active audit log. Auditing is disabled when nil.
+var audit *auditLog
+
+func NewAuditLog(path string, useSyslog bool) (*auditLog, error) {
+	if path == "" && !useSyslog {
+		return nil, errNoAuditSink
+	}
+	a := &auditLog{path: path}
+	if path != "" {
+		if err := a.Reopen(); err != nil {
+			return nil, err
+		}
+	}
+	if useSyslog {
+		w, err := newSyslogWriter("vault-gatekeeper")
+		if err != nil {
+			return nil, err
+		}
+		a.syslog = w
+	}
+	return a, nil
+}
+
+// Reopen the audit file, so that it can be rotated.
+func (a *auditLog) Reopen() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	a.Lock()
+	old := a.file
+	a.file = f
+	a.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+func (a *auditLog) Record(e auditEvent) {
+	if a == nil {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Failed to encode audit event: %v", err)
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	if a.file != nil {
+		if _, err := a.file.Write(append(b, '\n')); err != nil {
+			log.Printf("Failed to write to audit file: %v", err)
+		}
+	}
+	if a.syslog != nil {
+		if _, err := a.syslog.Write(b); err != nil {
+			log.Printf("Failed to write audit event to syslog: %v", err)
+		}
+	}
+}
+
+func (a *auditLog) Close() {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+}
+
+// Reopen the audit file whenever gatekeeper receives a SIGHUP.
+func (a *auditLog) watchReopen() {
+	if a.path == "" {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := a.Reopen(); err == nil {
+			log.Println("Reopened audit file.")
+		} else {
+			log.Printf("Failed to reopen audit file, continuing with the previous file. Error: %v", err)
+		}
+	}
+}
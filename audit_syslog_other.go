@@ -0,0 +1,15 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+var errSyslogUnsupported = errors.New("Syslog is not supported on this platform.")
+
+func newSyslogWriter(tag string) (io.Writer, error) {
+	return nil, errSyslogUnsupported
+}
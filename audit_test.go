@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLogFile(t *testing.T) {
+	if _, err := NewAuditLog("", false); err != errNoAuditSink {
+		t.Errorf("Expected an audit log without a sink to be rejected, got %v.", err)
+	}
+
+	dir, err := ioutil.TempDir("", "gatekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	a, err := NewAuditLog(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Record(auditEvent{Time: time.Now(), TaskId: "web.1", TaskName: "web", RemoteAddr: "10.0.0.1", Outcome: auditIssued})
+	// the rotated file keeps the events written so far
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	a.Record(auditEvent{Time: time.Now(), TaskId: "web.2", RemoteAddr: "10.0.0.2", Outcome: auditDenied, Error: errAlreadyGivenKey.Error()})
+	a.Close()
+	a.Record(auditEvent{Time: time.Now(), TaskId: "web.3", Outcome: auditIssued})
+
+	read := func(path string) []auditEvent {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var events []auditEvent
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var e auditEvent
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				t.Fatalf("Expected json lines, got %s.", scanner.Text())
+			}
+			events = append(events, e)
+		}
+		return events
+	}
+	if events := read(path + ".1"); len(events) != 1 || events[0].TaskId != "web.1" || events[0].Outcome != auditIssued {
+		t.Errorf("Expected the first event in the rotated file, got %+v.", events)
+	}
+	if events := read(path); len(events) != 1 || events[0].TaskId != "web.2" || events[0].Error == "" {
+		t.Errorf("Expected only the event recorded before closing in the new file, got %+v.", events)
+	}
+
+	var disabled *auditLog
+	disabled.Record(auditEvent{TaskId: "web.4"})
+}
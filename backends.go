@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/franela/goreq"
+	"log"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// A vaultBackend is an additional, named vault server that policies can
+// choose to create their tokens with. Gatekeeper logs in to each backend with
+// the backend's own credentials.
+type vaultBackend struct {
+	Name      string        `json:"-"`
+	Address   string        `json:"address"`
+	Namespace string        `json:"namespace,omitempty"`
+	Auth      unsealRequest `json:"auth"`
+
+	token string
+	// closed when the token is reset, which stops its renewal
+	stop chan struct{}
+	sync.RWMutex
+}
+
+var errUnknownVaultBackend = errors.New("Unknown vault backend.")
+
+var vaultBackends = make(map[string]*vaultBackend)
+
+// Loads the named vault backends from a json file in the format of
+// {"name":{"address":"https://vault:8200","auth":{"type":"token","token":"..."}}}
+func loadVaultBackends(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	backends := make(map[string]*vaultBackend)
+	if err := json.NewDecoder(f).Decode(&backends); err != nil {
+		return fmt.Errorf("Failed to decode vault backends: %v", err)
+	}
+	for name, backend := range backends {
+		if _, err := url.Parse(backend.Address); err != nil || backend.Address == "" {
+			return fmt.Errorf("Vault backend '%s' has an invalid address.", name)
+		}
+		if _, err := backend.Auth.unsealer(backend); err != nil {
+			return fmt.Errorf("Vault backend '%s': %v", name, err)
+		}
+		backend.Name = name
+	}
+	vaultBackends = backends
+	return nil
+}
+
+// Returns the vault backend with the given name. An empty name refers to the
+// default vault server, which is represented by a nil backend.
+func getVaultBackend(name string) (*vaultBackend, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if backend, ok := vaultBackends[name]; ok {
+		return backend, nil
+	}
+	return nil, errUnknownVaultBackend
+}
+
+func (b *vaultBackend) path(path string, query string) string {
+	if b == nil {
+		return vaultPath(path, query)
+	}
+	u, _ := url.Parse(b.Address)
+	u.Path = path
+	u.RawQuery = query
+	return u.String()
+}
+
+// The namespace of the backend. Only the default vault server inherits the
+// namespace configured with VAULT_NAMESPACE.
+func (b *vaultBackend) namespace() string {
+	if b == nil {
+		return config.Vault.Namespace
+	}
+	return b.Namespace
+}
+
+// Token returns gatekeeper's token for this backend, logging in if there
+// isn't one yet.
+func (b *vaultBackend) Token() (string, error) {
+	b.RLock()
+	token := b.token
+	b.RUnlock()
+	if token != "" {
+		return token, nil
+	}
+	return b.login()
+}
+
+func (b *vaultBackend) login() (string, error) {
+	b.Lock()
+	defer b.Unlock()
+	unsealer, err := b.Auth.unsealer(b)
+	if err != nil {
+		return "", err
+	}
+	token, err := unsealer.Token()
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Logged in to vault backend '%s' with method '%s'.", b.Name, unsealer.Name())
+	if b.stop != nil {
+		close(b.stop)
+	}
+	b.token, b.stop = token, make(chan struct{})
+	go b.renew(token, b.stop)
+	return token, nil
+}
+
+// Forget the token for this backend. The next call to Token will log in again.
+func (b *vaultBackend) reset() {
+	b.resetToken("")
+}
+
+// Forgets the token for this backend if it is the given token, or any token if
+// empty, and stops its renewal.
+func (b *vaultBackend) resetToken(token string) {
+	b.Lock()
+	defer b.Unlock()
+	if token != "" && token != b.token {
+		return
+	}
+	if b.stop != nil {
+		close(b.stop)
+	}
+	b.token, b.stop = "", nil
+}
+
+// renew keeps gatekeeper's token for the backend alive the way renew_worker
+// does for the token of the default vault server, until stop is closed. If the
+// token can't be renewed, it is forgotten rather than sealing gatekeeper, and
+// the next token request for the backend logs in again.
+func (b *vaultBackend) renew(token string, stop <-chan struct{}) {
+	for {
+		ttl, creationTtl, err := b.lookupToken(token)
+		if err != nil {
+			log.Printf("Failed to look up the token for vault backend '%s'. Error: %v", b.Name, err)
+			b.resetToken(token)
+			return
+		}
+		if creationTtl == 0 {
+			return
+		}
+		if ttl > 5 {
+			ttl -= 5
+		}
+		select {
+		case <-time.After(time.Duration(ttl) * time.Second):
+			if err := renew(b, token, creationTtl); err != nil {
+				log.Printf("Failed to renew the token for vault backend '%s'. Error: %v", b.Name, err)
+				b.resetToken(token)
+				return
+			}
+			log.Printf("Renewed the token for vault backend '%s' with ttl of %v.", b.Name, time.Duration(creationTtl)*time.Second)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Returns the ttl left of the token, and the ttl it was created with, in seconds.
+func (b *vaultBackend) lookupToken(token string) (int, int, error) {
+	r, err := VaultRequest{Request: goreq.Request{
+		Uri:             b.path("/v1/auth/token/lookup-self", ""),
+		MaxRedirects:    10,
+		RedirectHeaders: true,
+	}.WithHeader("X-Vault-Token", token), Namespace: b.namespace()}.Do()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		var e vaultError
+		e.Code = r.StatusCode
+		if err := r.Body.FromJsonTo(&e); err != nil {
+			e.Errors = []string{"communication error."}
+		}
+		return 0, 0, e
+	}
+	var tokenInfo struct {
+		Data struct {
+			Ttl         int `json:"ttl"`
+			CreationTtl int `json:"creation_ttl"`
+		} `json:"data"`
+	}
+	if err := r.Body.FromJsonTo(&tokenInfo); err != nil {
+		return 0, 0, err
+	}
+	return tokenInfo.Data.Ttl, tokenInfo.Data.CreationTtl, nil
+}
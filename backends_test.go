@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBackendVault serves the token endpoints of a vault backend that accepts
+// the token "backend-token", recording the namespace of the tokens it created.
+type fakeBackendVault struct {
+	sync.Mutex
+	namespaces []string
+	lookups    int
+	renewals   int
+}
+
+func (f *fakeBackendVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if r.Header.Get("X-Vault-Token") != "backend-token" {
+		w.WriteHeader(403)
+		w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/auth/token/lookup-self":
+		// the token expires right away the first time it is looked up after the login
+		if f.lookups++; f.lookups == 2 {
+			w.Write([]byte(`{"data":{"ttl":0,"creation_ttl":3600}}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"ttl":3600,"creation_ttl":3600}}`))
+	case "/v1/auth/token/renew-self":
+		f.renewals++
+		w.Write([]byte(`{"auth":{"client_token":"backend-token","lease_duration":3600}}`))
+	case "/v1/auth/token/create":
+		f.namespaces = append(f.namespaces, r.Header.Get("X-Vault-Namespace"))
+		w.Write([]byte(`{"wrap_info":{"token":"wrapping-token","accessor":"wrapping-accessor","ttl":600,"wrapped_accessor":"accessor"}}`))
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func TestPolicyVaultBackend(t *testing.T) {
+	defer func(backends map[string]*vaultBackend, namespace string) {
+		for _, backend := range vaultBackends {
+			backend.reset()
+		}
+		vaultBackends, config.Vault.Namespace = backends, namespace
+	}(vaultBackends, config.Vault.Namespace)
+	config.Vault.Namespace = "gatekeeper"
+
+	teams, ops := &fakeBackendVault{}, &fakeBackendVault{}
+	teamsServer, opsServer := httptest.NewServer(teams), httptest.NewServer(ops)
+	defer teamsServer.Close()
+	defer opsServer.Close()
+	vaultBackends = map[string]*vaultBackend{
+		"teams": {Name: "teams", Address: teamsServer.URL, Namespace: "teams", Auth: unsealRequest{Type: "token", Token: "backend-token"}},
+		"ops":   {Name: "ops", Address: opsServer.URL, Auth: unsealRequest{Type: "token", Token: "backend-token"}},
+	}
+
+	for _, test := range []struct {
+		policy    *policy
+		vault     *fakeBackendVault
+		namespace string
+	}{
+		{&policy{Vault: "teams"}, teams, "teams"},
+		{&policy{Vault: "teams", Namespace: "teams/web"}, teams, "teams/web"},
+		// only the default vault server inherits VAULT_NAMESPACE
+		{&policy{Vault: "ops"}, ops, ""},
+	} {
+		wrap, err := createTokenPair(context.Background(), "gatekeeper-token", test.policy)
+		if err != nil || wrap.Token != "wrapping-token" {
+			t.Errorf("Expected a token from vault backend '%s', got %+v, %v.", test.policy.Vault, wrap, err)
+			continue
+		}
+		test.vault.Lock()
+		if namespace := test.vault.namespaces[len(test.vault.namespaces)-1]; namespace != test.namespace {
+			t.Errorf("Expected the token of the policy on vault backend '%s' to be created in namespace '%s', got '%s'.", test.policy.Vault, test.namespace, namespace)
+		}
+		test.vault.Unlock()
+	}
+
+	if _, err := createTokenPair(context.Background(), "gatekeeper-token", &policy{Vault: "unknown"}); err != errUnknownVaultBackend {
+		t.Errorf("Expected a policy with an unknown vault backend to fail, got %v.", err)
+	}
+}
+
+func TestVaultBackendRenewal(t *testing.T) {
+	vault := &fakeBackendVault{}
+	ts := httptest.NewServer(vault)
+	defer ts.Close()
+	backend := &vaultBackend{Name: "teams", Address: ts.URL, Auth: unsealRequest{Type: "token", Token: "backend-token"}}
+
+	if token, err := backend.Token(); err != nil || token != "backend-token" {
+		t.Fatalf("Expected to log in to the backend, got '%s', %v.", token, err)
+	}
+	renewals := 0
+	for deadline := time.Now().Add(5 * time.Second); renewals == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		vault.Lock()
+		renewals = vault.renewals
+		vault.Unlock()
+	}
+	if renewals != 1 {
+		t.Errorf("Expected the token of the backend to be renewed once, got %d renewals.", renewals)
+	}
+
+	backend.reset()
+	backend.RLock()
+	stop := backend.stop
+	backend.RUnlock()
+	if stop != nil {
+		t.Error("Expected the renewal to be stopped when the token is reset.")
+	}
+}
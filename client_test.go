@@ -37,7 +37,7 @@ func RandString(n int) string {
 
 func TestGateKeeperClient(t *testing.T) {
 	seal()
-	if err := unseal(TokenUnsealer{*flagVaultToken}); err != nil {
+	if err := unseal(TokenUnsealer{AuthToken: *flagVaultToken}); err != nil {
 		t.Fatalf("Token Unseal Failed: %v", err)
 	}
 
@@ -52,7 +52,7 @@ func TestGateKeeperClient(t *testing.T) {
 		t.Fatalf("Failed to request vault token: %v", err)
 	} else {
 		t.Logf("Got token using client: %s", token)
-		r, err := VaultRequest{goreq.Request{
+		r, err := VaultRequest{Request: goreq.Request{
 			Uri:             vaultPath("/v1/auth/token/lookup-self", ""),
 			MaxRedirects:    10,
 			RedirectHeaders: true,
@@ -67,3 +67,36 @@ func TestGateKeeperClient(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenCheck(t *testing.T) {
+	seal()
+	if err := unseal(TokenUnsealer{AuthToken: *flagVaultToken}); err != nil {
+		t.Fatalf("Token Unseal Failed: %v", err)
+	}
+
+	state.testingTaskId = RandString(32)
+	for i := 0; i < 2; i++ {
+		r, err := goreq.Request{
+			Uri:    "http://" + gkListenAddress + "/token/check",
+			Method: "POST",
+			Body: struct {
+				TaskId string `json:"task_id"`
+			}{state.testingTaskId},
+		}.Do()
+		if err != nil {
+			t.Fatalf("Could not reach gatekeeper: %v", err)
+		}
+		defer r.Body.Close()
+		if r.StatusCode != 200 {
+			t.Fatalf("Token request check failed. Status Code: %d", r.StatusCode)
+		}
+	}
+
+	client, err := gatekeeper.NewClient(config.Vault.Server, "http://"+gkListenAddress, nil)
+	if err != nil {
+		t.Fatalf("Failed to create gatekeeper client: %v", err)
+	}
+	if _, err := client.RequestVaultToken(state.testingTaskId); err != nil {
+		t.Fatalf("Failed to request vault token after dry run: %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/hcl"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"sync/atomic"
+	"syscall"
+)
+
+// The settings of the configuration file, by section, and the flag each of
+// them sets.
+var configFileSettings = map[string]map[string]string{
+	"listen": {
+		"address":             "listen",
+		"grpc_address":        "grpc-listen",
+		"debug_address":       "debug-listen",
+		"tls_cert":            "tls-cert",
+		"tls_key":             "tls-key",
+		"tls_client_ca":       "tls-client-ca",
+		"tls_client_auth":     "tls-client-auth",
+		"admin_token":         "admin-token",
+		"admin_client_names":  "admin-client-names",
+		"drain_timeout":       "drain-timeout",
+		"request_timeout":     "request-timeout",
+		"response_version":    "token-response-version",
+		"rate_limit":          "rate-limit",
+		"rate_limit_burst":    "rate-limit-burst",
+		"ip_rate_limit":       "ip-rate-limit",
+		"ip_rate_limit_burst": "ip-rate-limit-burst",
+		"audit_file":          "audit-file",
+		"state_file":          "state-file",
+		"accessor_retention":  "accessor-retention",
+		"audit_syslog":        "audit-syslog",
+		"log_level":           "log-level",
+	},
+	"vault": {
+		"address":               "vault",
+		"namespace":             "vault-namespace",
+		"backends":              "vault-backends",
+		"tenants":               "tenants",
+		"tls_skip_verify":       "tls-skip-verify",
+		"ca_cert":               "ca-cert",
+		"ca_path":               "ca-path",
+		"policies":              "policies",
+		"policy_required":       "policy-required",
+		"self_recreate_token":   "self-recreate-token",
+		"retries":               "vault-retries",
+		"retry_backoff":         "vault-retry-backoff",
+		"breaker_threshold":     "vault-breaker-threshold",
+		"breaker_timeout":       "vault-breaker-timeout",
+		"timeout":               "vault-timeout",
+		"connect_timeout":       "vault-connect-timeout",
+		"entity_alias":          "entity-alias",
+		"entity_alias_role":     "entity-alias-role",
+		"entity_alias_accessor": "entity-alias-accessor",
+	},
+	"mesos": {
+		"master":          "mesos",
+		"api":             "mesos-api",
+		"principal":       "mesos-principal",
+		"secret":          "mesos-secret",
+		"tls":             "mesos-tls",
+		"ca_cert":         "mesos-ca-cert",
+		"skip_verify":     "mesos-skip-verify",
+		"task_cache":      "mesos-task-cache",
+		"instance_slack":  "instance-slack",
+		"timeout":         "mesos-timeout",
+		"connect_timeout": "mesos-connect-timeout",
+		"task_life":       "task-life",
+		"job_names":       "match-job-names",
+		"marathon":        "marathon-url",
+	},
+	"attestation": {
+		"attestors":           "attestors",
+		"spiffe_trust_domain": "spiffe-trust-domain",
+		"spiffe_task_name":    "spiffe-task-name",
+		"request_signing":     "request-signing",
+		"agent_secrets":       "agent-secrets",
+	},
+	"replication": {
+		"standby":          "standby",
+		"standby_address":  "standby-addr",
+		"token":            "replication-token",
+		"failover_timeout": "failover-timeout",
+	},
+	"tracing": {
+		"endpoint": "tracing-endpoint",
+	},
+	"hooks": {
+		"url":           "hook-url",
+		"kafka_brokers": "hook-kafka-brokers",
+		"kafka_topic":   "hook-kafka-topic",
+		"timeout":       "hook-timeout",
+	},
+	"unsealer": {
+		"cubby_token":        "cubby-token",
+		"cubby_path":         "cubby-path",
+		"wrapped_token":      "wrapped-token-auth",
+		"wrapped_token_file": "wrapped-token-auth-file",
+		"app_id":             "auth-appid",
+		"user_id_method":     "auth-userid-method",
+		"user_id_interface":  "auth-userid-interface",
+		"user_id_path":       "auth-userid-path",
+		"user_id_hash":       "auth-userid-hash",
+		"user_id_salt":       "auth-userid-salt",
+		"user_id_cloud":      "auth-userid-cloud",
+		"user_id_hmac_key":   "auth-userid-hmac-key",
+		"role_id":            "auth-role-id",
+		"secret_id":          "auth-secret-id",
+		"kubernetes_role":    "auth-kubernetes-role",
+		"kubernetes_jwt":     "auth-kubernetes-jwt",
+		"chain":              "unseal-chain",
+		"auth_mount":         "auth-mount",
+		"app_id_mount":       "auth-appid-mount",
+		"approle_mount":      "auth-approle-mount",
+		"kubernetes_mount":   "auth-kubernetes-mount",
+	},
+}
+
+// The flags that are applied again when the configuration file is reloaded.
+var reloadableFlags = map[string]bool{
+	"tls-cert":      true,
+	"tls-key":       true,
+	"tls-client-ca": true,
+	"policies":      true,
+	"log-level":     true,
+}
+
+// Reads the configuration file, which can be written in HCL or JSON, into the
+// flags it sets.
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file map[string]interface{}
+	if err := hcl.Decode(&file, string(b)); err != nil {
+		return nil, fmt.Errorf("Failed to parse config file: %v", err)
+	}
+
+	flags := make(map[string]string)
+	for section, value := range file {
+		settings, ok := configFileSettings[section]
+		if !ok {
+			return nil, fmt.Errorf("Unknown section '%s' in config file.", section)
+		}
+		// hcl decodes each block as a list of objects
+		var blocks []map[string]interface{}
+		switch v := value.(type) {
+		case []map[string]interface{}:
+			blocks = v
+		case map[string]interface{}:
+			blocks = []map[string]interface{}{v}
+		default:
+			return nil, fmt.Errorf("Section '%s' of config file must be a block.", section)
+		}
+		for _, block := range blocks {
+			for key, v := range block {
+				name, ok := settings[key]
+				if !ok {
+					return nil, fmt.Errorf("Unknown setting '%s' in section '%s' of config file.", key, section)
+				}
+				flags[name] = fmt.Sprint(v)
+			}
+		}
+	}
+	return flags, nil
+}
+
+// The flags given on the command line, recorded before the configuration file
+// sets any flags.
+var commandLineFlags map[string]bool
+
+// Applies the configuration file to the flags that weren't given on the command
+// line, so that command line flags take precedence over the file, which takes
+// precedence over environment variables. If only is not nil, only the flags in
+// it are applied.
+func applyConfigFile(path string, only map[string]bool) error {
+	flags, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if commandLineFlags == nil {
+		commandLineFlags = make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) {
+			commandLineFlags[f.Name] = true
+		})
+	}
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if commandLineFlags[name] || (only != nil && !only[name]) {
+			continue
+		}
+		if err := flag.Set(name, flags[name]); err != nil {
+			return fmt.Errorf("Invalid value '%s' for '%s' in config file: %v", flags[name], name, err)
+		}
+	}
+	return nil
+}
+
+var logLevel atomic.Value
+
+func validLogLevel(level string) bool {
+	switch level {
+	case "debug", "info", "warn":
+		return true
+	default:
+		return false
+	}
+}
+
+// The http access log is written at the info level.
+func accessLog() gin.HandlerFunc {
+	logger := gin.Logger()
+	return func(c *gin.Context) {
+		if level, _ := logLevel.Load().(string); level == "warn" {
+			c.Next()
+			return
+		}
+		logger(c)
+	}
+}
+
+// Reload the reloadable settings of the configuration file whenever gatekeeper
+// receives a SIGHUP.
+func watchConfigFile(path string, certs *listenerTLS) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		state.Lock()
+		oldPolicies := config.Vault.GkPolicies
+		err := applyConfigFile(path, reloadableFlags)
+		newPolicies := config.Vault.GkPolicies
+		state.Unlock()
+		if err != nil {
+			log.Printf("Failed to reload config file, continuing with the previous config. Error: %v", err)
+			continue
+		}
+		if !validLogLevel(config.LogLevel) {
+			log.Printf("Unknown log level '%s'. Valid levels are 'debug', 'info' and 'warn'.", config.LogLevel)
+		} else {
+			logLevel.Store(config.LogLevel)
+		}
+		log.Println("Reloaded config file.")
+
+		if certs != nil {
+			if err := certs.Load(); err == nil {
+				log.Println("Reloaded TLS certificates.")
+			} else {
+				log.Printf("Failed to reload TLS certificates, continuing with the previous certificates. Error: %v", err)
+			}
+		}
+		if oldPolicies != newPolicies {
+			state.RLock()
+			status, token := state.Status, state.Token
+			state.RUnlock()
+			if status == StatusUnsealed {
+				if err := activePolicies.Load(token); err == nil {
+					log.Printf("Loaded policies from '%s'.", policySourceName(newPolicies))
+				} else {
+					log.Printf("Failed to load policies from '%s': %v", policySourceName(newPolicies), err)
+					hooks.NotifyPolicyReloadFailed(policySourceName(newPolicies), err)
+				}
+			}
+		}
+	}
+}
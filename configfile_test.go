@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "gatekeeper-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(contents); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestReadConfigFile(t *testing.T) {
+	for _, contents := range []string{
+		`
+listen {
+	address = ":9201"
+	rate_limit = 2.5
+}
+vault {
+	address = "https://vault:8200"
+	tls_skip_verify = true
+}
+mesos {
+	master = "zk://zk:2181/mesos"
+	task_life = "5m"
+}`,
+		`{
+	"listen": {"address": ":9201", "rate_limit": 2.5},
+	"vault": {"address": "https://vault:8200", "tls_skip_verify": true},
+	"mesos": {"master": "zk://zk:2181/mesos", "task_life": "5m"}
+}`,
+	} {
+		path := writeConfigFile(t, contents)
+		defer os.Remove(path)
+		flags, err := readConfigFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read config file: %v", err)
+		}
+		for name, expected := range map[string]string{
+			"listen":          ":9201",
+			"rate-limit":      "2.5",
+			"vault":           "https://vault:8200",
+			"tls-skip-verify": "true",
+			"mesos":           "zk://zk:2181/mesos",
+			"task-life":       "5m",
+		} {
+			if flags[name] != expected {
+				t.Errorf("Expected flag '%s' to be '%s', got '%s'.", name, expected, flags[name])
+			}
+		}
+	}
+}
+
+func TestReadConfigFileUnknownSetting(t *testing.T) {
+	for _, contents := range []string{
+		`vault { adress = "https://vault:8200" }`,
+		`valt { address = "https://vault:8200" }`,
+	} {
+		path := writeConfigFile(t, contents)
+		defer os.Remove(path)
+		if _, err := readConfigFile(path); err == nil {
+			t.Errorf("Expected config file to be invalid: %s", contents)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	expvar.Publish("gatekeeper", expvar.Func(func() interface{} {
+		state.RLock()
+		defer state.RUnlock()
+		return map[string]interface{}{
+			"status":       state.Status,
+			"started":      state.Started,
+			"uptime":       time.Since(state.Started).String(),
+			"version":      gitNearestTag,
+			"requests":     atomic.LoadInt32(&state.Stats.Requests),
+			"successful":   atomic.LoadInt32(&state.Stats.Successful),
+			"denied":       atomic.LoadInt32(&state.Stats.Denied),
+			"rate_limited": atomic.LoadInt32(&state.Stats.RateLimited),
+		}
+	}))
+}
+
+// The pprof and expvar handlers. They are served on a listener of their own
+// rather than with the token api, as profiles and the command line can reveal
+// secrets and must only be reachable by operators.
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+func serveDebug(address string) {
+	listeners, err := listen(address)
+	if err != nil {
+		log.Fatalf("Failed to listen for the debug endpoints on '%s'. Error: %v", address, err)
+	}
+	for _, l := range listeners {
+		log.Printf("Serving pprof and /debug/vars on '%s'...", l.Addr())
+	}
+	handler := debugHandler()
+	if err := serveAll(listeners, func(l net.Listener) error { return http.Serve(l, handler) }); err != nil {
+		log.Fatalf("Failed to serve the debug endpoints. Error: %v", err)
+	}
+}
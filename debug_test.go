@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	server := httptest.NewServer(debugHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var vars struct {
+		Gatekeeper struct {
+			Status   GkStatus `json:"status"`
+			Requests int32    `json:"requests"`
+		} `json:"gatekeeper"`
+		Memstats map[string]interface{} `json:"memstats"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Memstats == nil {
+		t.Error("Expected /debug/vars to include the memory statistics.")
+	}
+	state.RLock()
+	status := state.Status
+	state.RUnlock()
+	if vars.Gatekeeper.Status != status {
+		t.Errorf("Expected status '%s', got '%s'.", status, vars.Gatekeeper.Status)
+	}
+
+	if resp, err := http.Get(server.URL + "/debug/pprof/goroutine?debug=1"); err != nil {
+		t.Error(err)
+	} else {
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Errorf("Expected the goroutine profile, got status %d.", resp.StatusCode)
+		}
+	}
+}
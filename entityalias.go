@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/franela/goreq"
+	"strings"
+	"sync"
+)
+
+var errNoEntityAliasRole = errors.New("Tokens can only be attached to an entity alias when created with a token role, set ENTITY_ALIAS_ROLE.")
+
+// Makes a request to vault's identity api, decoding the data of the response
+// into data if it isn't nil. Returns the status code of the response.
+func identityRequest(ctx context.Context, backend *vaultBackend, token string, namespace string, method string, path string, body interface{}, data interface{}) (int, error) {
+	req := goreq.Request{
+		Uri:             backend.path(path, ""),
+		Method:          method,
+		MaxRedirects:    10,
+		RedirectHeaders: true,
+	}
+	if body != nil {
+		req.Body = body
+		req.ContentType = "application/json"
+	}
+	r, err := VaultRequest{
+		Request:   req.WithHeader("X-Vault-Token", token),
+		Namespace: namespace,
+		Context:   ctx,
+	}.Do()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Body.Close()
+
+	switch r.StatusCode {
+	case 200:
+		if data != nil {
+			resp := struct {
+				Data interface{} `json:"data"`
+			}{data}
+			if err := r.Body.FromJsonTo(&resp); err != nil {
+				return r.StatusCode, err
+			}
+		}
+		return r.StatusCode, nil
+	case 204:
+		return r.StatusCode, nil
+	default:
+		var e vaultError
+		e.Code = r.StatusCode
+		if err := r.Body.FromJsonTo(&e); err != nil {
+			e.Errors = []string{"communication error."}
+		}
+		return r.StatusCode, e
+	}
+}
+
+// The entity ids of the aliases that are known to exist, by backend, namespace,
+// mount accessor and alias name, along with a lock per alias so that concurrent
+// token requests don't race each other to create the same entity and alias.
+var entityAliases = struct {
+	sync.Mutex
+	ids   map[string]string
+	locks map[string]*sync.Mutex
+}{ids: make(map[string]string), locks: make(map[string]*sync.Mutex)}
+
+// ensureEntityAlias makes sure that an entity named after the alias exists in
+// vault, with the alias on the auth mount of ENTITY_ALIAS_ACCESSOR, so that the
+// tokens attached to the alias show up under a stable, readable identity.
+// Without an accessor vault creates an entity with a generated name the first
+// time a token is attached to the alias.
+func ensureEntityAlias(ctx context.Context, backend *vaultBackend, token string, namespace string, alias string) error {
+	accessor := config.EntityAliasAccessor
+	if accessor == "" {
+		return nil
+	}
+	backendName := ""
+	if backend != nil {
+		backendName = backend.Name
+	}
+	key := strings.Join([]string{backendName, namespace, accessor, alias}, "\x00")
+
+	entityAliases.Lock()
+	_, ok := entityAliases.ids[key]
+	lock := entityAliases.locks[key]
+	if lock == nil {
+		lock = &sync.Mutex{}
+		entityAliases.locks[key] = lock
+	}
+	entityAliases.Unlock()
+	if ok {
+		return nil
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	// the alias may have been created while this request waited for the lock
+	entityAliases.Lock()
+	_, ok = entityAliases.ids[key]
+	entityAliases.Unlock()
+	if ok {
+		return nil
+	}
+
+	id, err := createEntityAlias(ctx, backend, token, namespace, accessor, alias)
+	if err != nil {
+		return err
+	}
+	entityAliases.Lock()
+	entityAliases.ids[key] = id
+	entityAliases.Unlock()
+	return nil
+}
+
+// createEntityAlias creates the entity and the alias on the mount unless they
+// exist, and returns the id of the entity. Another gatekeeper may create them
+// at the same time, so vault refusing to create them because they already
+// exist is not an error, as long as they can be looked up afterwards.
+func createEntityAlias(ctx context.Context, backend *vaultBackend, token string, namespace string, accessor string, alias string) (string, error) {
+	lookupAlias := func() (string, error) {
+		return lookupEntity(ctx, backend, token, namespace, struct {
+			AliasName          string `json:"alias_name"`
+			AliasMountAccessor string `json:"alias_mount_accessor"`
+		}{alias, accessor})
+	}
+	lookupName := func() (string, error) {
+		return lookupEntity(ctx, backend, token, namespace, struct {
+			Name string `json:"name"`
+		}{alias})
+	}
+
+	id, err := lookupAlias()
+	if err != nil {
+		return "", fmt.Errorf("Failed to look up entity alias '%s': %v", alias, err)
+	}
+	if id != "" {
+		return id, nil
+	}
+
+	id, err = lookupName()
+	if err != nil {
+		return "", fmt.Errorf("Failed to look up entity '%s': %v", alias, err)
+	}
+	if id == "" {
+		var entity struct {
+			Id string `json:"id"`
+		}
+		code, err := identityRequest(ctx, backend, token, namespace, "POST", "/v1/identity/entity", struct {
+			Name     string            `json:"name"`
+			Metadata map[string]string `json:"metadata"`
+		}{alias, map[string]string{"created_by": "vault-gatekeeper"}}, &entity)
+		if err != nil && code == 400 {
+			if existing, lookupErr := lookupName(); lookupErr == nil && existing != "" {
+				entity.Id, err = existing, nil
+			}
+		}
+		if err != nil {
+			return "", fmt.Errorf("Failed to create entity '%s': %v", alias, err)
+		}
+		id = entity.Id
+	}
+
+	code, err := identityRequest(ctx, backend, token, namespace, "POST", "/v1/identity/entity-alias", struct {
+		Name          string `json:"name"`
+		CanonicalId   string `json:"canonical_id"`
+		MountAccessor string `json:"mount_accessor"`
+	}{alias, id, accessor}, nil)
+	if err != nil && code == 400 {
+		if existing, lookupErr := lookupAlias(); lookupErr == nil && existing != "" {
+			return existing, nil
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to create entity alias '%s': %v", alias, err)
+	}
+	return id, nil
+}
+
+// Looks up the id of the entity matching the body of the lookup request, or an
+// empty id if there is no such entity.
+func lookupEntity(ctx context.Context, backend *vaultBackend, token string, namespace string, body interface{}) (string, error) {
+	var found struct {
+		Id string `json:"id"`
+	}
+	if _, err := identityRequest(ctx, backend, token, namespace, "POST", "/v1/identity/lookup/entity", body, &found); err != nil {
+		return "", err
+	}
+	return found.Id, nil
+}
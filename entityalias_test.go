@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeIdentity serves vault's identity api for the entities and aliases of a
+// single mount, counting the entities and aliases it was asked to create.
+type fakeIdentity struct {
+	sync.Mutex
+	entities map[string]string // name to id
+	aliases  map[string]string // alias name to entity id
+	created  map[string]int
+	// entities and aliases that another gatekeeper creates right after the entity
+	// is first looked up
+	racing map[string]bool
+}
+
+func newFakeIdentity() *fakeIdentity {
+	return &fakeIdentity{entities: make(map[string]string), aliases: make(map[string]string), created: make(map[string]int), racing: make(map[string]bool)}
+}
+
+func (f *fakeIdentity) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name          string `json:"name"`
+		AliasName     string `json:"alias_name"`
+		CanonicalId   string `json:"canonical_id"`
+		MountAccessor string `json:"mount_accessor"`
+	}
+	if r.Method != "POST" || r.Header.Get("X-Vault-Token") != "gatekeeper" || json.NewDecoder(r.Body).Decode(&body) != nil {
+		w.WriteHeader(400)
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	found := func(id string) {
+		if id == "" {
+			w.WriteHeader(204)
+			return
+		}
+		fmt.Fprintf(w, `{"data":{"id":"%s"}}`, id)
+	}
+	switch r.URL.Path {
+	case "/v1/identity/lookup/entity":
+		if body.AliasName != "" {
+			found(f.aliases[body.AliasName])
+			return
+		}
+		id := f.entities[body.Name]
+		if f.racing[body.Name] {
+			delete(f.racing, body.Name)
+			f.entities[body.Name] = "entity-" + body.Name
+			f.aliases[body.Name] = "entity-" + body.Name
+		}
+		found(id)
+	case "/v1/identity/entity":
+		f.created["entity "+body.Name]++
+		if _, ok := f.entities[body.Name]; ok {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"errors":["entity name is already in use"]}`))
+			return
+		}
+		f.entities[body.Name] = "entity-" + body.Name
+		found(f.entities[body.Name])
+	case "/v1/identity/entity-alias":
+		f.created["alias "+body.Name]++
+		if _, ok := f.aliases[body.Name]; ok {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"errors":["combination of mount and alias name is already in use"]}`))
+			return
+		}
+		f.aliases[body.Name] = body.CanonicalId
+		w.WriteHeader(204)
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func resetEntityAliases() {
+	entityAliases.Lock()
+	entityAliases.ids = make(map[string]string)
+	entityAliases.locks = make(map[string]*sync.Mutex)
+	entityAliases.Unlock()
+}
+
+func TestEnsureEntityAlias(t *testing.T) {
+	defer func(accessor string) { config.EntityAliasAccessor = accessor }(config.EntityAliasAccessor)
+	config.EntityAliasAccessor = "auth_token_1234"
+	defer resetEntityAliases()
+	resetEntityAliases()
+
+	identity := newFakeIdentity()
+	ts := httptest.NewServer(identity)
+	defer ts.Close()
+	backend := &vaultBackend{Name: "identity", Address: ts.URL}
+
+	if err := ensureEntityAlias(context.Background(), backend, "gatekeeper", "", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if identity.aliases["web"] != "entity-web" {
+		t.Errorf("Expected the alias to be created on the entity named after it, got %v.", identity.aliases)
+	}
+
+	// the entity id of the alias is cached
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected the alias to be cached, got a request to %s.", r.URL.Path)
+		w.WriteHeader(500)
+	})
+	if err := ensureEntityAlias(context.Background(), backend, "gatekeeper", "", "web"); err != nil {
+		t.Error(err)
+	}
+	ts.Config.Handler = identity
+
+	// another gatekeeper creates the entity and the alias between their lookup
+	// and their creation
+	identity.racing["db"] = true
+	if err := ensureEntityAlias(context.Background(), backend, "gatekeeper", "", "db"); err != nil {
+		t.Errorf("Expected an alias that already exists to be looked up again, got %v.", err)
+	}
+	if identity.created["entity db"] != 1 || identity.created["alias db"] != 1 {
+		t.Errorf("Expected the entity and the alias to be created once each, got %v.", identity.created)
+	}
+}
+
+func TestEnsureEntityAliasConcurrently(t *testing.T) {
+	defer func(accessor string) { config.EntityAliasAccessor = accessor }(config.EntityAliasAccessor)
+	config.EntityAliasAccessor = "auth_token_1234"
+	defer resetEntityAliases()
+	resetEntityAliases()
+
+	identity := newFakeIdentity()
+	ts := httptest.NewServer(identity)
+	defer ts.Close()
+	backend := &vaultBackend{Name: "identity", Address: ts.URL}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- ensureEntityAlias(context.Background(), backend, "gatekeeper", "", "web")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if identity.created["entity web"] != 1 || identity.created["alias web"] != 1 {
+		t.Errorf("Expected the entity and the alias to be created once, got %v.", identity.created)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeper"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+var errFetchNoCommand = errors.New("No command given to run after fetching the token.")
+var errFetchWrappedSecrets = errors.New("Secrets can only be read with an unwrapped token.")
+var errFetchSinkNoToken = errors.New("Gatekeeper provided secrets instead of a token, there is no token to write to the sinks.")
+var errFetchRenewWrapped = errors.New("Only unwrapped tokens can be renewed.")
+var errFetchNoAgent = errors.New("The agent to sign the token request as is required with an agent secret.")
+
+// A secretRef refers to a secret in vault as path#field. Without a field it
+// refers to all of the data of the secret.
+type secretRef struct {
+	Target string
+	Path   string
+	Field  string
+}
+
+// secretRefs collects repeated -env NAME=path#field and -file dest=path#field
+// flags.
+type secretRefs []secretRef
+
+func (s *secretRefs) String() string {
+	refs := make([]string, len(*s))
+	for i, ref := range *s {
+		refs[i] = ref.Target + "=" + ref.Path
+		if ref.Field != "" {
+			refs[i] += "#" + ref.Field
+		}
+	}
+	return strings.Join(refs, ",")
+}
+
+func (s *secretRefs) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("Invalid secret '%s', expected target=path#field.", value)
+	}
+	ref := secretRef{Target: parts[0], Path: parts[1]}
+	if i := strings.LastIndex(ref.Path, "#"); i >= 0 {
+		ref.Path, ref.Field = ref.Path[:i], ref.Path[i+1:]
+	}
+	*s = append(*s, ref)
+	return nil
+}
+
+// The value of the field of the secret. Fields of kv version 2 secrets are
+// nested in the secret's data.
+func secretField(secret gatekeeper.Secret, ref secretRef) (string, error) {
+	data := secret.Data
+	if ref.Field == "" {
+		b, err := json.Marshal(data)
+		return string(b), err
+	}
+	if _, ok := data[ref.Field]; !ok {
+		if nested, ok := data["data"].(map[string]interface{}); ok {
+			data = nested
+		}
+	}
+	value, ok := data[ref.Field]
+	if !ok {
+		return "", fmt.Errorf("Secret '%s' has no field '%s'.", ref.Path, ref.Field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(value)
+	return string(b), err
+}
+
+// runFetch implements the fetch subcommand: it requests the task's token from
+// gatekeeper, reads the declared secrets from vault, and then runs the command
+// with the token and secrets injected into its environment or written to files.
+// With sinks and no command it runs as a sidecar that only maintains the sinks.
+func runFetch(args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s fetch [flags] [-- command [args...]]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	taskId := fs.String("task-id", os.Getenv("MESOS_TASK_ID"), "The task id to request the token of.")
+	unwrap := fs.Bool("unwrap", true, "Unwrap the token. If false, the response wrapping token is injected instead, and no secrets can be read.")
+	tokenEnv := fs.String("token-env", "VAULT_TOKEN", "Environment variable to inject the token into. Empty to not inject the token.")
+	tokenFile := fs.String("token-file", "", "File to write the token to.")
+	var envs, files secretRefs
+	fs.Var(&envs, "env", "Inject a secret into an environment variable, as NAME=path#field. Can be repeated.")
+	fs.Var(&files, "file", "Write a secret to a file, as dest=path#field. Without a field the data of the secret is written as json. Can be repeated.")
+	var sinks tokenSinks
+	fs.Var(&sinks, "sink", "Write the token to a file, as path[,mode=0640][,owner=user][,group=group]. Can be repeated.")
+	renew := fs.Bool("renew", false, "Keep renewing the token, rewriting the sinks after every renewal, for as long as the command or sidecar runs.")
+	agent := fs.String("agent", "", "The id or hostname of the mesos agent to sign the token request as.")
+	agentSecretFile := fs.String("agent-secret-file", "", "File with the secret of the agent to sign the token request with.")
+	fs.Parse(args)
+
+	command := fs.Args()
+	if len(command) == 0 && len(sinks) == 0 {
+		fs.Usage()
+		return errFetchNoCommand
+	}
+	if *renew && !*unwrap {
+		return errFetchRenewWrapped
+	}
+
+	client := gatekeeper.DefaultClient
+	if client == nil {
+		return errors.New("The gatekeeper client is not configured, check VAULT_ADDR and GATEKEEPER_ADDR.")
+	}
+
+	if *agentSecretFile != "" {
+		if *agent == "" {
+			return errFetchNoAgent
+		}
+		secret, err := ioutil.ReadFile(*agentSecretFile)
+		if err != nil {
+			return err
+		}
+		client.AgentId, client.AgentSecret = *agent, []byte(strings.TrimSpace(string(secret)))
+	}
+
+	resp, err := client.RequestWrappedToken(*taskId)
+	if err != nil {
+		return err
+	}
+
+	var token string
+	var provided map[string]gatekeeper.Secret
+	switch {
+	case !*unwrap:
+		if len(envs) > 0 || len(files) > 0 {
+			return errFetchWrappedSecrets
+		}
+		token = resp.Token
+	case len(resp.SecretPaths) > 0:
+		// gatekeeper read the secrets for the task instead of giving it a token
+		if provided, err = client.UnwrapSecrets(resp); err != nil {
+			return err
+		}
+	default:
+		if token, err = client.UnwrapToken(resp); err != nil {
+			return err
+		}
+	}
+	if len(sinks) > 0 && token == "" {
+		return errFetchSinkNoToken
+	}
+
+	secrets := make(map[string]gatekeeper.Secret)
+	read := func(ref secretRef) (string, error) {
+		secret, ok := secrets[ref.Path]
+		if !ok {
+			var err error
+			if provided != nil {
+				if secret, ok = provided[ref.Path]; !ok {
+					return "", fmt.Errorf("Gatekeeper didn't provide the secret '%s'.", ref.Path)
+				}
+			} else if secret, err = client.ReadSecret(resp, token, ref.Path); err != nil {
+				return "", fmt.Errorf("Failed to read secret '%s': %v", ref.Path, err)
+			}
+			secrets[ref.Path] = secret
+		}
+		return secretField(secret, ref)
+	}
+
+	env := os.Environ()
+	if *tokenEnv != "" && token != "" {
+		env = append(env, *tokenEnv+"="+token)
+	}
+	if *tokenFile != "" && token != "" {
+		if err := ioutil.WriteFile(*tokenFile, []byte(token), 0600); err != nil {
+			return err
+		}
+	}
+	for _, ref := range envs {
+		value, err := read(ref)
+		if err != nil {
+			return err
+		}
+		env = append(env, ref.Target+"="+value)
+	}
+	for _, ref := range files {
+		value, err := read(ref)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(ref.Target, []byte(value), 0600); err != nil {
+			return err
+		}
+	}
+
+	if err := sinks.Write(token); err != nil {
+		return err
+	}
+
+	var path string
+	if len(command) > 0 {
+		if path, err = exec.LookPath(command[0]); err != nil {
+			return err
+		}
+	}
+	if !*renew || token == "" {
+		if path == "" {
+			return nil
+		}
+		return execCommand(path, command, env)
+	}
+
+	// gatekeeper has to stay around to renew the token, so the command is run
+	// as a child instead.
+	stop := make(chan struct{})
+	defer close(stop)
+	go renewSinks(client, resp, token, sinks, stop)
+	if path == "" {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		<-sig
+		return nil
+	}
+	code, err := runCommand(path, command, env)
+	if err != nil {
+		return err
+	}
+	os.Exit(code)
+	return nil
+}
+
+// runCommand runs the command as a child, forwarding SIGTERM and SIGINT to it,
+// and returns its exit code.
+func runCommand(path string, args []string, env []string) (int, error) {
+	cmd := exec.Command(path, args[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sig)
+	go func() {
+		for s := range sig {
+			cmd.Process.Signal(s)
+		}
+	}()
+	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
+		return 0, err
+	}
+	return 0, nil
+}
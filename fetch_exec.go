@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"syscall"
+)
+
+// Replaces gatekeeper with the command, so that it receives the task's signals
+// directly.
+func execCommand(path string, args []string, env []string) error {
+	return syscall.Exec(path, args, env)
+}
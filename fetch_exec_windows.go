@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+)
+
+// Windows can't replace the running process, so the command is run as a child
+// and gatekeeper exits with its exit code.
+func execCommand(path string, args []string, env []string) error {
+	code, err := runCommand(path, args, env)
+	if err != nil {
+		return err
+	}
+	os.Exit(code)
+	return nil
+}
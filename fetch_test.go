@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeper"
+	"testing"
+)
+
+func TestSecretRefs(t *testing.T) {
+	var refs secretRefs
+	for _, value := range []string{"DB_PASSWORD=database/creds/web#password", "/etc/app/aws.json=aws/creds/deploy"} {
+		if err := refs.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if refs[0] != (secretRef{"DB_PASSWORD", "database/creds/web", "password"}) {
+		t.Errorf("Unexpected secret ref %+v.", refs[0])
+	}
+	if refs[1] != (secretRef{"/etc/app/aws.json", "aws/creds/deploy", ""}) {
+		t.Errorf("Unexpected secret ref %+v.", refs[1])
+	}
+	for _, value := range []string{"database/creds/web", "=database/creds/web", "DB_PASSWORD="} {
+		if err := refs.Set(value); err == nil {
+			t.Errorf("Expected '%s' to be invalid.", value)
+		}
+	}
+}
+
+func TestSecretField(t *testing.T) {
+	secret := gatekeeper.Secret{Data: map[string]interface{}{"username": "web", "port": float64(5432)}}
+	kv2 := gatekeeper.Secret{Data: map[string]interface{}{
+		"data":     map[string]interface{}{"password": "hunter2"},
+		"metadata": map[string]interface{}{"version": float64(3)},
+	}}
+	for _, c := range []struct {
+		secret   gatekeeper.Secret
+		field    string
+		expected string
+	}{
+		{secret, "username", "web"},
+		{secret, "port", "5432"},
+		{secret, "", `{"port":5432,"username":"web"}`},
+		{kv2, "password", "hunter2"},
+	} {
+		value, err := secretField(c.secret, secretRef{Path: "secret/web", Field: c.field})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != c.expected {
+			t.Errorf("Expected field '%s' to be '%s', got '%s'.", c.field, c.expected, value)
+		}
+	}
+	if _, err := secretField(secret, secretRef{Path: "secret/web", Field: "password"}); err == nil {
+		t.Error("Expected a missing field to fail.")
+	}
+}
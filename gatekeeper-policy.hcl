@@ -16,4 +16,22 @@ path "auth/token/create-orphan" {
 // Policy Reading
 path "secret/gatekeeper" {
 	capabilities = ["read"]
+}
+
+// Entity aliases, only needed with ENTITY_ALIAS_ACCESSOR
+path "identity/lookup/entity" {
+	capabilities = ["update"]
+}
+
+path "identity/entity" {
+	capabilities = ["update"]
+}
+
+path "identity/entity-alias" {
+	capabilities = ["update"]
+}
+
+// Revoking the tokens issued to the tasks of an app or agent
+path "auth/token/revoke-accessor" {
+	capabilities = ["update"]
 }
\ No newline at end of file
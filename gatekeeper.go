@@ -10,6 +10,7 @@ import (
 	"github.com/franela/goreq"
 	"github.com/gin-gonic/gin"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -34,24 +35,94 @@ var config struct {
 		CaCert     string
 		CaPath     string
 		GkPolicies string
+		Namespace  string
+		Backends   string
+		Tenants    string
+
+		Retries          int
+		RetryBackoff     time.Duration
+		BreakerThreshold int
+		BreakerTimeout   time.Duration
+		Timeout          time.Duration
+		ConnectTimeout   time.Duration
 	}
 	SelfRecreate     bool
+	AdminToken       string
+	AdminClientNames string
 	ListenAddress    string
+	GrpcListen       string
+	DebugListen      string
 	TlsCert          string
 	TlsKey           string
+	TlsClientCa      string
+	TlsClientAuth    string
 	Mesos            string
+	MesosApi         string
+	MesosPrincipal   string
+	MesosSecret      string
+	MesosTls         bool
+	MesosCaCert      string
+	MesosInsecure    bool
+	MesosTaskCache   bool
+	MesosTimeout     time.Duration
+	MesosConnect     time.Duration
+	InstanceSlack    int
+	MatchJobNames    bool
+	Marathon         string
 	MaxTaskLife      time.Duration
+	PolicyRequired   bool
+	Attestors        string
+
+	SpiffeTrustDomain string
+	SpiffeTaskName    string
+	RequestSigning    string
+	AgentSecrets      string
+
+	DrainTimeout      time.Duration
+	RequestTimeout    time.Duration
+	ConfigFile        string
+	LogLevel          string
+	RateLimit         float64
+	RateLimitBurst    int
+	IpRateLimit       float64
+	IpRateLimitBurst  int
+	AuditFile         string
+	StateFile         string
+	AccessorRetention time.Duration
+	AuditSyslog       bool
+	HookUrl           string
+	HookKafkaBrokers  string
+	HookKafkaTopic    string
+	HookTimeout       time.Duration
+	TracingEndpoint   string
+
+	TokenResponseVersion int
+
+	Standby          bool
+	StandbyAddress   string
+	ReplicationToken string
+	FailoverTimeout  time.Duration
+
+	EntityAliasTemplate string
+	EntityAliasAccessor string
+	EntityAliasRole     string
+
 	AppIdAuth        AppIdUnsealer
 	CubbyAuth        CubbyUnsealer
 	WrappedTokenAuth WrappedTokenUnsealer
+	AppRoleAuth      AppRoleUnsealer
+	KubernetesAuth   KubernetesUnsealer
+	UnsealChain      string
+	AuthMount        string
 }
 
 var state struct {
 	Status GkStatus `json:"status"`
 	Stats  struct {
-		Requests   int32 `json:"requests"`
-		Successful int32 `json:"successful"`
-		Denied     int32 `json:"denied"`
+		Requests    int32 `json:"requests"`
+		Successful  int32 `json:"successful"`
+		Denied      int32 `json:"denied"`
+		RateLimited int32 `json:"rate_limited"`
 	} `json:"stats"`
 	Started  time.Time     `json:"started"`
 	Token    string        `json:"-"`
@@ -74,14 +145,65 @@ func defaultEnvVar(key string, def string) (val string) {
 }
 
 func init() {
+	flag.StringVar(&config.ConfigFile, "config", defaultEnvVar("CONFIG_FILE", ""), "Path to a HCL or JSON configuration file. Flags given on the command line take precedence over the file. (Overrides the CONFIG_FILE environment variable if set.)")
+	flag.StringVar(&config.LogLevel, "log-level", defaultEnvVar("LOG_LEVEL", "info"), "Either 'debug', 'info' or 'warn'. The http access log is only written at the 'debug' and 'info' levels. (Overrides the LOG_LEVEL environment variable if set.)")
 	flag.StringVar(&config.ListenAddress, "listen", defaultEnvVar("LISTEN_ADDR", ":9201"), "Hostname and port to listen on. (Overrides the LISTEN_ADDR environment variable if set.)")
+	flag.StringVar(&config.GrpcListen, "grpc-listen", defaultEnvVar("GRPC_LISTEN_ADDR", ""), "Hostname and port to serve the gRPC api on. If unset, the gRPC api is disabled. (Overrides the GRPC_LISTEN_ADDR environment variable if set.)")
+	flag.StringVar(&config.DebugListen, "debug-listen", defaultEnvVar("DEBUG_LISTEN_ADDR", ""), "Hostname and port to serve the pprof handlers and /debug/vars on, preferably a loopback address. If unset, they are disabled. (Overrides the DEBUG_LISTEN_ADDR environment variable if set.)")
 	flag.StringVar(&config.TlsCert, "tls-cert", defaultEnvVar("TLS_CERT", ""), "Path to TLS certificate. If this value is set, gatekeeper will be served over TLS.")
 	flag.StringVar(&config.TlsKey, "tls-key", defaultEnvVar("TLS_KEY", ""), "Path to TLS key. If this value is set, gatekeeper will be served over TLS.")
 
+	flag.StringVar(&config.TlsClientCa, "tls-client-ca", defaultEnvVar("TLS_CLIENT_CA", ""), "Path to a PEM encoded CA cert file or directory used to verify client certificates.")
+	flag.StringVar(&config.TlsClientAuth, "tls-client-auth", defaultEnvVar("TLS_CLIENT_AUTH", "none"), "Whether clients must present a certificate signed by TLS_CLIENT_CA ('none', 'verify' if presented, or 'require').")
+	flag.StringVar(&config.AdminToken, "admin-token", defaultEnvVar("ADMIN_TOKEN", ""), "Shared secret required to access the admin API. If unset, the admin API is disabled. (Overrides the ADMIN_TOKEN environment variable if set.)")
+
+	flag.StringVar(&config.AdminClientNames, "admin-client-names", defaultEnvVar("ADMIN_CLIENT_NAMES", ""), "Comma separated list of the names (common name or DNS name) of client certificates allowed to access the admin API. Requires TLS_CLIENT_CA. (Overrides the ADMIN_CLIENT_NAMES environment variable if set.)")
+
 	flag.StringVar(&config.Mesos, "mesos", defaultEnvVar("MESOS_MASTER", ""), "Address to mesos master. (Overrides the MESOS_MASTER environment variable if set.)")
 
-	flag.StringVar(&config.Vault.Server, "vault", defaultEnvVar("VAULT_ADDR", ""), "Address to vault server. (Overrides the VAULT_ADDR environment variable if set.)")
-	flag.StringVar(&config.Vault.GkPolicies, "policies", defaultEnvVar("GATE_POLICIES", "/gatekeeper"), "Path to the json formatted policies configuration file on the vault generic backend.")
+	flag.StringVar(&config.MesosApi, "mesos-api", defaultEnvVar("MESOS_API", "state"), "How to look up tasks on the mesos master, either 'state' (/state.json) or 'v1' (the v1 operator API). (Overrides the MESOS_API environment variable if set.)")
+	flag.StringVar(&config.MesosPrincipal, "mesos-principal", defaultEnvVar("MESOS_PRINCIPAL", ""), "Principal to authenticate to the mesos master with using HTTP basic auth. (Overrides the MESOS_PRINCIPAL environment variable if set.)")
+	flag.StringVar(&config.MesosSecret, "mesos-secret", defaultEnvVar("MESOS_SECRET", ""), "Secret for the mesos principal. (Overrides the MESOS_SECRET environment variable if set.)")
+	flag.BoolVar(&config.MesosTls, "mesos-tls", func() bool {
+		b, err := strconv.ParseBool(defaultEnvVar("MESOS_TLS", "0"))
+		return err == nil && b
+	}(), "Connect to the mesos masters over https. This is implied by a https MESOS_MASTER address. (Overrides the MESOS_TLS environment variable if set.)")
+	flag.StringVar(&config.MesosCaCert, "mesos-ca-cert", defaultEnvVar("MESOS_CACERT", ""), "Path to a PEM encoded CA cert file or directory to verify the mesos master SSL certificate. (Overrides the MESOS_CACERT environment variable if set.)")
+	flag.BoolVar(&config.MesosInsecure, "mesos-skip-verify", func() bool {
+		b, err := strconv.ParseBool(defaultEnvVar("MESOS_SKIP_VERIFY", "0"))
+		return err == nil && b
+	}(), "Do not verify the mesos master TLS certificate. (Overrides the MESOS_SKIP_VERIFY environment variable if set.)")
+	flag.BoolVar(&config.MesosTaskCache, "mesos-task-cache", func() bool {
+		b, err := strconv.ParseBool(defaultEnvVar("MESOS_TASK_CACHE", "0"))
+		return err == nil && b
+	}(), "Cache the running tasks by subscribing to the event stream of the mesos master, instead of querying the master on every request. (Overrides the MESOS_TASK_CACHE environment variable if set.)")
+	flag.IntVar(&config.InstanceSlack, "instance-slack", func() int {
+		i, err := strconv.Atoi(defaultEnvVar("INSTANCE_SLACK", "1"))
+		if err != nil {
+			return 1
+		}
+		return i
+	}(), "How many more tokens than running instances the tasks of an app with max_instances may be issued within its task life. (Overrides the INSTANCE_SLACK environment variable if set.)")
+	flag.StringVar(&config.Marathon, "marathon-url", defaultEnvVar("MARATHON_URL", ""), "Address of marathon. If set, deployed apps without a matching policy are reported. (Overrides the MARATHON_URL environment variable if set.)")
+
+	flag.StringVar(&config.Vault.Server, "vault", defaultEnvVar("VAULT_ADDR", ""), "Address to vault server, or a comma separated list of the addresses of the nodes of a vault HA cluster. (Overrides the VAULT_ADDR environment variable if set.)")
+	flag.StringVar(&config.Vault.GkPolicies, "policies", defaultEnvVar("GATE_POLICIES", "/gatekeeper"), "Path to the json formatted policies configuration file on the vault generic backend, or a file://, http(s)://, consul:// or consul+https:// url to load it from instead. (Overrides the GATE_POLICIES environment variable if set.)")
+	flag.BoolVar(&config.PolicyRequired, "policy-required", func() bool {
+		b, err := strconv.ParseBool(defaultEnvVar("POLICY_REQUIRED", "0"))
+		return err == nil && b
+	}(), "Reject token requests of tasks that have no policy of their own, rather than falling back to the '*' policy. (Overrides the POLICY_REQUIRED environment variable if set.)")
+	flag.StringVar(&config.Attestors, "attestors", defaultEnvVar("ATTESTORS", "mesos"), "Comma separated list of the attestors that verify the identity of the tasks requesting tokens, in the order they are tried. (Overrides the ATTESTORS environment variable if set.)")
+	flag.StringVar(&config.SpiffeTrustDomain, "spiffe-trust-domain", defaultEnvVar("SPIFFE_TRUST_DOMAIN", ""), "The SPIFFE trust domain that the spiffe attestor accepts SVIDs of. (Overrides the SPIFFE_TRUST_DOMAIN environment variable if set.)")
+	flag.StringVar(&config.RequestSigning, "request-signing", defaultEnvVar("REQUEST_SIGNING", "off"), "Whether token requests must be signed with the secret of the agent of the task ('off', 'optional' to verify signed requests only, or 'required'). (Overrides the REQUEST_SIGNING environment variable if set.)")
+	flag.StringVar(&config.AgentSecrets, "agent-secrets", defaultEnvVar("AGENT_SECRETS", ""), "Path to a json file of the secrets of the mesos agents by agent id or hostname, which token requests are signed with. (Overrides the AGENT_SECRETS environment variable if set.)")
+	flag.StringVar(&config.SpiffeTaskName, "spiffe-task-name", defaultEnvVar("SPIFFE_TASK_NAME", "{{.ID}}"), "Template of the task name, which policies are matched by, that the spiffe attestor derives from a SPIFFE ID. (Overrides the SPIFFE_TASK_NAME environment variable if set.)")
+	flag.BoolVar(&config.MatchJobNames, "match-job-names", func() bool {
+		b, err := strconv.ParseBool(defaultEnvVar("MATCH_JOB_NAMES", "0"))
+		return err == nil && b
+	}(), "Match the policies of tasks launched by Chronos or Metronome by the name of their job, rather than by their task name. (Overrides the MATCH_JOB_NAMES environment variable if set.)")
+	flag.StringVar(&config.Vault.Namespace, "vault-namespace", defaultEnvVar("VAULT_NAMESPACE", ""), "Vault Enterprise namespace to make all vault requests in. Can be overridden per policy. (Overrides the VAULT_NAMESPACE environment variable if set.)")
+	flag.StringVar(&config.Vault.Tenants, "tenants", defaultEnvVar("TENANTS", ""), "Path to a json file describing tenants, each with its own policies, vault backend and namespace, served under /t/<tenant> or on their own listen address. (Overrides the TENANTS environment variable if set.)")
+	flag.StringVar(&config.Vault.Backends, "vault-backends", defaultEnvVar("VAULT_BACKENDS", ""), "Path to a json file describing additional named vault servers that policies can create tokens with. (Overrides the VAULT_BACKENDS environment variable if set.)")
 	flag.BoolVar(&config.Vault.Insecure, "tls-skip-verify", func() bool {
 		b, err := strconv.ParseBool(defaultEnvVar("VAULT_SKIP_VERIFY", "0"))
 		return err == nil && b
@@ -93,24 +215,157 @@ func init() {
 	flag.StringVar(&config.CubbyAuth.Path, "cubby-path", defaultEnvVar("CUBBY_PATH", "/vault-token"), "Path to key in cubbyhole. By default this is /vault-token.")
 
 	flag.StringVar(&config.WrappedTokenAuth.TempToken, "wrapped-token-auth", defaultEnvVar("WRAPPED_TOKEN_AUTH", ""), "Temporary vault authorization token that has a wrapped permanent vault token.")
+	flag.StringVar(&config.WrappedTokenAuth.TempTokenPath, "wrapped-token-auth-file", defaultEnvVar("WRAPPED_TOKEN_AUTH_FILE", ""), "Path to a file containing a temporary vault authorization token that has a wrapped permanent vault token. (Overrides the WRAPPED_TOKEN_AUTH_FILE environment variable if set.)")
+
+	flag.StringVar(&config.AppRoleAuth.RoleId, "auth-role-id", defaultEnvVar("ROLE_ID", ""), "Vault AppRole role id for authentication. (Overrides the ROLE_ID environment variable if set.)")
+	flag.StringVar(&config.AppRoleAuth.SecretId, "auth-secret-id", defaultEnvVar("SECRET_ID", ""), "Vault AppRole secret id for authentication. (Overrides the SECRET_ID environment variable if set.)")
+
+	flag.StringVar(&config.KubernetesAuth.Role, "auth-kubernetes-role", defaultEnvVar("KUBERNETES_ROLE", ""), "Vault Kubernetes auth role to authenticate as with the pod's service account token. (Overrides the KUBERNETES_ROLE environment variable if set.)")
+	flag.StringVar(&config.KubernetesAuth.JwtPath, "auth-kubernetes-jwt", defaultEnvVar("KUBERNETES_JWT_PATH", defaultKubernetesJwtPath), "Path to the Kubernetes service account token. (Overrides the KUBERNETES_JWT_PATH environment variable if set.)")
+
+	flag.StringVar(&config.AppIdAuth.MountPath, "auth-appid-mount", defaultEnvVar("APP_ID_MOUNT", ""), "Path the app-id auth backend is mounted at. (Overrides the APP_ID_MOUNT environment variable if set.)")
+	flag.StringVar(&config.AppRoleAuth.MountPath, "auth-approle-mount", defaultEnvVar("APPROLE_MOUNT", ""), "Path the AppRole auth backend is mounted at. (Overrides the APPROLE_MOUNT environment variable if set.)")
+	flag.StringVar(&config.KubernetesAuth.MountPath, "auth-kubernetes-mount", defaultEnvVar("KUBERNETES_MOUNT", ""), "Path the Kubernetes auth backend is mounted at. (Overrides the KUBERNETES_MOUNT environment variable if set.)")
+	flag.StringVar(&config.AuthMount, "auth-mount", defaultEnvVar("AUTH_MOUNT", ""), "Path the auth backend used to unseal at startup is mounted at, for unsealers without a mount path of their own. (Overrides the AUTH_MOUNT environment variable if set.)")
+
+	flag.StringVar(&config.UnsealChain, "unseal-chain", defaultEnvVar("UNSEAL_CHAIN", ""), "Comma separated list of unseal methods to try in order at startup, and again when gatekeeper is sealed because its token expired. (Overrides the UNSEAL_CHAIN environment variable if set.)")
 
 	flag.StringVar(&config.AppIdAuth.AppId, "auth-appid", defaultEnvVar("APP_ID", ""), "Vault App Id for authenication. (Overrides the APP_ID environment variable if set.)")
-	flag.StringVar(&config.AppIdAuth.UserIdMethod, "auth-userid-method", defaultEnvVar("USER_ID_METHOD", ""), "Vault User Id authenication method (either 'mac' or 'file'). (Overrides the USER_ID_METHOD environment variable if set.)")
+	flag.StringVar(&config.AppIdAuth.UserIdMethod, "auth-userid-method", defaultEnvVar("USER_ID_METHOD", ""), "Vault User Id authenication method ('mac', 'file', 'instance-id', 'dmi' or 'hostname'). (Overrides the USER_ID_METHOD environment variable if set.)")
 	flag.StringVar(&config.AppIdAuth.UserIdInterface, "auth-userid-interface", defaultEnvVar("USER_ID_INTERFACE", ""), "Network interface for 'mac' user id authenication method. (Overrides the USER_ID_INTERFACE environment variable if set.)")
 	flag.StringVar(&config.AppIdAuth.UserIdPath, "auth-userid-path", defaultEnvVar("USER_ID_PATH", ""), "File path for 'file' user id authenication method. (Overrides the USER_ID_PATH environment variable if set.)")
-	flag.StringVar(&config.AppIdAuth.UserIdHash, "auth-userid-hash", defaultEnvVar("USER_ID_HASH", ""), "Hash the user id with the following algorithim (sha256, sha1, md5). The hex representation of the hash will be used. (Overrides the USER_ID_HASH environment variable if set.)")
+	flag.StringVar(&config.AppIdAuth.UserIdHash, "auth-userid-hash", defaultEnvVar("USER_ID_HASH", ""), "Hash the user id with the following algorithim (sha512, sha256, sha1, md5, hmac-sha256, hmac-sha512). The hex representation of the hash will be used. (Overrides the USER_ID_HASH environment variable if set.)")
 	flag.StringVar(&config.AppIdAuth.UserIdSalt, "auth-userid-salt", defaultEnvVar("USER_ID_SALT", ""), "If hashing, salt the hash in the format 'salt$user_id'. (Overrides the USER_ID_SALT environment variable if set.)")
+	flag.StringVar(&config.AppIdAuth.UserIdCloud, "auth-userid-cloud", defaultEnvVar("USER_ID_CLOUD", ""), "Cloud whose metadata service the 'instance-id' user id authenication method reads the instance id from (either 'ec2' or 'gce'). (Overrides the USER_ID_CLOUD environment variable if set.)")
+	flag.StringVar(&config.AppIdAuth.UserIdHmacKey, "auth-userid-hmac-key", defaultEnvVar("USER_ID_HMAC_KEY", ""), "Key of the 'hmac-sha256' and 'hmac-sha512' user id hashes. (Overrides the USER_ID_HMAC_KEY environment variable if set.)")
 
 	flag.BoolVar(&config.SelfRecreate, "self-recreate-token", func() bool {
 		b, err := strconv.ParseBool(defaultEnvVar("RECREATE_TOKEN", "0"))
 		return err == nil && b
 	}(), "When the current token is reaching it's MAX_TTL (720h by default), recreate the token with the same policy instead of trying to renew (requires a sudo/root token, and for the token to have a ttl).")
 
+	flag.Float64Var(&config.RateLimit, "rate-limit", func() float64 {
+		f, _ := strconv.ParseFloat(defaultEnvVar("RATE_LIMIT", "0"), 64)
+		return f
+	}(), "Maximum number of token requests per second accepted from all clients. 0 disables the limit. (Overrides the RATE_LIMIT environment variable if set.)")
+	flag.IntVar(&config.RateLimitBurst, "rate-limit-burst", func() int {
+		i, _ := strconv.Atoi(defaultEnvVar("RATE_LIMIT_BURST", "0"))
+		return i
+	}(), "Number of token requests from all clients allowed in a burst above the rate limit. (Overrides the RATE_LIMIT_BURST environment variable if set.)")
+	flag.Float64Var(&config.IpRateLimit, "ip-rate-limit", func() float64 {
+		f, _ := strconv.ParseFloat(defaultEnvVar("IP_RATE_LIMIT", "0"), 64)
+		return f
+	}(), "Maximum number of token requests per second accepted from a single ip. 0 disables the limit. (Overrides the IP_RATE_LIMIT environment variable if set.)")
+	flag.IntVar(&config.IpRateLimitBurst, "ip-rate-limit-burst", func() int {
+		i, _ := strconv.Atoi(defaultEnvVar("IP_RATE_LIMIT_BURST", "0"))
+		return i
+	}(), "Number of token requests from a single ip allowed in a burst above the rate limit. (Overrides the IP_RATE_LIMIT_BURST environment variable if set.)")
+
+	flag.StringVar(&config.StateFile, "state-file", defaultEnvVar("STATE_FILE", ""), "Path to a database file that the task ids that already got a token are persisted to, so that they cannot get another token after a restart. (Overrides the STATE_FILE environment variable if set.)")
+	flag.StringVar(&config.AuditFile, "audit-file", defaultEnvVar("AUDIT_FILE", ""), "Path to a file that a json record of every token request is appended to. The file is reopened on SIGHUP. (Overrides the AUDIT_FILE environment variable if set.)")
+	flag.BoolVar(&config.AuditSyslog, "audit-syslog", func() bool {
+		b, err := strconv.ParseBool(defaultEnvVar("AUDIT_SYSLOG", "0"))
+		return err == nil && b
+	}(), "Send a json record of every token request to syslog. (Overrides the AUDIT_SYSLOG environment variable if set.)")
+
+	flag.StringVar(&config.HookUrl, "hook-url", defaultEnvVar("HOOK_URL", ""), "URL that a json event is POSTed to whenever a token is issued or denied, or loading the policies fails. (Overrides the HOOK_URL environment variable if set.)")
+	flag.StringVar(&config.HookKafkaBrokers, "hook-kafka-brokers", defaultEnvVar("HOOK_KAFKA_BROKERS", ""), "Comma separated list of kafka brokers that hook events are published to. (Overrides the HOOK_KAFKA_BROKERS environment variable if set.)")
+	flag.StringVar(&config.HookKafkaTopic, "hook-kafka-topic", defaultEnvVar("HOOK_KAFKA_TOPIC", "vault-gatekeeper"), "Kafka topic that hook events are published to. (Overrides the HOOK_KAFKA_TOPIC environment variable if set.)")
+	if d, err := time.ParseDuration(defaultEnvVar("HOOK_TIMEOUT", "5s")); err == nil {
+		flag.DurationVar(&config.HookTimeout, "hook-timeout", d, "Timeout for delivering a hook event. (Overrides the HOOK_TIMEOUT environment variable if set.)")
+	} else {
+		panic(d)
+	}
+
+	flag.StringVar(&config.TracingEndpoint, "tracing-endpoint", defaultEnvVar("TRACING_ENDPOINT", ""), "URL of an OTLP/HTTP collector, e.g. 'http://otel-collector:4318', that spans of token requests are exported to. If unset, tracing is disabled. (Overrides the TRACING_ENDPOINT environment variable if set.)")
+
+	flag.BoolVar(&config.Standby, "standby", func() bool {
+		b, err := strconv.ParseBool(defaultEnvVar("STANDBY", "0"))
+		return err == nil && b
+	}(), "Start as the warm standby of an active gatekeeper, which mirrors its state and provides no tokens until it is promoted. (Overrides the STANDBY environment variable if set.)")
+	flag.StringVar(&config.StandbyAddress, "standby-addr", defaultEnvVar("STANDBY_ADDR", ""), "Address of the warm standby, e.g. 'https://gatekeeper-standby:9201', that the used task ids and policies are replicated to. (Overrides the STANDBY_ADDR environment variable if set.)")
+	flag.StringVar(&config.ReplicationToken, "replication-token", defaultEnvVar("REPLICATION_TOKEN", ""), "Shared secret the active and standby gatekeepers authenticate replication with. (Overrides the REPLICATION_TOKEN environment variable if set.)")
+	if d, err := time.ParseDuration(defaultEnvVar("FAILOVER_TIMEOUT", "15s")); err == nil {
+		flag.DurationVar(&config.FailoverTimeout, "failover-timeout", d, "How long the standby waits without hearing from the active gatekeeper before it promotes itself. 0 disables automatic promotion. (Overrides the FAILOVER_TIMEOUT environment variable if set.)")
+	} else {
+		panic(d)
+	}
+
+	flag.StringVar(&config.EntityAliasTemplate, "entity-alias", defaultEnvVar("ENTITY_ALIAS", ""), "Template of the vault entity alias that tokens are attached to, for example '{{.AppID}}'. Policies can override it with 'entity_alias'. (Overrides the ENTITY_ALIAS environment variable if set.)")
+	flag.StringVar(&config.EntityAliasRole, "entity-alias-role", defaultEnvVar("ENTITY_ALIAS_ROLE", ""), "Token role that tokens attached to an entity alias are created with. The role must allow the aliases. (Overrides the ENTITY_ALIAS_ROLE environment variable if set.)")
+	flag.StringVar(&config.EntityAliasAccessor, "entity-alias-accessor", defaultEnvVar("ENTITY_ALIAS_ACCESSOR", ""), "Accessor of the token auth backend. If set, gatekeeper creates an entity named after each alias before attaching tokens to it. (Overrides the ENTITY_ALIAS_ACCESSOR environment variable if set.)")
+
 	if d, err := time.ParseDuration(defaultEnvVar("TASK_LIFE", "2m")); err == nil {
 		flag.DurationVar(&config.MaxTaskLife, "task-life", d, "The maximum amount of time that a task can be alive during which it can ask for a authorization token.")
 	} else {
 		panic(d)
 	}
+	flag.IntVar(&config.Vault.Retries, "vault-retries", func() int {
+		i, err := strconv.Atoi(defaultEnvVar("VAULT_RETRIES", "3"))
+		if err != nil {
+			return 3
+		}
+		return i
+	}(), "Number of times a vault request is retried after a network or server error. (Overrides the VAULT_RETRIES environment variable if set.)")
+	if d, err := time.ParseDuration(defaultEnvVar("VAULT_RETRY_BACKOFF", "100ms")); err == nil {
+		flag.DurationVar(&config.Vault.RetryBackoff, "vault-retry-backoff", d, "Initial wait before retrying a vault request, which doubles with every retry. (Overrides the VAULT_RETRY_BACKOFF environment variable if set.)")
+	} else {
+		panic(d)
+	}
+	flag.IntVar(&config.Vault.BreakerThreshold, "vault-breaker-threshold", func() int {
+		i, err := strconv.Atoi(defaultEnvVar("VAULT_BREAKER_THRESHOLD", "5"))
+		if err != nil {
+			return 5
+		}
+		return i
+	}(), "Number of consecutive failed vault requests after which requests to that vault server fail immediately. 0 disables the circuit breaker. (Overrides the VAULT_BREAKER_THRESHOLD environment variable if set.)")
+	if d, err := time.ParseDuration(defaultEnvVar("VAULT_BREAKER_TIMEOUT", "30s")); err == nil {
+		flag.DurationVar(&config.Vault.BreakerTimeout, "vault-breaker-timeout", d, "How long requests to a vault server fail immediately once its circuit breaker has tripped. (Overrides the VAULT_BREAKER_TIMEOUT environment variable if set.)")
+	} else {
+		panic(d)
+	}
+	if d, err := time.ParseDuration(defaultEnvVar("VAULT_TIMEOUT", "30s")); err == nil {
+		flag.DurationVar(&config.Vault.Timeout, "vault-timeout", d, "Timeout for a single vault request, including reading the response. (Overrides the VAULT_TIMEOUT environment variable if set.)")
+	} else {
+		panic(d)
+	}
+	if d, err := time.ParseDuration(defaultEnvVar("VAULT_CONNECT_TIMEOUT", "5s")); err == nil {
+		flag.DurationVar(&config.Vault.ConnectTimeout, "vault-connect-timeout", d, "Timeout for connecting to a vault server. (Overrides the VAULT_CONNECT_TIMEOUT environment variable if set.)")
+	} else {
+		panic(d)
+	}
+	if d, err := time.ParseDuration(defaultEnvVar("MESOS_TIMEOUT", "30s")); err == nil {
+		flag.DurationVar(&config.MesosTimeout, "mesos-timeout", d, "Timeout for a single mesos master request, including reading the response. (Overrides the MESOS_TIMEOUT environment variable if set.)")
+	} else {
+		panic(d)
+	}
+	if d, err := time.ParseDuration(defaultEnvVar("MESOS_CONNECT_TIMEOUT", "5s")); err == nil {
+		flag.DurationVar(&config.MesosConnect, "mesos-connect-timeout", d, "Timeout for connecting to a mesos master. (Overrides the MESOS_CONNECT_TIMEOUT environment variable if set.)")
+	} else {
+		panic(d)
+	}
+	if d, err := time.ParseDuration(defaultEnvVar("REQUEST_TIMEOUT", "60s")); err == nil {
+		flag.DurationVar(&config.RequestTimeout, "request-timeout", d, "Deadline for handling a token request, including all the vault and mesos requests made for it. (Overrides the REQUEST_TIMEOUT environment variable if set.)")
+	} else {
+		panic(d)
+	}
+	flag.IntVar(&config.TokenResponseVersion, "token-response-version", func() int {
+		i, err := strconv.Atoi(defaultEnvVar("TOKEN_RESPONSE_VERSION", "1"))
+		if err != nil {
+			return 1
+		}
+		return i
+	}(), "Version of the /token response given to clients that don't ask for a version. (Overrides the TOKEN_RESPONSE_VERSION environment variable if set.)")
+	if d, err := time.ParseDuration(defaultEnvVar("DRAIN_TIMEOUT", "30s")); err == nil {
+		flag.DurationVar(&config.DrainTimeout, "drain-timeout", d, "How long to wait for requests in flight to finish when shutting down. (Overrides the DRAIN_TIMEOUT environment variable if set.)")
+	} else {
+		panic(d)
+	}
+	if d, err := time.ParseDuration(defaultEnvVar("ACCESSOR_RETENTION", "768h")); err == nil {
+		flag.DurationVar(&config.AccessorRetention, "accessor-retention", d, "How long the accessors of issued tokens are kept for revocation. Should be at least the max ttl of the tokens. (Overrides the ACCESSOR_RETENTION environment variable if set.)")
+	} else {
+		panic(d)
+	}
 }
 
 func recreateToken(token string, policies []string, ttl int) (string, error) {
@@ -131,16 +386,18 @@ func recreateToken(token string, policies []string, ttl int) (string, error) {
 	}
 }
 
-func renew(token string, ttl int) error {
-	r, err := VaultRequest{goreq.Request{
-		Uri: vaultPath("/v1/auth/token/renew-self", ""),
+// Renews gatekeeper's token for the vault backend (or the default vault server
+// if nil) with the given ttl.
+func renew(backend *vaultBackend, token string, ttl int) error {
+	r, err := VaultRequest{Request: goreq.Request{
+		Uri: backend.path("/v1/auth/token/renew-self", ""),
 		Body: struct {
 			Increment int `json:"increment"`
 		}{ttl},
 		Method:          "POST",
 		MaxRedirects:    10,
 		RedirectHeaders: true,
-	}.WithHeader("X-Vault-Token", token)}.Do()
+	}.WithHeader("X-Vault-Token", token), Namespace: backend.namespace()}.Do()
 	if err == nil {
 		defer r.Body.Close()
 		switch r.StatusCode {
@@ -164,7 +421,7 @@ func renew(token string, ttl int) error {
 func renew_worker(token string, onUnsealed <-chan struct{}) {
 	creationTtl := 0
 	for {
-		r, err := VaultRequest{goreq.Request{
+		r, err := VaultRequest{Request: goreq.Request{
 			Uri:             vaultPath("/v1/auth/token/lookup-self", ""),
 			MaxRedirects:    10,
 			RedirectHeaders: true,
@@ -204,11 +461,12 @@ func renew_worker(token string, onUnsealed <-chan struct{}) {
 					select {
 					case <-time.After(time.Duration(tokenInfo.Data.Ttl) * time.Second):
 						log.Printf("Renewing token with ttl of %v.", time.Duration(tokenInfo.Data.CreationTtl)*time.Second)
-						if err := renew(token, tokenInfo.Data.CreationTtl); err == nil {
+						if err := renew(nil, token, tokenInfo.Data.CreationTtl); err == nil {
 							log.Printf("Renewed token with ttl of %v.", time.Duration(tokenInfo.Data.CreationTtl)*time.Second)
 						} else {
 							log.Println("Failed to renew token. Sealing gatekeeper.")
 							seal()
+							go unsealAgain()
 							return
 						}
 					case <-onUnsealed:
@@ -221,6 +479,7 @@ func renew_worker(token string, onUnsealed <-chan struct{}) {
 			} else if r.StatusCode == 403 {
 				log.Println("Token is no longer valid. Sealing gatekeeper.")
 				seal()
+				go unsealAgain()
 				return
 			} else {
 				log.Printf("Failed to lookup token. Error Code: %d", r.StatusCode)
@@ -240,15 +499,24 @@ func unseal(unsealer Unsealer) error {
 		return errAlreadyUnsealed
 	}
 	if token, err := unsealer.Token(); err == nil {
-		if err := (&activePolicies).Load(token); err != nil {
+		if err := activePolicies.Load(token); err != nil {
 			log.Printf("Failed to load policies: %v", err)
+			hooks.NotifyPolicyReloadFailed(policySourceName(config.Vault.GkPolicies), err)
 			return err
 		}
+		loadTenantPolicies(token)
 		log.Printf("The gate has been unsealed with method '%s'.", unsealer.Name())
 		state.Token = token
 		state.Status = StatusUnsealed
 		state.OnSealed = make(chan struct{})
 		go renew_worker(token, state.OnSealed)
+		for _, backend := range vaultBackends {
+			go func(backend *vaultBackend) {
+				if _, err := backend.Token(); err != nil {
+					log.Printf("Failed to log in to vault backend '%s': %v", backend.Name, err)
+				}
+			}(backend)
+		}
 		return nil
 	} else {
 		return err
@@ -265,33 +533,33 @@ func seal() error {
 	state.OnSealed = nil
 	state.Token = ""
 	state.Status = StatusSealed
+	for _, backend := range vaultBackends {
+		backend.reset()
+	}
 	return nil
 }
 
 func vaultPath(path string, query string) string {
-	u, _ := url.Parse(config.Vault.Server)
+	u, _ := url.Parse(vaultAddresses.Current())
 	u.Path = path
 	u.RawQuery = query
 	return u.String()
 }
 
-func intro() {
-	fmt.Println(" __")
-	fmt.Println("/__ _ _|_ _ |/  _  _ |_) _  __")
-	fmt.Println("\\_|(_| |_(/_|\\ (/_(/_|  (/_ |")
-	fmt.Println("github.com/channelmeter/vault-gatekeeper-mesos")
-	fmt.Println("Version: " + gitNearestTag)
+// defaultAuthMounts mounts the unseal methods that don't set their own mount
+// path at AUTH_MOUNT.
+func defaultAuthMounts() {
+	for _, mountPath := range []*string{&config.AppIdAuth.MountPath, &config.AppRoleAuth.MountPath, &config.KubernetesAuth.MountPath} {
+		if *mountPath == "" {
+			*mountPath = config.AuthMount
+		}
+	}
 }
 
-func main() {
-	// gin-gonic disables the log flags
-	log.SetFlags(log.LstdFlags)
-	state.Status = StatusSealed
-	state.Started = time.Now()
-	flag.Parse()
-
-	intro()
-
+// configureVaultTransport sets up the client vault is talked to with, using the
+// configured connect timeout, CA and TLS verification.
+func configureVaultTransport() error {
+	goreq.SetConnectTimeout(config.Vault.ConnectTimeout)
 	if config.Vault.Insecure || config.Vault.CaPath != "" || config.Vault.CaCert != "" {
 		tr := &http.Transport{
 			Dial:            goreq.DefaultDialer.Dial,
@@ -314,28 +582,297 @@ func main() {
 			if certs, err := LoadCA(); err == nil {
 				tr.TLSClientConfig.RootCAs = certs
 			} else {
-				log.Printf("Failed to read client certs.")
-				log.Println("Error:", err)
-				os.Exit(1)
+				return err
 			}
 		}
 		// TODO: Fallback to regular client when communicating with Mesos Master
 		goreq.DefaultTransport = tr
 		goreq.DefaultClient = &http.Client{Transport: goreq.DefaultTransport}
 	}
+	return nil
+}
 
-	r := gin.Default()
+func intro() {
+	fmt.Println(" __")
+	fmt.Println("/__ _ _|_ _ |/  _  _ |_) _  __")
+	fmt.Println("\\_|(_| |_(/_|\\ (/_(/_|  (/_ |")
+	fmt.Println("github.com/channelmeter/vault-gatekeeper-mesos")
+	fmt.Println("Version: " + gitNearestTag)
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fetch" {
+		if err := runFetch(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Gatekeeper: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "policy" {
+		if err := runPolicy(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Gatekeeper: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "tokens" {
+		if err := runTokens(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Gatekeeper: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "selftest" {
+		if err := runSelftest(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Gatekeeper: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// gin-gonic disables the log flags
+	log.SetFlags(log.LstdFlags)
+	state.Status = StatusSealed
+	state.Started = time.Now()
+	flag.Parse()
+
+	intro()
+
+	if config.ConfigFile != "" {
+		if err := applyConfigFile(config.ConfigFile, nil); err != nil {
+			log.Println("Failed to load config file.")
+			log.Println("Error:", err)
+			os.Exit(1)
+		}
+	}
+	if !validLogLevel(config.LogLevel) {
+		log.Printf("Unknown log level '%s'. Valid levels are 'debug', 'info' and 'warn'.", config.LogLevel)
+		os.Exit(1)
+	}
+	logLevel.Store(config.LogLevel)
+
+	defaultAuthMounts()
+
+	if err := configureVaultTransport(); err != nil {
+		log.Printf("Failed to read client certs.")
+		log.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	if err := vaultAddresses.Set(config.Vault.Server); err != nil {
+		log.Println("Invalid vault address.")
+		log.Println("Error:", err)
+		os.Exit(1)
+	}
+	if n := vaultAddresses.Len(); n > 1 {
+		log.Printf("Using %d vault addresses, starting with %s.", n, vaultAddresses.Current())
+	}
+
+	switch config.MesosApi {
+	case "state", "v1":
+	default:
+		log.Printf("Unknown mesos api '%s'. Valid values are 'state' and 'v1'.", config.MesosApi)
+		os.Exit(1)
+	}
+	if client, err := newMesosClient(); err == nil {
+		mesosClient = client
+	} else {
+		log.Printf("Failed to read mesos CA certs.")
+		log.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	if list, err := newAttestors(config.Attestors); err == nil {
+		attestors = list
+	} else {
+		log.Println("Invalid attestors.")
+		log.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	if !validResponseVersion(config.TokenResponseVersion) {
+		log.Printf("Unknown token response version %d. Valid versions are 1 and 2.", config.TokenResponseVersion)
+		os.Exit(1)
+	}
+
+	switch config.RequestSigning {
+	case signingOff:
+	case signingOptional, signingRequired:
+		if secrets, err := loadAgentSecrets(config.AgentSecrets); err == nil {
+			agentSecrets = secrets
+		} else {
+			log.Println("Failed to read the agent secrets.")
+			log.Println("Error:", err)
+			os.Exit(1)
+		}
+	default:
+		log.Printf("Unknown request signing mode '%s'. Valid modes are 'off', 'optional' and 'required'.", config.RequestSigning)
+		os.Exit(1)
+	}
+
+	if _, err := newPolicySource(config.Vault.GkPolicies); err != nil {
+		log.Println("Invalid policies location.")
+		log.Println("Error:", err)
+		os.Exit(1)
+	}
+	go watchPolicyFile(activePolicies)
+
+	if config.MesosTaskCache {
+		mesosTasks = newMesosTaskCache()
+		go mesosTasks.watch()
+	}
+
+	if config.Vault.Backends != "" {
+		if err := loadVaultBackends(config.Vault.Backends); err != nil {
+			log.Println("Failed to load vault backends.")
+			log.Println("Error:", err)
+			os.Exit(1)
+		}
+		log.Printf("Loaded %d additional vault backends.", len(vaultBackends))
+	}
+
+	if config.Vault.Tenants != "" {
+		if err := loadTenants(config.Vault.Tenants); err != nil {
+			log.Println("Failed to load tenants.")
+			log.Println("Error:", err)
+			os.Exit(1)
+		}
+		for _, t := range tenants {
+			go watchPolicyFile(t.store)
+		}
+		log.Printf("Loaded %d tenants.", len(tenants))
+	}
+
+	if config.AuditFile != "" || config.AuditSyslog {
+		var err error
+		if audit, err = NewAuditLog(config.AuditFile, config.AuditSyslog); err != nil {
+			log.Println("Failed to open audit log.")
+			log.Println("Error:", err)
+			os.Exit(1)
+		}
+		go audit.watchReopen()
+	}
+
+	if config.StateFile != "" {
+		s, err := openBoltStore(config.StateFile)
+		if err != nil {
+			log.Println("Failed to open state file.")
+			log.Println("Error:", err)
+			os.Exit(1)
+		}
+		store = s
+		n, err := restoreUsedTaskIds(store)
+		if err != nil {
+			log.Println("Failed to restore used task ids from state file.")
+			log.Println("Error:", err)
+			os.Exit(1)
+		}
+		log.Printf("Restored %d used task ids from %s.", n, config.StateFile)
+		go expireStoredTaskIds(store)
+		if n, err = restoreIssuedTokens(store); err != nil {
+			log.Println("Failed to restore the accessors of issued tokens from state file.")
+			log.Println("Error:", err)
+			os.Exit(1)
+		}
+		log.Printf("Restored %d token accessors from %s.", n, config.StateFile)
+	}
+
+	if config.HookUrl != "" || config.HookKafkaBrokers != "" {
+		var sinks []hookSink
+		if config.HookUrl != "" {
+			sinks = append(sinks, webhookSink{config.HookUrl, &http.Client{Timeout: config.HookTimeout}})
+		}
+		if config.HookKafkaBrokers != "" {
+			sink, err := newKafkaSink(config.HookKafkaBrokers, config.HookKafkaTopic, config.HookTimeout)
+			if err != nil {
+				log.Println("Failed to connect to the kafka brokers for hooks.")
+				log.Println("Error:", err)
+				os.Exit(1)
+			}
+			sinks = append(sinks, sink)
+		}
+		hooks = NewHookDispatcher(sinks...)
+	}
+
+	if config.TracingEndpoint != "" {
+		if err := setupTracing(config.TracingEndpoint); err != nil {
+			log.Println("Failed to set up tracing.")
+			log.Println("Error:", err)
+			os.Exit(1)
+		}
+		log.Printf("Exporting traces to '%s'.", config.TracingEndpoint)
+	}
+
+	if config.Standby || config.StandbyAddress != "" {
+		if config.Standby && config.StandbyAddress != "" {
+			log.Println("A standby can't have a standby of its own. Set either STANDBY or STANDBY_ADDR.")
+			os.Exit(1)
+		}
+		if config.ReplicationToken == "" {
+			log.Println("REPLICATION_TOKEN is required for warm standby replication.")
+			os.Exit(1)
+		}
+		if config.Standby {
+			replication.standby = true
+			go watchActive()
+			log.Println("Starting as a standby, waiting for the active gatekeeper to replicate its state.")
+		} else {
+			go replicateToStandby()
+			log.Printf("Replicating to the standby at '%s'.", config.StandbyAddress)
+		}
+	}
+
+	if config.RateLimit > 0 || config.IpRateLimit > 0 {
+		tokenRateLimiter = NewRateLimiter(config.RateLimit, config.RateLimitBurst, config.IpRateLimit, config.IpRateLimitBurst)
+	}
+
+	r := gin.New()
+	r.Use(accessLog(), gin.Recovery())
 	r.SetHTMLTemplate(statusPage)
 	r.GET("/", Status)
 	r.GET("/status.json", Status)
-	r.POST("/seal", Seal)
-	r.POST("/unseal", Unseal)
-	r.POST("/token", Provide)
+	r.GET("/swagger.json", Swagger)
+	r.POST("/seal", SealAuth, Seal)
+	r.POST("/unseal", SealAuth, Unseal)
+	r.POST("/token", RateLimit, Provide)
+	r.POST("/token/check", RateLimit, CheckToken)
 	r.POST("/policies/reload", ReloadPolicies)
+	r.GET("/health", Health)
+	r.GET("/ready", Ready)
+	r.GET("/health/policies", PolicyHealth)
+	r.GET("/status", AdminAuth, AdminStatus)
+	r.GET("/policies", AdminAuth, ListPolicies)
+	r.GET("/policies/*key", AdminAuth, InspectPolicy)
+	r.GET("/tokens", AdminAuth, ListTokens)
+	r.POST("/tokens/revoke", AdminAuth, RevokeTokens)
+	r.GET("/replication", AdminAuth, ReplicationStatus)
+	r.POST("/replication/promote", AdminAuth, PromoteStandby)
+	r.POST("/replication/sync", ReplicationAuth, ReplicationSync)
+	r.POST("/replication/claim", ReplicationAuth, ReplicationClaim)
+	addTenantRoutes(r.Group("/t/:tenant", TenantRoute))
 
-	if os.Getenv("VAULT_TOKEN") != "" {
+	if !adminEnabled() {
+		log.Println("The admin API is disabled, so anyone who can reach gatekeeper can seal and unseal it. Set ADMIN_TOKEN or ADMIN_CLIENT_NAMES to require authentication.")
+	}
+
+	if config.UnsealChain != "" {
+		chain, err := newUnsealerChain(config.UnsealChain)
+		if err != nil {
+			log.Println("Invalid unseal chain.")
+			log.Println("Error:", err)
+			os.Exit(1)
+		}
+		startupUnsealChain = chain
+		log.Printf("Attempting to unseal with the unseal chain '%s'...", config.UnsealChain)
+		if err := unseal(chain); err != nil {
+			log.Println("Failed to unseal using the unseal chain. Please make sure at least one of the methods is correctly setup.")
+			log.Println("Error:", err)
+			os.Exit(1)
+		}
+		log.Printf("Unseal successful with method '%s' of the unseal chain.", chain.Name())
+	} else if os.Getenv("VAULT_TOKEN") != "" {
 		log.Println("VAULT_TOKEN detected in environment, unsealing with token...")
-		if err := unseal(TokenUnsealer{os.Getenv("VAULT_TOKEN")}); err != nil {
+		if err := unseal(TokenUnsealer{AuthToken: os.Getenv("VAULT_TOKEN")}); err != nil {
 			log.Println("Failed to unseal using VAULT_TOKEN. Either unset VAULT_TOKEN or provide a valid VAULT_TOKEN.")
 			log.Println("Error:", err)
 			os.Exit(1)
@@ -349,7 +886,7 @@ func main() {
 			os.Exit(1)
 		}
 		log.Println("Unseal successful with token provided by Cubbyhole.")
-	} else if config.WrappedTokenAuth.TempToken != "" {
+	} else if config.WrappedTokenAuth.TempToken != "" || config.WrappedTokenAuth.TempTokenPath != "" {
 		log.Println("Attempting to unseal with Wrapped Token...")
 		if err := unseal(config.WrappedTokenAuth); err != nil {
 			log.Println("Failed to unseal using Wrapped Token. Please make sure the Wrapped Token auth is correctly setup.")
@@ -365,19 +902,93 @@ func main() {
 			os.Exit(1)
 		}
 		log.Println("Unseal successful with app-id credentials.")
+	} else if config.AppRoleAuth.RoleId != "" {
+		log.Println("Attempting to unseal with provided AppRole credentials...")
+		if err := unseal(config.AppRoleAuth); err != nil {
+			log.Println("Failed to unseal using AppRole credentials. Provide a valid role id and secret id.")
+			log.Println("Error:", err)
+			os.Exit(1)
+		}
+		log.Println("Unseal successful with AppRole credentials.")
+	} else if config.KubernetesAuth.Role != "" {
+		log.Println("Attempting to unseal with the Kubernetes service account token...")
+		if err := unseal(config.KubernetesAuth); err != nil {
+			log.Println("Failed to unseal using Kubernetes auth. Please make sure the Kubernetes auth role is correctly setup.")
+			log.Println("Error:", err)
+			os.Exit(1)
+		}
+		log.Println("Unseal successful with Kubernetes auth.")
+	}
+	if config.Marathon != "" {
+		log.Printf("Watching marathon at '%s' for apps without a matching policy...", config.Marathon)
+		go marathonWatcher()
 	}
-	log.Printf("Listening and serving on '%s'...", config.ListenAddress)
 
-	runFunc := func() error {
-		return r.Run(config.ListenAddress)
+	listeners, err := listen(config.ListenAddress)
+	if err != nil {
+		log.Println("Failed to listen.")
+		log.Println("Error:", err)
+		os.Exit(1)
 	}
+	for _, l := range listeners {
+		log.Printf("Listening and serving on '%s'...", l.Addr())
+	}
+
+	server := &http.Server{
+		Handler: r,
+	}
+	serve := server.Serve
+	var certs *listenerTLS
 	if config.TlsCert != "" || config.TlsKey != "" {
-		runFunc = func() error {
-			return r.RunTLS(config.ListenAddress, config.TlsCert, config.TlsKey)
+		tlsConfig, l, err := newListenerTLSConfig()
+		if err != nil {
+			log.Println("Failed to load TLS configuration. Error: " + err.Error())
+			os.Exit(1)
+		}
+		certs = l
+		server.TLSConfig = tlsConfig
+		serve = func(l net.Listener) error {
+			return server.ServeTLS(l, "", "")
+		}
+	}
+	if config.GrpcListen != "" {
+		grpcServer = newGrpcServer(server.TLSConfig)
+		go serveGrpc(grpcServer, config.GrpcListen)
+	}
+	if config.DebugListen != "" {
+		go serveDebug(config.DebugListen)
+	}
+	if config.ConfigFile != "" {
+		go watchConfigFile(config.ConfigFile, certs)
+	} else if certs != nil {
+		go certs.watchReload()
+	}
+	servers := []*http.Server{server}
+	// the listener errors of the server and of the tenants
+	errs := make(chan error, len(tenants)+1)
+	for _, t := range tenants {
+		if t.Listen == "" {
+			continue
 		}
+		s, err := serveTenant(t, server.TLSConfig, errs)
+		if err != nil {
+			log.Printf("Failed to listen for tenant '%s'.", t.Name)
+			log.Println("Error:", err)
+			os.Exit(1)
+		}
+		servers = append(servers, s)
 	}
-	if err := runFunc(); err != nil {
-		log.Println("Failed to start server. Error: " + err.Error())
+	done := make(chan struct{})
+	go watchShutdown(servers, done)
+	go func() {
+		if err := serveAll(listeners, serve); err != nil && err != http.ErrServerClosed {
+			errs <- errors.New("Failed to start server. Error: " + err.Error())
+		}
+	}()
+	select {
+	case err := <-errs:
+		log.Println(err)
 		os.Exit(1)
+	case <-done:
 	}
 }
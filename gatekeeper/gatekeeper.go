@@ -7,21 +7,52 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Client struct {
+	// The address of vault, or a comma separated list of the addresses of the
+	// nodes of a vault HA cluster, which are tried in order.
 	VaultAddress      string
+	VaultNamespace    string
 	GatekeeperAddress string
 	HttpClient        *http.Client
+
+	// How many times a token request is retried when gatekeeper can't be
+	// connected to or the failure is temporary, and the wait before the first
+	// retry, which doubles with every retry. A request whose response is lost
+	// isn't retried: gatekeeper may have given the task its token, and gives
+	// each task only one.
+	Retries      int
+	RetryBackoff time.Duration
+
+	// If AgentSecret is set, token requests are signed with the secret of the
+	// mesos agent AgentId (its id or hostname) the task runs on. Only agents,
+	// for example in an executor hook, should hold the secret, not tasks.
+	AgentId     string
+	AgentSecret []byte
+
+	// The version of the token response gatekeeper is asked for, see
+	// TokenResponseV2. Gatekeeper picks the version if it is 0.
+	ResponseVersion int
 }
 
+const (
+	DefaultRetries      = 3
+	DefaultRetryBackoff = time.Second
+)
+
 var DefaultClient *Client
 
 var ErrNoTaskId = errors.New("No task id provided.")
+var ErrSecretsProvided = errors.New("Gatekeeper provided secrets instead of a token for this task.")
+var ErrTokenProvided = errors.New("Gatekeeper provided a token instead of secrets for this task.")
 
 func init() {
 	capath := os.Getenv("VAULT_CAPATH")
@@ -50,6 +81,17 @@ func init() {
 		if b, err := strconv.ParseBool(os.Getenv("VAULT_SKIP_VERIFY")); err == nil && b {
 			DefaultClient.InsecureSkipVerify(true)
 		}
+		DefaultClient.VaultNamespace = os.Getenv("VAULT_NAMESPACE")
+		if retries, err := strconv.Atoi(os.Getenv("GATEKEEPER_RETRIES")); err == nil {
+			DefaultClient.Retries = retries
+		}
+		if certFile, keyFile := os.Getenv("GATEKEEPER_CLIENT_CERT"), os.Getenv("GATEKEEPER_CLIENT_KEY"); certFile != "" && keyFile != "" {
+			if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+				DefaultClient.ClientCertificate(cert)
+			} else {
+				fmt.Fprintf(os.Stderr, "Gatekeeper: Failed to read client certificate. Error: %v\n", err)
+			}
+		}
 	}
 }
 
@@ -61,10 +103,20 @@ func EnvRequestVaultToken() (string, error) {
 	return DefaultClient.RequestVaultToken(os.Getenv("MESOS_TASK_ID"))
 }
 
+func RequestSecrets(taskId string) (map[string]Secret, error) {
+	return DefaultClient.RequestSecrets(taskId)
+}
+
+func EnvRequestSecrets() (map[string]Secret, error) {
+	return DefaultClient.RequestSecrets(os.Getenv("MESOS_TASK_ID"))
+}
+
 func NewClient(vaultAddress, gatekeeperAddress string, certPool *x509.CertPool) (*Client, error) {
 	client := new(Client)
 	client.VaultAddress = vaultAddress
 	client.GatekeeperAddress = gatekeeperAddress
+	client.Retries = DefaultRetries
+	client.RetryBackoff = DefaultRetryBackoff
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{},
 	}
@@ -75,8 +127,10 @@ func NewClient(vaultAddress, gatekeeperAddress string, certPool *x509.CertPool)
 	if _, err := url.Parse(client.GatekeeperAddress); err != nil {
 		return nil, err
 	}
-	if _, err := url.Parse(client.VaultAddress); err != nil {
-		return nil, err
+	for _, address := range vaultAddresses(client.VaultAddress) {
+		if _, err := url.Parse(address); err != nil {
+			return nil, err
+		}
 	}
 	return client, nil
 }
@@ -87,96 +141,286 @@ func (c *Client) InsecureSkipVerify(skipVerify bool) {
 	}
 }
 
+// ClientCertificate sets the certificate presented to gatekeeper when it
+// requires TLS client authentication.
+func (c *Client) ClientCertificate(cert tls.Certificate) {
+	if _, ok := c.HttpClient.Transport.(*http.Transport); ok {
+		c.HttpClient.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+}
+
 func (c *Client) RequestVaultToken(taskId string) (string, error) {
 	if c.HttpClient == nil {
 		c.HttpClient = http.DefaultClient
 	}
-	tempToken, err := c.requestTempToken(taskId)
+	gkTokResp, err := c.requestTempToken(taskId)
 	if err != nil {
 		return "", err
 	}
+	return c.UnwrapToken(gkTokResp)
+}
 
-	permToken, err := c.requestPermToken(tempToken)
+// RequestSecrets requests the secrets provided by gatekeeper for the task, for
+// tasks whose gatekeeper policy provides secrets rather than a token. The secrets
+// are keyed by their path in vault.
+func (c *Client) RequestSecrets(taskId string) (map[string]Secret, error) {
+	if c.HttpClient == nil {
+		c.HttpClient = http.DefaultClient
+	}
+	gkTokResp, err := c.requestTempToken(taskId)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return c.UnwrapSecrets(gkTokResp)
+}
+
+// RequestWrappedToken requests the task's response wrapping token from
+// gatekeeper without unwrapping it, for tasks that unwrap it themselves.
+func (c *Client) RequestWrappedToken(taskId string) (*TokenResponse, error) {
+	if c.HttpClient == nil {
+		c.HttpClient = http.DefaultClient
+	}
+	return c.requestTempToken(taskId)
+}
+
+// UnwrapToken unwraps the task's vault token from a token response.
+func (c *Client) UnwrapToken(resp *TokenResponse) (string, error) {
+	if len(resp.SecretPaths) > 0 {
+		return "", ErrSecretsProvided
+	}
+	return c.requestPermToken(resp.Token, c.vaultAddress(resp), c.vaultNamespace(resp))
+}
+
+// UnwrapSecrets unwraps the secrets gatekeeper read for the task from a token
+// response, keyed by their path in vault.
+func (c *Client) UnwrapSecrets(resp *TokenResponse) (map[string]Secret, error) {
+	if len(resp.SecretPaths) == 0 {
+		return nil, ErrTokenProvided
+	}
+	secretResp := struct {
+		Data map[string]Secret `json:"data"`
+	}{}
+	if err := c.unwrap(resp.Token, c.vaultAddress(resp), c.vaultNamespace(resp), &secretResp); err != nil {
+		return nil, err
 	}
+	return secretResp.Data, nil
+}
+
+// ReadSecret reads the secret at the given path in vault with the token
+// unwrapped from the token response.
+func (c *Client) ReadSecret(resp *TokenResponse, token string, path string) (Secret, error) {
+	if c.HttpClient == nil {
+		c.HttpClient = http.DefaultClient
+	}
+	var secret Secret
+	err := c.vaultRequest("GET", token, c.vaultAddress(resp), c.vaultNamespace(resp), "/v1/"+strings.TrimPrefix(path, "/"), &secret)
+	return secret, err
+}
 
-	return permToken, err
+// RenewToken renews the token unwrapped from the token response, and returns
+// its new time to live and whether it can be renewed again.
+func (c *Client) RenewToken(resp *TokenResponse, token string) (time.Duration, bool, error) {
+	if c.HttpClient == nil {
+		c.HttpClient = http.DefaultClient
+	}
+	var renewal struct {
+		Auth struct {
+			LeaseDuration int  `json:"lease_duration"`
+			Renewable     bool `json:"renewable"`
+		} `json:"auth"`
+	}
+	if err := c.vaultRequest("POST", token, c.vaultAddress(resp), c.vaultNamespace(resp), "/v1/auth/token/renew-self", &renewal); err != nil {
+		return 0, false, err
+	}
+	return time.Duration(renewal.Auth.LeaseDuration) * time.Second, renewal.Auth.Renewable, nil
+}
+
+// The vault server the temp token was created on.
+func (c *Client) vaultAddress(gkTokResp *TokenResponse) string {
+	if gkTokResp != nil && gkTokResp.VaultAddr != "" {
+		return gkTokResp.VaultAddr
+	}
+	return c.VaultAddress
+}
+
+// The vault namespace the temp token was created in.
+func (c *Client) vaultNamespace(gkTokResp *TokenResponse) string {
+	if gkTokResp != nil && gkTokResp.VaultNamespace != "" {
+		return gkTokResp.VaultNamespace
+	}
+	return c.VaultNamespace
+}
+
+func vaultAddresses(address string) []string {
+	var addresses []string
+	for _, a := range strings.Split(address, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addresses = append(addresses, a)
+		}
+	}
+	return addresses
 }
 
-func (c *Client) requestTempToken(taskID string) (string, error) {
+// Requests the temp token of the task from gatekeeper, retrying when gatekeeper
+// can't be connected to or the failure is temporary. Rejected requests, for
+// example of a task that has already been given a token, aren't retried.
+func (c *Client) requestTempToken(taskID string) (*TokenResponse, error) {
 	if taskID == "" {
-		return "", ErrNoTaskId
+		return nil, ErrNoTaskId
 	}
 
 	gkAddr, err := url.Parse(c.GatekeeperAddress)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	gkAddr.Path = "/token"
 
-	gkTaskID := gkTokenReq{TaskId: taskID}
-	gkReq, err := json.Marshal(gkTaskID)
+	gkReq, err := json.Marshal(TokenRequest{TaskId: taskID})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	gkResp, err := c.HttpClient.Post(gkAddr.String(), "application/json", bytes.NewReader(gkReq))
-	if err != nil {
-		return "", err
+	backoff := c.RetryBackoff
+	for retry := 0; ; retry++ {
+		gkTokResp, wait, err := c.postTokenRequest(gkAddr.String(), gkReq, c.tokenRequestHeaders(taskID))
+		if err == nil {
+			return gkTokResp, nil
+		}
+		if !retryableTokenRequest(err) || retry >= c.Retries {
+			return nil, err
+		}
+		if wait < backoff {
+			wait = backoff
+		}
+		time.Sleep(wait)
+		backoff *= 2
 	}
-	defer gkResp.Body.Close()
+}
 
-	gkTokResp := &gkTokenResp{}
-	if err := json.NewDecoder(gkResp.Body).Decode(gkTokResp); err != nil {
-		return "", err
+// Whether a failed token request can be retried. That is only safe when the
+// request never reached gatekeeper, or gatekeeper failed it without giving the
+// task its token. A response lost after gatekeeper sent it can't be recovered:
+// the task has been given its token, and a retry is rejected.
+func retryableTokenRequest(err error) bool {
+	if e, ok := err.(GatekeeperError); ok {
+		return e.Temporary()
 	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
 
-	if !gkTokResp.OK {
-		return "", errors.New(gkTokResp.Error)
+// The headers of the token request of the task, which sign it and select the
+// version of the response.
+func (c *Client) tokenRequestHeaders(taskId string) map[string]string {
+	headers := c.signatureHeaders(taskId)
+	if c.ResponseVersion > 0 {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers["Accept"] = TokenResponseMediaType(c.ResponseVersion)
 	}
-
-	return gkTokResp.Token, nil
+	return headers
 }
 
-func (c *Client) requestPermToken(tempToken string) (string, error) {
-	vaultAddr, err := url.Parse(c.VaultAddress)
+// Makes a single token request. Returns how long gatekeeper asked to wait
+// before retrying, if it rate limited the request.
+func (c *Client) postTokenRequest(address string, body []byte, headers map[string]string) (*TokenResponse, time.Duration, error) {
+	req, err := http.NewRequest("POST", address, bytes.NewReader(body))
 	if err != nil {
-		return "", err
+		return nil, 0, err
 	}
-	vaultAddr.Path = "/v1/cubbyhole/response"
-
-	req, err := http.NewRequest("GET", vaultAddr.String(), nil)
-	if err != nil {
-		return "", err
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
 	}
-	req.Header.Add("X-Vault-Token", tempToken)
-
-	vaultResp, err := c.HttpClient.Do(req)
+	gkResp, err := c.HttpClient.Do(req)
 	if err != nil {
-		return "", err
+		return nil, 0, err
 	}
-	defer vaultResp.Body.Close()
+	defer gkResp.Body.Close()
 
-	if err := buildVaultError(vaultResp); err != nil {
-		return "", err
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(gkResp.Header.Get("Retry-After")); err == nil {
+		wait = time.Duration(seconds) * time.Second
 	}
 
-	var vaultWrappedResp vaultWrappedResponse
+	gkTokResp := &TokenResponse{}
+	if err := json.NewDecoder(gkResp.Body).Decode(gkTokResp); err != nil {
+		if gkResp.StatusCode != 200 {
+			return nil, wait, GatekeeperError{gkResp.StatusCode, gkResp.Status}
+		}
+		return nil, wait, err
+	}
 
-	if err := json.NewDecoder(vaultResp.Body).Decode(&vaultWrappedResp); err != nil {
-		return "", err
+	if !gkTokResp.OK {
+		return nil, wait, GatekeeperError{gkResp.StatusCode, gkTokResp.Error}
 	}
 
+	return gkTokResp, wait, nil
+}
+
+func (c *Client) requestPermToken(tempToken string, vaultAddress string, namespace string) (string, error) {
 	secretResp := struct {
 		Auth struct {
 			ClientToken string `json:"client_token"`
 		} `json:"auth"`
 	}{}
 
-	if err := vaultWrappedResp.Unwrap(&secretResp); err != nil {
+	if err := c.unwrap(tempToken, vaultAddress, namespace, &secretResp); err != nil {
 		return "", err
 	}
 
 	return secretResp.Auth.ClientToken, nil
 }
+
+// Unwraps the response wrapped by the temp token and decodes it into v.
+func (c *Client) unwrap(tempToken string, vaultAddress string, namespace string, v interface{}) error {
+	return c.vaultRequest("POST", tempToken, vaultAddress, namespace, "/v1/sys/wrapping/unwrap", v)
+}
+
+// Makes a request to vault and decodes the response into v. The nodes of a
+// vault HA cluster are tried in order until one can be reached.
+func (c *Client) vaultRequest(method string, token string, vaultAddress string, namespace string, path string, v interface{}) error {
+	var err error
+	for _, address := range vaultAddresses(vaultAddress) {
+		var next bool
+		if next, err = c.vaultRequestAt(method, token, address, namespace, path, v); !next {
+			return err
+		}
+	}
+	if err == nil {
+		err = errors.New("No vault address configured.")
+	}
+	return err
+}
+
+// Returns whether the next vault node should be tried, because this one
+// couldn't be reached or is sealed.
+func (c *Client) vaultRequestAt(method string, token string, vaultAddress string, namespace string, path string, v interface{}) (bool, error) {
+	vaultAddr, err := url.Parse(vaultAddress)
+	if err != nil {
+		return false, err
+	}
+	vaultAddr.Path = path
+
+	req, err := http.NewRequest(method, vaultAddr.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Add("X-Vault-Token", token)
+	if namespace != "" {
+		req.Header.Add("X-Vault-Namespace", namespace)
+	}
+
+	vaultResp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer vaultResp.Body.Close()
+
+	if err := buildVaultError(vaultResp); err != nil {
+		return vaultResp.StatusCode == 503, err
+	}
+
+	return false, json.NewDecoder(vaultResp.Body).Decode(v)
+}
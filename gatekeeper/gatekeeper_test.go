@@ -0,0 +1,231 @@
+package gatekeeper
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestVaultTokenRetries(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/sys/wrapping/unwrap" || r.Header.Get("X-Vault-Token") != "temp" {
+			w.WriteHeader(400)
+			return
+		}
+		w.Write([]byte(`{"auth":{"client_token":"perm"}}`))
+	}))
+	defer vault.Close()
+
+	requests := 0
+	gk := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req TokenRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if requests++; requests == 1 {
+			w.WriteHeader(503)
+			json.NewEncoder(w).Encode(TokenResponse{Status: "Sealed", Error: "Gatekeeper is sealed."})
+			return
+		}
+		json.NewEncoder(w).Encode(TokenResponse{Status: "Unsealed", OK: true, Token: "temp"})
+	}))
+	defer gk.Close()
+
+	// the first vault address can't be reached
+	client, err := NewClient("http://127.0.0.1:1,"+vault.URL, gk.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.RetryBackoff = 0
+	token, err := client.RequestVaultToken("web.1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "perm" || requests != 2 {
+		t.Errorf("Expected token 'perm' after 2 requests, got '%s' after %d.", token, requests)
+	}
+}
+
+func TestRequestVaultTokenRejected(t *testing.T) {
+	requests := 0
+	gk := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(403)
+		json.NewEncoder(w).Encode(TokenResponse{Status: "Unsealed", Error: "This task has already been given a token."})
+	}))
+	defer gk.Close()
+
+	client, err := NewClient("http://127.0.0.1:1", gk.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.RetryBackoff = 0
+	_, err = client.RequestVaultToken("web.1234")
+	if e, ok := err.(GatekeeperError); !ok || e.Code != 403 {
+		t.Errorf("Expected a 403 gatekeeper error, got %v.", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected a rejected request not to be retried, got %d requests.", requests)
+	}
+}
+
+func TestRequestVaultTokenLostResponse(t *testing.T) {
+	var requests int32
+	gk := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		// the connection drops before the response reaches the client
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer gk.Close()
+
+	client, err := NewClient("http://127.0.0.1:1", gk.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.RetryBackoff = 0
+	if _, err := client.RequestVaultToken("web.1234"); err == nil {
+		t.Error("Expected the request to fail.")
+	}
+	if requests := atomic.LoadInt32(&requests); requests != 1 {
+		t.Errorf("Expected a request that may have reached gatekeeper not to be retried, got %d requests.", requests)
+	}
+}
+
+func TestRetryableTokenRequest(t *testing.T) {
+	refused := &url.Error{Op: "Post", URL: "http://gatekeeper:9201/token", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	reset := &url.Error{Op: "Post", URL: "http://gatekeeper:9201/token", Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}}
+	for _, test := range []struct {
+		err       error
+		retryable bool
+	}{
+		{refused, true},
+		{reset, false},
+		{GatekeeperError{503, "Gatekeeper is sealed."}, true},
+		{GatekeeperError{429, "Too many requests."}, true},
+		{GatekeeperError{403, "This task has already been given a token."}, false},
+		{errors.New("unexpected EOF"), false},
+	} {
+		if retryable := retryableTokenRequest(test.err); retryable != test.retryable {
+			t.Errorf("Expected %v to be retryable: %v, got %v.", test.err, test.retryable, retryable)
+		}
+	}
+}
+
+func TestRenewToken(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v1/auth/token/renew-self" || r.Header.Get("X-Vault-Token") != "perm" {
+			w.WriteHeader(403)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		w.Write([]byte(`{"auth":{"client_token":"perm","lease_duration":3600,"renewable":true}}`))
+	}))
+	defer vault.Close()
+
+	client, err := NewClient(vault.URL, "http://127.0.0.1:1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttl, renewable, err := client.RenewToken(&TokenResponse{}, "perm")
+	if err != nil || ttl != time.Hour || !renewable {
+		t.Errorf("Expected the token to be renewed for an hour, got %v, %v (%v).", ttl, renewable, err)
+	}
+	if _, _, err := client.RenewToken(&TokenResponse{}, "other"); err == nil {
+		t.Error("Expected renewing an invalid token to fail.")
+	}
+
+	// the token is renewed on the vault server it was created on
+	client.VaultAddress = "http://127.0.0.1:1"
+	if _, _, err := client.RenewToken(&TokenResponse{VaultAddr: vault.URL}, "perm"); err != nil {
+		t.Errorf("Expected the token to be renewed on the vault server of the response, got %v.", err)
+	}
+}
+
+func TestSignedTokenRequest(t *testing.T) {
+	gk := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamp, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		if r.Header.Get(AgentHeader) != "agent-1" || r.Header.Get(SignatureHeader) != SignTokenRequest([]byte("secret"), "agent-1", timestamp, "web.1234") {
+			w.WriteHeader(403)
+			json.NewEncoder(w).Encode(TokenResponse{Status: "Unsealed", Error: "The signature of the token request is invalid."})
+			return
+		}
+		json.NewEncoder(w).Encode(TokenResponse{Status: "Unsealed", OK: true, Token: "temp"})
+	}))
+	defer gk.Close()
+
+	client, err := NewClient("", gk.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.AgentId, client.AgentSecret = "agent-1", []byte("secret")
+	if resp, err := client.RequestWrappedToken("web.1234"); err != nil || resp.Token != "temp" {
+		t.Fatalf("Expected a signed request to be accepted, got %v.", err)
+	}
+	client.AgentSecret = []byte("wrong")
+	if _, err := client.RequestWrappedToken("web.1234"); err == nil {
+		t.Fatal("Expected a request signed with the wrong secret to be rejected.")
+	}
+}
+
+func TestTokenResponseVersion(t *testing.T) {
+	gk := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != TokenResponseMediaType(TokenResponseV2) {
+			json.NewEncoder(w).Encode(TokenResponse{Status: "Unsealed", OK: true, Token: "temp"})
+			return
+		}
+		json.NewEncoder(w).Encode(TokenResponse{Status: "Unsealed", OK: true, Token: "temp", Version: TokenResponseV2, PolicyKey: "web", LeaseDuration: 600, Renewable: true,
+			VaultAddr: "https://vault:8200", WrapInfo: &WrapInfo{Token: "temp", TTL: 600}})
+	}))
+	defer gk.Close()
+
+	client, err := NewClient("", gk.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.RequestWrappedToken("web.1234"); err != nil || resp.Version != 0 || resp.PolicyKey != "" {
+		t.Fatalf("Expected the version 1 response by default, got %+v (%v).", resp, err)
+	}
+	client.ResponseVersion = TokenResponseV2
+	resp, err := client.RequestWrappedToken("web.1234")
+	if err != nil || resp.Version != TokenResponseV2 || resp.PolicyKey != "web" || resp.LeaseDuration != 600 || !resp.Renewable || resp.WrapInfo == nil || resp.WrapInfo.TTL != 600 {
+		t.Fatalf("Expected the version 2 response, got %+v (%v).", resp, err)
+	}
+	if address := client.vaultAddress(resp); address != "https://vault:8200" {
+		t.Errorf("Expected the token to be unwrapped with the vault address of the response, got '%s'.", address)
+	}
+}
+
+func TestUnwrapInResponseNamespace(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/sys/wrapping/unwrap" || r.Header.Get("X-Vault-Namespace") != "teams/web" {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"errors":["wrapping token is not valid or does not exist"]}`))
+			return
+		}
+		w.Write([]byte(`{"auth":{"client_token":"perm"}}`))
+	}))
+	defer vault.Close()
+
+	gk := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(TokenResponse{Status: "Unsealed", OK: true, Token: "temp", VaultAddr: vault.URL, VaultNamespace: "teams/web"})
+	}))
+	defer gk.Close()
+
+	client, err := NewClient("http://127.0.0.1:1", gk.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.VaultNamespace = "teams"
+	token, err := client.RequestVaultToken("web.1234")
+	if err != nil || token != "perm" {
+		t.Errorf("Expected the token to be unwrapped in the namespace of the response, got '%s', %v.", token, err)
+	}
+}
@@ -0,0 +1,40 @@
+package gatekeeper
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// The headers (and gRPC metadata keys) a token request signed with the secret
+// of a mesos agent carries.
+const (
+	AgentHeader     = "X-Gatekeeper-Agent"
+	TimestampHeader = "X-Gatekeeper-Timestamp"
+	SignatureHeader = "X-Gatekeeper-Signature"
+)
+
+// SignTokenRequest returns the hex encoded HMAC-SHA256 of the token request of
+// the task, signed by the agent (its id or hostname) with its secret at the
+// given unix timestamp.
+func SignTokenRequest(secret []byte, agent string, timestamp int64, taskId string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(agent + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + taskId))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signatureHeaders returns the headers that sign the token request of the
+// task, if the client has an agent secret.
+func (c *Client) signatureHeaders(taskId string) map[string]string {
+	if c.AgentSecret == nil {
+		return nil
+	}
+	timestamp := time.Now().Unix()
+	return map[string]string{
+		AgentHeader:     c.AgentId,
+		TimestampHeader: strconv.FormatInt(timestamp, 10),
+		SignatureHeader: SignTokenRequest(c.AgentSecret, c.AgentId, timestamp, taskId),
+	}
+}
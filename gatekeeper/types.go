@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type VaultError struct {
@@ -29,23 +30,83 @@ func buildVaultError(resp *http.Response) error {
 	return vaultErr
 }
 
-type gkTokenReq struct {
+// A TokenRequest is the body of a request to gatekeeper's /token endpoint.
+type TokenRequest struct {
 	TaskId string `json:"task_id"`
 }
 
-type gkTokenResp struct {
-	OK     bool   `json:"ok"`
-	Token  string `json:"token"`
-	Status string `json:"status"`
-	Error  string `json:"error"`
+// The versions of the token response. Version 1 is the response gatekeeper
+// has always given, and is the default.
+const (
+	TokenResponseV1 = 1
+	TokenResponseV2 = 2
+)
+
+// TokenResponseMediaType is the media type a client accepts to be given the
+// given version of the token response.
+func TokenResponseMediaType(version int) string {
+	return fmt.Sprintf("application/vnd.gatekeeper.v%d+json", version)
+}
+
+// A TokenResponse is gatekeeper's response to a token request. Token is a
+// response wrapping token, which unwraps to the task's vault token or, if
+// SecretPaths is set, to the secrets gatekeeper read for the task.
+type TokenResponse struct {
+	Status      string   `json:"status"`
+	OK          bool     `json:"ok"`
+	Token       string   `json:"token"`
+	VaultAddr   string   `json:"vault_addr,omitempty"`
+	SecretPaths []string `json:"secret_paths,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	// The vault namespace Token was created in, if any. It has to be unwrapped
+	// in the same namespace.
+	VaultNamespace string `json:"vault_namespace,omitempty"`
+
+	// Only set from version 2 of the response on, in which VaultAddr is always
+	// set. LeaseDuration is the ttl in seconds the token was requested with,
+	// 0 for the default ttl of vault, and Renewable whether it can be renewed.
+	Version       int       `json:"version,omitempty"`
+	PolicyKey     string    `json:"policy_key,omitempty"`
+	LeaseDuration int       `json:"lease_duration,omitempty"`
+	Renewable     bool      `json:"renewable,omitempty"`
+	WrapInfo      *WrapInfo `json:"wrap_info,omitempty"`
+}
+
+// WrapInfo describes the response wrapping token of a token response.
+type WrapInfo struct {
+	Token           string    `json:"token"`
+	Accessor        string    `json:"accessor,omitempty"`
+	TTL             int       `json:"ttl"`
+	CreationTime    time.Time `json:"creation_time"`
+	WrappedAccessor string    `json:"wrapped_accessor,omitempty"`
+}
+
+// A GatekeeperError is a token request gatekeeper rejected or failed.
+type GatekeeperError struct {
+	Code    int
+	Message string
 }
 
-type vaultWrappedResponse struct {
-	Data struct {
-		WrappedSecret string `json:"response"`
-	} `json:"data"`
+func (e GatekeeperError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+// Temporary reports whether the request may succeed if it is retried, that is
+// when gatekeeper is sealed, rate limited the request or failed to create the
+// token.
+func (e GatekeeperError) Temporary() bool {
+	switch e.Code {
+	case 429, 500, 502, 503, 504:
+		return true
+	default:
+		return false
+	}
 }
 
-func (vr *vaultWrappedResponse) Unwrap(v interface{}) error {
-	return json.Unmarshal([]byte(vr.Data.WrappedSecret), v)
+// A dynamic secret read from vault by gatekeeper on behalf of the task.
+type Secret struct {
+	LeaseId       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
 }
@@ -36,10 +36,15 @@ path "secret/gatekeeper" {
 	    }
 	}`
 	gkListenAddress = "127.0.0.1:8765"
+	gkAdminToken    = "gk-admin-test"
 )
 
 func TestMain(m *testing.M) {
-	r, err := VaultRequest{goreq.Request{
+	if err := vaultAddresses.Set(config.Vault.Server); err != nil {
+		panic("Invalid vault address: " + err.Error())
+	}
+
+	r, err := VaultRequest{Request: goreq.Request{
 		Uri:             vaultPath("/v1/secret/gatekeeper", ""),
 		MaxRedirects:    10,
 		RedirectHeaders: true,
@@ -65,7 +70,7 @@ func TestMain(m *testing.M) {
 		panic("Could not reach vault server: " + err.Error())
 	}
 
-	r, err = VaultRequest{goreq.Request{
+	r, err = VaultRequest{Request: goreq.Request{
 		Uri:             vaultPath("/v1/sys/policy/unseal", ""),
 		MaxRedirects:    10,
 		RedirectHeaders: true,
@@ -95,14 +100,30 @@ func TestMain(m *testing.M) {
 
 	{
 		config.ListenAddress = gkListenAddress
+		config.AdminToken = gkAdminToken
 		r := gin.Default()
 		r.SetHTMLTemplate(statusPage)
 		r.GET("/", Status)
 		r.GET("/status.json", Status)
-		r.POST("/seal", Seal)
-		r.POST("/unseal", Unseal)
-		r.POST("/token", Provide)
+		r.GET("/swagger.json", Swagger)
+		r.POST("/seal", SealAuth, Seal)
+		r.POST("/unseal", SealAuth, Unseal)
+		r.POST("/token", RateLimit, Provide)
+		r.POST("/token/check", RateLimit, CheckToken)
 		r.POST("/policies/reload", ReloadPolicies)
+		r.GET("/health", Health)
+		r.GET("/ready", Ready)
+		r.GET("/health/policies", PolicyHealth)
+		r.GET("/status", AdminAuth, AdminStatus)
+		r.GET("/policies", AdminAuth, ListPolicies)
+		r.GET("/policies/*key", AdminAuth, InspectPolicy)
+		r.GET("/tokens", AdminAuth, ListTokens)
+		r.POST("/tokens/revoke", AdminAuth, RevokeTokens)
+		r.GET("/replication", AdminAuth, ReplicationStatus)
+		r.POST("/replication/promote", AdminAuth, PromoteStandby)
+		r.POST("/replication/sync", ReplicationAuth, ReplicationSync)
+		r.POST("/replication/claim", ReplicationAuth, ReplicationClaim)
+		addTenantRoutes(r.Group("/t/:tenant", TenantRoute))
 
 		go func() {
 			//log.Printf("Listening and serving on '%s'...", config.ListenAddress)
@@ -117,14 +138,14 @@ func TestMain(m *testing.M) {
 
 func TestTokenUnseal(t *testing.T) {
 	seal()
-	if err := unseal(TokenUnsealer{*flagVaultToken}); err != nil {
+	if err := unseal(TokenUnsealer{AuthToken: *flagVaultToken}); err != nil {
 		t.Fatalf("Token Unseal Failed: %v", err)
 	}
 }
 
 func TestWrappedTokenUnseal(t *testing.T) {
 	r, err := VaultRequest{
-		goreq.Request{
+		Request: goreq.Request{
 			Uri:    vaultPath("/v1/auth/token/create", ""),
 			Method: "POST",
 			Body: struct {
@@ -148,7 +169,7 @@ func TestWrappedTokenUnseal(t *testing.T) {
 				t.Fatal("Could not create wrapped token.")
 			}
 			seal()
-			if err := unseal(WrappedTokenUnsealer{tk.WrapInfo.Token}); err != nil {
+			if err := unseal(WrappedTokenUnsealer{TempToken: tk.WrapInfo.Token}); err != nil {
 				t.Fatalf("Wrapped Token Unseal Failed: %v", err)
 			}
 		default:
@@ -167,7 +188,7 @@ func TestWrappedTokenUnseal(t *testing.T) {
 }
 
 func TestUserPassUnseal(t *testing.T) {
-	r, err := VaultRequest{goreq.Request{
+	r, err := VaultRequest{Request: goreq.Request{
 		Uri:             vaultPath("/v1/auth/userpass/users/"+vaultUser, ""),
 		MaxRedirects:    10,
 		RedirectHeaders: true,
@@ -214,3 +235,76 @@ func TestAppIdUnseal(t *testing.T) {
 func TestGitHubUnseal(t *testing.T) {
 	t.Skip("TODO")
 }
+
+func TestInspectPolicy(t *testing.T) {
+	seal()
+	if err := unseal(TokenUnsealer{AuthToken: *flagVaultToken}); err != nil {
+		t.Fatalf("Token Unseal Failed: %v", err)
+	}
+
+	r, err := goreq.Request{
+		Uri: "http://" + gkListenAddress + "/policies/app1",
+	}.Do()
+	if err != nil {
+		t.Fatalf("Could not reach gatekeeper: %v", err)
+	}
+	r.Body.Close()
+	if r.StatusCode != 401 {
+		t.Fatalf("Expected unauthenticated request to be rejected, got status code %d.", r.StatusCode)
+	}
+
+	for taskName, expected := range map[string]string{"app1": "app1", "app2": "*"} {
+		r, err := goreq.Request{
+			Uri: "http://" + gkListenAddress + "/policies/" + taskName,
+		}.WithHeader("X-Gatekeeper-Token", gkAdminToken).Do()
+		if err != nil {
+			t.Fatalf("Could not reach gatekeeper: %v", err)
+		}
+		defer r.Body.Close()
+		var resp struct {
+			Matched string `json:"matched"`
+		}
+		if err := r.Body.FromJsonTo(&resp); err != nil {
+			t.Fatalf("Could not decode policy inspection: %v", err)
+		}
+		if resp.Matched != expected {
+			t.Fatalf("Expected task '%s' to match policy '%s', matched '%s'.", taskName, expected, resp.Matched)
+		}
+	}
+}
+
+func TestReady(t *testing.T) {
+	type readyResp struct {
+		Ok     bool `json:"ok"`
+		Checks struct {
+			Unsealed   healthCheck `json:"unsealed"`
+			VaultToken healthCheck `json:"vault_token"`
+		} `json:"checks"`
+	}
+	ready := func() (int, readyResp) {
+		r, err := goreq.Request{
+			Uri: "http://" + gkListenAddress + "/ready",
+		}.Do()
+		if err != nil {
+			t.Fatalf("Could not reach gatekeeper: %v", err)
+		}
+		defer r.Body.Close()
+		var resp readyResp
+		if err := r.Body.FromJsonTo(&resp); err != nil {
+			t.Fatalf("Could not decode readiness: %v", err)
+		}
+		return r.StatusCode, resp
+	}
+
+	seal()
+	if code, resp := ready(); code != 503 || resp.Ok || resp.Checks.Unsealed.Ok {
+		t.Fatalf("Expected sealed gatekeeper not to be ready, got status code %d.", code)
+	}
+
+	if err := unseal(TokenUnsealer{AuthToken: *flagVaultToken}); err != nil {
+		t.Fatalf("Token Unseal Failed: %v", err)
+	}
+	if _, resp := ready(); !resp.Checks.Unsealed.Ok || !resp.Checks.VaultToken.Ok {
+		t.Fatalf("Expected unsealed gatekeeper to have a valid token: %+v", resp.Checks)
+	}
+}
@@ -0,0 +1,605 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: gatekeeper.proto
+
+// The gRPC api of vault-gatekeeper-mesos. It vends the same tokens as the http
+// api, and is served on GRPC_LISTEN_ADDR.
+
+package gatekeeperpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type TokenRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TokenRequest) Reset() {
+	*x = TokenRequest{}
+	mi := &file_gatekeeper_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TokenRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TokenRequest) ProtoMessage() {}
+
+func (x *TokenRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gatekeeper_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TokenRequest.ProtoReflect.Descriptor instead.
+func (*TokenRequest) Descriptor() ([]byte, []int) {
+	return file_gatekeeper_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *TokenRequest) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+type TokenResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The response wrapping token, which unwraps to the task's token or, if the
+	// policy provides secrets, to the secrets.
+	Token string `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	// The address of the vault server the token belongs to, if it isn't the
+	// default vault server.
+	VaultAddr     string   `protobuf:"bytes,2,opt,name=vault_addr,json=vaultAddr,proto3" json:"vault_addr,omitempty"`
+	SecretPaths   []string `protobuf:"bytes,3,rep,name=secret_paths,json=secretPaths,proto3" json:"secret_paths,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TokenResponse) Reset() {
+	*x = TokenResponse{}
+	mi := &file_gatekeeper_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TokenResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TokenResponse) ProtoMessage() {}
+
+func (x *TokenResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_gatekeeper_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TokenResponse.ProtoReflect.Descriptor instead.
+func (*TokenResponse) Descriptor() ([]byte, []int) {
+	return file_gatekeeper_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TokenResponse) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *TokenResponse) GetVaultAddr() string {
+	if x != nil {
+		return x.VaultAddr
+	}
+	return ""
+}
+
+func (x *TokenResponse) GetSecretPaths() []string {
+	if x != nil {
+		return x.SecretPaths
+	}
+	return nil
+}
+
+type CheckTokenResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	TaskName      string                 `protobuf:"bytes,2,opt,name=task_name,json=taskName,proto3" json:"task_name,omitempty"`
+	PolicyKey     string                 `protobuf:"bytes,3,opt,name=policy_key,json=policyKey,proto3" json:"policy_key,omitempty"`
+	Token         *TokenOptions          `protobuf:"bytes,4,opt,name=token,proto3" json:"token,omitempty"`
+	SecretPaths   []string               `protobuf:"bytes,5,rep,name=secret_paths,json=secretPaths,proto3" json:"secret_paths,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckTokenResponse) Reset() {
+	*x = CheckTokenResponse{}
+	mi := &file_gatekeeper_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckTokenResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckTokenResponse) ProtoMessage() {}
+
+func (x *CheckTokenResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_gatekeeper_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckTokenResponse.ProtoReflect.Descriptor instead.
+func (*CheckTokenResponse) Descriptor() ([]byte, []int) {
+	return file_gatekeeper_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *CheckTokenResponse) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+func (x *CheckTokenResponse) GetTaskName() string {
+	if x != nil {
+		return x.TaskName
+	}
+	return ""
+}
+
+func (x *CheckTokenResponse) GetPolicyKey() string {
+	if x != nil {
+		return x.PolicyKey
+	}
+	return ""
+}
+
+func (x *CheckTokenResponse) GetToken() *TokenOptions {
+	if x != nil {
+		return x.Token
+	}
+	return nil
+}
+
+func (x *CheckTokenResponse) GetSecretPaths() []string {
+	if x != nil {
+		return x.SecretPaths
+	}
+	return nil
+}
+
+// The options the task's token would be created with.
+type TokenOptions struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ttl           string                 `protobuf:"bytes,1,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	Policies      []string               `protobuf:"bytes,2,rep,name=policies,proto3" json:"policies,omitempty"`
+	Meta          map[string]string      `protobuf:"bytes,3,rep,name=meta,proto3" json:"meta,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	NumUses       int32                  `protobuf:"varint,4,opt,name=num_uses,json=numUses,proto3" json:"num_uses,omitempty"`
+	Renewable     bool                   `protobuf:"varint,5,opt,name=renewable,proto3" json:"renewable,omitempty"`
+	Type          string                 `protobuf:"bytes,6,opt,name=type,proto3" json:"type,omitempty"`
+	BoundCidrs    []string               `protobuf:"bytes,7,rep,name=bound_cidrs,json=boundCidrs,proto3" json:"bound_cidrs,omitempty"`
+	EntityAlias   string                 `protobuf:"bytes,8,opt,name=entity_alias,json=entityAlias,proto3" json:"entity_alias,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TokenOptions) Reset() {
+	*x = TokenOptions{}
+	mi := &file_gatekeeper_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TokenOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TokenOptions) ProtoMessage() {}
+
+func (x *TokenOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_gatekeeper_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TokenOptions.ProtoReflect.Descriptor instead.
+func (*TokenOptions) Descriptor() ([]byte, []int) {
+	return file_gatekeeper_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *TokenOptions) GetTtl() string {
+	if x != nil {
+		return x.Ttl
+	}
+	return ""
+}
+
+func (x *TokenOptions) GetPolicies() []string {
+	if x != nil {
+		return x.Policies
+	}
+	return nil
+}
+
+func (x *TokenOptions) GetMeta() map[string]string {
+	if x != nil {
+		return x.Meta
+	}
+	return nil
+}
+
+func (x *TokenOptions) GetNumUses() int32 {
+	if x != nil {
+		return x.NumUses
+	}
+	return 0
+}
+
+func (x *TokenOptions) GetRenewable() bool {
+	if x != nil {
+		return x.Renewable
+	}
+	return false
+}
+
+func (x *TokenOptions) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *TokenOptions) GetBoundCidrs() []string {
+	if x != nil {
+		return x.BoundCidrs
+	}
+	return nil
+}
+
+func (x *TokenOptions) GetEntityAlias() string {
+	if x != nil {
+		return x.EntityAlias
+	}
+	return ""
+}
+
+type StatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatusRequest) Reset() {
+	*x = StatusRequest{}
+	mi := &file_gatekeeper_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusRequest) ProtoMessage() {}
+
+func (x *StatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_gatekeeper_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusRequest.ProtoReflect.Descriptor instead.
+func (*StatusRequest) Descriptor() ([]byte, []int) {
+	return file_gatekeeper_proto_rawDescGZIP(), []int{4}
+}
+
+type StatusResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Either "Sealed" or "Unsealed".
+	Status        string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	Started       *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=started,proto3" json:"started,omitempty"`
+	Version       string                 `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
+	Stats         *Stats                 `protobuf:"bytes,4,opt,name=stats,proto3" json:"stats,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatusResponse) Reset() {
+	*x = StatusResponse{}
+	mi := &file_gatekeeper_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusResponse) ProtoMessage() {}
+
+func (x *StatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_gatekeeper_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusResponse.ProtoReflect.Descriptor instead.
+func (*StatusResponse) Descriptor() ([]byte, []int) {
+	return file_gatekeeper_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *StatusResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *StatusResponse) GetStarted() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Started
+	}
+	return nil
+}
+
+func (x *StatusResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *StatusResponse) GetStats() *Stats {
+	if x != nil {
+		return x.Stats
+	}
+	return nil
+}
+
+type Stats struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Requests      int32                  `protobuf:"varint,1,opt,name=requests,proto3" json:"requests,omitempty"`
+	Successful    int32                  `protobuf:"varint,2,opt,name=successful,proto3" json:"successful,omitempty"`
+	Denied        int32                  `protobuf:"varint,3,opt,name=denied,proto3" json:"denied,omitempty"`
+	RateLimited   int32                  `protobuf:"varint,4,opt,name=rate_limited,json=rateLimited,proto3" json:"rate_limited,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Stats) Reset() {
+	*x = Stats{}
+	mi := &file_gatekeeper_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stats) ProtoMessage() {}
+
+func (x *Stats) ProtoReflect() protoreflect.Message {
+	mi := &file_gatekeeper_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stats.ProtoReflect.Descriptor instead.
+func (*Stats) Descriptor() ([]byte, []int) {
+	return file_gatekeeper_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Stats) GetRequests() int32 {
+	if x != nil {
+		return x.Requests
+	}
+	return 0
+}
+
+func (x *Stats) GetSuccessful() int32 {
+	if x != nil {
+		return x.Successful
+	}
+	return 0
+}
+
+func (x *Stats) GetDenied() int32 {
+	if x != nil {
+		return x.Denied
+	}
+	return 0
+}
+
+func (x *Stats) GetRateLimited() int32 {
+	if x != nil {
+		return x.RateLimited
+	}
+	return 0
+}
+
+var File_gatekeeper_proto protoreflect.FileDescriptor
+
+const file_gatekeeper_proto_rawDesc = "" +
+	"\n" +
+	"\x10gatekeeper.proto\x12\rgatekeeper.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"'\n" +
+	"\fTokenRequest\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\"g\n" +
+	"\rTokenResponse\x12\x14\n" +
+	"\x05token\x18\x01 \x01(\tR\x05token\x12\x1d\n" +
+	"\n" +
+	"vault_addr\x18\x02 \x01(\tR\tvaultAddr\x12!\n" +
+	"\fsecret_paths\x18\x03 \x03(\tR\vsecretPaths\"\xbf\x01\n" +
+	"\x12CheckTokenResponse\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\x12\x1b\n" +
+	"\ttask_name\x18\x02 \x01(\tR\btaskName\x12\x1d\n" +
+	"\n" +
+	"policy_key\x18\x03 \x01(\tR\tpolicyKey\x121\n" +
+	"\x05token\x18\x04 \x01(\v2\x1b.gatekeeper.v1.TokenOptionsR\x05token\x12!\n" +
+	"\fsecret_paths\x18\x05 \x03(\tR\vsecretPaths\"\xc1\x02\n" +
+	"\fTokenOptions\x12\x10\n" +
+	"\x03ttl\x18\x01 \x01(\tR\x03ttl\x12\x1a\n" +
+	"\bpolicies\x18\x02 \x03(\tR\bpolicies\x129\n" +
+	"\x04meta\x18\x03 \x03(\v2%.gatekeeper.v1.TokenOptions.MetaEntryR\x04meta\x12\x19\n" +
+	"\bnum_uses\x18\x04 \x01(\x05R\anumUses\x12\x1c\n" +
+	"\trenewable\x18\x05 \x01(\bR\trenewable\x12\x12\n" +
+	"\x04type\x18\x06 \x01(\tR\x04type\x12\x1f\n" +
+	"\vbound_cidrs\x18\a \x03(\tR\n" +
+	"boundCidrs\x12!\n" +
+	"\fentity_alias\x18\b \x01(\tR\ventityAlias\x1a7\n" +
+	"\tMetaEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x0f\n" +
+	"\rStatusRequest\"\xa4\x01\n" +
+	"\x0eStatusResponse\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x124\n" +
+	"\astarted\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\astarted\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\tR\aversion\x12*\n" +
+	"\x05stats\x18\x04 \x01(\v2\x14.gatekeeper.v1.StatsR\x05stats\"~\n" +
+	"\x05Stats\x12\x1a\n" +
+	"\brequests\x18\x01 \x01(\x05R\brequests\x12\x1e\n" +
+	"\n" +
+	"successful\x18\x02 \x01(\x05R\n" +
+	"successful\x12\x16\n" +
+	"\x06denied\x18\x03 \x01(\x05R\x06denied\x12!\n" +
+	"\frate_limited\x18\x04 \x01(\x05R\vrateLimited2\xb3\x02\n" +
+	"\n" +
+	"Gatekeeper\x12B\n" +
+	"\x05Token\x12\x1b.gatekeeper.v1.TokenRequest\x1a\x1c.gatekeeper.v1.TokenResponse\x12L\n" +
+	"\n" +
+	"CheckToken\x12\x1b.gatekeeper.v1.TokenRequest\x1a!.gatekeeper.v1.CheckTokenResponse\x12E\n" +
+	"\x06Status\x12\x1c.gatekeeper.v1.StatusRequest\x1a\x1d.gatekeeper.v1.StatusResponse\x12L\n" +
+	"\vWatchStatus\x12\x1c.gatekeeper.v1.StatusRequest\x1a\x1d.gatekeeper.v1.StatusResponse0\x01B=Z;github.com/channelmeter/vault-gatekeeper-mesos/gatekeeperpbb\x06proto3"
+
+var (
+	file_gatekeeper_proto_rawDescOnce sync.Once
+	file_gatekeeper_proto_rawDescData []byte
+)
+
+func file_gatekeeper_proto_rawDescGZIP() []byte {
+	file_gatekeeper_proto_rawDescOnce.Do(func() {
+		file_gatekeeper_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_gatekeeper_proto_rawDesc), len(file_gatekeeper_proto_rawDesc)))
+	})
+	return file_gatekeeper_proto_rawDescData
+}
+
+var file_gatekeeper_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_gatekeeper_proto_goTypes = []any{
+	(*TokenRequest)(nil),          // 0: gatekeeper.v1.TokenRequest
+	(*TokenResponse)(nil),         // 1: gatekeeper.v1.TokenResponse
+	(*CheckTokenResponse)(nil),    // 2: gatekeeper.v1.CheckTokenResponse
+	(*TokenOptions)(nil),          // 3: gatekeeper.v1.TokenOptions
+	(*StatusRequest)(nil),         // 4: gatekeeper.v1.StatusRequest
+	(*StatusResponse)(nil),        // 5: gatekeeper.v1.StatusResponse
+	(*Stats)(nil),                 // 6: gatekeeper.v1.Stats
+	nil,                           // 7: gatekeeper.v1.TokenOptions.MetaEntry
+	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
+}
+var file_gatekeeper_proto_depIdxs = []int32{
+	3, // 0: gatekeeper.v1.CheckTokenResponse.token:type_name -> gatekeeper.v1.TokenOptions
+	7, // 1: gatekeeper.v1.TokenOptions.meta:type_name -> gatekeeper.v1.TokenOptions.MetaEntry
+	8, // 2: gatekeeper.v1.StatusResponse.started:type_name -> google.protobuf.Timestamp
+	6, // 3: gatekeeper.v1.StatusResponse.stats:type_name -> gatekeeper.v1.Stats
+	0, // 4: gatekeeper.v1.Gatekeeper.Token:input_type -> gatekeeper.v1.TokenRequest
+	0, // 5: gatekeeper.v1.Gatekeeper.CheckToken:input_type -> gatekeeper.v1.TokenRequest
+	4, // 6: gatekeeper.v1.Gatekeeper.Status:input_type -> gatekeeper.v1.StatusRequest
+	4, // 7: gatekeeper.v1.Gatekeeper.WatchStatus:input_type -> gatekeeper.v1.StatusRequest
+	1, // 8: gatekeeper.v1.Gatekeeper.Token:output_type -> gatekeeper.v1.TokenResponse
+	2, // 9: gatekeeper.v1.Gatekeeper.CheckToken:output_type -> gatekeeper.v1.CheckTokenResponse
+	5, // 10: gatekeeper.v1.Gatekeeper.Status:output_type -> gatekeeper.v1.StatusResponse
+	5, // 11: gatekeeper.v1.Gatekeeper.WatchStatus:output_type -> gatekeeper.v1.StatusResponse
+	8, // [8:12] is the sub-list for method output_type
+	4, // [4:8] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_gatekeeper_proto_init() }
+func file_gatekeeper_proto_init() {
+	if File_gatekeeper_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_gatekeeper_proto_rawDesc), len(file_gatekeeper_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_gatekeeper_proto_goTypes,
+		DependencyIndexes: file_gatekeeper_proto_depIdxs,
+		MessageInfos:      file_gatekeeper_proto_msgTypes,
+	}.Build()
+	File_gatekeeper_proto = out.File
+	file_gatekeeper_proto_goTypes = nil
+	file_gatekeeper_proto_depIdxs = nil
+}
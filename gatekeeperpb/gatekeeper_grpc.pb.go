@@ -0,0 +1,265 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: gatekeeper.proto
+
+// The gRPC api of vault-gatekeeper-mesos. It vends the same tokens as the http
+// api, and is served on GRPC_LISTEN_ADDR.
+
+package gatekeeperpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Gatekeeper_Token_FullMethodName       = "/gatekeeper.v1.Gatekeeper/Token"
+	Gatekeeper_CheckToken_FullMethodName  = "/gatekeeper.v1.Gatekeeper/CheckToken"
+	Gatekeeper_Status_FullMethodName      = "/gatekeeper.v1.Gatekeeper/Status"
+	Gatekeeper_WatchStatus_FullMethodName = "/gatekeeper.v1.Gatekeeper/WatchStatus"
+)
+
+// GatekeeperClient is the client API for Gatekeeper service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type GatekeeperClient interface {
+	// Token requests a wrapped vault token (or wrapped secrets) for a mesos
+	// task. A task can only be given a token once.
+	Token(ctx context.Context, in *TokenRequest, opts ...grpc.CallOption) (*TokenResponse, error)
+	// CheckToken performs all of the validation of a token request without
+	// creating a token or marking the task as having been given one.
+	CheckToken(ctx context.Context, in *TokenRequest, opts ...grpc.CallOption) (*CheckTokenResponse, error)
+	// Status returns the status of gatekeeper.
+	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+	// WatchStatus streams the status of gatekeeper, starting with the current
+	// status and then whenever it is sealed or unsealed.
+	WatchStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (Gatekeeper_WatchStatusClient, error)
+}
+
+type gatekeeperClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGatekeeperClient(cc grpc.ClientConnInterface) GatekeeperClient {
+	return &gatekeeperClient{cc}
+}
+
+func (c *gatekeeperClient) Token(ctx context.Context, in *TokenRequest, opts ...grpc.CallOption) (*TokenResponse, error) {
+	out := new(TokenResponse)
+	err := c.cc.Invoke(ctx, Gatekeeper_Token_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatekeeperClient) CheckToken(ctx context.Context, in *TokenRequest, opts ...grpc.CallOption) (*CheckTokenResponse, error) {
+	out := new(CheckTokenResponse)
+	err := c.cc.Invoke(ctx, Gatekeeper_CheckToken_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatekeeperClient) Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, Gatekeeper_Status_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gatekeeperClient) WatchStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (Gatekeeper_WatchStatusClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Gatekeeper_ServiceDesc.Streams[0], Gatekeeper_WatchStatus_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &gatekeeperWatchStatusClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Gatekeeper_WatchStatusClient interface {
+	Recv() (*StatusResponse, error)
+	grpc.ClientStream
+}
+
+type gatekeeperWatchStatusClient struct {
+	grpc.ClientStream
+}
+
+func (x *gatekeeperWatchStatusClient) Recv() (*StatusResponse, error) {
+	m := new(StatusResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GatekeeperServer is the server API for Gatekeeper service.
+// All implementations must embed UnimplementedGatekeeperServer
+// for forward compatibility
+type GatekeeperServer interface {
+	// Token requests a wrapped vault token (or wrapped secrets) for a mesos
+	// task. A task can only be given a token once.
+	Token(context.Context, *TokenRequest) (*TokenResponse, error)
+	// CheckToken performs all of the validation of a token request without
+	// creating a token or marking the task as having been given one.
+	CheckToken(context.Context, *TokenRequest) (*CheckTokenResponse, error)
+	// Status returns the status of gatekeeper.
+	Status(context.Context, *StatusRequest) (*StatusResponse, error)
+	// WatchStatus streams the status of gatekeeper, starting with the current
+	// status and then whenever it is sealed or unsealed.
+	WatchStatus(*StatusRequest, Gatekeeper_WatchStatusServer) error
+	mustEmbedUnimplementedGatekeeperServer()
+}
+
+// UnimplementedGatekeeperServer must be embedded to have forward compatible implementations.
+type UnimplementedGatekeeperServer struct {
+}
+
+func (UnimplementedGatekeeperServer) Token(context.Context, *TokenRequest) (*TokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Token not implemented")
+}
+func (UnimplementedGatekeeperServer) CheckToken(context.Context, *TokenRequest) (*CheckTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckToken not implemented")
+}
+func (UnimplementedGatekeeperServer) Status(context.Context, *StatusRequest) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedGatekeeperServer) WatchStatus(*StatusRequest, Gatekeeper_WatchStatusServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchStatus not implemented")
+}
+func (UnimplementedGatekeeperServer) mustEmbedUnimplementedGatekeeperServer() {}
+
+// UnsafeGatekeeperServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GatekeeperServer will
+// result in compilation errors.
+type UnsafeGatekeeperServer interface {
+	mustEmbedUnimplementedGatekeeperServer()
+}
+
+func RegisterGatekeeperServer(s grpc.ServiceRegistrar, srv GatekeeperServer) {
+	s.RegisterService(&Gatekeeper_ServiceDesc, srv)
+}
+
+func _Gatekeeper_Token_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatekeeperServer).Token(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gatekeeper_Token_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatekeeperServer).Token(ctx, req.(*TokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gatekeeper_CheckToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatekeeperServer).CheckToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gatekeeper_CheckToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatekeeperServer).CheckToken(ctx, req.(*TokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gatekeeper_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GatekeeperServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Gatekeeper_Status_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GatekeeperServer).Status(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Gatekeeper_WatchStatus_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StatusRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GatekeeperServer).WatchStatus(m, &gatekeeperWatchStatusServer{stream})
+}
+
+type Gatekeeper_WatchStatusServer interface {
+	Send(*StatusResponse) error
+	grpc.ServerStream
+}
+
+type gatekeeperWatchStatusServer struct {
+	grpc.ServerStream
+}
+
+func (x *gatekeeperWatchStatusServer) Send(m *StatusResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Gatekeeper_ServiceDesc is the grpc.ServiceDesc for Gatekeeper service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Gatekeeper_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gatekeeper.v1.Gatekeeper",
+	HandlerType: (*GatekeeperServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Token",
+			Handler:    _Gatekeeper_Token_Handler,
+		},
+		{
+			MethodName: "CheckToken",
+			Handler:    _Gatekeeper_CheckToken_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _Gatekeeper_Status_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchStatus",
+			Handler:       _Gatekeeper_WatchStatus_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "gatekeeper.proto",
+}
@@ -0,0 +1,5 @@
+// Package gatekeeperpb contains the protocol buffers and gRPC service of
+// gatekeeper's gRPC api, generated from gatekeeper.proto.
+package gatekeeperpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative gatekeeper.proto
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeper"
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeperpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// How often WatchStatus checks whether the status changed.
+const watchStatusInterval = time.Second
+
+// grpcApi serves the gRPC api defined in gatekeeperpb/gatekeeper.proto.
+type grpcApi struct {
+	gatekeeperpb.UnimplementedGatekeeperServer
+}
+
+// The gRPC server, nil unless GRPC_LISTEN_ADDR is set.
+var grpcServer *grpc.Server
+
+// Creates the gRPC server. If tlsConfig is not nil it is served over TLS with
+// the same certificates, and client certificates, as the http api.
+func newGrpcServer(tlsConfig *tls.Config) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(grpcRecoverUnary),
+		grpc.StreamInterceptor(grpcRecoverStream),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s := grpc.NewServer(opts...)
+	gatekeeperpb.RegisterGatekeeperServer(s, grpcApi{})
+	return s
+}
+
+// A panic in a handler fails the request with an internal error instead of
+// crashing gatekeeper, like gin.Recovery does for the http api.
+func grpcRecoverUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in gRPC %s: %v\n%s", info.FullMethod, r, debug.Stack())
+			err = status.Error(codes.Internal, "Internal error.")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+func grpcRecoverStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in gRPC %s: %v\n%s", info.FullMethod, r, debug.Stack())
+			err = status.Error(codes.Internal, "Internal error.")
+		}
+	}()
+	return handler(srv, ss)
+}
+
+func serveGrpc(s *grpc.Server, address string) {
+	listeners, err := listen(address)
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC on '%s'. Error: %v", address, err)
+	}
+	for _, l := range listeners {
+		log.Printf("Serving gRPC on '%s'...", l.Addr())
+	}
+	if err := serveAll(listeners, s.Serve); err != nil {
+		log.Fatalf("Failed to serve gRPC. Error: %v", err)
+	}
+}
+
+func grpcRemoteAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// The TLS connection state of the client, for attestors that verify the
+// client certificate.
+func grpcTlsState(ctx context.Context) *tls.ConnectionState {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			return &info.State
+		}
+	}
+	return nil
+}
+
+// The signature of the token request, sent in the same metadata keys as the
+// headers of a signed http token request.
+func grpcSignature(ctx context.Context) requestSignature {
+	md, _ := metadata.FromIncomingContext(ctx)
+	get := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	return requestSignature{
+		Agent:     get(gatekeeper.AgentHeader),
+		Timestamp: get(gatekeeper.TimestampHeader),
+		Signature: get(gatekeeper.SignatureHeader),
+	}
+}
+
+// Maps the http status code of a failed token request to a gRPC status.
+func grpcTokenError(err error) error {
+	code := codes.Internal
+	if e, ok := err.(tokenRequestError); ok {
+		switch e.Code {
+		case 400:
+			code = codes.InvalidArgument
+		case 403:
+			code = codes.PermissionDenied
+		case 503:
+			code = codes.Unavailable
+		}
+	}
+	return status.Error(code, err.Error())
+}
+
+func (grpcApi) token(ctx context.Context, req *gatekeeperpb.TokenRequest, dryRun bool) (tokenGrant, error) {
+	remoteAddr := grpcRemoteAddr(ctx)
+	if ok, wait := rateLimitTokenRequest(remoteAddr); !ok {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(wait.Seconds()+1))))
+		return tokenGrant{}, status.Error(codes.ResourceExhausted, errRateLimited.Error())
+	}
+	grant, err := requestToken(attestationRequest{
+		TaskId:     req.GetTaskId(),
+		RemoteAddr: remoteAddr,
+		TLS:        grpcTlsState(ctx),
+		Context:    grpcTraceContext(ctx),
+		Signature:  grpcSignature(ctx),
+	}, dryRun)
+	if err != nil {
+		return grant, grpcTokenError(err)
+	}
+	return grant, nil
+}
+
+func (a grpcApi) Token(ctx context.Context, req *gatekeeperpb.TokenRequest) (*gatekeeperpb.TokenResponse, error) {
+	grant, err := a.token(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	return &gatekeeperpb.TokenResponse{
+		Token:       grant.Token,
+		VaultAddr:   grant.VaultAddr,
+		SecretPaths: grant.SecretPaths,
+	}, nil
+}
+
+func (a grpcApi) CheckToken(ctx context.Context, req *gatekeeperpb.TokenRequest) (*gatekeeperpb.CheckTokenResponse, error) {
+	grant, err := a.token(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+	return &gatekeeperpb.CheckTokenResponse{
+		TaskId:    grant.TaskId,
+		TaskName:  grant.TaskName,
+		PolicyKey: grant.PolicyKey,
+		Token: &gatekeeperpb.TokenOptions{
+			Ttl:         grant.Options.Ttl,
+			Policies:    grant.Options.Policies,
+			Meta:        grant.Options.Meta,
+			NumUses:     int32(grant.Options.NumUses),
+			Renewable:   grant.Options.Renewable,
+			Type:        grant.Options.Type,
+			BoundCidrs:  grant.Options.BoundCidrs,
+			EntityAlias: grant.Options.EntityAlias,
+		},
+		SecretPaths: grant.SecretPaths,
+	}, nil
+}
+
+func grpcStatus() *gatekeeperpb.StatusResponse {
+	state.RLock()
+	defer state.RUnlock()
+	return &gatekeeperpb.StatusResponse{
+		Status:  string(state.Status),
+		Started: timestamppb.New(state.Started),
+		Version: gitNearestTag,
+		Stats: &gatekeeperpb.Stats{
+			Requests:    atomic.LoadInt32(&state.Stats.Requests),
+			Successful:  atomic.LoadInt32(&state.Stats.Successful),
+			Denied:      atomic.LoadInt32(&state.Stats.Denied),
+			RateLimited: atomic.LoadInt32(&state.Stats.RateLimited),
+		},
+	}
+}
+
+func (grpcApi) Status(context.Context, *gatekeeperpb.StatusRequest) (*gatekeeperpb.StatusResponse, error) {
+	return grpcStatus(), nil
+}
+
+func (grpcApi) WatchStatus(_ *gatekeeperpb.StatusRequest, stream gatekeeperpb.Gatekeeper_WatchStatusServer) error {
+	current := grpcStatus()
+	if err := stream.Send(current); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(watchStatusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+			if s := grpcStatus(); s.Status != current.Status {
+				current = s
+				if err := stream.Send(current); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeperpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func testGrpcClient(t *testing.T) (gatekeeperpb.GatekeeperClient, func()) {
+	lis := bufconn.Listen(1 << 20)
+	s := newGrpcServer(nil)
+	go s.Serve(lis)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gatekeeperpb.NewGatekeeperClient(conn), func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func TestGrpcTokenSealed(t *testing.T) {
+	state.Lock()
+	prev := state.Status
+	state.Status = StatusSealed
+	state.Unlock()
+	defer func() {
+		state.Lock()
+		state.Status = prev
+		state.Unlock()
+	}()
+
+	client, stop := testGrpcClient(t)
+	defer stop()
+
+	_, err := client.Token(context.Background(), &gatekeeperpb.TokenRequest{TaskId: "web.1234"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected a sealed gatekeeper to be unavailable, got %v.", err)
+	}
+
+	resp, err := client.Status(context.Background(), &gatekeeperpb.StatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != string(StatusSealed) {
+		t.Errorf("Expected status '%s', got '%s'.", StatusSealed, resp.Status)
+	}
+}
+
+func TestGrpcTokenError(t *testing.T) {
+	for code, expected := range map[int]codes.Code{
+		400: codes.InvalidArgument,
+		403: codes.PermissionDenied,
+		500: codes.Internal,
+		503: codes.Unavailable,
+	} {
+		if c := status.Code(grpcTokenError(tokenRequestError{code, errTaskNotFresh})); c != expected {
+			t.Errorf("Expected %d to map to %v, got %v.", code, expected, c)
+		}
+	}
+}
+
+func TestGrpcRecover(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/gatekeeper.Gatekeeper/Token"}
+	_, err := grpcRecoverUnary(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		var r *http.Response
+		return r.Body, nil
+	})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("Expected a panic in the handler to be an internal error, got %v.", err)
+	}
+
+	resp, err := grpcRecoverUnary(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+	if resp != "ok" || err != nil {
+		t.Errorf("Expected the response of the handler, got %v, %v.", resp, err)
+	}
+}
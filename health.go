@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/franela/goreq"
+	"github.com/gin-gonic/gin"
+	"sync"
+	"time"
+)
+
+var errSealed = errors.New("Gatekeeper is sealed.")
+
+type healthCheck struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func newHealthCheck(err error) healthCheck {
+	if err != nil {
+		return healthCheck{false, err.Error()}
+	}
+	return healthCheck{Ok: true}
+}
+
+// Health is a liveness check, it only reports that gatekeeper is serving
+// requests.
+func Health(c *gin.Context) {
+	state.RLock()
+	status := state.Status
+	state.RUnlock()
+	c.JSON(200, struct {
+		Status        string            `json:"status"`
+		Ok            bool              `json:"ok"`
+		VaultCircuits map[string]string `json:"vault_circuits"`
+	}{string(status), true, vaultBreakerStates()})
+}
+
+func checkVaultToken(token string) error {
+	r, err := VaultRequest{Request: goreq.Request{
+		Uri:             vaultPath("/v1/auth/token/lookup-self", ""),
+		MaxRedirects:    10,
+		RedirectHeaders: true,
+		Timeout:         5 * time.Second,
+	}.WithHeader("X-Vault-Token", token)}.Do()
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		var e vaultError
+		e.Code = r.StatusCode
+		if err := r.Body.FromJsonTo(&e); err != nil {
+			e.Errors = []string{"communication error."}
+		}
+		return e
+	}
+	return nil
+}
+
+// Checks that vault can be reached and is unsealed. Standby nodes are healthy.
+func checkVaultHealth() error {
+	r, err := VaultRequest{Request: goreq.Request{
+		Uri:     vaultPath("/v1/sys/health", "standbyok=true&perfstandbyok=true"),
+		Timeout: 5 * time.Second,
+	}}.Do()
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		return fmt.Errorf("Vault responded with status code %d.", r.StatusCode)
+	}
+	return nil
+}
+
+func checkMesosMaster() error {
+	masterHosts, err := getMesosMaster()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.MesosTimeout)
+	defer cancel()
+	var masterErr error
+	for _, host := range masterHosts {
+		if resp, err := mesosRequest(ctx, "GET", host, "/health", nil); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == 200 {
+				return nil
+			}
+			masterErr = fmt.Errorf("Mesos master '%s' responded with status code %d.", host, resp.StatusCode)
+		} else {
+			masterErr = err
+		}
+	}
+	return masterErr
+}
+
+// Ready reports whether gatekeeper can provide tokens: it must be unsealed with
+// a valid vault token and its policies loaded, and the mesos master must be
+// reachable. A standby, or an instance whose standby was promoted, isn't
+// ready either. It responds with a 503 if any of the checks fail, so that load
+// balancers stop routing to a sealed instance.
+func Ready(c *gin.Context) {
+	state.RLock()
+	status := state.Status
+	token := state.Token
+	state.RUnlock()
+	numPolicies := len(activePolicies.Get())
+
+	var checks struct {
+		Unsealed     healthCheck `json:"unsealed"`
+		VaultToken   healthCheck `json:"vault_token"`
+		Policies     healthCheck `json:"policies"`
+		Mesos        healthCheck `json:"mesos"`
+		VaultCircuit healthCheck `json:"vault_circuit"`
+		Replication  healthCheck `json:"replication"`
+	}
+	if status == StatusUnsealed {
+		checks.Unsealed = newHealthCheck(nil)
+		checks.Policies = newHealthCheck(nil)
+	} else {
+		checks.Unsealed = newHealthCheck(errSealed)
+		checks.VaultToken = newHealthCheck(errSealed)
+		checks.Policies = newHealthCheck(fmt.Errorf("Policies are loaded when gatekeeper is unsealed."))
+	}
+
+	if vaultBreaker(vaultAddresses.Current()).State(time.Now()) == circuitOpen {
+		checks.VaultCircuit = newHealthCheck(errVaultCircuitOpen)
+	} else {
+		checks.VaultCircuit = newHealthCheck(nil)
+	}
+
+	checks.Replication = newHealthCheck(replicationRole())
+
+	var wg sync.WaitGroup
+	if status == StatusUnsealed {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checks.VaultToken = newHealthCheck(checkVaultToken(token))
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		checks.Mesos = newHealthCheck(checkMesosMaster())
+	}()
+	wg.Wait()
+
+	ok := checks.Unsealed.Ok && checks.VaultToken.Ok && checks.Policies.Ok && checks.Mesos.Ok && checks.VaultCircuit.Ok && checks.Replication.Ok
+	code := 200
+	if !ok {
+		code = 503
+	}
+	c.JSON(code, struct {
+		Status   string      `json:"status"`
+		Ok       bool        `json:"ok"`
+		Policies int         `json:"policies"`
+		Checks   interface{} `json:"checks"`
+	}{string(status), ok, numPolicies, checks})
+}
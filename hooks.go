@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	hookTokenIssued        = "token_issued"
+	hookTokenDenied        = "token_denied"
+	hookPolicyReloadFailed = "policy_reload_failed"
+)
+
+// A hookEvent notifies the hook sinks of a token being issued or denied, or of
+// a failure to load the policies.
+type hookEvent struct {
+	Type     string      `json:"type"`
+	Time     time.Time   `json:"time"`
+	Request  *auditEvent `json:"request,omitempty"`
+	Policies string      `json:"policies,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// A hookSink delivers encoded hook events.
+type hookSink interface {
+	Send(event []byte) error
+	Close() error
+}
+
+// webhookSink POSTs every event to a url.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (w webhookSink) Send(event []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(event))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook '%s' responded with status code %d.", w.url, resp.StatusCode)
+	}
+	return nil
+}
+
+func (w webhookSink) Close() error {
+	return nil
+}
+
+// kafkaSink publishes every event to a kafka topic.
+type kafkaSink struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+func newKafkaSink(brokers string, topic string, timeout time.Duration) (kafkaSink, error) {
+	c := sarama.NewConfig()
+	c.ClientID = "vault-gatekeeper"
+	c.Net.DialTimeout = timeout
+	c.Producer.Timeout = timeout
+	c.Producer.RequiredAcks = sarama.WaitForAll
+	c.Producer.Return.Successes = true
+	producer, err := sarama.NewSyncProducer(strings.Split(brokers, ","), c)
+	if err != nil {
+		return kafkaSink{}, err
+	}
+	return kafkaSink{producer, topic}, nil
+}
+
+func (k kafkaSink) Send(event []byte) error {
+	_, _, err := k.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: k.topic,
+		Value: sarama.ByteEncoder(event),
+	})
+	return err
+}
+
+func (k kafkaSink) Close() error {
+	return k.producer.Close()
+}
+
+const hookQueueSize = 1000
+
+// hookDispatcher delivers hook events to its sinks in the background, so that
+// a slow sink doesn't hold up token requests. Events are dropped when the
+// queue is full.
+type hookDispatcher struct {
+	sync.RWMutex
+	sinks  []hookSink
+	queue  chan hookEvent
+	done   chan struct{}
+	closed bool
+}
+
+// The active hooks. Hooks are disabled when nil.
+var hooks *hookDispatcher
+
+func NewHookDispatcher(sinks ...hookSink) *hookDispatcher {
+	h := &hookDispatcher{
+		sinks: sinks,
+		queue: make(chan hookEvent, hookQueueSize),
+		done:  make(chan struct{}),
+	}
+	go h.deliver()
+	return h
+}
+
+func (h *hookDispatcher) Notify(e hookEvent) {
+	if h == nil {
+		return
+	}
+	h.RLock()
+	defer h.RUnlock()
+	// requests still draining at shutdown may finish after Close
+	if h.closed {
+		log.Printf("Hooks are closed, dropping '%s' event.", e.Type)
+		return
+	}
+	select {
+	case h.queue <- e:
+	default:
+		log.Printf("Hook queue is full, dropping '%s' event.", e.Type)
+	}
+}
+
+// NotifyToken notifies the hooks of the outcome of a token request. Dry runs
+// don't issue tokens, and aren't notified.
+func (h *hookDispatcher) NotifyToken(e auditEvent) {
+	if e.DryRun {
+		return
+	}
+	event := hookEvent{Type: hookTokenDenied, Time: e.Time, Request: &e, Error: e.Error}
+	if e.Outcome == auditIssued {
+		event.Type = hookTokenIssued
+	}
+	h.Notify(event)
+}
+
+func (h *hookDispatcher) NotifyPolicyReloadFailed(policies string, err error) {
+	h.Notify(hookEvent{Type: hookPolicyReloadFailed, Time: time.Now(), Policies: policies, Error: err.Error()})
+}
+
+func (h *hookDispatcher) deliver() {
+	defer close(h.done)
+	for e := range h.queue {
+		b, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("Failed to encode hook event: %v", err)
+			continue
+		}
+		for _, sink := range h.sinks {
+			if err := sink.Send(b); err != nil {
+				log.Printf("Failed to deliver '%s' hook event: %v", e.Type, err)
+			}
+		}
+	}
+}
+
+// Close delivers the events in the queue, then closes the sinks. Events
+// notified after Close are dropped.
+func (h *hookDispatcher) Close() {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	if h.closed {
+		h.Unlock()
+		return
+	}
+	h.closed = true
+	close(h.queue)
+	h.Unlock()
+	<-h.done
+	for _, sink := range h.sinks {
+		if err := sink.Close(); err != nil {
+			log.Printf("Failed to close hook sink: %v", err)
+		}
+	}
+}
+
+// Records the outcome of a token request in the audit log and notifies the
+// hooks of it.
+func recordTokenRequest(e auditEvent) {
+	audit.Record(e)
+	hooks.NotifyToken(e)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	events []hookEvent
+	closed bool
+}
+
+func (s *recordingSink) Send(event []byte) error {
+	var e hookEvent
+	if err := json.Unmarshal(event, &e); err != nil {
+		return err
+	}
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestHookDispatcher(t *testing.T) {
+	sink := &recordingSink{}
+	h := NewHookDispatcher(sink)
+	h.NotifyToken(auditEvent{Time: time.Now(), TaskId: "a", Outcome: auditIssued})
+	h.NotifyToken(auditEvent{Time: time.Now(), TaskId: "b", Outcome: auditChecked, DryRun: true})
+	h.NotifyToken(auditEvent{Time: time.Now(), TaskId: "c", Outcome: auditDenied, Error: errTaskNotFresh.Error()})
+	h.NotifyPolicyReloadFailed("gatekeeper", errors.New("Failed."))
+	h.Close()
+
+	if !sink.closed {
+		t.Error("Expected sink to be closed.")
+	}
+	if len(sink.events) != 3 {
+		t.Fatalf("Expected 3 events, got %d.", len(sink.events))
+	}
+	if e := sink.events[0]; e.Type != hookTokenIssued || e.Request == nil || e.Request.TaskId != "a" {
+		t.Errorf("Unexpected issued event %+v.", e)
+	}
+	if e := sink.events[1]; e.Type != hookTokenDenied || e.Request.TaskId != "c" || e.Error != errTaskNotFresh.Error() {
+		t.Errorf("Unexpected denied event %+v.", e)
+	}
+	if e := sink.events[2]; e.Type != hookPolicyReloadFailed || e.Policies != "gatekeeper" || e.Error != "Failed." {
+		t.Errorf("Unexpected policy reload event %+v.", e)
+	}
+}
+
+func TestHookDispatcherClosed(t *testing.T) {
+	sink := &recordingSink{}
+	h := NewHookDispatcher(sink)
+	h.Close()
+	h.NotifyToken(auditEvent{Time: time.Now(), TaskId: "a", Outcome: auditIssued})
+	h.Close()
+
+	if len(sink.events) != 0 {
+		t.Errorf("Expected events after closing to be dropped, got %+v.", sink.events)
+	}
+}
+
+func TestHookDispatcherNil(t *testing.T) {
+	var h *hookDispatcher
+	h.NotifyToken(auditEvent{Outcome: auditIssued})
+	h.NotifyPolicyReloadFailed("gatekeeper", errors.New("Failed."))
+	h.Close()
+}
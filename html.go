@@ -31,6 +31,12 @@ const htmlTemplateVal = `<!DOCTYPE html>
       .active-form.active-userpass .visible-userpass {
         display: block;
       }
+      .active-form.active-ldap .visible-ldap {
+        display: block;
+      }
+      .active-form.active-okta .visible-okta {
+        display: block;
+      }
       .active-form.active-token .visible-token {
         display: block;
       }
@@ -67,12 +73,19 @@ const htmlTemplateVal = `<!DOCTYPE html>
             <li class="list-group-item">Token Requests: {{.Stats.Requests}}</li>
             <li class="list-group-item">Successful Requests: {{.Stats.Successful}}</li>
             <li class="list-group-item">Denied Requests: {{.Stats.Denied}}</li>
+            <li class="list-group-item">Rate Limited Requests: {{.Stats.RateLimited}}</li>
             <li class="list-group-item">Uptime: {{.Uptime}}</li>
             <li class="list-group-item">Version: {{.Version}}</li>
           </ul>
         </div>
         <div class="col-sm-8 status-unsealed">
         	<form id="form-unsealed" method="POST" action="/seal">
+            {{if .AdminAuth}}
+            <div class="form-group">
+              <label for="seal_admin_token">Admin Token</label>
+              <input type="password" class="form-control" id="seal_admin_token" name="admin_token">
+            </div>
+            {{end}}
         		<div class="text-right">
               		<button type="submit" class="btn btn-danger text-right">Seal</button>
             	</div>
@@ -87,6 +100,8 @@ const htmlTemplateVal = `<!DOCTYPE html>
                 <option value="app-id">App ID</option>
                 <option value="github">GitHub</option>
                 <option value="userpass">Username &amp; Password</option>
+                <option value="ldap">LDAP</option>
+                <option value="okta">Okta</option>
                 <option value="cubby">Cubby Method</option>
                 <option value="wrapped-token">Wrapped Token Method</option>
                 <option value="token">Token</option>
@@ -103,6 +118,9 @@ const htmlTemplateVal = `<!DOCTYPE html>
                   <select id="app-id_userid_method" class="form-control" name="app-id_userid_method">
                     <option value="mac">Mac Address (specify interface name)</option>
                     <option value="file">File Value (specify path)</option>
+                    <option value="instance-id">Cloud Instance ID (specify ec2 or gce)</option>
+                    <option value="dmi">DMI Product UUID</option>
+                    <option value="hostname">Hostname</option>
                   </select>
                 </div>
                 <div class="col-xs-6">
@@ -115,9 +133,12 @@ const htmlTemplateVal = `<!DOCTYPE html>
                   <label for="app-id_userid_hash">App ID: User ID Hash Function</label>
                   <select id="app-id_userid_hash" class="form-control" name="app-id_userid_hash">
                     <option value="">none</option>
+                    <option value="sha512">sha512</option>
                     <option value="sha256">sha256</option>
                     <option value="sha1">sha1</option>
                     <option value="md5">md5</option>
+                    <option value="hmac-sha256">hmac-sha256</option>
+                    <option value="hmac-sha512">hmac-sha512</option>
                   </select>
                 </div>
                 <div class="col-xs-6">
@@ -125,6 +146,10 @@ const htmlTemplateVal = `<!DOCTYPE html>
                   <input type="text" class="form-control" id="app-id_userid_salt" name="app-id_userid_salt">
                 </div>
               </div>
+              <div class="form-group">
+                <label for="app-id_userid_hmac_key">App ID: User ID HMAC Key</label>
+                <input type="password" class="form-control" id="app-id_userid_hmac_key" name="app-id_userid_hmac_key">
+              </div>
             </div>
             <div class="form-group form-section visible-github">
               <label for="github_token">GitHub: Personal Token</label>
@@ -140,6 +165,34 @@ const htmlTemplateVal = `<!DOCTYPE html>
                 <input type="password" class="form-control" id="username_password" name="username_password">
               </div>
             </div>
+            <div class="form-section visible-ldap">
+              <div class="form-group">
+                <label for="ldap_username">LDAP: Username</label>
+                <input type="text" class="form-control" id="ldap_username" name="ldap_username">
+              </div>
+              <div class="form-group">
+                <label for="ldap_password">LDAP: Password</label>
+                <input type="password" class="form-control" id="ldap_password" name="ldap_password">
+              </div>
+              <div class="form-group">
+                <label for="ldap_mount_path">LDAP: Mount Path</label>
+                <input type="text" class="form-control" id="ldap_mount_path" name="ldap_mount_path" placeholder="ldap">
+              </div>
+            </div>
+            <div class="form-section visible-okta">
+              <div class="form-group">
+                <label for="okta_username">Okta: Username</label>
+                <input type="text" class="form-control" id="okta_username" name="okta_username">
+              </div>
+              <div class="form-group">
+                <label for="okta_password">Okta: Password</label>
+                <input type="password" class="form-control" id="okta_password" name="okta_password">
+              </div>
+              <div class="form-group">
+                <label for="okta_mount_path">Okta: Mount Path</label>
+                <input type="text" class="form-control" id="okta_mount_path" name="okta_mount_path" placeholder="okta">
+              </div>
+            </div>
             <div class="form-group form-section visible-token">
               <label for="token_token">Token: Token</label>
               <input type="text" class="form-control" id="token_token" name="token_token">
@@ -160,6 +213,12 @@ const htmlTemplateVal = `<!DOCTYPE html>
                 <input type="text" class="form-control" id="wrapped_token" name="wrapped_token">
               </div>
             </div>
+            {{if .AdminAuth}}
+            <div class="form-group">
+              <label for="admin_token">Admin Token</label>
+              <input type="password" class="form-control" id="admin_token" name="admin_token">
+            </div>
+            {{end}}
             <div class="text-right">
               <button type="submit" class="btn btn-primary text-right">Unseal</button>
             </div>
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var errTooManyInstances = errors.New("More tokens were requested for the tasks of this app than it has running instances.")
+
+// The tokens recently issued to the tasks of each app, by framework id and
+// task name, as apps of the same name on other frameworks are other apps. Each
+// token is kept for the task life of its policy, the window in which the
+// instances of the app can request their tokens.
+var instanceTokens = struct {
+	sync.Mutex
+	m    map[string][]instanceToken
+	next uint64
+}{m: make(map[string][]instanceToken)}
+
+type instanceToken struct {
+	id     uint64
+	issued time.Time
+}
+
+// reserveInstanceToken reserves a token for a task of the app, unless limit
+// tokens were issued to its tasks within window already. The returned func
+// gives the reservation back, if the token ends up not being issued.
+func reserveInstanceToken(name string, limit int, window time.Duration, now time.Time) (func(), error) {
+	instanceTokens.Lock()
+	defer instanceTokens.Unlock()
+	cutoff := now.Add(-window)
+	recent := instanceTokens.m[name][:0]
+	for _, t := range instanceTokens.m[name] {
+		if t.issued.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= limit {
+		instanceTokens.m[name] = recent
+		return nil, errTooManyInstances
+	}
+	instanceTokens.next++
+	id := instanceTokens.next
+	instanceTokens.m[name] = append(recent, instanceToken{id, now})
+	return func() {
+		instanceTokens.Lock()
+		defer instanceTokens.Unlock()
+		tokens := instanceTokens.m[name]
+		for i, t := range tokens {
+			if t.id == id {
+				instanceTokens.m[name] = append(tokens[:i], tokens[i+1:]...)
+				break
+			}
+		}
+	}, nil
+}
+
+// instanceLimit is the most tokens the tasks of an app with the given number of
+// running instances may be issued: the running instances plus INSTANCE_SLACK,
+// for tasks that are replaced while the token requests are in flight, but never
+// more than the max_instances of the policy.
+func (p *policy) instanceLimit(running int) int {
+	limit := running + config.InstanceSlack
+	if limit > p.MaxInstances {
+		limit = p.MaxInstances
+	}
+	return limit
+}
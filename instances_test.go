@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReserveInstanceToken(t *testing.T) {
+	now := time.Now()
+	name := "reserve.test"
+	for i := 0; i < 2; i++ {
+		if _, err := reserveInstanceToken(name, 2, time.Minute, now); err != nil {
+			t.Fatalf("Expected token %d to be reserved, got %v.", i+1, err)
+		}
+	}
+	if _, err := reserveInstanceToken(name, 2, time.Minute, now); err != errTooManyInstances {
+		t.Fatalf("Expected %v beyond the limit, got %v.", errTooManyInstances, err)
+	}
+	// tokens issued before the window don't count
+	release, err := reserveInstanceToken(name, 2, time.Minute, now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("Expected a token to be reserved after the window, got %v.", err)
+	}
+	release()
+	if _, err := reserveInstanceToken(name, 1, time.Minute, now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("Expected a released token to be reserved again, got %v.", err)
+	}
+}
+
+func TestInstanceLimit(t *testing.T) {
+	slack := config.InstanceSlack
+	defer func() { config.InstanceSlack = slack }()
+	config.InstanceSlack = 1
+
+	pol := &policy{MaxInstances: 5}
+	if limit := pol.instanceLimit(3); limit != 4 {
+		t.Errorf("Expected the running instances plus the slack, got %d.", limit)
+	}
+	if limit := pol.instanceLimit(10); limit != 5 {
+		t.Errorf("Expected max_instances to cap the limit, got %d.", limit)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// Metronome names the tasks of a job run <job id>_<run id>, where the run id is
+// the time of the run followed by 5 random characters, and adds a uuid to
+// make the task id.
+var metronomeRun = regexp.MustCompile(`^(.+)_\d{14}[[:alnum:]]{5}$`)
+
+// scheduledJobName returns the name of the Chronos or Metronome job that
+// launched the task, or an empty string if the task wasn't launched by either.
+// framework is the name of the framework that launched the task, so that the
+// tasks of other frameworks whose ids happen to look like those of a job
+// aren't matched as the job.
+func scheduledJobName(task mesosTask, framework string) string {
+	framework = strings.ToLower(framework)
+	switch {
+	case strings.Contains(framework, "chronos"):
+		// Chronos task ids are ct:<due time>:<attempt>:<job name>:<arguments>
+		if strings.HasPrefix(task.Id, "ct:") {
+			if parts := strings.SplitN(task.Id, ":", 5); len(parts) >= 4 && parts[3] != "" {
+				return parts[3]
+			}
+		}
+		if strings.HasPrefix(task.Name, "ChronosTask:") {
+			return strings.TrimPrefix(task.Name, "ChronosTask:")
+		}
+	case strings.Contains(framework, "metronome"):
+		run := task.Id
+		if i := strings.LastIndex(run, "."); i > 0 {
+			run = run[:i]
+		}
+		for _, name := range []string{task.Name, run} {
+			if m := metronomeRun.FindStringSubmatch(name); m != nil {
+				return m[1]
+			}
+		}
+	}
+	return ""
+}
+
+// taskFrameworkName looks up the name of the framework that launched the task
+// on the mesos master. Tasks that weren't attested by mesos have no framework.
+func taskFrameworkName(ctx context.Context, task mesosTask) (string, error) {
+	if task.FrameworkId == "" {
+		return "", nil
+	}
+	return getMesosFrameworkName(ctx, task.FrameworkId)
+}
+
+// policyTaskName is the name the policy of the task is looked up by. With
+// MATCH_JOB_NAMES, that is the job name for tasks of Chronos and Metronome
+// jobs, as their task names differ from run to run.
+func policyTaskName(task mesosTask, framework string) string {
+	if config.MatchJobNames {
+		if job := scheduledJobName(task, framework); job != "" {
+			return job
+		}
+	}
+	return task.Name
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestScheduledJobName(t *testing.T) {
+	for _, c := range []struct {
+		task      mesosTask
+		framework string
+		expected  string
+	}{
+		{mesosTask{Id: "ct:1460000000000:0:nightly-report:", Name: "ChronosTask:nightly-report"}, "chronos", "nightly-report"},
+		{mesosTask{Id: "ct:1460000000000:2:backup:--full", Name: "ChronosTask:backup"}, "chronos-2.5", "backup"},
+		{mesosTask{Id: "other", Name: "ChronosTask:cleanup"}, "Chronos", "cleanup"},
+		{mesosTask{Id: "prod.reports.nightly_20160614133245lZjzV.3f0a2d2e-3212-11e6-8d2c-00163e105043", Name: "prod.reports.nightly_20160614133245lZjzV"}, "metronome", "prod.reports.nightly"},
+		{mesosTask{Id: "prod.reports.nightly_20160614133245lZjzV.3f0a2d2e-3212-11e6-8d2c-00163e105043"}, "metronome", "prod.reports.nightly"},
+		{mesosTask{Id: "frontend.web.3d151450-1092-11e6-8d2c-00163e105043", Name: "frontend.web"}, "marathon", ""},
+		{mesosTask{Id: "worker_1.3d151450-1092-11e6-8d2c-00163e105043", Name: "worker_1"}, "metronome", ""},
+		// tasks of other frameworks are never taken for jobs
+		{mesosTask{Id: "ct:1460000000000:0:nightly-report:", Name: "ChronosTask:nightly-report"}, "marathon", ""},
+		{mesosTask{Id: "prod.reports.nightly_20160614133245lZjzV.3f0a2d2e-3212-11e6-8d2c-00163e105043", Name: "prod.reports.nightly_20160614133245lZjzV"}, "marathon", ""},
+		{mesosTask{Id: "prod.reports.nightly_20160614133245lZjzV.3f0a2d2e-3212-11e6-8d2c-00163e105043", Name: "prod.reports.nightly_20160614133245lZjzV"}, "chronos", ""},
+		{mesosTask{Id: "ct:1460000000000:0:nightly-report:", Name: "ChronosTask:nightly-report"}, "", ""},
+	} {
+		if job := scheduledJobName(c.task, c.framework); job != c.expected {
+			t.Errorf("Expected job '%s' for task '%s' of framework '%s', got '%s'.", c.expected, c.task.Id, c.framework, job)
+		}
+	}
+}
+
+func TestPolicyTaskName(t *testing.T) {
+	defer func(match bool) { config.MatchJobNames = match }(config.MatchJobNames)
+	task := mesosTask{Id: "ct:1460000000000:0:nightly-report:", Name: "ChronosTask:nightly-report"}
+
+	config.MatchJobNames = false
+	if name := policyTaskName(task, "chronos"); name != task.Name {
+		t.Errorf("Expected the task name without MATCH_JOB_NAMES, got '%s'.", name)
+	}
+	config.MatchJobNames = true
+	if name := policyTaskName(task, "chronos"); name != "nightly-report" {
+		t.Errorf("Expected the job name with MATCH_JOB_NAMES, got '%s'.", name)
+	}
+	if name := policyTaskName(task, "marathon"); name != task.Name {
+		t.Errorf("Expected a task of another framework to keep its task name, got '%s'.", name)
+	}
+	if name := policyTaskName(mesosTask{Name: "frontend.web"}, "marathon"); name != "frontend.web" {
+		t.Errorf("Expected tasks that aren't jobs to keep their task name, got '%s'.", name)
+	}
+}
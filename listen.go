@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	errNoListenAddress  = errors.New("No listen address given.")
+	errNoSystemdSockets = errors.New("No sockets were passed by systemd.")
+)
+
+// The address prefix that selects the sockets passed by systemd socket
+// activation, optionally followed by the FileDescriptorName of the sockets.
+const systemdAddress = "systemd"
+
+// listenNetwork picks the network to listen on for an address. Addresses with
+// an IPv4 or IPv6 literal bind to that family only, so that "0.0.0.0:9201" and
+// "[::]:9201" can be listed side by side. Addresses without a host, or with a
+// hostname, listen on a single dual-stack socket where the system supports it.
+func listenNetwork(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "tcp"
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			return "tcp4"
+		}
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// listen opens a listener for every address in a comma separated list of
+// addresses. "systemd" selects all the sockets passed by systemd, and
+// "systemd:name" those with FileDescriptorName=name.
+func listen(addresses string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	fail := func(err error) ([]net.Listener, error) {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, err
+	}
+	for _, address := range strings.Split(addresses, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		if address == systemdAddress || strings.HasPrefix(address, systemdAddress+":") {
+			activated, err := systemdListeners(strings.TrimPrefix(strings.TrimPrefix(address, systemdAddress), ":"))
+			if err != nil {
+				return fail(err)
+			}
+			listeners = append(listeners, activated...)
+			continue
+		}
+		l, err := net.Listen(listenNetwork(address), address)
+		if err != nil {
+			return fail(err)
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 0 {
+		return nil, errNoListenAddress
+	}
+	return listeners, nil
+}
+
+// serveAll serves on every listener, and returns the first error, which is
+// http.ErrServerClosed once the server is shut down.
+func serveAll(listeners []net.Listener, serve func(net.Listener) error) error {
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errs <- serve(l)
+		}(l)
+	}
+	return <-errs
+}
+
+// The sockets passed by systemd that haven't been claimed by a listen address
+// yet, by FileDescriptorName.
+var systemdSockets struct {
+	sync.Mutex
+	once  sync.Once
+	files map[string][]*os.File
+	err   error
+}
+
+// The first file descriptor systemd passes sockets from (SD_LISTEN_FDS_START).
+const systemdFirstFd = 3
+
+// parseSystemdSockets reads the sockets passed with the LISTEN_PID, LISTEN_FDS
+// and LISTEN_FDNAMES variables of the socket activation protocol.
+func parseSystemdSockets(getenv func(string) string, firstFd int) (map[string][]*os.File, error) {
+	files := make(map[string][]*os.File)
+	if getenv("LISTEN_PID") == "" {
+		return files, nil
+	}
+	if pid, err := strconv.Atoi(getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		// the sockets were passed to another process
+		return files, nil
+	}
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("Invalid LISTEN_FDS '%s'.", getenv("LISTEN_FDS"))
+	}
+	var names []string
+	if getenv("LISTEN_FDNAMES") != "" {
+		names = strings.Split(getenv("LISTEN_FDNAMES"), ":")
+	}
+	for i := 0; i < n; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files[name] = append(files[name], os.NewFile(uintptr(firstFd+i), name))
+	}
+	return files, nil
+}
+
+// systemdListeners claims the sockets passed by systemd with the given name,
+// or all the remaining ones if name is empty.
+func systemdListeners(name string) ([]net.Listener, error) {
+	systemdSockets.once.Do(func() {
+		systemdSockets.files, systemdSockets.err = parseSystemdSockets(os.Getenv, systemdFirstFd)
+		// don't pass the sockets on to processes gatekeeper starts
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	})
+	if systemdSockets.err != nil {
+		return nil, systemdSockets.err
+	}
+	systemdSockets.Lock()
+	var files []*os.File
+	for fdName, f := range systemdSockets.files {
+		if name == "" || name == fdName {
+			files = append(files, f...)
+			delete(systemdSockets.files, fdName)
+		}
+	}
+	systemdSockets.Unlock()
+	if len(files) == 0 {
+		if name == "" {
+			return nil, errNoSystemdSockets
+		}
+		return nil, fmt.Errorf("No socket named '%s' was passed by systemd.", name)
+	}
+	var listeners []net.Listener
+	var listenErr error
+	for _, f := range files {
+		// FileListener duplicates the descriptor
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			listenErr = fmt.Errorf("Socket '%s' passed by systemd is not a stream socket: %v", f.Name(), err)
+			continue
+		}
+		listeners = append(listeners, l)
+	}
+	if listenErr != nil {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, listenErr
+	}
+	return listeners, nil
+}
@@ -0,0 +1,61 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestParseSystemdSockets(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the file passed by "systemd" takes ownership of its own descriptor
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"LISTEN_PID":     strconv.Itoa(os.Getpid()),
+		"LISTEN_FDS":     "1",
+		"LISTEN_FDNAMES": "http",
+	}
+	files, err := parseSystemdSockets(func(key string) string { return env[key] }, fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files["http"]) != 1 {
+		t.Fatalf("Expected a socket named http, got %v.", files)
+	}
+	activated, err := net.FileListener(files["http"][0])
+	files["http"][0].Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer activated.Close()
+	if activated.Addr().String() != l.Addr().String() {
+		t.Errorf("Expected the activated socket to listen on %s, got %s.", l.Addr(), activated.Addr())
+	}
+
+	env["LISTEN_PID"] = "1"
+	if files, err := parseSystemdSockets(func(key string) string { return env[key] }, fd); err != nil || len(files) != 0 {
+		t.Errorf("Expected the sockets of another process to be ignored, got %v, %v.", files, err)
+	}
+	env["LISTEN_PID"], env["LISTEN_FDS"] = strconv.Itoa(os.Getpid()), "x"
+	if _, err := parseSystemdSockets(func(key string) string { return env[key] }, fd); err == nil {
+		t.Error("Expected an invalid LISTEN_FDS to fail.")
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestListenNetwork(t *testing.T) {
+	for address, network := range map[string]string{
+		":9201":                "tcp",
+		"localhost:9201":       "tcp",
+		"0.0.0.0:9201":         "tcp4",
+		"127.0.0.1:9201":       "tcp4",
+		"[::]:9201":            "tcp6",
+		"[2001:db8::1]:9201":   "tcp6",
+		"[::ffff:10.0.0.1]:80": "tcp4",
+	} {
+		if n := listenNetwork(address); n != network {
+			t.Errorf("Expected %s to listen on %s, got %s.", address, network, n)
+		}
+	}
+}
+
+func TestListenAddresses(t *testing.T) {
+	listeners, err := listen("127.0.0.1:0, 127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	if len(listeners) != 2 {
+		t.Fatalf("Expected 2 listeners, got %d.", len(listeners))
+	}
+	if _, err := listen(" , "); err != errNoListenAddress {
+		t.Fatalf("Expected %v, got %v.", errNoListenAddress, err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeper"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var errUnknownClientAuth = errors.New("Unknown TLS client auth mode. Valid modes are 'none', 'verify' and 'require'.")
+var errClientAuthNoCA = errors.New("A client CA must be provided to verify client certificates.")
+
+// listenerTLS holds the certificate and client CA of the listener, which can be
+// reloaded from disk while the server is running.
+type listenerTLS struct {
+	sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	// The TLS configuration of the listener, which the configuration of each
+	// connection is cloned from.
+	base *tls.Config
+}
+
+func clientAuthType(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "verify":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, errUnknownClientAuth
+	}
+}
+
+// Reads the listener certificate and client CA from disk.
+func (l *listenerTLS) Load() error {
+	cert, err := tls.LoadX509KeyPair(config.TlsCert, config.TlsKey)
+	if err != nil {
+		return err
+	}
+	var clientCAs *x509.CertPool
+	if config.TlsClientCa != "" {
+		if clientCAs, err = gatekeeper.LoadCAPath(config.TlsClientCa); err != nil {
+			return err
+		}
+	}
+	l.Lock()
+	l.cert = &cert
+	l.clientCAs = clientCAs
+	l.Unlock()
+	return nil
+}
+
+func (l *listenerTLS) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.RLock()
+	defer l.RUnlock()
+	return l.cert, nil
+}
+
+// The configuration of a connection is the listener's with the current
+// certificate and client CA, so that the protocols, versions and cipher suites
+// of the listener apply to every connection.
+func (l *listenerTLS) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	clientAuth, _ := clientAuthType(config.TlsClientAuth)
+	l.RLock()
+	defer l.RUnlock()
+	c := l.base.Clone()
+	c.GetConfigForClient = nil
+	c.Certificates = []tls.Certificate{*l.cert}
+	c.ClientAuth = clientAuth
+	c.ClientCAs = l.clientCAs
+	return c, nil
+}
+
+// Reload the certificate and client CA whenever gatekeeper receives a SIGHUP.
+func (l *listenerTLS) watchReload() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := l.Load(); err == nil {
+			log.Println("Reloaded TLS certificates.")
+		} else {
+			log.Printf("Failed to reload TLS certificates, continuing with the previous certificates. Error: %v", err)
+		}
+	}
+}
+
+// Creates the TLS configuration for the listener from the TLS_* settings, along
+// with the certificates it serves, which can be reloaded.
+func newListenerTLSConfig() (*tls.Config, *listenerTLS, error) {
+	clientAuth, err := clientAuthType(config.TlsClientAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+	if clientAuth != tls.NoClientCert && config.TlsClientCa == "" {
+		return nil, nil, errClientAuthNoCA
+	}
+
+	l := &listenerTLS{}
+	if err := l.Load(); err != nil {
+		return nil, nil, err
+	}
+
+	// the servers add the protocols they speak to a copy of the configuration,
+	// so they are set here for the configurations cloned from it
+	l.base = &tls.Config{
+		NextProtos:         []string{"h2", "http/1.1"},
+		GetCertificate:     l.getCertificate,
+		GetConfigForClient: l.getConfigForClient,
+	}
+	return l.base, l, nil
+}
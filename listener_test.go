@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and its key, signed by the ca if it has one.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, ca *testCert, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signer := template, key
+	if ca == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert, key, der}
+}
+
+func (c *testCert) write(t *testing.T, certFile string, keyFile string) {
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	key, _ := x509.MarshalECPrivateKey(c.key)
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestListenerMutualTLS(t *testing.T) {
+	defer func(cert, key, ca, auth string) {
+		config.TlsCert, config.TlsKey, config.TlsClientCa, config.TlsClientAuth = cert, key, ca, auth
+	}(config.TlsCert, config.TlsKey, config.TlsClientCa, config.TlsClientAuth)
+
+	dir, err := ioutil.TempDir("", "listener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCert(t, "ca", nil, x509.ExtKeyUsageAny)
+	newTestCert(t, "gatekeeper", ca, x509.ExtKeyUsageServerAuth).write(t, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	ca.write(t, filepath.Join(dir, "ca.pem"), "")
+	client := newTestCert(t, "task", ca, x509.ExtKeyUsageClientAuth)
+	config.TlsCert, config.TlsKey, config.TlsClientCa, config.TlsClientAuth = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem"), "require"
+
+	tlsConfig, l, err := newListenerTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{TLSConfig: tlsConfig, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "task" {
+			w.WriteHeader(403)
+		}
+	})}
+	go server.ServeTLS(listener, "", "")
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs ...tls.Certificate) (*http.Response, error) {
+		c := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+			ForceAttemptHTTP2: true,
+		}}
+		defer c.CloseIdleConnections()
+		resp, err := c.Get("https://" + listener.Addr().String() + "/health")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	if _, err := get(); err == nil {
+		t.Error("Expected a client without a certificate to be refused.")
+	}
+	resp, err := get(client.tlsCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("Expected the client certificate to be verified, got status code %d.", resp.StatusCode)
+	}
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Expected the protocols of the listener to be negotiated, got %s.", resp.Proto)
+	}
+
+	// the certificate and client CA are reloaded
+	reloaded := newTestCert(t, "gatekeeper-reloaded", ca, x509.ExtKeyUsageServerAuth)
+	reloaded.write(t, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	otherCa := newTestCert(t, "other-ca", nil, x509.ExtKeyUsageAny)
+	otherCa.write(t, filepath.Join(dir, "ca.pem"), "")
+	if err := l.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(client.tlsCertificate()); err == nil {
+		t.Error("Expected a client certificate of the previous client CA to be refused after the reload.")
+	}
+	resp, err = get(newTestCert(t, "task", otherCa, x509.ExtKeyUsageClientAuth).tlsCertificate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; cn != "gatekeeper-reloaded" {
+		t.Errorf("Expected the reloaded certificate to be served, got '%s'.", cn)
+	}
+}
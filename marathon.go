@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// marathonApps tracks the apps deployed on marathon, so that apps without a
+// matching policy can be reported.
+var marathonApps struct {
+	sync.RWMutex
+	ids []string
+	// The name and id of the marathon framework, to match policy entries
+	// keyed by framework.
+	frameworks []string
+	synced     time.Time
+}
+
+// Marathon names the mesos tasks of an app after the app id, with the path
+// reversed and joined by dots. For example /web/frontend becomes frontend.web.
+func marathonTaskName(appId string) string {
+	parts := strings.Split(strings.Trim(appId, "/"), "/")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, ".")
+}
+
+// The inverse of marathonTaskName.
+func marathonAppId(taskName string) string {
+	parts := strings.Split(taskName, ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+func marathonPath(path string, query string) string {
+	u, _ := url.Parse(config.Marathon)
+	u.Path = path
+	u.RawQuery = query
+	return u.String()
+}
+
+// Fetch the ids of every app deployed on marathon.
+func syncMarathonApps() error {
+	resp, err := http.Get(marathonPath("/v2/apps", ""))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Marathon responded with status code %d.", resp.StatusCode)
+	}
+	var apps struct {
+		Apps []struct {
+			Id string `json:"id"`
+		} `json:"apps"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apps); err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(apps.Apps))
+	for _, app := range apps.Apps {
+		ids = append(ids, app.Id)
+	}
+	sort.Strings(ids)
+
+	frameworks, err := marathonFrameworks()
+	if err != nil {
+		return err
+	}
+
+	marathonApps.Lock()
+	marathonApps.ids = ids
+	marathonApps.frameworks = frameworks
+	marathonApps.synced = time.Now()
+	marathonApps.Unlock()
+
+	for _, id := range unmatchedMarathonApps() {
+		log.Printf("Warning: marathon app '%s' (task name: %s) has no matching policy and will be given the '*' or default policy.", id, marathonTaskName(id))
+	}
+	return nil
+}
+
+// Fetch the name and the mesos framework id of marathon.
+func marathonFrameworks() ([]string, error) {
+	resp, err := http.Get(marathonPath("/v2/info", ""))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Marathon responded with status code %d.", resp.StatusCode)
+	}
+	var info struct {
+		Name        string `json:"name"`
+		FrameworkId string `json:"frameworkId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return []string{info.Name, info.FrameworkId}, nil
+}
+
+// Returns the marathon apps whose tasks don't have a policy of their own, either
+// by task name or keyed by the marathon framework.
+func unmatchedMarathonApps() []string {
+	marathonApps.RLock()
+	ids, frameworks := marathonApps.ids, marathonApps.frameworks
+	marathonApps.RUnlock()
+
+	var unmatched []string
+	current := activePolicies.Get()
+	for _, id := range ids {
+		if key, _ := current.MatchTask(marathonTaskName(id), frameworks...); key == "*" || key == "" {
+			unmatched = append(unmatched, id)
+		}
+	}
+	return unmatched
+}
+
+// Subscribe to the marathon event stream, and resync the deployed apps
+// whenever a deployment finishes.
+func watchMarathonEvents() error {
+	req, err := http.NewRequest("GET", marathonPath("/v2/events", "event_type=deployment_success&event_type=app_terminated_event"), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Marathon responded with status code %d.", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	event := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case line == "":
+			if event == "deployment_success" || event == "app_terminated_event" {
+				if err := syncMarathonApps(); err != nil {
+					log.Printf("Failed to sync marathon apps: %v", err)
+				}
+			}
+			event = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("Marathon closed the event stream.")
+}
+
+func marathonWatcher() {
+	for {
+		if err := syncMarathonApps(); err != nil {
+			log.Printf("Failed to sync marathon apps: %v", err)
+		}
+		if err := watchMarathonEvents(); err != nil {
+			log.Printf("Lost marathon event stream, reconnecting in 5s. Error: %v", err)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// PolicyHealth fails when a deployed marathon app has no matching policy,
+// so that it can be used as a health check.
+func PolicyHealth(c *gin.Context) {
+	if config.Marathon == "" {
+		c.JSON(404, struct {
+			Ok    bool   `json:"ok"`
+			Error string `json:"error"`
+		}{false, "Marathon integration is not enabled."})
+		return
+	}
+	marathonApps.RLock()
+	synced := marathonApps.synced
+	marathonApps.RUnlock()
+
+	unmatched := unmatchedMarathonApps()
+	code := 200
+	if len(unmatched) > 0 {
+		code = 503
+	}
+	c.JSON(code, struct {
+		Ok        bool      `json:"ok"`
+		Synced    time.Time `json:"synced"`
+		Unmatched []string  `json:"unmatched"`
+	}{len(unmatched) == 0, synced, unmatched})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMarathonTaskName(t *testing.T) {
+	for appId, expected := range map[string]string{
+		"/web":           "web",
+		"/web/frontend":  "frontend.web",
+		"/prod/web/api/": "api.web.prod",
+	} {
+		if taskName := marathonTaskName(appId); taskName != expected {
+			t.Errorf("Expected app '%s' to have task name '%s', got '%s'.", appId, expected, taskName)
+		}
+	}
+}
+
+func TestMarathonAppId(t *testing.T) {
+	for taskName, expected := range map[string]string{
+		"web":          "/web",
+		"frontend.web": "/web/frontend",
+		"api.web.prod": "/prod/web/api",
+	} {
+		if appId := marathonAppId(taskName); appId != expected {
+			t.Errorf("Expected task '%s' to have app id '%s', got '%s'.", taskName, expected, appId)
+		}
+	}
+}
+
+func TestUnmatchedMarathonApps(t *testing.T) {
+	defer activePolicies.Set(activePolicies.Get())
+	defer func(ids, frameworks []string) {
+		marathonApps.ids, marathonApps.frameworks = ids, frameworks
+	}(marathonApps.ids, marathonApps.frameworks)
+
+	activePolicies.Set(policies{
+		"*":                          &policy{Ttl: 60},
+		"frontend.web":               &policy{Ttl: 60},
+		"marathon-prod:/web/api":     &policy{Ttl: 60},
+		"marathon-staging:/web/jobs": &policy{Ttl: 60},
+	})
+	marathonApps.ids = []string{"/web/api", "/web/frontend", "/web/jobs"}
+	marathonApps.frameworks = []string{"marathon-prod", "20180101-000000-1-5050-0001"}
+
+	unmatched := unmatchedMarathonApps()
+	if len(unmatched) != 1 || unmatched[0] != "/web/jobs" {
+		t.Errorf("Expected only the app keyed under another framework to be unmatched, got %v.", unmatched)
+	}
+}
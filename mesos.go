@@ -1,14 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeper"
 	"github.com/samuel/go-zookeeper/zk"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -27,11 +36,12 @@ type mesosMaster struct {
 }
 
 type mesosTask struct {
-	Id        string `json:"id"`
-	Name      string `json:"name"`
-	State     string `json:"state"`
-	SlaveId   string `json:"slave_id"`
-	Resources struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	SlaveId     string `json:"slave_id"`
+	FrameworkId string `json:"framework_id"`
+	Resources   struct {
 		Cpus  float64 `json:"cpus"`
 		Disk  float64 `json:"disk"`
 		Mem   float64 `json:"mem"`
@@ -41,6 +51,13 @@ type mesosTask struct {
 		State     string  `json:"state"`
 		Timestamp float64 `json:"timestamp"`
 	} `json:"statuses"`
+	// When the credential the task was attested with expires, for tasks that
+	// aren't looked up on mesos.
+	NotAfter time.Time `json:"-"`
+	// The instances of the task that haven't terminated, counted in the same
+	// task list the task was found in. 0 if the task wasn't looked up in a
+	// task list.
+	Instances int `json:"-"`
 }
 
 type mesosState struct {
@@ -62,6 +79,138 @@ var errMesosNoMaster = errors.New("Error finding mesos master.")
 var errUnknownScheme = errors.New("Unknown mesos scheme.")
 var errMesosUnreachable = errors.New("No reachable mesos masters.")
 var errNoSuchTask = errors.New("No such task.")
+var errNoSuchAgent = errors.New("No such mesos agent.")
+var errNoSuchFramework = errors.New("No such mesos framework.")
+var errMesosUnauthorized = errors.New("Not authorized to query the mesos master. Check MESOS_PRINCIPAL and MESOS_SECRET.")
+
+// The calls to zookeeper the mesos leader lookup makes.
+type zkConn interface {
+	Children(path string) ([]string, *zk.Stat, error)
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	Close()
+}
+
+var zkConnect = func(servers []string) (zkConn, error) {
+	zoo, _, err := zk.Connect(servers, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return zoo, nil
+}
+
+// mesosLeaderWatcher keeps track of the leading mesos master registered in
+// zookeeper, and follows it when leadership changes.
+type mesosLeaderWatcher struct {
+	sync.RWMutex
+	servers string
+	path    string
+	zoo     zkConn
+	leader  string
+	err     error
+	stop    chan struct{}
+}
+
+// The watcher of the zookeeper servers and path of MESOS_MASTER, replaced when
+// the setting changes.
+var mesosLeader struct {
+	sync.Mutex
+	watcher *mesosLeaderWatcher
+}
+
+func newMesosLeaderWatcher(servers string, path string) *mesosLeaderWatcher {
+	w := &mesosLeaderWatcher{servers: servers, path: path, stop: make(chan struct{})}
+	if zoo, err := zkConnect(strings.Split(servers, ",")); err == nil {
+		w.zoo = zoo
+		go w.watch()
+	} else {
+		w.err = err
+	}
+	return w
+}
+
+// getMesosLeaderWatcher returns the watcher of the zookeeper servers and path,
+// stopping the watcher of a previous setting.
+func getMesosLeaderWatcher(servers string, path string) *mesosLeaderWatcher {
+	mesosLeader.Lock()
+	defer mesosLeader.Unlock()
+	if w := mesosLeader.watcher; w != nil {
+		if w.servers == servers && w.path == path {
+			return w
+		}
+		w.Stop()
+	}
+	mesosLeader.watcher = newMesosLeaderWatcher(servers, path)
+	return mesosLeader.watcher
+}
+
+func (w *mesosLeaderWatcher) Stop() {
+	close(w.stop)
+	if w.zoo != nil {
+		w.zoo.Close()
+	}
+}
+
+func (w *mesosLeaderWatcher) Leader() (string, error) {
+	w.RLock()
+	defer w.RUnlock()
+	return w.leader, w.err
+}
+
+// Read the current leader, and wait for the set of masters to change.
+func (w *mesosLeaderWatcher) watch() {
+	for {
+		children, _, events, err := w.zoo.ChildrenW(w.path)
+		select {
+		case <-w.stop:
+			// the connection was closed by Stop
+			return
+		default:
+		}
+		if err != nil {
+			w.Lock()
+			w.err = errMesosNoMaster
+			w.Unlock()
+			log.Printf("Failed to watch mesos masters in zookeeper, retrying in 5s. Error: %v", err)
+			select {
+			case <-time.After(5 * time.Second):
+				continue
+			case <-w.stop:
+				return
+			}
+		}
+		leader, err := readMesosLeader(w.zoo, w.path, children)
+		w.Lock()
+		if err == nil && leader != w.leader {
+			log.Printf("Mesos master leader is now '%s'.", leader)
+		}
+		w.leader, w.err = leader, err
+		w.Unlock()
+		select {
+		case <-events:
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// The leading master is the one with the lowest sequence number.
+func readMesosLeader(zoo zkConn, zookeeperPath string, children []string) (string, error) {
+	sort.Strings(children)
+	for _, child := range children {
+		if strings.HasPrefix(child, "json.info_") {
+			if data, _, err := zoo.Get(zookeeperPath + "/" + child); err == nil {
+				var masterInfo mesosMaster
+				if err := json.Unmarshal(data, &masterInfo); err == nil {
+					return fmt.Sprintf("%s:%d", masterInfo.Address.Hostname, masterInfo.Address.Port), nil
+				} else {
+					return "", errMesosParseError
+				}
+			}
+		}
+	}
+	return "", errMesosNoMaster
+}
 
 func getMesosMaster() ([]string, error) {
 	var masterHosts []string
@@ -76,23 +225,16 @@ func getMesosMaster() ([]string, error) {
 			if zookeeperPath[0] != '/' {
 				zookeeperPath = "/" + zookeeperPath
 			}
-			if zoo, _, err := zk.Connect(strings.Split(path.Host, ","), 10*time.Second); err == nil {
+			if leader, err := getMesosLeaderWatcher(path.Host, zookeeperPath).Leader(); err == nil && leader != "" {
+				masterHosts = []string{leader}
+			} else if zoo, err := zkConnect(strings.Split(path.Host, ",")); err == nil {
+				// The watcher hasn't found the leader yet, look it up directly.
 				defer zoo.Close()
 				if children, _, err := zoo.Children(zookeeperPath); err == nil {
-					sort.Strings(children)
-					for _, child := range children {
-						if strings.HasPrefix(child, "json.info_") {
-							if data, _, err := zoo.Get(zookeeperPath + "/" + child); err == nil {
-								var masterInfo mesosMaster
-								if err := json.Unmarshal(data, &masterInfo); err == nil {
-									masterHosts = []string{fmt.Sprintf("%s:%d", masterInfo.Address.Hostname, masterInfo.Address.Port)}
-									break
-								} else {
-									return nil, errMesosParseError
-
-								}
-							}
-						}
+					if leader, err := readMesosLeader(zoo, zookeeperPath, children); err == nil {
+						masterHosts = []string{leader}
+					} else {
+						return nil, err
 					}
 				} else {
 					return nil, errMesosNoMaster
@@ -113,12 +255,130 @@ func getMesosMaster() ([]string, error) {
 	return masterHosts, nil
 }
 
-func getMesosTask(taskId string) (mesosTask, error) {
+// The client used to talk to the mesos masters, configured by the MESOS_*
+// TLS and timeout settings.
+var mesosClient = http.DefaultClient
+
+// The client has no overall timeout, as the event stream of the task cache
+// stays open. Requests for a single response are bounded by MESOS_TIMEOUT
+// through their context instead.
+func newMesosClient() (*http.Client, error) {
+	tr := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: config.MesosConnect, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   config.MesosConnect,
+		ResponseHeaderTimeout: config.MesosTimeout,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: config.MesosInsecure},
+	}
+	if config.MesosCaCert != "" {
+		if certs, err := gatekeeper.LoadCAPath(config.MesosCaCert); err == nil {
+			tr.TLSClientConfig.RootCAs = certs
+		} else {
+			return nil, err
+		}
+	}
+	return &http.Client{Transport: tr}, nil
+}
+
+func mesosScheme() string {
+	if u, err := url.Parse(config.Mesos); (err == nil && u.Scheme == "https") || config.MesosTls {
+		return "https"
+	}
+	return "http"
+}
+
+// How many redirects to the leading master a request to a mesos master follows.
+const mesosMaxRedirects = 5
+
+// Makes a request to a mesos master, authenticating with the configured
+// principal if any. Masters that aren't leading redirect v1 calls to the
+// leader, which is followed here rather than by net/http, as net/http drops
+// the credentials on a redirect to another host.
+func mesosRequest(ctx context.Context, method string, host string, path string, body interface{}) (*http.Response, error) {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	client := *mesosClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	u, err := url.Parse(mesosScheme() + "://" + host + path)
+	if err != nil {
+		return nil, err
+	}
+	for redirects := 0; ; redirects++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(b)
+		}
+		req, err := http.NewRequest(method, u.String(), reader)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", "application/json")
+		}
+		if config.MesosPrincipal != "" {
+			req.SetBasicAuth(config.MesosPrincipal, config.MesosSecret)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.StatusCode {
+		case 401, 403:
+			resp.Body.Close()
+			return nil, errMesosUnauthorized
+		case 307, 308:
+			location, err := resp.Location()
+			if err != nil || redirects >= mesosMaxRedirects {
+				return resp, nil
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			u = location
+			continue
+		}
+		return resp, nil
+	}
+}
+
+func getMesosTask(ctx context.Context, taskId string) (mesosTask, error) {
+	if task, ok := mesosTasks.Get(taskId); ok {
+		return task, nil
+	}
+	tasks, err := getMesosTasks(ctx)
+	if err != nil {
+		return mesosTask{}, err
+	}
+	for _, task := range tasks {
+		if task.Id == taskId {
+			task.Instances = countInstances(tasks, task)
+			return task, nil
+		}
+	}
+	return mesosTask{}, errNoSuchTask
+}
+
+// getMesosTasks fetches the tasks of all the frameworks from the leading
+// mesos master.
+func getMesosTasks(ctx context.Context) ([]mesosTask, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.MesosTimeout)
+	defer cancel()
+	if config.MesosApi == "v1" {
+		return getMesosTasksV1(ctx)
+	}
 	var state mesosState
 	var masterErr error
 	if masterHosts, err := getMesosMaster(); err == nil {
 		for _, host := range masterHosts {
-			if resp, err := http.Get("http://" + host + "/state.json"); err == nil {
+			if resp, err := mesosRequest(ctx, "GET", host, "/state.json", nil); err == nil {
 				defer resp.Body.Close()
 				if err := json.NewDecoder(resp.Body).Decode(&state); err == nil {
 					if state.Pid == state.Leader {
@@ -133,22 +393,222 @@ func getMesosTask(taskId string) (mesosTask, error) {
 			}
 		}
 		if masterErr != nil {
-			return mesosTask{}, masterErr
+			return nil, masterErr
 		}
 		if state.Pid != state.Leader {
-			return mesosTask{}, errMesosUnreachable
+			return nil, errMesosUnreachable
 		}
 
+		var tasks []mesosTask
 		for _, framework := range state.Frameworks {
-			for _, task := range framework.Tasks {
-				if task.Id == taskId {
-					return task, nil
+			tasks = append(tasks, framework.Tasks...)
+		}
+		return tasks, nil
+	} else {
+		return nil, err
+	}
+}
+
+// countMesosTasks counts the instances of the task that haven't terminated.
+// The count of the task list the task was looked up in is used if there is
+// one, so that the tasks aren't fetched from the master twice.
+func countMesosTasks(ctx context.Context, task mesosTask) (int, error) {
+	if task.Instances > 0 {
+		return task.Instances, nil
+	}
+	if n, ok := mesosTasks.Count(task); ok {
+		return n, nil
+	}
+	tasks, err := getMesosTasks(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return countInstances(tasks, task), nil
+}
+
+// countInstances counts the tasks that haven't terminated that are instances of
+// the task: tasks of the same name, launched by the same framework. Apps of
+// the same name on other frameworks are other apps.
+func countInstances(tasks []mesosTask, task mesosTask) int {
+	n := 0
+	for _, t := range tasks {
+		if sameApp(t, task) && !mesosTaskTerminal(t.State) {
+			n++
+		}
+	}
+	return n
+}
+
+// Tasks attested without mesos have no framework, and only go by their name.
+func sameApp(t mesosTask, task mesosTask) bool {
+	return t.Name == task.Name && (task.FrameworkId == "" || t.FrameworkId == task.FrameworkId)
+}
+
+type mesosV1Task struct {
+	Name   string `json:"name"`
+	TaskId struct {
+		Value string `json:"value"`
+	} `json:"task_id"`
+	FrameworkId struct {
+		Value string `json:"value"`
+	} `json:"framework_id"`
+	AgentId struct {
+		Value string `json:"value"`
+	} `json:"agent_id"`
+	State    string `json:"state"`
+	Statuses []struct {
+		State     string  `json:"state"`
+		Timestamp float64 `json:"timestamp"`
+	} `json:"statuses"`
+}
+
+func (t mesosV1Task) mesosTask() mesosTask {
+	return mesosTask{
+		Id:          t.TaskId.Value,
+		Name:        t.Name,
+		State:       t.State,
+		SlaveId:     t.AgentId.Value,
+		FrameworkId: t.FrameworkId.Value,
+		Statuses:    t.Statuses,
+	}
+}
+
+// Fetches the tasks with the GET_TASKS call of the v1 operator API. Masters
+// that aren't leading redirect the call to the leader.
+func getMesosTasksV1(ctx context.Context) ([]mesosTask, error) {
+	var tasks struct {
+		GetTasks struct {
+			Tasks []mesosV1Task `json:"tasks"`
+		} `json:"get_tasks"`
+	}
+	var masterErr error
+	if masterHosts, err := getMesosMaster(); err == nil {
+		for _, host := range masterHosts {
+			if resp, err := mesosRequest(ctx, "POST", host, "/api/v1", struct {
+				Type string `json:"type"`
+			}{"GET_TASKS"}); err == nil {
+				defer resp.Body.Close()
+				if resp.StatusCode != 200 {
+					masterErr = fmt.Errorf("Mesos master '%s' responded with status code %d.", host, resp.StatusCode)
+				} else if err := json.NewDecoder(resp.Body).Decode(&tasks); err == nil {
+					masterErr = nil
+					break
+				} else {
+					masterErr = err
 				}
+			} else {
+				masterErr = err
 			}
+		}
+		if masterErr != nil {
+			return nil, masterErr
+		}
 
+		list := make([]mesosTask, len(tasks.GetTasks.Tasks))
+		for i, task := range tasks.GetTasks.Tasks {
+			list[i] = task.mesosTask()
 		}
-		return mesosTask{}, errNoSuchTask
+		return list, nil
 	} else {
-		return mesosTask{}, err
+		return nil, err
+	}
+}
+
+// Fetches an endpoint of the leading mesos master and decodes the json
+// response into v.
+func getMesosMasterJson(ctx context.Context, path string, v interface{}) error {
+	masterHosts, err := getMesosMaster()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.MesosTimeout)
+	defer cancel()
+	var masterErr error
+	for _, host := range masterHosts {
+		if resp, err := mesosRequest(ctx, "GET", host, path, nil); err == nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				masterErr = fmt.Errorf("Mesos master '%s' responded with status code %d.", host, resp.StatusCode)
+			} else if err := json.NewDecoder(resp.Body).Decode(v); err == nil {
+				return nil
+			} else {
+				masterErr = err
+			}
+		} else {
+			masterErr = err
+		}
+	}
+	return masterErr
+}
+
+func getMesosAgentHostname(ctx context.Context, agentId string) (string, error) {
+	var agents struct {
+		Slaves []struct {
+			Id       string `json:"id"`
+			Hostname string `json:"hostname"`
+		} `json:"slaves"`
+	}
+	if err := getMesosMasterJson(ctx, "/master/slaves", &agents); err != nil {
+		return "", err
+	}
+	for _, agent := range agents.Slaves {
+		if agent.Id == agentId {
+			return agent.Hostname, nil
+		}
 	}
+	return "", errNoSuchAgent
+}
+
+// Framework names are cached for mesosFrameworkTtl, as the master lists every
+// framework on each lookup, and a framework keeps its name while it's registered.
+const mesosFrameworkTtl = 5 * time.Minute
+
+type mesosFrameworkCache struct {
+	sync.Mutex
+	names   map[string]string
+	fetched time.Time
+}
+
+var mesosFrameworks = newMesosFrameworkCache()
+
+func newMesosFrameworkCache() *mesosFrameworkCache {
+	return &mesosFrameworkCache{names: make(map[string]string)}
+}
+
+// Get returns the name of the framework, fetching the frameworks from the master
+// when the cache has expired or the framework isn't in it yet.
+func (c *mesosFrameworkCache) Get(ctx context.Context, frameworkId string, now time.Time) (string, error) {
+	c.Lock()
+	name, ok := c.names[frameworkId]
+	fresh := now.Sub(c.fetched) < mesosFrameworkTtl
+	c.Unlock()
+	if ok && fresh {
+		return name, nil
+	}
+
+	var frameworks struct {
+		Frameworks []struct {
+			Id   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"frameworks"`
+	}
+	if err := getMesosMasterJson(ctx, "/master/frameworks", &frameworks); err != nil {
+		return "", err
+	}
+	names := make(map[string]string, len(frameworks.Frameworks))
+	for _, framework := range frameworks.Frameworks {
+		names[framework.Id] = framework.Name
+	}
+	c.Lock()
+	c.names, c.fetched = names, now
+	c.Unlock()
+
+	if name, ok := names[frameworkId]; ok {
+		return name, nil
+	}
+	return "", errNoSuchFramework
+}
+
+func getMesosFrameworkName(ctx context.Context, frameworkId string) (string, error) {
+	return mesosFrameworks.Get(ctx, frameworkId, time.Now())
 }
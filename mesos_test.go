@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/samuel/go-zookeeper/zk"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMesosRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	mesos, timeout := config.Mesos, config.MesosTimeout
+	defer func() { config.Mesos, config.MesosTimeout = mesos, timeout }()
+	config.Mesos, config.MesosTimeout = ts.URL, 50*time.Millisecond
+
+	start := time.Now()
+	var v struct{}
+	if err := getMesosMasterJson(context.Background(), "/master/slaves", &v); err == nil {
+		t.Fatal("Expected the request to time out.")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected the request to be bounded by MESOS_TIMEOUT, took %v.", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	config.MesosTimeout = time.Minute
+	if _, err := getMesosTask(ctx, "no-such-task"); err == nil {
+		t.Fatal("Expected the lookup to fail with a cancelled context.")
+	}
+}
+
+func TestCountMesosTasks(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"pid":"master@10.0.0.1:5050","leader":"master@10.0.0.1:5050","frameworks":[
+			{"tasks":[{"id":"web.1","name":"web","framework_id":"marathon","state":"TASK_RUNNING"},{"id":"web.2","name":"web","framework_id":"marathon","state":"TASK_STAGING"}]},
+			{"tasks":[{"id":"web.3","name":"web","framework_id":"marathon","state":"TASK_KILLED"},{"id":"db.1","name":"db","framework_id":"marathon","state":"TASK_RUNNING"}]},
+			{"tasks":[{"id":"web.4","name":"web","framework_id":"aurora","state":"TASK_RUNNING"}]}
+		]}`))
+	}))
+	defer ts.Close()
+
+	mesos, api := config.Mesos, config.MesosApi
+	defer func() { config.Mesos, config.MesosApi = mesos, api }()
+	config.Mesos, config.MesosApi = ts.URL, "state"
+
+	if n, err := countMesosTasks(context.Background(), mesosTask{Name: "web", FrameworkId: "marathon"}); err != nil || n != 2 {
+		t.Errorf("Expected 2 running instances of web on marathon, got %d, %v.", n, err)
+	}
+	if task, err := getMesosTask(context.Background(), "db.1"); err != nil || task.Name != "db" {
+		t.Errorf("Expected to find db.1, got %v, %v.", task, err)
+	}
+
+	// the instances are counted in the task list the task was found in
+	requests = 0
+	task, err := getMesosTask(context.Background(), "web.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := countMesosTasks(context.Background(), task); err != nil || n != 1 || requests != 1 {
+		t.Errorf("Expected 1 running instance of web on aurora from a single request, got %d, %v (%d requests).", n, err, requests)
+	}
+}
+
+func TestMesosTasksV1Redirect(t *testing.T) {
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "gatekeeper" || pass != "secret" {
+			w.WriteHeader(401)
+			return
+		}
+		var call struct {
+			Type string `json:"type"`
+		}
+		if r.Method != "POST" || r.URL.Path != "/api/v1" || json.NewDecoder(r.Body).Decode(&call) != nil || call.Type != "GET_TASKS" {
+			w.WriteHeader(400)
+			return
+		}
+		w.Write([]byte(`{"type":"GET_TASKS","get_tasks":{"tasks":[
+			{"task_id":{"value":"web.1"},"name":"web","framework_id":{"value":"marathon"},"agent_id":{"value":"S1"},"state":"TASK_RUNNING","statuses":[{"state":"TASK_RUNNING","timestamp":1}]}
+		]}}`))
+	}))
+	defer leader.Close()
+	// masters that aren't leading redirect to the leader without a scheme
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "//"+strings.TrimPrefix(leader.URL, "http://")+r.URL.Path)
+		w.WriteHeader(307)
+	}))
+	defer follower.Close()
+
+	mesos, api, principal, secret := config.Mesos, config.MesosApi, config.MesosPrincipal, config.MesosSecret
+	defer func() {
+		config.Mesos, config.MesosApi, config.MesosPrincipal, config.MesosSecret = mesos, api, principal, secret
+	}()
+	config.Mesos, config.MesosApi, config.MesosPrincipal, config.MesosSecret = follower.URL, "v1", "gatekeeper", "secret"
+
+	task, err := getMesosTask(context.Background(), "web.1")
+	if err != nil || task.Name != "web" || task.FrameworkId != "marathon" || task.SlaveId != "S1" || len(task.Statuses) != 1 {
+		t.Fatalf("Expected web.1 from the leader, got %+v, %v.", task, err)
+	}
+
+	config.MesosSecret = "wrong"
+	if _, err := getMesosTask(context.Background(), "web.1"); err != errMesosUnauthorized {
+		t.Errorf("Expected the leader to reject the wrong secret, got %v.", err)
+	}
+}
+
+func TestMesosFrameworkCache(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"frameworks":[{"id":"f-1","name":"marathon"},{"id":"f-2","name":"chronos"}]}`))
+	}))
+	defer ts.Close()
+
+	mesos := config.Mesos
+	defer func() { config.Mesos = mesos }()
+	config.Mesos = ts.URL
+
+	cache, now := newMesosFrameworkCache(), time.Now()
+	for _, lookup := range []struct {
+		id       string
+		at       time.Duration
+		name     string
+		requests int
+	}{
+		{"f-1", 0, "marathon", 1},
+		{"f-2", time.Minute, "chronos", 1},
+		{"f-3", time.Minute, "", 2},
+		{"f-1", mesosFrameworkTtl + 2*time.Minute, "marathon", 3},
+	} {
+		name, err := cache.Get(context.Background(), lookup.id, now.Add(lookup.at))
+		if name != lookup.name || requests != lookup.requests {
+			t.Errorf("Expected framework '%s' to be '%s' after %d requests, got '%s' after %d requests.", lookup.id, lookup.name, lookup.requests, name, requests)
+		}
+		if lookup.name == "" && err != errNoSuchFramework {
+			t.Errorf("Expected unknown framework '%s' to fail with errNoSuchFramework, got %v.", lookup.id, err)
+		}
+	}
+}
+
+// fakeZk serves the masters registered under /mesos, notifying the watches of
+// the children when they change.
+type fakeZk struct {
+	sync.Mutex
+	masters map[string]string // child to data
+	watches []chan zk.Event
+}
+
+func newFakeZk(leaders ...string) *fakeZk {
+	f := &fakeZk{}
+	f.setMasters(leaders...)
+	return f
+}
+
+// setMasters registers the masters in order of leadership, after the log
+// replicas that mesos registers under the same path.
+func (f *fakeZk) setMasters(masters ...string) {
+	f.Lock()
+	defer f.Unlock()
+	f.masters = map[string]string{"log_replicas": ""}
+	for i, master := range masters {
+		f.masters[fmt.Sprintf("json.info_%010d", i+1)] = master
+	}
+	for _, watch := range f.watches {
+		watch <- zk.Event{Type: zk.EventNodeChildrenChanged, Path: "/mesos"}
+	}
+	f.watches = nil
+}
+
+func (f *fakeZk) Children(path string) ([]string, *zk.Stat, error) {
+	f.Lock()
+	defer f.Unlock()
+	if path != "/mesos" {
+		return nil, nil, errors.New("zk: node does not exist")
+	}
+	var children []string
+	for child := range f.masters {
+		children = append(children, child)
+	}
+	return children, &zk.Stat{}, nil
+}
+
+func (f *fakeZk) ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	children, stat, err := f.Children(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	watch := make(chan zk.Event, 1)
+	f.Lock()
+	f.watches = append(f.watches, watch)
+	f.Unlock()
+	return children, stat, watch, nil
+}
+
+func (f *fakeZk) Get(path string) ([]byte, *zk.Stat, error) {
+	f.Lock()
+	defer f.Unlock()
+	data, ok := f.masters[strings.TrimPrefix(path, "/mesos/")]
+	if !ok {
+		return nil, nil, errors.New("zk: node does not exist")
+	}
+	return []byte(data), &zk.Stat{}, nil
+}
+
+func (f *fakeZk) Close() {}
+
+func zkMasterInfo(hostname string) string {
+	return fmt.Sprintf(`{"address":{"hostname":"%s","ip":"10.0.0.1","port":5050},"hostname":"%s","port":5050}`, hostname, hostname)
+}
+
+func TestReadMesosLeader(t *testing.T) {
+	for _, test := range []struct {
+		zoo      *fakeZk
+		expected string
+		err      error
+	}{
+		{newFakeZk(zkMasterInfo("master-1"), zkMasterInfo("master-2")), "master-1:5050", nil},
+		{newFakeZk(), "", errMesosNoMaster},
+		{newFakeZk("not json"), "", errMesosParseError},
+	} {
+		children, _, _ := test.zoo.Children("/mesos")
+		if leader, err := readMesosLeader(test.zoo, "/mesos", children); leader != test.expected || err != test.err {
+			t.Errorf("Expected the leader of %v to be '%s', %v, got '%s', %v.", test.zoo.masters, test.expected, test.err, leader, err)
+		}
+	}
+}
+
+func TestMesosLeaderWatcher(t *testing.T) {
+	defer func(connect func([]string) (zkConn, error), mesos string) {
+		mesosLeader.Lock()
+		if mesosLeader.watcher != nil {
+			mesosLeader.watcher.Stop()
+			mesosLeader.watcher = nil
+		}
+		mesosLeader.Unlock()
+		zkConnect, config.Mesos = connect, mesos
+	}(zkConnect, config.Mesos)
+
+	zks := map[string]*fakeZk{
+		"zk-1:2181,zk-2:2181": newFakeZk(zkMasterInfo("master-1"), zkMasterInfo("master-2")),
+		"zk-3:2181":           newFakeZk(zkMasterInfo("master-3")),
+	}
+	zkConnect = func(servers []string) (zkConn, error) {
+		if zoo, ok := zks[strings.Join(servers, ",")]; ok {
+			return zoo, nil
+		}
+		return nil, errors.New("zk: could not connect to a server")
+	}
+	waitForLeader := func(expected string) {
+		var hosts []string
+		var err error
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if hosts, err = getMesosMaster(); err == nil && len(hosts) == 1 && hosts[0] == expected {
+				return
+			}
+		}
+		t.Fatalf("Expected the mesos master to be '%s', got %v, %v.", expected, hosts, err)
+	}
+
+	config.Mesos = "zk://zk-1:2181,zk-2:2181/mesos"
+	waitForLeader("master-1:5050")
+	mesosLeader.Lock()
+	watcher := mesosLeader.watcher
+	mesosLeader.Unlock()
+
+	// the leader changes
+	zks["zk-1:2181,zk-2:2181"].setMasters(zkMasterInfo("master-2"))
+	waitForLeader("master-2:5050")
+
+	// MESOS_MASTER changes
+	config.Mesos = "zk://zk-3:2181/mesos"
+	waitForLeader("master-3:5050")
+	select {
+	case <-watcher.stop:
+	default:
+		t.Error("Expected the watcher of the previous MESOS_MASTER to be stopped.")
+	}
+	zks["zk-1:2181,zk-2:2181"].setMasters(zkMasterInfo("master-1"))
+	waitForLeader("master-3:5050")
+}
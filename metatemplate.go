@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// The data available to the templates in a policy's meta, for example
+// {"task": "{{.TaskID}}", "host": "{{.AgentHostname}}"}.
+type metaTemplateData struct {
+	TaskID      string
+	TaskName    string
+	AppID       string
+	AgentID     string
+	FrameworkID string
+
+	ctx  context.Context
+	task mesosTask
+}
+
+func newMetaTemplateData(ctx context.Context, task mesosTask) metaTemplateData {
+	return metaTemplateData{
+		ctx:         ctx,
+		task:        task,
+		TaskID:      task.Id,
+		TaskName:    task.Name,
+		AppID:       marathonAppId(task.Name),
+		AgentID:     task.SlaveId,
+		FrameworkID: task.FrameworkId,
+	}
+}
+
+// AgentHostname, FrameworkName and JobName are looked up on the mesos master
+// only when a template uses them.
+func (d metaTemplateData) AgentHostname() (string, error) {
+	return getMesosAgentHostname(d.ctx, d.AgentID)
+}
+
+func (d metaTemplateData) FrameworkName() (string, error) {
+	return getMesosFrameworkName(d.ctx, d.FrameworkID)
+}
+
+func (d metaTemplateData) JobName() (string, error) {
+	framework, err := taskFrameworkName(d.ctx, d.task)
+	if err != nil {
+		return "", err
+	}
+	return scheduledJobName(d.task, framework), nil
+}
+
+func isMetaTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+func parseMetaTemplate(key string, value string) (*template.Template, error) {
+	return template.New(key).Option("missingkey=error").Parse(value)
+}
+
+// The template of the entity alias tokens of the policy are attached to, if
+// any.
+func (p *policy) entityAliasTemplate() string {
+	if p.EntityAlias != "" {
+		return p.EntityAlias
+	}
+	return config.EntityAliasTemplate
+}
+
+// Checks that the templates in the meta and entity alias of every policy parse.
+func (p policies) validateMeta() error {
+	for name, pol := range p {
+		if alias := pol.entityAliasTemplate(); alias != "" {
+			if _, err := parseMetaTemplate("entity_alias", alias); err != nil {
+				return fmt.Errorf("Policy '%s' has an invalid entity alias template: %v", name, err)
+			}
+			if config.EntityAliasRole == "" {
+				return fmt.Errorf("Policy '%s': %v", name, errNoEntityAliasRole)
+			}
+		}
+		for key, value := range pol.Meta {
+			if !isMetaTemplate(value) {
+				continue
+			}
+			if _, err := parseMetaTemplate(key, value); err != nil {
+				return fmt.Errorf("Policy '%s' has an invalid meta template for '%s': %v", name, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func renderMetaTemplate(key string, value string, data metaTemplateData) (string, error) {
+	tmpl, err := parseMetaTemplate(key, value)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("Failed to render %s: %v", key, err)
+	}
+	return buf.String(), nil
+}
+
+// withTask returns the policy with the templates in its meta and entity alias
+// rendered for the given task. The policy itself is left untouched as it is
+// shared between requests.
+func (p *policy) withTask(ctx context.Context, task mesosTask) (*policy, error) {
+	var meta map[string]string
+	data := newMetaTemplateData(ctx, task)
+	for key, value := range p.Meta {
+		if !isMetaTemplate(value) {
+			continue
+		}
+		if meta == nil {
+			meta = make(map[string]string, len(p.Meta))
+			for k, v := range p.Meta {
+				meta[k] = v
+			}
+		}
+		rendered, err := renderMetaTemplate("meta '"+key+"'", value, data)
+		if err != nil {
+			return nil, err
+		}
+		meta[key] = rendered
+	}
+	alias := p.entityAliasTemplate()
+	if meta == nil && alias == "" {
+		return p, nil
+	}
+	rendered := *p
+	if meta != nil {
+		rendered.Meta = meta
+	}
+	if alias != "" {
+		var err error
+		if rendered.EntityAlias, err = renderMetaTemplate("entity alias", alias, data); err != nil {
+			return nil, err
+		}
+	}
+	return &rendered, nil
+}
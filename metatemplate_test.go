@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPolicyWithTask(t *testing.T) {
+	pol := &policy{
+		Policies: []string{"web"},
+		Meta: map[string]string{
+			"foo":  "bar",
+			"task": "{{.TaskID}}",
+			"app":  "app {{.AppID}}",
+		},
+	}
+	rendered, err := pol.withTask(context.Background(), mesosTask{Id: "frontend.web.1234", Name: "frontend.web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]string{
+		"foo":  "bar",
+		"task": "frontend.web.1234",
+		"app":  "app /web/frontend",
+	} {
+		if rendered.Meta[key] != expected {
+			t.Errorf("Expected meta '%s' to be '%s', got '%s'.", key, expected, rendered.Meta[key])
+		}
+	}
+	if pol.Meta["task"] != "{{.TaskID}}" {
+		t.Error("Rendering should not modify the policy.")
+	}
+
+	if _, err := (&policy{Meta: map[string]string{"task": "{{.NoSuchField}}"}}).withTask(context.Background(), mesosTask{}); err == nil {
+		t.Error("Expected an unknown field to fail to render.")
+	}
+}
+
+func TestValidateMeta(t *testing.T) {
+	if err := (policies{"web": &policy{Meta: map[string]string{"task": "{{.TaskID}}"}}}).validateMeta(); err != nil {
+		t.Errorf("Expected valid template, got %v.", err)
+	}
+	if err := (policies{"web": &policy{Meta: map[string]string{"task": "{{.TaskID"}}}).validateMeta(); err == nil {
+		t.Error("Expected an unterminated template to be invalid.")
+	}
+}
+
+func TestPolicyEntityAlias(t *testing.T) {
+	defer func(role string) { config.EntityAliasRole = role }(config.EntityAliasRole)
+
+	pol := &policy{Policies: []string{"web"}, EntityAlias: "mesos-{{.AppID}}"}
+	if err := (policies{"web": pol}).validateMeta(); err == nil {
+		t.Error("Expected an entity alias without a token role to be invalid.")
+	}
+	config.EntityAliasRole = "gatekeeper"
+	if err := (policies{"web": pol}).validateMeta(); err != nil {
+		t.Errorf("Expected valid entity alias, got %v.", err)
+	}
+
+	rendered, err := pol.withTask(context.Background(), mesosTask{Id: "frontend.web.1234", Name: "frontend.web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := rendered.tokenOptions()
+	if opts.EntityAlias != "mesos-/web/frontend" || opts.Role != "gatekeeper" {
+		t.Errorf("Expected token attached to 'mesos-/web/frontend' with role 'gatekeeper', got '%s' and '%s'.", opts.EntityAlias, opts.Role)
+	}
+	if opts := (&policy{}).tokenOptions(); opts.Role != "" {
+		t.Errorf("Expected token without an entity alias to be created without a role, got '%s'.", opts.Role)
+	}
+}
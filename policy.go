@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/franela/goreq"
-	"log"
-	"path"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type policyLoadError struct {
@@ -12,18 +16,59 @@ type policyLoadError struct {
 }
 
 func (ple policyLoadError) Error() string {
-	return fmt.Sprintf("Error loading policy from vault: %v", ple.Err)
+	return fmt.Sprintf("Error loading policy: %v", ple.Err)
 }
 
 type policy struct {
-	Policies []string          `json:"policies"`
-	Meta     map[string]string `json:"meta,omitempty"`
-	Ttl      int               `json:"ttl,omitempty"`
-	NumUses  int               `json:"num_users,omitempty"`
+	Policies    []string          `json:"policies"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	Ttl         int               `json:"ttl,omitempty"`
+	NumUses     int               `json:"num_uses,omitempty"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Vault       string            `json:"vault,omitempty"`
+	SecretPaths []string          `json:"secret_paths,omitempty"`
+	TokenType   string            `json:"token_type,omitempty"`
+	EntityAlias string            `json:"entity_alias,omitempty"`
+	MaxTaskLife int               `json:"max_task_life,omitempty"`
+	// If set, the tokens issued to the tasks of the app are limited by the
+	// number of running instances, and at most MaxInstances.
+	MaxInstances int `json:"max_instances,omitempty"`
+
+	AllowedCidrs  []string `json:"allowed_cidrs,omitempty"`
+	AllowedAgents []string `json:"allowed_agents,omitempty"`
+
+	BoundCidrs      []string `json:"bound_cidrs,omitempty"`
+	BindToRequestor bool     `json:"bind_to_requestor,omitempty"`
+}
+
+// num_uses used to be read from 'num_users'. It is still accepted, so that
+// the policies written for earlier versions keep their limit.
+func (p *policy) UnmarshalJSON(data []byte) error {
+	type plainPolicy policy
+	aux := struct {
+		*plainPolicy
+		LegacyNumUses *int `json:"num_users"`
+	}{plainPolicy: (*plainPolicy)(p)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if p.NumUses == 0 && aux.LegacyNumUses != nil {
+		p.NumUses = *aux.LegacyNumUses
+	}
+	return nil
 }
 
 type policies map[string]*policy
 
+var errSourceNotAllowed = errors.New("Token requests for this task are not allowed from this address.")
+var errAgentNotAllowed = errors.New("Tokens are not provided to this task on its mesos agent.")
+var errNoPolicy = errors.New("There is no policy for this task.")
+
+const (
+	tokenTypeService = "service"
+	tokenTypeBatch   = "batch"
+)
+
 var defaultPolicy = &policy{
 	Ttl: 21600,
 }
@@ -33,62 +78,221 @@ var defaultPolicies = map[string]*policy{
 		Ttl:      21600,
 	},
 }
-var activePolicies = make(policies)
+
+// policyStore holds the active policies. A set of policies is never modified
+// once it is in the store: reloading builds a new set and swaps it in
+// atomically, so token requests always see a complete set without locking,
+// and keep using the set they started with while a reload is in progress.
+type policyStore struct {
+	current atomic.Value // policies
+	// The tenant the policies belong to, nil for those of GATE_POLICIES.
+	tenant *tenant
+}
+
+var activePolicies = &policyStore{}
+
+// Get returns the current set of policies, which must not be modified.
+func (s *policyStore) Get() policies {
+	p, _ := s.current.Load().(policies)
+	return p
+}
+
+// Set replaces the current set of policies.
+func (s *policyStore) Set(p policies) {
+	s.current.Store(p)
+}
+
+// Load replaces the current policies with those from GATE_POLICIES, or from
+// the policies of the tenant. The current policies are kept if they cannot be
+// loaded.
+func (s *policyStore) Load(authToken string) error {
+	var p policies
+	var err error
+	if s.tenant != nil {
+		p, err = s.tenant.loadPolicies(authToken)
+	} else {
+		p, err = loadPolicies(authToken)
+	}
+	if err != nil {
+		return err
+	}
+	s.Set(p)
+	return nil
+}
+
+// location returns where the policies are loaded from.
+func (s *policyStore) location() string {
+	if s.tenant != nil {
+		return s.tenant.Policies
+	}
+	return config.Vault.GkPolicies
+}
 
 func (p policies) Get(key string) *policy {
+	_, pol := p.Match(key)
+	return pol
+}
+
+// Required returns the policy entry of the given task name like MatchTask, but
+// when POLICY_REQUIRED is set, tasks without a policy entry of their own are
+// rejected rather than given the '*' or default policy.
+func (p policies) Required(key string, frameworks ...string) (string, *policy, error) {
+	matched, pol := p.MatchTask(key, frameworks...)
+	if config.PolicyRequired && (matched == "*" || matched == "") {
+		return matched, nil, errNoPolicy
+	}
+	return matched, pol, nil
+}
+
+// MatchTask is like Match, but first looks for a policy entry of the task under
+// one of the given frameworks (by name or id), keyed framework:task name or
+// framework:marathon app id, such as marathon-prod:/web/frontend.
+func (p policies) MatchTask(key string, frameworks ...string) (string, *policy) {
+	for _, framework := range frameworks {
+		if framework == "" {
+			continue
+		}
+		for _, k := range []string{framework + ":" + key, framework + ":" + marathonAppId(key)} {
+			if pol, ok := p[k]; ok {
+				return k, pol
+			}
+		}
+	}
+	return p.Match(key)
+}
+
+// Whether any of the policy entries may be keyed by framework, in which case
+// the name of the framework of a task needs to be looked up to match it.
+func (p policies) hasFrameworkKeys() bool {
+	for key := range p {
+		if strings.Contains(key, ":") {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the policy entry that applies to the given task name, along with
+// the key it was found under. If neither the task name nor the '*' catch all is
+// present, an empty key and the default policy are returned.
+func (p policies) Match(key string) (string, *policy) {
 	if pol, ok := p[key]; ok {
-		return pol
+		return key, pol
 	} else if pol, ok := p["*"]; ok {
-		return pol
+		return "*", pol
 	} else {
-		return defaultPolicy
-	}
-}
-
-func (p policies) Load(authToken string) error {
-	r, err := VaultRequest{goreq.Request{
-		Uri:             vaultPath(path.Join("/v1/secret", config.Vault.GkPolicies), ""),
-		MaxRedirects:    10,
-		RedirectHeaders: true,
-	}.WithHeader("X-Vault-Token", authToken)}.Do()
-	if err == nil {
-		defer r.Body.Close()
-		switch r.StatusCode {
-		case 200:
-			resp := struct {
-				Data policies `json:"data"`
-			}{}
-			if err := r.Body.FromJsonTo(&resp); err == nil {
-				for k, _ := range p {
-					delete(p, k)
-				}
-				for k, v := range resp.Data {
-					p[k] = v
-				}
-				return nil
-			} else {
-				return policyLoadError{fmt.Errorf("There was an error decoding policy from vault. This can occur when using vault-cli to save the policy json, as vault-cli saves it as a string rather than a json object.")}
+		return "", defaultPolicy
+	}
+}
+
+// loadPolicies reads and validates the policies from GATE_POLICIES. Without
+// a policy document the default policies apply.
+func loadPolicies(authToken string) (policies, error) {
+	source, err := newPolicySource(config.Vault.GkPolicies)
+	if err != nil {
+		return nil, policyLoadError{err}
+	}
+	return source.Load(authToken)
+}
+
+// Checks that every policy is well formed.
+func (p policies) validate() error {
+	for name, pol := range p {
+		if pol.MaxTaskLife < 0 {
+			return fmt.Errorf("Policy '%s' has a negative max task life.", name)
+		}
+		if pol.MaxInstances < 0 {
+			return fmt.Errorf("Policy '%s' has a negative max instances.", name)
+		}
+		switch pol.TokenType {
+		case "", tokenTypeService, tokenTypeBatch:
+		default:
+			return fmt.Errorf("Policy '%s' has an unknown token type '%s'. Valid types are '%s' and '%s'.", name, pol.TokenType, tokenTypeService, tokenTypeBatch)
+		}
+		for _, cidr := range pol.AllowedCidrs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("Policy '%s' has an invalid allowed cidr: %v", name, err)
+			}
+		}
+		for _, cidr := range pol.BoundCidrs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("Policy '%s' has an invalid bound cidr: %v", name, err)
+			}
+		}
+	}
+	return p.validateMeta()
+}
+
+// taskLife is how long after starting a task matching the policy may request
+// its token. Policies without a max_task_life (in seconds) use TASK_LIFE.
+func (p *policy) taskLife() time.Duration {
+	if p.MaxTaskLife > 0 {
+		return time.Duration(p.MaxTaskLife) * time.Second
+	}
+	return config.MaxTaskLife
+}
+
+// Checks the restrictions of the policy on where tokens may be requested from,
+// and which mesos agents the task may be running on.
+func (p *policy) allows(ctx context.Context, remoteAddr string, task mesosTask) error {
+	if len(p.AllowedCidrs) > 0 {
+		host, _, err := net.SplitHostPort(remoteAddr)
+		if err != nil {
+			host = remoteAddr
+		}
+		ip := net.ParseIP(host)
+		allowed := false
+		for _, cidr := range p.AllowedCidrs {
+			if _, network, err := net.ParseCIDR(cidr); err == nil && ip != nil && network.Contains(ip) {
+				allowed = true
+				break
 			}
-		case 404:
-			log.Printf("There was no policy in the secret backend at %v. Tokens created will have the default vault policy.", config.Vault.GkPolicies)
-			for k, _ := range p {
-				delete(p, k)
+		}
+		if !allowed {
+			return errSourceNotAllowed
+		}
+	}
+	if len(p.AllowedAgents) > 0 {
+		hostname := ""
+		for _, agent := range p.AllowedAgents {
+			if agent == task.SlaveId {
+				return nil
 			}
-			for k, v := range defaultPolicies {
-				p[k] = v
+			// Only look up the hostname when the agent wasn't named by id.
+			if hostname == "" {
+				var err error
+				if hostname, err = getMesosAgentHostname(ctx, task.SlaveId); err != nil {
+					return err
+				}
 			}
-			return nil
-		default:
-			var e vaultError
-			e.Code = r.StatusCode
-			if err := r.Body.FromJsonTo(&e); err == nil {
-				return policyLoadError{e}
-			} else {
-				e.Errors = []string{"communication error."}
-				return policyLoadError{e}
+			if agent == hostname {
+				return nil
 			}
 		}
-	} else {
-		return policyLoadError{err}
+		return errAgentNotAllowed
+	}
+	return nil
+}
+
+// boundTo returns the policy with the address of the requestor added to the
+// bound cidrs of the token, if the policy binds tokens to their requestor.
+func (p *policy) boundTo(remoteAddr string) *policy {
+	if !p.BindToRequestor {
+		return p
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return p
+	}
+	cidr := ip.String() + "/128"
+	if ip.To4() != nil {
+		cidr = ip.String() + "/32"
 	}
+	bound := *p
+	bound.BoundCidrs = append(append([]string(nil), p.BoundCidrs...), cidr)
+	return &bound
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPolicyAllowedCidrs(t *testing.T) {
+	pol := &policy{AllowedCidrs: []string{"10.20.0.0/16", "fd00:20::/64"}}
+	for remoteAddr, allowed := range map[string]bool{
+		"10.20.1.2:41234":     true,
+		"10.21.1.2:41234":     false,
+		"[fd00:20::5]:41234":  true,
+		"[fd00:21::5]:41234":  false,
+		"not an address:1234": false,
+	} {
+		if err := pol.allows(context.Background(), remoteAddr, mesosTask{}); (err == nil) != allowed {
+			t.Errorf("Expected %s to be allowed: %v, got error %v.", remoteAddr, allowed, err)
+		}
+	}
+}
+
+func TestPolicyAllowedAgentId(t *testing.T) {
+	pol := &policy{AllowedAgents: []string{"agent-1"}}
+	if err := pol.allows(context.Background(), "10.0.0.1:1234", mesosTask{SlaveId: "agent-1"}); err != nil {
+		t.Errorf("Expected task on agent-1 to be allowed, got %v.", err)
+	}
+}
+
+func TestValidatePolicies(t *testing.T) {
+	if err := (policies{"web": &policy{AllowedCidrs: []string{"10.0.0.0/8"}}}).validate(); err != nil {
+		t.Errorf("Expected valid policy, got %v.", err)
+	}
+	if err := (policies{"web": &policy{AllowedCidrs: []string{"10.0.0.0"}}}).validate(); err == nil {
+		t.Error("Expected a cidr without a prefix length to be invalid.")
+	}
+	if err := (policies{"cron": &policy{TokenType: "batch"}}).validate(); err != nil {
+		t.Errorf("Expected batch token type to be valid, got %v.", err)
+	}
+	if err := (policies{"cron": &policy{TokenType: "periodic"}}).validate(); err == nil {
+		t.Error("Expected unknown token type to be invalid.")
+	}
+}
+
+func TestPolicyBatchTokenOptions(t *testing.T) {
+	if opts := (&policy{}).tokenOptions(); opts.Type != "" || !opts.Renewable {
+		t.Errorf("Expected renewable token of vault's default type, got %+v.", opts)
+	}
+	if opts := (&policy{TokenType: "batch"}).tokenOptions(); opts.Type != "batch" || opts.Renewable {
+		t.Errorf("Expected non renewable batch token, got %+v.", opts)
+	}
+}
+
+func TestPolicyTaskLife(t *testing.T) {
+	defer func(life time.Duration) { config.MaxTaskLife = life }(config.MaxTaskLife)
+	config.MaxTaskLife = 2 * time.Minute
+
+	started := func(ago time.Duration) mesosTask {
+		task := mesosTask{}
+		task.Statuses = append(task.Statuses, struct {
+			State     string  `json:"state"`
+			Timestamp float64 `json:"timestamp"`
+		}{"RUNNING", float64(time.Now().Add(-ago).UnixNano()) / 1e9})
+		return task
+	}
+	slow := &policy{MaxTaskLife: 600}
+	if err := checkTaskLife(started(5*time.Minute), (&policy{}).taskLife()); err != errTaskNotFresh {
+		t.Errorf("Expected task older than TASK_LIFE to be rejected, got %v.", err)
+	}
+	if err := checkTaskLife(started(5*time.Minute), slow.taskLife()); err != nil {
+		t.Errorf("Expected task within the policy's max task life to be accepted, got %v.", err)
+	}
+	if err := checkTaskLife(started(11*time.Minute), slow.taskLife()); err != errTaskNotFresh {
+		t.Errorf("Expected task older than the policy's max task life to be rejected, got %v.", err)
+	}
+	if err := (policies{"web": &policy{MaxTaskLife: -1}}).validate(); err == nil {
+		t.Error("Expected negative max task life to be invalid.")
+	}
+}
+
+func TestPolicyBoundTo(t *testing.T) {
+	pol := &policy{BoundCidrs: []string{"10.20.0.0/16"}}
+	if bound := pol.boundTo("10.20.1.2:41234"); bound != pol {
+		t.Error("Expected policy without bind_to_requestor to be unchanged.")
+	}
+
+	pol.BindToRequestor = true
+	for remoteAddr, expected := range map[string]string{
+		"10.20.1.2:41234":    "10.20.1.2/32",
+		"[fd00:20::5]:41234": "fd00:20::5/128",
+	} {
+		bound := pol.boundTo(remoteAddr)
+		if len(bound.BoundCidrs) != 2 || bound.BoundCidrs[0] != "10.20.0.0/16" || bound.BoundCidrs[1] != expected {
+			t.Errorf("Expected %s to be bound to %s, got %v.", remoteAddr, expected, bound.BoundCidrs)
+		}
+	}
+	if len(pol.BoundCidrs) != 1 {
+		t.Error("Binding should not modify the policy.")
+	}
+}
+
+func TestPoliciesRequired(t *testing.T) {
+	defer func(required bool) { config.PolicyRequired = required }(config.PolicyRequired)
+	p := policies{"web": &policy{Policies: []string{"web"}}, "*": &policy{Policies: []string{"default"}}}
+
+	config.PolicyRequired = false
+	if key, pol, err := p.Required("worker"); err != nil || key != "*" || pol != p["*"] {
+		t.Errorf("Expected the catch all policy, got '%s' (%v).", key, err)
+	}
+	config.PolicyRequired = true
+	if key, pol, err := p.Required("web"); err != nil || key != "web" || pol != p["web"] {
+		t.Errorf("Expected the task's own policy, got '%s' (%v).", key, err)
+	}
+	if _, _, err := p.Required("worker"); err != errNoPolicy {
+		t.Errorf("Expected a task without a policy to be rejected, got %v.", err)
+	}
+	if _, _, err := (policies{}).Required("worker"); err != errNoPolicy {
+		t.Errorf("Expected a task without a policy to be rejected instead of given the default policy, got %v.", err)
+	}
+}
+
+func TestPoliciesMatchTask(t *testing.T) {
+	p := policies{
+		"frontend.web":                &policy{Policies: []string{"web"}},
+		"marathon-prod:/web/frontend": &policy{Policies: []string{"web-prod"}},
+		"20160101-000000-1-0001:api":  &policy{Policies: []string{"api-aurora"}},
+		"*":                           &policy{Policies: []string{"default"}},
+	}
+	for _, c := range []struct {
+		name       string
+		frameworks []string
+		expected   string
+	}{
+		{"frontend.web", []string{"marathon-prod", "20160101-000000-1-0002"}, "marathon-prod:/web/frontend"},
+		{"frontend.web", []string{"marathon-staging", "20160101-000000-1-0003"}, "frontend.web"},
+		{"api", []string{"aurora", "20160101-000000-1-0001"}, "20160101-000000-1-0001:api"},
+		{"api", []string{"marathon-prod", ""}, "*"},
+		{"frontend.web", nil, "frontend.web"},
+	} {
+		if key, _ := p.MatchTask(c.name, c.frameworks...); key != c.expected {
+			t.Errorf("Expected task '%s' of %v to match '%s', got '%s'.", c.name, c.frameworks, c.expected, key)
+		}
+	}
+	if !p.hasFrameworkKeys() || (policies{"frontend.web": &policy{}}).hasFrameworkKeys() {
+		t.Error("Expected only policies keyed by framework to need the framework name.")
+	}
+
+	defer func(required bool) { config.PolicyRequired = required }(config.PolicyRequired)
+	config.PolicyRequired = true
+	if _, _, err := p.Required("frontend.web", "marathon-prod"); err != nil {
+		t.Errorf("Expected a framework specific policy to satisfy POLICY_REQUIRED, got %v.", err)
+	}
+}
+
+// Reloads the policies while token requests read them. Run with -race.
+func TestPolicyStoreReload(t *testing.T) {
+	defer activePolicies.Set(activePolicies.Get())
+
+	generation := func(ttl int) policies {
+		return policies{
+			"web": &policy{Policies: []string{"web"}, Ttl: ttl},
+			"db":  &policy{Policies: []string{"db"}, Ttl: ttl},
+			"*":   &policy{Policies: []string{"default"}, Ttl: ttl},
+		}
+	}
+	activePolicies.Set(generation(1))
+
+	r := gin.New()
+	r.GET("/policies", ListPolicies)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				current := activePolicies.Get()
+				_, web := current.Match("web")
+				_, db := current.Match("db")
+				_, other := current.Match("api")
+				if len(current) != 3 || web.Ttl != db.Ttl || web.Ttl != other.Ttl {
+					t.Error("Expected every read to see a complete set of policies.")
+					return
+				}
+
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest("GET", "/policies", nil))
+				var resp struct {
+					Policies policies `json:"policies"`
+				}
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || len(resp.Policies) != 3 {
+					t.Errorf("Expected the listed policies to be a complete set, got %d (%v).", len(resp.Policies), err)
+					return
+				}
+			}
+		}()
+	}
+	for ttl := 2; ttl < 500; ttl++ {
+		activePolicies.Set(generation(ttl))
+	}
+	close(stop)
+	wg.Wait()
+
+	if _, pol := activePolicies.Get().Match("web"); pol.Ttl != 499 {
+		t.Errorf("Expected the last set of policies to be active, got ttl %d.", pol.Ttl)
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/franela/goreq"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var errPolicyCommand = errors.New("Unknown policy command. Valid commands are 'validate', 'push' and 'pull'.")
+var errPolicyNoFile = errors.New("No policy document given.")
+var errPolicyNoVaultToken = errors.New("VAULT_TOKEN must be set to access vault.")
+var errPolicyNotFound = errors.New("There is no policy document in vault.")
+
+// runPolicy implements the policy subcommand, which works with the policy
+// document gatekeeper loads from GATE_POLICIES.
+func runPolicy(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s policy validate|push|pull [flags]\n", os.Args[0])
+		return errPolicyCommand
+	}
+	switch args[0] {
+	case "validate":
+		return runPolicyValidate(args[1:])
+	case "push":
+		return runPolicyPush(args[1:])
+	case "pull":
+		return runPolicyPull(args[1:])
+	default:
+		return errPolicyCommand
+	}
+}
+
+// The token the policy subcommands access vault with.
+func policyVaultToken() (string, error) {
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		return "", errPolicyNoVaultToken
+	}
+	if err := vaultAddresses.Set(config.Vault.Server); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func runPolicyValidate(args []string) error {
+	fs := flag.NewFlagSet("policy validate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s policy validate [flags] <file>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	checkVault := fs.Bool("vault", false, "Also check that the vault policies the document refers to exist, using the token in VAULT_TOKEN.")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errPolicyNoFile
+	}
+
+	data, err := readPolicyFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	pols, problems := validatePolicyDocument(data)
+	if *checkVault && pols != nil {
+		token, err := policyVaultToken()
+		if err != nil {
+			return err
+		}
+		if config.Vault.Backends != "" {
+			if err := loadVaultBackends(config.Vault.Backends); err != nil {
+				return err
+			}
+		}
+		problems = append(problems, checkVaultPolicies(pols, token)...)
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Found %d problems in %s.", len(problems), fs.Arg(0))
+	}
+	fmt.Printf("%s is valid, it has %d policies.\n", fs.Arg(0), len(pols))
+	return nil
+}
+
+// Reads a policy document from a file, or from stdin if the file is "-".
+func readPolicyFile(file string) ([]byte, error) {
+	if file == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// The json fields of a policy.
+func policyFields() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(policy{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// Misspelled policy fields, and the field that was meant.
+var policyFieldTypos = map[string]string{
+	"num_users": "num_uses",
+	"policy":    "policies",
+	"max_uses":  "num_uses",
+}
+
+// validatePolicyDocument parses a policy document and reports everything that
+// is wrong with it, rather than stopping at the first problem like loading the
+// policies does. The policies are nil if the document couldn't be parsed.
+func validatePolicyDocument(data []byte) (policies, []string) {
+	var problems []string
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		return nil, []string{"The policy document is a json string rather than an object. This happens when saving it with vault-cli, which stores the document as a string. Use 'vltgatekeeper policy push' instead."}
+	}
+
+	// Decode the document key by key, as duplicate keys would otherwise
+	// silently replace each other.
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, []string{"The policy document must be a json object of policies by task name."}
+	}
+	type entry struct {
+		key   string
+		value json.RawMessage
+	}
+	var entries []entry
+	seen := make(map[string]bool)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, []string{fmt.Sprintf("The policy document is not valid json: %v", err)}
+		}
+		key := t.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, []string{fmt.Sprintf("The policy document is not valid json: %v", err)}
+		}
+		if seen[key] {
+			problems = append(problems, fmt.Sprintf("Policy '%s' is defined more than once, only the last definition is used.", key))
+		}
+		seen[key] = true
+		entries = append(entries, entry{key, value})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, []string{fmt.Sprintf("The policy document is not valid json: %v", err)}
+	}
+
+	fields := policyFields()
+	pols := make(policies, len(entries))
+	for _, e := range entries {
+		key := e.key
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(e.value, &values); err != nil {
+			problems = append(problems, fmt.Sprintf("Policy '%s' must be a json object.", key))
+			continue
+		}
+		for field := range values {
+			if fields[field] {
+				continue
+			}
+			if meant, ok := policyFieldTypos[field]; ok {
+				problems = append(problems, fmt.Sprintf("Policy '%s' has the unknown field '%s', did you mean '%s'?", key, field, meant))
+			} else {
+				problems = append(problems, fmt.Sprintf("Policy '%s' has the unknown field '%s'.", key, field))
+			}
+		}
+		pol := &policy{}
+		if err := json.Unmarshal(e.value, pol); err != nil {
+			problems = append(problems, fmt.Sprintf("Policy '%s' is invalid: %v", key, err))
+			continue
+		}
+		if pol.Ttl < 0 {
+			problems = append(problems, fmt.Sprintf("Policy '%s' has a negative ttl.", key))
+		}
+		if pol.NumUses < 0 {
+			problems = append(problems, fmt.Sprintf("Policy '%s' has a negative num_uses.", key))
+		}
+		if problem := unreachablePolicyKey(key); problem != "" {
+			problems = append(problems, fmt.Sprintf("Policy '%s' %s", key, problem))
+		}
+		pols[key] = pol
+	}
+	if err := pols.validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return pols, problems
+}
+
+// Policies are matched by the exact task name, or the '*' catch all. Keys that
+// can't be a task name are never used.
+func unreachablePolicyKey(key string) string {
+	switch {
+	case key == "":
+		return "has an empty name and never matches a task."
+	case key != strings.TrimSpace(key):
+		return "has leading or trailing spaces and never matches a task."
+	case strings.HasPrefix(key, "/"):
+		return fmt.Sprintf("looks like a marathon app id. Policies are matched by task name, for example '%s'.", marathonTaskName(key))
+	case key != "*" && strings.Contains(key, "*"):
+		return "contains a wildcard, but only the '*' catch all is supported, so it never matches a task."
+	}
+	return ""
+}
+
+// checkVaultPolicies reports the vault policies referenced by the policy
+// document that don't exist in vault.
+func checkVaultPolicies(pols policies, token string) []string {
+	var problems []string
+	for key, pol := range pols {
+		for _, name := range pol.Policies {
+			_, err := pol.withVault(token, func(backend *vaultBackend, token string, namespace string) (string, error) {
+				r, err := VaultRequest{Request: goreq.Request{
+					Uri: backend.path(path.Join("/v1/sys/policy", name), ""),
+				}.WithHeader("X-Vault-Token", token), Namespace: namespace}.Do()
+				if err != nil {
+					return "", err
+				}
+				defer r.Body.Close()
+				if r.StatusCode == 404 {
+					return "", fmt.Errorf("The vault policy '%s' does not exist.", name)
+				}
+				if r.StatusCode != 200 {
+					var e vaultError
+					e.Code = r.StatusCode
+					r.Body.FromJsonTo(&e)
+					return "", e
+				}
+				return "", nil
+			})
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("Policy '%s': %v", key, err))
+			}
+		}
+	}
+	return problems
+}
+
+func runPolicyPull(args []string) error {
+	fs := flag.NewFlagSet("policy pull", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s policy pull [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	output := fs.String("o", "", "File to write the policy document to, instead of stdout.")
+	fs.Parse(args)
+
+	token, err := policyVaultToken()
+	if err != nil {
+		return err
+	}
+	doc, err := readVaultPolicyDocument(token)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if *output != "" {
+		return ioutil.WriteFile(*output, b, 0644)
+	}
+	_, err = os.Stdout.Write(b)
+	return err
+}
+
+func runPolicyPush(args []string) error {
+	fs := flag.NewFlagSet("policy push", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s policy push [flags] <file>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	diff := fs.Bool("diff", false, "Only show how the document differs from the one in vault, without writing it.")
+	force := fs.Bool("force", false, "Write the document even if it doesn't validate.")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errPolicyNoFile
+	}
+
+	data, err := readPolicyFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	pols, problems := validatePolicyDocument(data)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if pols == nil || (len(problems) > 0 && !*force) {
+		return fmt.Errorf("Found %d problems in %s, not pushing it.", len(problems), fs.Arg(0))
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	token, err := policyVaultToken()
+	if err != nil {
+		return err
+	}
+	if *diff {
+		current, err := readVaultPolicyDocument(token)
+		if err == errPolicyNotFound {
+			current = nil
+		} else if err != nil {
+			return err
+		}
+		changes := diffPolicyDocuments(current, doc)
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+		if len(changes) == 0 {
+			fmt.Println("No changes.")
+		}
+		return nil
+	}
+
+	if err := writeVaultPolicyDocument(token, doc); err != nil {
+		return err
+	}
+	fmt.Printf("Pushed %d policies to %s. Reload the policies of the running gatekeepers with POST /policies/reload.\n", len(doc), path.Join("secret", config.Vault.GkPolicies))
+	return nil
+}
+
+// readVaultPolicyDocument reads the policy document from GATE_POLICIES.
+func readVaultPolicyDocument(token string) (map[string]interface{}, error) {
+	secretPath, err := vaultPolicyPath()
+	if err != nil {
+		return nil, err
+	}
+	r, err := VaultRequest{Request: goreq.Request{
+		Uri:             vaultPath(secretPath, ""),
+		MaxRedirects:    10,
+		RedirectHeaders: true,
+	}.WithHeader("X-Vault-Token", token)}.Do()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	switch r.StatusCode {
+	case 200:
+		var resp struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := r.Body.FromJsonTo(&resp); err != nil {
+			return nil, err
+		}
+		return resp.Data, nil
+	case 404:
+		return nil, errPolicyNotFound
+	default:
+		var e vaultError
+		e.Code = r.StatusCode
+		r.Body.FromJsonTo(&e)
+		return nil, e
+	}
+}
+
+// writeVaultPolicyDocument writes the policy document to GATE_POLICIES. The
+// policies are sent as the fields of the secret, so that vault stores them as
+// json objects rather than strings.
+func writeVaultPolicyDocument(token string, doc map[string]interface{}) error {
+	secretPath, err := vaultPolicyPath()
+	if err != nil {
+		return err
+	}
+	r, err := VaultRequest{Request: goreq.Request{
+		Uri:             vaultPath(secretPath, ""),
+		Method:          "PUT",
+		Body:            doc,
+		MaxRedirects:    10,
+		RedirectHeaders: true,
+	}.WithHeader("X-Vault-Token", token)}.Do()
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	switch r.StatusCode {
+	case 200, 204:
+		return nil
+	default:
+		var e vaultError
+		e.Code = r.StatusCode
+		r.Body.FromJsonTo(&e)
+		return e
+	}
+}
+
+// diffPolicyDocuments lists the policies that are added, removed or changed
+// from one policy document to the next, in the order of their keys.
+func diffPolicyDocuments(from map[string]interface{}, to map[string]interface{}) []string {
+	keys := make(map[string]bool)
+	for key := range from {
+		keys[key] = true
+	}
+	for key := range to {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	var changes []string
+	for _, key := range sorted {
+		// encoding/json sorts the fields of maps, so equal policies encode equally
+		before, inFrom := from[key]
+		after, inTo := to[key]
+		b, _ := json.Marshal(before)
+		a, _ := json.Marshal(after)
+		switch {
+		case !inFrom:
+			changes = append(changes, fmt.Sprintf("+ %s: %s", key, a))
+		case !inTo:
+			changes = append(changes, fmt.Sprintf("- %s: %s", key, b))
+		case !bytes.Equal(a, b):
+			changes = append(changes, fmt.Sprintf("~ %s:\n\t- %s\n\t+ %s", key, b, a))
+		}
+	}
+	return changes
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePolicyDocument(t *testing.T) {
+	pols, problems := validatePolicyDocument([]byte(`{
+		"web": {"policies": ["web"], "ttl": 3000, "num_uses": 1},
+		"*": {"policies": ["default"]}
+	}`))
+	if len(problems) != 0 || len(pols) != 2 || pols["web"].NumUses != 1 {
+		t.Errorf("Expected a valid document, got %v.", problems)
+	}
+
+	_, problems = validatePolicyDocument([]byte(`{
+		"web": {"policies": ["web"], "num_users": 1},
+		"web": {"policies": ["web"], "ttl": -1},
+		"/api/backend": {"policies": ["api"]},
+		"api-*": {"policies": ["api"]},
+		"worker": {"policies": ["worker"], "token_type": "periodic"}
+	}`))
+	for _, expected := range []string{
+		"'web' is defined more than once",
+		"did you mean 'num_uses'",
+		"'web' has a negative ttl",
+		"Policies are matched by task name, for example 'backend.api'",
+		"'api-*' contains a wildcard",
+		"unknown token type 'periodic'",
+	} {
+		found := false
+		for _, problem := range problems {
+			if strings.Contains(problem, expected) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected a problem containing \"%s\", got %v.", expected, problems)
+		}
+	}
+
+	if pols, problems := validatePolicyDocument([]byte(`"{\"web\":{}}"`)); pols != nil || len(problems) != 1 {
+		t.Errorf("Expected a string wrapped document to be rejected, got %v.", problems)
+	}
+}
+
+func TestPolicyLegacyNumUses(t *testing.T) {
+	pols, _ := validatePolicyDocument([]byte(`{"web": {"num_users": 3}}`))
+	if pols["web"].NumUses != 3 {
+		t.Errorf("Expected num_users to still set num_uses, got %d.", pols["web"].NumUses)
+	}
+}
+
+func TestDiffPolicyDocuments(t *testing.T) {
+	from := map[string]interface{}{
+		"web":    map[string]interface{}{"policies": []interface{}{"web"}, "ttl": 3000.0},
+		"api":    map[string]interface{}{"policies": []interface{}{"api"}},
+		"worker": map[string]interface{}{"policies": []interface{}{"worker"}},
+	}
+	to := map[string]interface{}{
+		"web":    map[string]interface{}{"ttl": 3000.0, "policies": []interface{}{"web"}},
+		"api":    map[string]interface{}{"policies": []interface{}{"api", "db"}},
+		"search": map[string]interface{}{"policies": []interface{}{"search"}},
+	}
+	changes := diffPolicyDocuments(from, to)
+	expected := []string{
+		"~ api:\n\t- {\"policies\":[\"api\"]}\n\t+ {\"policies\":[\"api\",\"db\"]}",
+		"+ search: {\"policies\":[\"search\"]}",
+		"- worker: {\"policies\":[\"worker\"]}",
+	}
+	if strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected changes %q, got %q.", expected, changes)
+	}
+}
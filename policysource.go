@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/franela/goreq"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+)
+
+var errUnknownPolicyScheme = errors.New("Unknown policy scheme. Valid schemes are 'file', 'http', 'https', 'consul' and 'consul+https', or a path on the vault generic backend.")
+var errPolicyNotInVault = errors.New("GATE_POLICIES is not a path in vault.")
+var errRelativePolicyFile = errors.New("The path of a policy file must be absolute, as in file:///etc/gatekeeper/policies.json.")
+
+// A policySource is where the policy document is loaded from, selected by the
+// scheme of GATE_POLICIES. Without a scheme, GATE_POLICIES is a path on the
+// vault generic backend.
+type policySource interface {
+	// Load reads and validates the policies. Without a policy document the
+	// default policies apply.
+	Load(authToken string) (policies, error)
+}
+
+// The client policies are fetched over http and from consul with.
+var policyHttpClient = &http.Client{Timeout: 30 * time.Second}
+
+// How often a policy file is checked for changes.
+const policyWatchInterval = 5 * time.Second
+
+func newPolicySource(setting string) (policySource, error) {
+	u, err := url.Parse(setting)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "":
+		return vaultPolicySource{Path: setting}, nil
+	case "file":
+		// file://policies.json would read the host, not the path, of the url
+		if (u.Host != "" && u.Host != "localhost") || !path.IsAbs(u.Path) {
+			return nil, errRelativePolicyFile
+		}
+		return filePolicySource{u.Path}, nil
+	case "http", "https":
+		return httpPolicySource{u.String()}, nil
+	case "consul", "consul+https":
+		consul := &url.URL{Scheme: "http", Host: u.Host, Path: path.Join("/v1/kv", u.Path), RawQuery: u.RawQuery}
+		if u.Scheme == "consul+https" {
+			consul.Scheme = "https"
+		}
+		query := consul.Query()
+		query.Set("raw", "")
+		consul.RawQuery = query.Encode()
+		return consulPolicySource{consul.String()}, nil
+	default:
+		return nil, errUnknownPolicyScheme
+	}
+}
+
+// vaultPolicyPath returns the path of the policy document in vault, if
+// GATE_POLICIES is one.
+func vaultPolicyPath() (string, error) {
+	source, err := newPolicySource(config.Vault.GkPolicies)
+	if err != nil {
+		return "", err
+	}
+	v, ok := source.(vaultPolicySource)
+	if !ok {
+		return "", errPolicyNotInVault
+	}
+	return path.Join("/v1/secret", v.Path), nil
+}
+
+func copyDefaultPolicies() policies {
+	p := make(policies, len(defaultPolicies))
+	for k, v := range defaultPolicies {
+		p[k] = v
+	}
+	return p
+}
+
+// parsePolicyDocument decodes and validates a policy document, a json object
+// of task names to policies.
+func parsePolicyDocument(data []byte) (policies, error) {
+	var p policies
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, policyLoadError{fmt.Errorf("Failed to decode the policy document: %v", err)}
+	}
+	if err := p.validate(); err != nil {
+		return nil, policyLoadError{err}
+	}
+	if p == nil {
+		p = make(policies)
+	}
+	return p, nil
+}
+
+// vaultPolicySource loads the policies from the vault generic backend, where
+// each policy is a field of the secret. The secret is read from the default
+// vault unless a vault backend or namespace is given, as for tenants.
+type vaultPolicySource struct {
+	Path      string
+	Vault     string
+	Namespace string
+}
+
+func (s vaultPolicySource) Load(authToken string) (policies, error) {
+	var p policies
+	location := &policy{Vault: s.Vault, Namespace: s.Namespace}
+	_, err := location.withVault(authToken, func(backend *vaultBackend, token string, namespace string) (string, error) {
+		var err error
+		p, err = s.read(backend, token, namespace)
+		return "", err
+	})
+	if err != nil {
+		if _, ok := err.(policyLoadError); !ok {
+			err = policyLoadError{err}
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// read returns vault errors unwrapped, so that withVault can log in to a
+// vault backend again when its token was rejected.
+func (s vaultPolicySource) read(backend *vaultBackend, token string, namespace string) (policies, error) {
+	r, err := VaultRequest{
+		Request: goreq.Request{
+			Uri:             backend.path(path.Join("/v1/secret", s.Path), ""),
+			MaxRedirects:    10,
+			RedirectHeaders: true,
+		}.WithHeader("X-Vault-Token", token),
+		Namespace: namespace,
+	}.Do()
+	if err == nil {
+		defer r.Body.Close()
+		switch r.StatusCode {
+		case 200:
+			resp := struct {
+				Data policies `json:"data"`
+			}{}
+			if err := r.Body.FromJsonTo(&resp); err == nil {
+				if err := resp.Data.validate(); err != nil {
+					return nil, policyLoadError{err}
+				}
+				if resp.Data == nil {
+					resp.Data = make(policies)
+				}
+				return resp.Data, nil
+			} else {
+				return nil, policyLoadError{fmt.Errorf("There was an error decoding policy from vault. This can occur when using vault-cli to save the policy json, as vault-cli saves it as a string rather than a json object. Use 'vltgatekeeper policy push' to save it instead.")}
+			}
+		case 404:
+			log.Printf("There was no policy in the secret backend at %v. Tokens created will have the default vault policy.", s.Path)
+			return copyDefaultPolicies(), nil
+		default:
+			var e vaultError
+			e.Code = r.StatusCode
+			if err := r.Body.FromJsonTo(&e); err != nil {
+				e.Errors = []string{"communication error."}
+			}
+			return nil, e
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// filePolicySource loads the policies from a local file, for example one kept
+// in sync with a git repository. The file is watched for changes.
+type filePolicySource struct {
+	Path string
+}
+
+func (s filePolicySource) Load(string) (policies, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		log.Printf("There was no policy file at %v. Tokens created will have the default vault policy.", s.Path)
+		return copyDefaultPolicies(), nil
+	} else if err != nil {
+		return nil, policyLoadError{err}
+	}
+	return parsePolicyDocument(data)
+}
+
+// httpPolicySource loads the policies from an http(s) url.
+type httpPolicySource struct {
+	Url string
+}
+
+func (s httpPolicySource) Load(string) (policies, error) {
+	return fetchPolicyDocument(s.Url, nil)
+}
+
+// consulPolicySource loads the policies from a key in the consul KV store,
+// authenticating with the token in CONSUL_HTTP_TOKEN if it is set.
+type consulPolicySource struct {
+	Url string
+}
+
+func (s consulPolicySource) Load(string) (policies, error) {
+	var header http.Header
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		header = http.Header{"X-Consul-Token": []string{token}}
+	}
+	return fetchPolicyDocument(s.Url, header)
+}
+
+func fetchPolicyDocument(address string, header http.Header) (policies, error) {
+	req, err := http.NewRequest("GET", address, nil)
+	if err != nil {
+		return nil, policyLoadError{err}
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := policyHttpClient.Do(req)
+	if err != nil {
+		return nil, policyLoadError{err}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case 200:
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, policyLoadError{err}
+		}
+		return parsePolicyDocument(data)
+	case 404:
+		log.Printf("There was no policy document at %v. Tokens created will have the default vault policy.", req.URL.Redacted())
+		return copyDefaultPolicies(), nil
+	default:
+		return nil, policyLoadError{fmt.Errorf("%s responded with status code %d.", req.URL.Redacted(), resp.StatusCode)}
+	}
+}
+
+// A policyFileWatcher notices changes to the policy file by its modification
+// time and size, which also change when a git sync swaps the file out.
+type policyFileWatcher struct {
+	store *policyStore
+	path  string
+	mod   time.Time
+	size  int64
+	// Whether the file was missing, in which case the default policies were
+	// loaded, and the file showing up is a change.
+	missing bool
+}
+
+// check reloads the policies of the store if they are loaded from a file that
+// changed since the last check.
+func (w *policyFileWatcher) check() {
+	state.RLock()
+	setting, status, token := w.store.location(), state.Status, state.Token
+	state.RUnlock()
+	source, err := newPolicySource(setting)
+	file, ok := source.(filePolicySource)
+	if err != nil || !ok {
+		w.path = ""
+		return
+	}
+	info, err := os.Stat(file.Path)
+	if os.IsNotExist(err) {
+		w.path, w.missing = file.Path, true
+		return
+	} else if err != nil {
+		return
+	}
+	changed := w.path == file.Path && (w.missing || !info.ModTime().Equal(w.mod) || info.Size() != w.size)
+	w.path, w.mod, w.size, w.missing = file.Path, info.ModTime(), info.Size(), false
+	if !changed || status != StatusUnsealed {
+		return
+	}
+	if err := w.store.Load(token); err == nil {
+		log.Printf("Reloaded the policies from '%s' after it changed.", file.Path)
+	} else {
+		log.Printf("Failed to reload the policies from '%s', continuing with the previous policies: %v", file.Path, err)
+		hooks.NotifyPolicyReloadFailed(policySourceName(setting), err)
+	}
+}
+
+// watchPolicyFile reloads the policies of the store whenever their policy file
+// changes, for as long as they are loaded from a file.
+func watchPolicyFile(store *policyStore) {
+	w := &policyFileWatcher{store: store}
+	for range time.Tick(policyWatchInterval) {
+		w.check()
+	}
+}
+
+// policySourceName is how GATE_POLICIES is shown in logs, without the
+// credentials an url might contain.
+func policySourceName(setting string) string {
+	if u, err := url.Parse(setting); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+	return setting
+}
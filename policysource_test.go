@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewPolicySource(t *testing.T) {
+	for setting, expected := range map[string]policySource{
+		"/gatekeeper":                                      vaultPolicySource{Path: "/gatekeeper"},
+		"file:///etc/gatekeeper/policies.json":             filePolicySource{"/etc/gatekeeper/policies.json"},
+		"https://config.example.com/policies.json":         httpPolicySource{"https://config.example.com/policies.json"},
+		"consul://consul.service:8500/gatekeeper/policies": consulPolicySource{"http://consul.service:8500/v1/kv/gatekeeper/policies?raw="},
+		"consul+https://consul:8501/gk?dc=east":            consulPolicySource{"https://consul:8501/v1/kv/gk?dc=east&raw="},
+	} {
+		source, err := newPolicySource(setting)
+		if err != nil {
+			t.Errorf("Expected %s to be valid, got %v.", setting, err)
+		} else if source != expected {
+			t.Errorf("Expected %s to be %#v, got %#v.", setting, expected, source)
+		}
+	}
+	if _, err := newPolicySource("s3://bucket/policies.json"); err != errUnknownPolicyScheme {
+		t.Errorf("Expected %v, got %v.", errUnknownPolicyScheme, err)
+	}
+	for _, setting := range []string{"file://policies.json", "file://etc/gatekeeper/policies.json", "file:policies.json"} {
+		if _, err := newPolicySource(setting); err != errRelativePolicyFile {
+			t.Errorf("Expected %s to be rejected as relative, got %v.", setting, err)
+		}
+	}
+}
+
+func TestFilePolicySource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policies.json")
+
+	if p, err := (filePolicySource{path}).Load(""); err != nil || p.Get("web") != defaultPolicies["*"] {
+		t.Errorf("Expected the default policies without a policy file, got %v, %v.", p, err)
+	}
+	ioutil.WriteFile(path, []byte(`{"web": {"policies": ["web"], "ttl": 3600}}`), 0600)
+	if p, err := (filePolicySource{path}).Load(""); err != nil || p.Get("web").Ttl != 3600 {
+		t.Errorf("Expected the policies of the file, got %v, %v.", p, err)
+	}
+	ioutil.WriteFile(path, []byte(`{"web": {"token_type": "unknown"}}`), 0600)
+	if _, err := (filePolicySource{path}).Load(""); err == nil {
+		t.Error("Expected an invalid policy document to be rejected.")
+	}
+}
+
+func TestHttpPolicySource(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/policies.json":
+			w.Write([]byte(`{"web": {"policies": ["web"]}}`))
+		case "/v1/kv/gatekeeper":
+			if _, raw := r.URL.Query()["raw"]; !raw || r.Header.Get("X-Consul-Token") != "consul-token" {
+				w.WriteHeader(403)
+				return
+			}
+			w.Write([]byte(`{"db": {"policies": ["db"]}}`))
+		case "/broken.json":
+			w.WriteHeader(500)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	if p, err := (httpPolicySource{ts.URL + "/policies.json"}).Load(""); err != nil || p.Get("web").Policies[0] != "web" {
+		t.Errorf("Expected the policies served over http, got %v, %v.", p, err)
+	}
+	if p, err := (httpPolicySource{ts.URL + "/missing.json"}).Load(""); err != nil || p.Get("web") != defaultPolicies["*"] {
+		t.Errorf("Expected the default policies without a policy document, got %v, %v.", p, err)
+	}
+	if _, err := (httpPolicySource{ts.URL + "/broken.json"}).Load(""); err == nil {
+		t.Error("Expected a failed request to fail loading the policies.")
+	}
+
+	os.Setenv("CONSUL_HTTP_TOKEN", "consul-token")
+	defer os.Unsetenv("CONSUL_HTTP_TOKEN")
+	source, err := newPolicySource("consul://" + ts.Listener.Addr().String() + "/gatekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := source.Load(""); err != nil || p.Get("db").Policies[0] != "db" {
+		t.Errorf("Expected the policies stored in consul, got %v, %v.", p, err)
+	}
+}
+
+func TestPolicyFileWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policies.json")
+	ioutil.WriteFile(path, []byte(`{"web": {"ttl": 60}}`), 0600)
+
+	setting, status, current := config.Vault.GkPolicies, state.Status, activePolicies.Get()
+	defer func() {
+		config.Vault.GkPolicies, state.Status = setting, status
+		activePolicies.Set(current)
+	}()
+	config.Vault.GkPolicies, state.Status = "file://"+path, StatusUnsealed
+	activePolicies.Set(policies{})
+
+	w := &policyFileWatcher{store: activePolicies}
+	w.check()
+	if len(activePolicies.Get()) != 0 {
+		t.Fatal("Expected the first check not to reload the policies.")
+	}
+	ioutil.WriteFile(path, []byte(`{"web": {"ttl": 120}}`), 0600)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	w.check()
+	if pol := activePolicies.Get().Get("web"); pol == nil || pol.Ttl != 120 {
+		t.Fatalf("Expected the changed policy file to be reloaded, got %v.", pol)
+	}
+
+	// the default policies were loaded as the file was missing, until it shows up
+	missing := filepath.Join(dir, "missing.json")
+	config.Vault.GkPolicies = "file://" + missing
+	activePolicies.Set(copyDefaultPolicies())
+	w = &policyFileWatcher{store: activePolicies}
+	w.check()
+	ioutil.WriteFile(missing, []byte(`{"web": {"ttl": 180}}`), 0600)
+	w.check()
+	if pol := activePolicies.Get().Get("web"); pol == nil || pol.Ttl != 180 {
+		t.Errorf("Expected the policy file to be loaded once it shows up, got %v.", pol)
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeper"
 	"github.com/franela/goreq"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"log"
+	"path"
 	"strconv"
 	"sync/atomic"
 	"time"
@@ -16,7 +22,7 @@ var errAlreadyGivenKey = errors.New("This task has already been given a token.")
 var usedTaskIds = NewTtlSet()
 
 func createToken(token string, opts interface{}) (string, error) {
-	r, err := VaultRequest{goreq.Request{
+	r, err := VaultRequest{Request: goreq.Request{
 		Uri:             vaultPath("/v1/auth/token/create", ""),
 		Method:          "POST",
 		Body:            opts,
@@ -48,199 +54,525 @@ func createToken(token string, opts interface{}) (string, error) {
 	}
 }
 
-func createWrappedToken(token string, opts interface{}, wrapTTL time.Duration) (string, error) {
+// createWrappedToken returns the wrap info of the new token, which includes
+// the accessor of the new token.
+func createWrappedToken(ctx context.Context, backend *vaultBackend, token string, namespace string, opts tokenOptions, wrapTTL time.Duration) (vaultWrapInfo, error) {
 	wrapTTLSeconds := strconv.Itoa(int(wrapTTL.Seconds()))
 
+	createPath := "/v1/auth/token/create"
+	if opts.Role != "" {
+		createPath = path.Join(createPath, opts.Role)
+	}
 	r, err := VaultRequest{
-		goreq.Request{
-			Uri:             vaultPath("/v1/auth/token/create", ""),
+		Request: goreq.Request{
+			Uri:             backend.path(createPath, ""),
 			Method:          "POST",
 			Body:            opts,
 			MaxRedirects:    10,
 			RedirectHeaders: true,
 		}.WithHeader("X-Vault-Token", token).WithHeader("X-Vault-Wrap-TTL", wrapTTLSeconds),
+		Namespace: namespace,
+		Context:   ctx,
 	}.Do()
-	defer r.Body.Close()
-
 	if err != nil {
-		return "", err
+		return vaultWrapInfo{}, err
 	}
+	defer r.Body.Close()
 
 	if r.StatusCode != 200 {
 		var e vaultError
 		e.Code = r.StatusCode
 		if err := r.Body.FromJsonTo(&e); err == nil {
-			return "", e
+			return vaultWrapInfo{}, e
 		} else {
 			e.Errors = []string{"communication error."}
-			return "", e
+			return vaultWrapInfo{}, e
 		}
 	}
 
 	t := &vaultTokenResp{}
 	if err := r.Body.FromJsonTo(t); err != nil {
-		return "", err
+		return vaultWrapInfo{}, err
 	}
 
 	if t.WrapInfo.Token == "" {
-		return "", errors.New("Request for wrapped token did not return wrapped response")
+		return vaultWrapInfo{}, errors.New("Request for wrapped token did not return wrapped response")
 	}
 
-	return t.WrapInfo.Token, nil
+	return t.WrapInfo, nil
 }
 
-func createTokenPair(token string, p *policy) (string, error) {
+type tokenOptions struct {
+	Ttl       string            `json:"ttl,omitempty"`
+	Policies  []string          `json:"policies"`
+	Meta      map[string]string `json:"meta,omitempty"`
+	NumUses   int               `json:"num_uses"`
+	NoParent  bool              `json:"no_parent"`
+	Renewable bool              `json:"renewable"`
+	Type      string            `json:"type,omitempty"`
+
+	BoundCidrs []string `json:"bound_cidrs,omitempty"`
+
+	// vault only attaches tokens created with a token role to an entity alias
+	EntityAlias string `json:"entity_alias,omitempty"`
+	Role        string `json:"-"`
+}
+
+// The options used to create the perm token for a task matching this policy.
+func (p *policy) tokenOptions() tokenOptions {
 	pol := p.Policies
 	if len(pol) == 0 { // explicitly set the policy, else the token will inherit ours
 		pol = []string{"default"}
 	}
+	opts := tokenOptions{
+		Ttl:         time.Duration(time.Duration(p.Ttl) * time.Second).String(),
+		Policies:    pol,
+		Meta:        p.Meta,
+		NumUses:     p.NumUses,
+		NoParent:    true,
+		Renewable:   p.TokenType != tokenTypeBatch, // batch tokens can't be renewed
+		Type:        p.TokenType,
+		BoundCidrs:  p.BoundCidrs,
+		EntityAlias: p.EntityAlias,
+	}
+	if opts.EntityAlias != "" {
+		opts.Role = config.EntityAliasRole
+	}
+	return opts
+}
 
-	permTokenOpts := struct {
-		Ttl       string            `json:"ttl,omitempty"`
-		Policies  []string          `json:"policies"`
-		Meta      map[string]string `json:"meta,omitempty"`
-		NumUses   int               `json:"num_uses"`
-		NoParent  bool              `json:"no_parent"`
-		Renewable bool              `json:"renewable"`
-	}{time.Duration(time.Duration(p.Ttl) * time.Second).String(), pol, p.Meta, p.NumUses, true, true}
+// vaultNamespace is the namespace the policy's credentials are created in on
+// the vault backend.
+func (p *policy) vaultNamespace(backend *vaultBackend) string {
+	if p.Namespace != "" {
+		return p.Namespace
+	}
+	return backend.namespace()
+}
 
-	return createWrappedToken(token, permTokenOpts, 10*time.Minute)
+// withVault calls fn with the vault backend, gatekeeper token and namespace the
+// policy's credentials are created with. If the token for an additional vault
+// backend is rejected, gatekeeper logs in to the backend again and retries once.
+func (p *policy) withVault(token string, fn func(backend *vaultBackend, token string, namespace string) (string, error)) (string, error) {
+	backend, err := getVaultBackend(p.Vault)
+	if err != nil {
+		return "", err
+	}
+	if backend != nil {
+		if token, err = backend.Token(); err != nil {
+			return "", err
+		}
+	}
+	namespace := p.vaultNamespace(backend)
+
+	result, err := fn(backend, token, namespace)
+	if e, ok := err.(vaultError); ok && e.Code == 403 && backend != nil {
+		// Our token for this backend may have expired, log in again and retry once.
+		backend.reset()
+		if token, err = backend.Token(); err == nil {
+			result, err = fn(backend, token, namespace)
+		}
+	}
+	return result, err
 }
 
-func Provide(c *gin.Context) {
+// createTokenPair returns the wrap info of the response wrapping token of the
+// task's token, which includes the accessor of the task's token.
+func createTokenPair(ctx context.Context, token string, p *policy) (vaultWrapInfo, error) {
+	var wrap vaultWrapInfo
+	_, err := p.withVault(token, func(backend *vaultBackend, token string, namespace string) (string, error) {
+		opts := p.tokenOptions()
+		if opts.EntityAlias != "" {
+			if err := ensureEntityAlias(ctx, backend, token, namespace, opts.EntityAlias); err != nil {
+				return "", err
+			}
+		}
+		var err error
+		wrap, err = createWrappedToken(ctx, backend, token, namespace, opts, 10*time.Minute)
+		return wrap.Token, err
+	})
+	return wrap, err
+}
+
+// verifyTask checks that the task has not already been given a token, and has
+// the configured attestors establish its identity. Whether it was started
+// recently enough to ask for one depends on its policy, see checkTaskLife.
+func verifyTask(request attestationRequest) (mesosTask, string, error) {
+	if usedTaskIds.Has(request.TaskId) {
+		return mesosTask{}, "", errAlreadyGivenKey
+	}
+	task, attestor, err := attestTask(request)
+	// attestors that don't go by the task id of the request identify the task themselves
+	if err == nil && task.Id != request.TaskId && usedTaskIds.Has(task.Id) {
+		return task, attestor, errAlreadyGivenKey
+	}
+	return task, attestor, err
+}
+
+// checkTaskLife checks that the task was started no longer than maxLife ago.
+// Tasks whose attestor doesn't know when they started aren't checked.
+func checkTaskLife(task mesosTask, maxLife time.Duration) error {
+	if len(task.Statuses) == 0 {
+		return nil
+	}
+	// https://github.com/apache/mesos/blob/a61074586d778d432ba991701c9c4de9459db897/src/webui/master/static/js/controllers.js#L148
+	startTime := time.Unix(0, int64(task.Statuses[0].Timestamp*1000000000))
+	if time.Now().Sub(startTime) > maxLife {
+		return errTaskNotFresh
+	}
+	return nil
+}
+
+// How long the task id stays used after the task got its token. Tasks are
+// rejected once they are older than the task life, but an SVID can be presented
+// again for as long as it is valid.
+func usedTaskIdTtl(task mesosTask, taskLife time.Duration, now time.Time) time.Duration {
+	ttl := taskLife + 1*time.Minute
+	if valid := task.NotAfter.Sub(now) + 1*time.Minute; valid > ttl {
+		ttl = valid
+	}
+	return ttl
+}
+
+// Returns the http status code a failed verification should be reported with.
+func verifyErrorCode(err error) int {
+	switch err {
+	case errAlreadyGivenKey, errTaskNotFresh, errNoSuchTask, errSourceNotAllowed, errAgentNotAllowed, errNoPolicy, errNotTenantTask, errNotAttested, errSpiffeTrustDomain, errTooManyInstances,
+		errUnsignedRequest, errUnknownAgent, errSignatureExpired, errInvalidSignature, errWrongSigningAgent:
+		return 403
+	default:
+		return 500
+	}
+}
+
+// A tokenGrant is the result of a token request that passed validation. Token
+// is empty for dry runs.
+type tokenGrant struct {
+	TaskId      string
+	TaskName    string
+	PolicyKey   string
+	Options     tokenOptions
+	SecretPaths []string
+	Token       string
+	VaultAddr   string
+	// The namespace Token was created in, which it is unwrapped in.
+	VaultNamespace string
+	// The wrap info of Token.
+	Wrap vaultWrapInfo
+}
+
+// A tokenRequestError is a failed token request, along with the http status
+// code it is reported with.
+type tokenRequestError struct {
+	Code int
+	Err  error
+}
+
+func (e tokenRequestError) Error() string {
+	return e.Err.Error()
+}
+
+// Records a token request that couldn't be decoded.
+func invalidTokenRequest(remoteIp string, dryRun bool, err error) error {
+	log.Printf("Rejected token request from %s. Reason: %v", remoteIp, err)
+	if !dryRun {
+		atomic.AddInt32(&state.Stats.Requests, 1)
+		atomic.AddInt32(&state.Stats.Denied, 1)
+	}
+	recordTokenRequest(auditEvent{Time: time.Now(), RemoteAddr: remoteIp, DryRun: dryRun, Outcome: auditInvalid, Error: err.Error()})
+	return tokenRequestError{400, err}
+}
+
+// requestToken validates the token request of a task and, unless it is a dry
+// run, creates its token. The http and grpc apis both go through it, so that
+// every request is logged, counted and audited the same way.
+func requestToken(request attestationRequest, dryRun bool) (tokenGrant, error) {
+	remoteIp, taskId := request.RemoteAddr, request.TaskId
 	requestStartTime := time.Now()
 	state.RLock()
 	status := state.Status
 	token := state.Token
 	state.RUnlock()
 
-	remoteIp := c.Request.RemoteAddr
+	ctx, cancel := context.WithTimeout(request.context(), config.RequestTimeout)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "gatekeeper.token_request", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("gatekeeper.task_id", taskId),
+		attribute.Bool("gatekeeper.dry_run", dryRun),
+	))
+	request.Context = ctx
+	event := auditEvent{Time: requestStartTime, TaskId: taskId, RemoteAddr: remoteIp, DryRun: dryRun}
+	store := activePolicies
+	if request.Tenant != nil {
+		store = request.Tenant.store
+		event.Tenant = request.Tenant.Name
+		span.SetAttributes(attribute.String("gatekeeper.tenant", request.Tenant.Name))
+	}
+	defer func() {
+		recordTokenRequest(event)
+		span.SetAttributes(
+			attribute.String("gatekeeper.outcome", event.Outcome),
+			attribute.String("gatekeeper.task_name", event.TaskName),
+			attribute.String("gatekeeper.policy_key", event.PolicyKey),
+		)
+		if event.Error != "" {
+			span.SetStatus(codes.Error, event.Error)
+		}
+		span.End()
+	}()
 
-	atomic.AddInt32(&state.Stats.Requests, 1)
+	if !dryRun {
+		atomic.AddInt32(&state.Stats.Requests, 1)
+	}
+	failed := func(code int, outcome string, err error) (tokenGrant, error) {
+		if !dryRun {
+			atomic.AddInt32(&state.Stats.Denied, 1)
+		}
+		event.Outcome, event.Error = outcome, err.Error()
+		return tokenGrant{}, tokenRequestError{code, err}
+	}
+	verifyFailed := func(err error) (tokenGrant, error) {
+		if code := verifyErrorCode(err); code == 403 {
+			log.Printf("Rejected token request from %s (Task Id: %s). Reason: %v", remoteIp, taskId, err)
+			return failed(code, auditDenied, err)
+		}
+		log.Printf("Failed to retrieve task information for %s (Task Id: %s). Reason: %v", remoteIp, taskId, err)
+		return failed(500, auditFailed, err)
+	}
 
 	if status == StatusSealed {
 		log.Printf("Rejected token request from %s. Reason: sealed.", remoteIp)
-		atomic.AddInt32(&state.Stats.Denied, 1)
-		c.JSON(503, struct {
-			Status string `json:"status"`
-			Ok     bool   `json:"ok"`
-			Error  string `json:"error"`
-		}{string(state.Status), false, "Gatekeeper is sealed."})
-		return
+		return failed(503, auditSealed, errSealed)
+	}
+	if err := replicationRole(); err != nil {
+		log.Printf("Rejected token request from %s. Reason: %v", remoteIp, err)
+		return failed(503, auditFailed, err)
+	}
+	if request.Tenant != nil && store.Get() == nil {
+		log.Printf("Rejected token request from %s to tenant '%s'. Reason: %v", remoteIp, event.Tenant, errTenantNotLoaded)
+		return failed(503, auditFailed, errTenantNotLoaded)
 	}
 
-	var reqParams struct {
-		TaskId string `json:"task_id"`
+	// the signature is checked before the task is looked up on mesos
+	signer, err := verifySignature(request, time.Now())
+	if err != nil {
+		return verifyFailed(err)
 	}
-	decoder := json.NewDecoder(c.Request.Body)
-	if err := decoder.Decode(&reqParams); err == nil {
-		if usedTaskIds.Has(reqParams.TaskId) {
-			log.Printf("Rejected token request from %s (Task Id: %s). Reason: %v", remoteIp, reqParams.TaskId, errAlreadyGivenKey)
-			atomic.AddInt32(&state.Stats.Denied, 1)
-			c.JSON(403, struct {
-				Status string `json:"status"`
-				Ok     bool   `json:"ok"`
-				Error  string `json:"error"`
-			}{string(state.Status), false, errAlreadyGivenKey.Error()})
-			return
+
+	_, verifySpan := tracer.Start(ctx, "gatekeeper.verify_task")
+	task, attestor, err := verifyTask(request)
+	verifySpan.SetAttributes(attribute.String("gatekeeper.attestor", attestor))
+	endSpan(verifySpan, err)
+	event.TaskName = task.Name
+	event.Attestor = attestor
+	if err != nil {
+		return verifyFailed(err)
+	}
+	if err := checkSigningAgent(ctx, signer, task); err != nil {
+		return verifyFailed(err)
+	}
+	taskId = task.Id
+	event.TaskId = taskId
+
+	_, policySpan := tracer.Start(ctx, "gatekeeper.match_policy")
+	// the whole request matches against the same set of policies, even if they
+	// are reloaded in the meantime
+	current := store.Get()
+	frameworkKeys := current.hasFrameworkKeys()
+	frameworks := []string{task.FrameworkId}
+	var frameworkName string
+	tenantFrameworks := request.Tenant != nil && len(request.Tenant.Frameworks) > 0
+	if frameworkKeys || config.MatchJobNames || tenantFrameworks {
+		// a failed lookup must not fall back to a policy that isn't specific to the framework
+		frameworkName, err = taskFrameworkName(ctx, task)
+		if err != nil {
+			endSpan(policySpan, err)
+			log.Printf("Failed to look up the framework of %s (Task Id: %s). Reason: %v", remoteIp, taskId, err)
+			return failed(500, auditFailed, err)
 		}
-		/*
-			The task can start, but the task's framework may have not reported
-			that it is RUNNING back to mesos. In this case, the task will still
-			be STAGING and have a statuses length of 0.
-
-			This is a network race, so we just sleep and try again.
-		*/
-		gMT := func(taskId string) (mesosTask, error) {
-			task, err := getMesosTask(taskId)
-			for i := time.Duration(0); i < 3 && err == nil && len(task.Statuses) == 0; i++ {
-				time.Sleep((500 + 250*i) * time.Millisecond)
-				task, err = getMesosTask(taskId)
-			}
-			return task, err
+		if frameworkKeys && frameworkName != "" {
+			frameworks = append([]string{frameworkName}, frameworks...)
 		}
+	}
 
-		// TODO: Remove this when we can incorporate Mesos in testing environment
-		if reqParams.TaskId == state.testingTaskId && state.testingTaskId != "" {
-			gMT = func(taskId string) (mesosTask, error) {
-				return mesosTask{
-					Statuses: []struct {
-						State     string  `json:"state"`
-						Timestamp float64 `json:"timestamp"`
-					}{{"RUNNING", float64(time.Now().UnixNano()) / float64(1000000000)}},
-					Id:   reqParams.TaskId,
-					Name: "Test",
-				}, nil
-			}
+	policyKey, policy, err := current.Required(policyTaskName(task, frameworkName), frameworks...)
+	if err == nil && request.Tenant != nil {
+		err = request.Tenant.admits(task, frameworkName, policyKey)
+	}
+	policySpan.SetAttributes(attribute.String("gatekeeper.policy_key", policyKey))
+	endSpan(policySpan, err)
+	event.PolicyKey = policyKey
+	if err != nil {
+		return verifyFailed(err)
+	}
+
+	if err := checkTaskLife(task, policy.taskLife()); err != nil {
+		return verifyFailed(err)
+	}
+	if err := policy.allows(ctx, remoteIp, task); err != nil {
+		return verifyFailed(err)
+	}
+
+	policy = policy.boundTo(remoteIp)
+	policy, err = policy.withTask(ctx, task)
+	if err != nil {
+		log.Printf("Failed to create token pair for %s (Task Id: %s). Reason: %v", remoteIp, taskId, err)
+		return failed(500, auditFailed, err)
+	}
+	event.Policies = policy.tokenOptions().Policies
+	event.Ttl = policy.Ttl
+	event.SecretPaths = policy.SecretPaths
+
+	backend, err := getVaultBackend(policy.Vault)
+	if err != nil {
+		log.Printf("Failed to create token pair for %s (Task Id: %s). Reason: %v (%s)", remoteIp, taskId, err, policy.Vault)
+		return failed(500, auditFailed, err)
+	}
+	grant := tokenGrant{
+		TaskId:         taskId,
+		TaskName:       task.Name,
+		PolicyKey:      policyKey,
+		Options:        policy.tokenOptions(),
+		SecretPaths:    policy.SecretPaths,
+		VaultNamespace: policy.vaultNamespace(backend),
+	}
+	if backend != nil {
+		grant.VaultAddr = backend.Address
+	}
+
+	// guards against many fabricated task ids of a real app
+	release := func() {}
+	if policy.MaxInstances > 0 {
+		running, err := countMesosTasks(ctx, task)
+		if err != nil {
+			log.Printf("Failed to count the instances of %s for %s (Task Id: %s). Reason: %v", task.Name, remoteIp, taskId, err)
+			return failed(500, auditFailed, err)
 		}
-		if task, err := gMT(reqParams.TaskId); err == nil {
-			if len(task.Statuses) == 0 {
-				log.Printf("Rejected token request from %s (Task Id: %s). Reason: %v (no status)", remoteIp, reqParams.TaskId, errTaskNotFresh)
-				atomic.AddInt32(&state.Stats.Denied, 1)
-				c.JSON(403, struct {
-					Status string `json:"status"`
-					Ok     bool   `json:"ok"`
-					Error  string `json:"error"`
-				}{string(state.Status), false, errTaskNotFresh.Error()})
-				return
-			}
-			// https://github.com/apache/mesos/blob/a61074586d778d432ba991701c9c4de9459db897/src/webui/master/static/js/controllers.js#L148
-			startTime := time.Unix(0, int64(task.Statuses[0].Timestamp*1000000000))
-			if time.Now().Sub(startTime) > config.MaxTaskLife {
-				log.Printf("Rejected token request from %s (Task Id: %s). Reason: %v (no status)", remoteIp, reqParams.TaskId, errTaskNotFresh)
-				atomic.AddInt32(&state.Stats.Denied, 1)
-				c.JSON(403, struct {
-					Status string `json:"status"`
-					Ok     bool   `json:"ok"`
-					Error  string `json:"error"`
-				}{string(state.Status), false, errTaskNotFresh.Error()})
-				return
-			}
-			state.RLock()
-			policy := activePolicies.Get(task.Name)
-			state.RUnlock()
-			if tempToken, err := createTokenPair(token, policy); err == nil {
-				log.Printf("Provided token pair for %s in %v. (Task Id: %s) (Task Name: %s). Policies: %v", remoteIp, time.Now().Sub(requestStartTime), reqParams.TaskId, task.Name, policy.Policies)
-				atomic.AddInt32(&state.Stats.Successful, 1)
-				usedTaskIds.Put(reqParams.TaskId, config.MaxTaskLife+1*time.Minute)
-				c.JSON(200, struct {
-					Status string `json:"status"`
-					Ok     bool   `json:"ok"`
-					Token  string `json:"token"`
-				}{string(state.Status), true, tempToken})
-			} else {
-				log.Printf("Failed to create token pair for %s (Task Id: %s). Reason: %v", remoteIp, reqParams.TaskId, errTaskNotFresh)
-				atomic.AddInt32(&state.Stats.Denied, 1)
-				c.JSON(500, struct {
-					Status string `json:"status"`
-					Ok     bool   `json:"ok"`
-					Error  string `json:"error"`
-				}{string(state.Status), false, err.Error()})
-			}
-		} else if err == errNoSuchTask {
-			log.Printf("Rejected token request from %s (Task Id: %s). Reason: %v", remoteIp, reqParams.TaskId, errNoSuchTask)
-			atomic.AddInt32(&state.Stats.Denied, 1)
-			c.JSON(403, struct {
-				Status string `json:"status"`
-				Ok     bool   `json:"ok"`
-				Error  string `json:"error"`
-			}{string(state.Status), false, err.Error()})
-		} else {
-			log.Printf("Failed to retrieve task information for %s (Task Id: %s). Reason: %v", remoteIp, reqParams.TaskId, err)
-			atomic.AddInt32(&state.Stats.Denied, 1)
-			c.JSON(500, struct {
-				Status string `json:"status"`
-				Ok     bool   `json:"ok"`
-				Error  string `json:"error"`
-			}{string(state.Status), false, err.Error()})
+		if release, err = reserveInstanceToken(task.FrameworkId+"/"+task.Name, policy.instanceLimit(running), policy.taskLife(), time.Now()); err != nil {
+			return verifyFailed(err)
 		}
+	}
+
+	if dryRun {
+		release()
+		log.Printf("Token request check for %s passed in %v. (Task Id: %s) (Task Name: %s). Policies: %v", remoteIp, time.Now().Sub(requestStartTime), taskId, task.Name, policy.Policies)
+		event.Outcome = auditChecked
+		return grant, nil
+	}
+
+	// a promoted standby must know that the task got its token
+	usedTtl := usedTaskIdTtl(task, policy.taskLife(), time.Now())
+	if err := claimTaskId(ctx, taskId, usedTtl); err != nil {
+		release()
+		log.Printf("Failed to create token pair for %s (Task Id: %s). Reason: %v", remoteIp, taskId, err)
+		return failed(503, auditFailed, err)
+	}
+
+	var accessor string
+	_, createSpan := tracer.Start(ctx, "gatekeeper.create_token", trace.WithAttributes(attribute.String("gatekeeper.vault", policy.Vault)))
+	if len(policy.SecretPaths) > 0 {
+		grant.Wrap, err = createWrappedSecrets(ctx, token, policy)
 	} else {
-		log.Printf("Rejected token request from %s. Reason: %v", remoteIp, err)
-		atomic.AddInt32(&state.Stats.Denied, 1)
-		c.JSON(400, struct {
-			Status string `json:"status"`
-			Ok     bool   `json:"ok"`
-			Error  string `json:"error"`
-		}{string(state.Status), false, err.Error()})
+		grant.Wrap, err = createTokenPair(ctx, token, policy)
+		accessor = grant.Wrap.WrappedAccessor
+	}
+	grant.Token = grant.Wrap.Token
+	endSpan(createSpan, err)
+	if err != nil {
+		release()
+		log.Printf("Failed to create token pair for %s (Task Id: %s). Reason: %v", remoteIp, taskId, err)
+		return failed(500, auditFailed, err)
+	}
+	if len(policy.SecretPaths) > 0 {
+		log.Printf("Provided secrets for %s in %v. (Task Id: %s) (Task Name: %s). Secrets: %v", remoteIp, time.Now().Sub(requestStartTime), taskId, task.Name, policy.SecretPaths)
+	} else {
+		log.Printf("Provided token pair for %s in %v. (Task Id: %s) (Task Name: %s). Policies: %v", remoteIp, time.Now().Sub(requestStartTime), taskId, task.Name, policy.Policies)
+	}
+	atomic.AddInt32(&state.Stats.Successful, 1)
+	markTaskIdUsed(taskId, usedTtl)
+	// batch tokens have no accessor, and can't be revoked
+	if accessor != "" {
+		recordIssuedToken(issuedToken{
+			Accessor:  accessor,
+			TaskId:    taskId,
+			TaskName:  task.Name,
+			AppId:     marathonAppId(task.Name),
+			AgentId:   task.SlaveId,
+			Vault:     policy.Vault,
+			Namespace: policy.Namespace,
+			Issued:    time.Now(),
+		})
+	}
+	event.Outcome = auditIssued
+	return grant, nil
+}
+
+func Provide(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	provide(c, dryRun)
+}
+
+// CheckToken performs all of the validation of a token request without
+// creating a token or marking the task as having been given one.
+func CheckToken(c *gin.Context) {
+	provide(c, true)
+}
+
+func provide(c *gin.Context, dryRun bool) {
+	var reqParams gatekeeper.TokenRequest
+	var grant tokenGrant
+	version, err := tokenResponseVersion(c)
+	if err == nil {
+		err = json.NewDecoder(c.Request.Body).Decode(&reqParams)
+	}
+	if err != nil {
+		err = invalidTokenRequest(c.Request.RemoteAddr, dryRun, err)
+	} else {
+		grant, err = requestToken(attestationRequest{
+			TaskId:     reqParams.TaskId,
+			RemoteAddr: c.Request.RemoteAddr,
+			TLS:        c.Request.TLS,
+			Context:    httpTraceContext(c.Request),
+			Tenant:     requestTenant(c),
+			Signature: requestSignature{
+				Agent:     c.GetHeader(gatekeeper.AgentHeader),
+				Timestamp: c.GetHeader(gatekeeper.TimestampHeader),
+				Signature: c.GetHeader(gatekeeper.SignatureHeader),
+			},
+		}, dryRun)
+	}
+	if version < gatekeeper.TokenResponseV2 || dryRun {
+		version = 0
+	} else {
+		c.Header("Content-Type", gatekeeper.TokenResponseMediaType(version))
+	}
+	if err != nil {
+		code := 500
+		if e, ok := err.(tokenRequestError); ok {
+			code = e.Code
+		}
+		c.JSON(code, struct {
+			Status  string `json:"status"`
+			Ok      bool   `json:"ok"`
+			Version int    `json:"version,omitempty"`
+			DryRun  bool   `json:"dry_run,omitempty"`
+			Error   string `json:"error"`
+		}{string(state.Status), false, version, dryRun, err.Error()})
+		return
+	}
+
+	if dryRun {
+		c.JSON(200, struct {
+			Status      string       `json:"status"`
+			Ok          bool         `json:"ok"`
+			DryRun      bool         `json:"dry_run"`
+			TaskId      string       `json:"task_id"`
+			TaskName    string       `json:"task_name"`
+			PolicyKey   string       `json:"policy_key"`
+			Token       tokenOptions `json:"token"`
+			SecretPaths []string     `json:"secret_paths,omitempty"`
+		}{string(state.Status), true, true, grant.TaskId, grant.TaskName, grant.PolicyKey, grant.Options, grant.SecretPaths})
+		return
 	}
+	c.JSON(200, tokenResponse(state.Status, grant, version))
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errRateLimited = errors.New("Too many token requests. Try again later.")
+
+// A tokenBucket allows bursts of up to burst requests, refilling at rate
+// requests per second.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &tokenBucket{rate, float64(burst), float64(burst), time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// Take a token from the bucket if one is available.
+func (b *tokenBucket) Allow(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Give back a token taken by a request that was rejected anyway.
+func (b *tokenBucket) Refund() {
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// The time until the next token is available.
+func (b *tokenBucket) Wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+type rateLimiter struct {
+	sync.Mutex
+	global  *tokenBucket
+	ipRate  float64
+	ipBurst int
+	ips     map[string]*tokenBucket
+	quit    chan struct{}
+}
+
+// Creates a rate limiter with a global limit and a limit per source ip. A rate
+// of 0 disables the respective limit.
+func NewRateLimiter(rate float64, burst int, ipRate float64, ipBurst int) *rateLimiter {
+	r := &rateLimiter{
+		ipRate:  ipRate,
+		ipBurst: ipBurst,
+		ips:     make(map[string]*tokenBucket),
+		quit:    make(chan struct{}),
+	}
+	if rate > 0 {
+		r.global = newTokenBucket(rate, burst)
+	}
+	if ipRate > 0 {
+		go r.garbageCollector()
+	}
+	return r
+}
+
+// Allow reports whether a request from ip may proceed, and if not, how long
+// until it may be retried.
+func (r *rateLimiter) Allow(ip string) (bool, time.Duration) {
+	now := time.Now()
+	r.Lock()
+	defer r.Unlock()
+	var bucket *tokenBucket
+	if r.ipRate > 0 {
+		var ok bool
+		if bucket, ok = r.ips[ip]; !ok {
+			bucket = newTokenBucket(r.ipRate, r.ipBurst)
+			r.ips[ip] = bucket
+		}
+		if !bucket.Allow(now) {
+			return false, bucket.Wait()
+		}
+	}
+	if r.global != nil && !r.global.Allow(now) {
+		// a request rejected by the global limit doesn't count against its ip
+		if bucket != nil {
+			bucket.Refund()
+		}
+		return false, r.global.Wait()
+	}
+	return true, 0
+}
+
+func (r *rateLimiter) Destroy() {
+	close(r.quit)
+}
+
+// Forget ips whose buckets have refilled, as they are no different from a new bucket.
+func (r *rateLimiter) cleanup() {
+	now := time.Now()
+	r.Lock()
+	for ip, bucket := range r.ips {
+		if bucket.refill(now); bucket.tokens >= bucket.burst {
+			delete(r.ips, ip)
+		}
+	}
+	r.Unlock()
+}
+
+func (r *rateLimiter) garbageCollector() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.cleanup()
+		case <-r.quit:
+			return
+		}
+	}
+}
+
+var tokenRateLimiter *rateLimiter
+
+// Checks a token request from remoteAddr against the configured rate limits,
+// recording it if it is rejected. Returns how long to wait before retrying.
+func rateLimitTokenRequest(remoteAddr string) (bool, time.Duration) {
+	if tokenRateLimiter == nil {
+		return true, 0
+	}
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		ip = remoteAddr
+	}
+	ok, wait := tokenRateLimiter.Allow(ip)
+	if !ok {
+		log.Printf("Rejected token request from %s. Reason: %v", remoteAddr, errRateLimited)
+		atomic.AddInt32(&state.Stats.RateLimited, 1)
+		recordTokenRequest(auditEvent{
+			Time:       time.Now(),
+			RemoteAddr: remoteAddr,
+			Outcome:    auditRateLimited,
+			Error:      errRateLimited.Error(),
+		})
+	}
+	return ok, wait
+}
+
+// RateLimit rejects token requests exceeding the configured rate limits.
+func RateLimit(c *gin.Context) {
+	if ok, wait := rateLimitTokenRequest(c.Request.RemoteAddr); !ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(429, struct {
+			Status string `json:"status"`
+			Ok     bool   `json:"ok"`
+			Error  string `json:"error"`
+		}{string(state.Status), false, errRateLimited.Error()})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
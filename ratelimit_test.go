@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(1, 3)
+	b.last = now
+	for i := 0; i < 3; i++ {
+		if !b.Allow(now) {
+			t.Fatalf("Expected request %d of the burst to be allowed.", i+1)
+		}
+	}
+	if b.Allow(now) {
+		t.Fatal("Expected request above the burst to be rejected.")
+	}
+	if wait := b.Wait(); wait <= 0 || wait > time.Second {
+		t.Fatalf("Expected to wait less than a second for the next token, got %v.", wait)
+	}
+	if !b.Allow(now.Add(time.Second)) {
+		t.Fatal("Expected the bucket to refill after a second.")
+	}
+}
+
+func TestRateLimiterPerIp(t *testing.T) {
+	r := NewRateLimiter(0, 0, 1, 1)
+	defer r.Destroy()
+	if ok, _ := r.Allow("10.0.0.1"); !ok {
+		t.Fatal("Expected first request from ip to be allowed.")
+	}
+	if ok, _ := r.Allow("10.0.0.1"); ok {
+		t.Fatal("Expected second request from ip to be rate limited.")
+	}
+	if ok, _ := r.Allow("10.0.0.2"); !ok {
+		t.Fatal("Expected request from another ip to be allowed.")
+	}
+}
+
+func TestRateLimiterGlobalRejectRefund(t *testing.T) {
+	r := NewRateLimiter(1, 1, 1, 2)
+	defer r.Destroy()
+	if ok, _ := r.Allow("10.0.0.2"); !ok {
+		t.Fatal("Expected first request to be allowed.")
+	}
+	for i := 0; i < 3; i++ {
+		if ok, _ := r.Allow("10.0.0.1"); ok {
+			t.Fatal("Expected request over the global limit to be rate limited.")
+		}
+	}
+	if tokens := r.ips["10.0.0.1"].tokens; tokens != 2 {
+		t.Errorf("Expected the requests rejected by the global limit not to use up the limit of the ip, got %v tokens left.", tokens)
+	}
+}
@@ -1,33 +1,107 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/franela/goreq"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
 )
 
 type VaultRequest struct {
 	goreq.Request
+	// The Vault Enterprise namespace to make the request in. If empty, requests
+	// to the default vault server are made in the namespace configured with
+	// VAULT_NAMESPACE, and requests to other vault backends in none.
+	Namespace string
+	// The context of the token request the request is made for, which cancels
+	// it and bounds its timeout. May be nil.
+	Context context.Context
 }
 
 func (r VaultRequest) Do() (*goreq.Response, error) {
+	return r.doWithRetry()
+}
+
+func (r VaultRequest) do() (*goreq.Response, error) {
+	if r.Request.Timeout == 0 {
+		r.Request.Timeout = config.Vault.Timeout
+	}
+	if ctx := r.Context; ctx != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			if left := time.Until(deadline); r.Request.Timeout == 0 || left < r.Request.Timeout {
+				r.Request.Timeout = left
+			}
+		}
+		r.Request.OnBeforeRequest = func(_ *goreq.Request, req *http.Request) {
+			*req = *req.WithContext(ctx)
+		}
+	}
+	namespace := r.Namespace
+	if _, ok := vaultAddresses.match(r.Request.Uri); namespace == "" && ok {
+		namespace = config.Vault.Namespace
+	}
+	if namespace != "" {
+		r.Request = r.Request.WithHeader("X-Vault-Namespace", namespace)
+	}
+	resp, err := r.followRedirects()
+	// fail over to the other nodes of the default vault server
+	for tries := 1; tries < vaultAddresses.Len() && vaultNodeUnavailable(r.Request.Method, resp, err); tries++ {
+		address, ok := vaultAddresses.match(r.Request.Uri)
+		if !ok {
+			break
+		}
+		if err == nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		r.Request.Uri = rebaseVaultUri(r.Request.Uri, vaultAddresses.Failover(address))
+		resp, err = r.followRedirects()
+	}
+	return resp, err
+}
+
+// The most redirects followed for a single vault request.
+const maxVaultRedirects = 5
+
+var errTooManyVaultRedirects = errors.New("Vault redirected the request too many times.")
+
+// Standby nodes of a vault HA cluster redirect to the active node.
+func (r VaultRequest) followRedirects() (*goreq.Response, error) {
 	resp, err := r.Request.Do()
-	for err == nil && resp.StatusCode == 307 {
+	for redirects := 0; err == nil && resp.StatusCode == 307; redirects++ {
 		io.Copy(ioutil.Discard, resp.Body)
 		resp.Body.Close()
-		r.Request.Uri = resp.Header.Get("Location")
+		if redirects >= maxVaultRedirects {
+			return nil, errTooManyVaultRedirects
+		}
+		location, locationErr := redirectLocation(r.Request.Uri, resp.Header.Get("Location"))
+		if locationErr != nil {
+			return nil, locationErr
+		}
+		r.Request.Uri = location
 		resp, err = r.Request.Do()
 	}
 	return resp, err
 }
 
-type VaultWrappedResponse struct {
-	Data struct {
-		WrappedSecret string `json:"response"`
-	} `json:"data"`
-}
-
-func (vr *VaultWrappedResponse) Unwrap(v interface{}) error {
-	return json.Unmarshal([]byte(vr.Data.WrappedSecret), v)
+// The uri a request to uri is redirected to, resolving a relative location
+// against uri.
+func redirectLocation(uri string, location string) (string, error) {
+	base, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("Vault redirected the request to an invalid location '%s': %v", location, err)
+	}
+	return base.ResolveReference(ref).String(), nil
 }
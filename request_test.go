@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/franela/goreq"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectLocation(t *testing.T) {
+	for _, test := range []struct {
+		location string
+		expected string
+	}{
+		{"https://vault-2:8200/v1/sys/health", "https://vault-2:8200/v1/sys/health"},
+		{"/v1/sys/health?standbyok=true", "https://vault-1:8200/v1/sys/health?standbyok=true"},
+		{"health", "https://vault-1:8200/v1/sys/health"},
+	} {
+		if location, err := redirectLocation("https://vault-1:8200/v1/sys/leader", test.location); err != nil || location != test.expected {
+			t.Errorf("Expected '%s' to redirect to '%s', got '%s', %v.", test.location, test.expected, location, err)
+		}
+	}
+	if _, err := redirectLocation("https://vault-1:8200/v1/sys/leader", "http://[::1"); err == nil {
+		t.Error("Expected an invalid location to fail.")
+	}
+}
+
+func TestFollowRedirects(t *testing.T) {
+	active := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"initialized":true}`))
+	}))
+	defer active.Close()
+
+	redirects := 0
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirects++
+		switch r.URL.Path {
+		case "/v1/sys/init":
+			// a relative location, resolved against the standby
+			w.Header().Set("Location", "/v1/sys/leader")
+		case "/v1/sys/leader":
+			w.Header().Set("Location", active.URL+"/v1/sys/init")
+		default:
+			w.Header().Set("Location", r.URL.Path)
+		}
+		w.WriteHeader(307)
+	}))
+	defer standby.Close()
+
+	resp, err := VaultRequest{Request: goreq.Request{Uri: standby.URL + "/v1/sys/init"}}.followRedirects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || redirects != 2 {
+		t.Errorf("Expected the active node to respond after 2 redirects, got %d after %d.", resp.StatusCode, redirects)
+	}
+
+	// a redirect loop
+	redirects = 0
+	if _, err := (VaultRequest{Request: goreq.Request{Uri: standby.URL + "/v1/sys/loop"}}).followRedirects(); err != errTooManyVaultRedirects {
+		t.Errorf("Expected a redirect loop to fail, got %v.", err)
+	}
+	if redirects != maxVaultRedirects+1 {
+		t.Errorf("Expected %d redirects to be followed, got %d.", maxVaultRedirects, redirects-1)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeper"
+	"github.com/gin-gonic/gin"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errUnsupportedResponseVersion = errors.New("Unsupported token response version. Valid versions are 1 and 2.")
+
+// The prefix and suffix of the versioned media types of the token response,
+// see gatekeeper.TokenResponseMediaType.
+const (
+	responseMediaTypePrefix = "application/vnd.gatekeeper.v"
+	responseMediaTypeSuffix = "+json"
+)
+
+func validResponseVersion(version int) bool {
+	return version == gatekeeper.TokenResponseV1 || version == gatekeeper.TokenResponseV2
+}
+
+// tokenResponseVersion picks the version of the token response: the version
+// query parameter, else the versioned media type the client accepts, else
+// TOKEN_RESPONSE_VERSION, so that existing clients keep getting the response
+// they were written for.
+func tokenResponseVersion(c *gin.Context) (int, error) {
+	if v := c.Query("version"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil || !validResponseVersion(version) {
+			return 0, errUnsupportedResponseVersion
+		}
+		return version, nil
+	}
+	for _, accept := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.Split(accept, ";")[0])
+		if !strings.HasPrefix(mediaType, responseMediaTypePrefix) || !strings.HasSuffix(mediaType, responseMediaTypeSuffix) {
+			continue
+		}
+		version, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(mediaType, responseMediaTypePrefix), responseMediaTypeSuffix))
+		if err != nil || !validResponseVersion(version) {
+			return 0, errUnsupportedResponseVersion
+		}
+		return version, nil
+	}
+	return config.TokenResponseVersion, nil
+}
+
+// tokenResponse is the response to a token request that was granted, in the
+// given version.
+func tokenResponse(status GkStatus, grant tokenGrant, version int) gatekeeper.TokenResponse {
+	resp := gatekeeper.TokenResponse{
+		Status:         string(status),
+		OK:             true,
+		Token:          grant.Token,
+		VaultAddr:      grant.VaultAddr,
+		VaultNamespace: grant.VaultNamespace,
+		SecretPaths:    grant.SecretPaths,
+	}
+	if version < gatekeeper.TokenResponseV2 {
+		return resp
+	}
+	resp.Version = version
+	resp.PolicyKey = grant.PolicyKey
+	if resp.VaultAddr == "" {
+		resp.VaultAddr = vaultAddresses.Current()
+	}
+	// tasks that are given secrets don't get a token of their own
+	if len(grant.SecretPaths) == 0 {
+		if ttl, err := time.ParseDuration(grant.Options.Ttl); err == nil {
+			resp.LeaseDuration = int(ttl.Seconds())
+		}
+		resp.Renewable = grant.Options.Renewable
+	}
+	if grant.Wrap.Token != "" {
+		resp.WrapInfo = &gatekeeper.WrapInfo{
+			Token:           grant.Wrap.Token,
+			Accessor:        grant.Wrap.Accessor,
+			TTL:             grant.Wrap.TTL,
+			CreationTime:    grant.Wrap.CreationTime,
+			WrappedAccessor: grant.Wrap.WrappedAccessor,
+		}
+	}
+	return resp
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenResponseVersion(t *testing.T) {
+	defer func(version int) {
+		config.TokenResponseVersion = version
+	}(config.TokenResponseVersion)
+	config.TokenResponseVersion = 1
+
+	for _, test := range []struct {
+		url, accept string
+		version     int
+		valid       bool
+	}{
+		{"/token", "", 1, true},
+		{"/token", "application/json", 1, true},
+		{"/token?version=2", "", 2, true},
+		{"/token?version=1", "application/vnd.gatekeeper.v2+json", 1, true},
+		{"/token", "application/json, application/vnd.gatekeeper.v2+json; q=0.9", 2, true},
+		{"/token?version=3", "", 0, false},
+		{"/token?version=two", "", 0, false},
+		{"/token", "application/vnd.gatekeeper.v9+json", 0, false},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", test.url, nil)
+		if test.accept != "" {
+			c.Request.Header.Set("Accept", test.accept)
+		}
+		version, err := tokenResponseVersion(c)
+		if (err == nil) != test.valid || version != test.version {
+			t.Errorf("Expected version %d for %s with Accept '%s', got %d, %v.", test.version, test.url, test.accept, version, err)
+		}
+	}
+}
+
+func TestTokenResponse(t *testing.T) {
+	defer func(addresses *vaultAddressList) {
+		vaultAddresses = addresses
+	}(vaultAddresses)
+	vaultAddresses = &vaultAddressList{}
+	vaultAddresses.Set("https://vault:8200")
+
+	grant := tokenGrant{
+		PolicyKey: "web",
+		Options:   tokenOptions{Ttl: "50m0s", Renewable: true},
+		Token:     "wrapping-token",
+		Wrap:      vaultWrapInfo{Token: "wrapping-token", TTL: 60, WrappedAccessor: "accessor"},
+	}
+
+	b, _ := json.Marshal(tokenResponse(StatusUnsealed, grant, 1))
+	if expected := `{"status":"Unsealed","ok":true,"token":"wrapping-token"}`; string(b) != expected {
+		t.Errorf("Expected version 1 of the response to be unchanged, got %s.", b)
+	}
+
+	resp := tokenResponse(StatusUnsealed, grant, 2)
+	if resp.Version != 2 || resp.PolicyKey != "web" || resp.VaultAddr != "https://vault:8200" {
+		t.Errorf("Expected the version, policy key and vault address in version 2, got %+v.", resp)
+	}
+	if resp.LeaseDuration != 3000 || !resp.Renewable {
+		t.Errorf("Expected the lease of the token in version 2, got %d, %v.", resp.LeaseDuration, resp.Renewable)
+	}
+	if resp.WrapInfo == nil || resp.WrapInfo.TTL != 60 || resp.WrapInfo.WrappedAccessor != "accessor" {
+		t.Errorf("Expected the wrap info in version 2, got %+v.", resp.WrapInfo)
+	}
+
+	grant.SecretPaths = []string{"secret/web"}
+	grant.VaultAddr = "https://vault-teams:8200"
+	resp = tokenResponse(StatusUnsealed, grant, 2)
+	if resp.LeaseDuration != 0 || resp.Renewable {
+		t.Errorf("Expected no lease when the task is given secrets, got %d, %v.", resp.LeaseDuration, resp.Renewable)
+	}
+	if resp.VaultAddr != "https://vault-teams:8200" {
+		t.Errorf("Expected the vault address of the grant, got '%s'.", resp.VaultAddr)
+	}
+
+	// the token is unwrapped in the namespace it was created in
+	grant.VaultNamespace = "teams/web"
+	for _, version := range []int{1, 2} {
+		if resp := tokenResponse(StatusUnsealed, grant, version); resp.VaultNamespace != "teams/web" {
+			t.Errorf("Expected the namespace of the grant in version %d, got '%s'.", version, resp.VaultNamespace)
+		}
+	}
+}
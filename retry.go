@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"github.com/franela/goreq"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errVaultCircuitOpen = errors.New("Too many vault requests failed, not contacting vault until it recovers.")
+
+const maxRetryBackoff = 5 * time.Second
+
+// Network errors and server errors from vault are usually transient. Other
+// responses are final.
+func failedVaultResponse(resp *goreq.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500
+}
+
+// Only reads are safe to send twice. Vault may have created the token, or
+// wrapped the secret, of a write that failed, so writes are only retried when
+// they never reached vault.
+func retryableVaultResponse(method string, resp *goreq.Response, err error) bool {
+	if idempotentVaultMethod(method) {
+		return failedVaultResponse(resp, err)
+	}
+	return err != nil && vaultRequestUnsent(err)
+}
+
+// Vault treats PUT like POST, so only the methods that read are idempotent.
+func idempotentVaultMethod(method string) bool {
+	switch strings.ToUpper(method) {
+	case "", "GET", "HEAD", "LIST":
+		return true
+	default:
+		return false
+	}
+}
+
+// Whether the request failed before it was sent, because no connection to
+// vault could be made.
+func vaultRequestUnsent(err error) bool {
+	if e, ok := err.(*goreq.Error); ok {
+		err = e.Err
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// The time to wait before the given retry, growing exponentially from base with
+// full jitter.
+func retryBackoff(base time.Duration, retry int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	backoff := base << uint(retry)
+	if backoff > maxRetryBackoff || backoff <= 0 {
+		backoff = maxRetryBackoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff)) + 1)
+}
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// A circuitBreaker opens after threshold consecutive failed requests, failing
+// requests immediately for the timeout. After the timeout a single trial
+// request is let through, which closes the breaker again if it succeeds.
+type circuitBreaker struct {
+	sync.Mutex
+	threshold int
+	timeout   time.Duration
+	failures  int
+	openedAt  time.Time
+	trial     bool
+}
+
+func (b *circuitBreaker) Allow(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+	if now.Sub(b.openedAt) < b.timeout || b.trial {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *circuitBreaker) Record(success bool, now time.Time) {
+	b.Lock()
+	defer b.Unlock()
+	b.trial = false
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openedAt = now
+	}
+}
+
+// Abandon ends a request that says nothing about the health of vault, such as
+// a cancelled one, without counting it. If it was the trial request, the next
+// request is let through as the trial instead.
+func (b *circuitBreaker) Abandon() {
+	b.Lock()
+	defer b.Unlock()
+	b.trial = false
+}
+
+func (b *circuitBreaker) State(now time.Time) string {
+	b.Lock()
+	defer b.Unlock()
+	switch {
+	case b.threshold <= 0 || b.failures < b.threshold:
+		return circuitClosed
+	case now.Sub(b.openedAt) < b.timeout:
+		return circuitOpen
+	default:
+		return circuitHalfOpen
+	}
+}
+
+// Circuit breakers for each vault server, by host.
+var vaultBreakers = struct {
+	sync.Mutex
+	m map[string]*circuitBreaker
+}{m: make(map[string]*circuitBreaker)}
+
+func vaultBreaker(uri string) *circuitBreaker {
+	host := uri
+	if u, err := url.Parse(uri); err == nil {
+		host = u.Host
+	}
+	vaultBreakers.Lock()
+	defer vaultBreakers.Unlock()
+	b, ok := vaultBreakers.m[host]
+	if !ok {
+		b = &circuitBreaker{threshold: config.Vault.BreakerThreshold, timeout: config.Vault.BreakerTimeout}
+		vaultBreakers.m[host] = b
+	}
+	return b
+}
+
+// The state of the circuit breaker of every vault server that has been
+// contacted.
+func vaultBreakerStates() map[string]string {
+	now := time.Now()
+	states := make(map[string]string)
+	vaultBreakers.Lock()
+	defer vaultBreakers.Unlock()
+	for host, b := range vaultBreakers.m {
+		states[host] = b.State(now)
+	}
+	return states
+}
+
+// Makes the request, retrying transient failures with backoff, and tracking
+// failures in the circuit breaker of the vault server.
+func (r VaultRequest) doWithRetry() (*goreq.Response, error) {
+	return r.retry(r.do)
+}
+
+func (r VaultRequest) retry(do func() (*goreq.Response, error)) (*goreq.Response, error) {
+	breaker := vaultBreaker(r.Request.Uri)
+	if !breaker.Allow(time.Now()) {
+		return nil, errVaultCircuitOpen
+	}
+	resp, err := do()
+	for retry := 0; retry < config.Vault.Retries && retryableVaultResponse(r.Request.Method, resp, err); retry++ {
+		backoff := retryBackoff(config.Vault.RetryBackoff, retry)
+		if r.Context != nil {
+			// don't retry past the deadline of the token request
+			if deadline, ok := r.Context.Deadline(); (ok && time.Until(deadline) < backoff) || r.Context.Err() != nil {
+				break
+			}
+		}
+		if err == nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		log.Printf("Vault request to %s failed, retrying in %v. Error: %v", r.Request.Uri, backoff, vaultRequestError(resp, err))
+		time.Sleep(backoff)
+		resp, err = do()
+	}
+	// a cancelled token request says nothing about the health of vault
+	if r.Context == nil || r.Context.Err() == nil {
+		breaker.Record(!failedVaultResponse(resp, err), time.Now())
+	} else {
+		breaker.Abandon()
+	}
+	return resp, err
+}
+
+func vaultRequestError(resp *goreq.Response, err error) interface{} {
+	if err != nil {
+		return err
+	}
+	return resp.Status
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/franela/goreq"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	for retry := 0; retry < 10; retry++ {
+		max := 100 * time.Millisecond << uint(retry)
+		if max > maxRetryBackoff {
+			max = maxRetryBackoff
+		}
+		if backoff := retryBackoff(100*time.Millisecond, retry); backoff <= 0 || backoff > max {
+			t.Errorf("Expected backoff of retry %d to be within (0, %v], got %v.", retry, max, backoff)
+		}
+	}
+	if backoff := retryBackoff(0, 3); backoff != 0 {
+		t.Errorf("Expected no backoff without a base, got %v.", backoff)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := &circuitBreaker{threshold: 2, timeout: time.Minute}
+
+	b.Record(false, now)
+	if !b.Allow(now) || b.State(now) != circuitClosed {
+		t.Fatal("Expected breaker to stay closed below the threshold.")
+	}
+	b.Record(false, now)
+	if b.Allow(now) || b.State(now) != circuitOpen {
+		t.Fatal("Expected breaker to open at the threshold.")
+	}
+
+	later := now.Add(2 * time.Minute)
+	if b.State(later) != circuitHalfOpen {
+		t.Fatal("Expected breaker to be half open after the timeout.")
+	}
+	if !b.Allow(later) {
+		t.Fatal("Expected a trial request after the timeout.")
+	}
+	if b.Allow(later) {
+		t.Fatal("Expected only a single trial request.")
+	}
+	b.Record(false, later)
+	if b.Allow(later.Add(time.Second)) {
+		t.Fatal("Expected a failed trial to open the breaker again.")
+	}
+
+	evenLater := later.Add(2 * time.Minute)
+	if !b.Allow(evenLater) {
+		t.Fatal("Expected a trial request after the timeout.")
+	}
+	b.Record(true, evenLater)
+	if !b.Allow(evenLater) || b.State(evenLater) != circuitClosed {
+		t.Fatal("Expected a successful trial to close the breaker.")
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	now := time.Now()
+	b := &circuitBreaker{threshold: 0, timeout: time.Minute}
+	for i := 0; i < 10; i++ {
+		b.Record(false, now)
+	}
+	if !b.Allow(now) {
+		t.Fatal("Expected a disabled breaker to allow every request.")
+	}
+}
+
+func TestCancelledRequestNotRetried(t *testing.T) {
+	retries, threshold := config.Vault.Retries, config.Vault.BreakerThreshold
+	defer func() { config.Vault.Retries, config.Vault.BreakerThreshold = retries, threshold }()
+	config.Vault.Retries, config.Vault.BreakerThreshold = 3, 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	uri := "http://cancelled.vault.example.com:8200/v1/auth/token/create"
+	start := time.Now()
+	_, err := VaultRequest{Request: goreq.Request{Uri: uri}, Context: ctx}.Do()
+	if err != context.Canceled {
+		t.Fatalf("Expected the request to be cancelled, got %v.", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("Expected the cancelled request not to be retried.")
+	}
+	if state := vaultBreaker(uri).State(time.Now()); state != circuitClosed {
+		t.Fatalf("Expected a cancelled request not to trip the breaker, got %s.", state)
+	}
+}
+
+func TestDoWithRetry(t *testing.T) {
+	retries, backoff, threshold := config.Vault.Retries, config.Vault.RetryBackoff, config.Vault.BreakerThreshold
+	defer func() {
+		config.Vault.Retries, config.Vault.RetryBackoff, config.Vault.BreakerThreshold = retries, backoff, threshold
+	}()
+	config.Vault.Retries, config.Vault.RetryBackoff, config.Vault.BreakerThreshold = 2, 0, 0
+
+	refused := &goreq.Error{Err: &url.Error{Op: "Post", URL: "http://vault:8200", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}}
+	reset := &goreq.Error{Err: &url.Error{Op: "Post", URL: "http://vault:8200", Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}}}
+	serverError := &goreq.Response{Response: &http.Response{StatusCode: 500, Status: "500 Internal Server Error"}}
+
+	for _, test := range []struct {
+		method string
+		resp   *goreq.Response
+		err    error
+		sent   int
+	}{
+		{"GET", nil, reset, 3},
+		{"GET", nil, refused, 3},
+		{"POST", nil, refused, 3},
+		{"POST", nil, reset, 1},
+		{"POST", serverError, nil, 1},
+		{"PUT", nil, reset, 1},
+	} {
+		sent := 0
+		r := VaultRequest{Request: goreq.Request{Uri: "http://retry.vault.example.com:8200/v1/auth/token/create", Method: test.method}}
+		resp, err := r.retry(func() (*goreq.Response, error) {
+			sent++
+			return test.resp, test.err
+		})
+		if sent != test.sent {
+			t.Errorf("Expected a %s failing with %v to be sent %d times, was sent %d times.", test.method, vaultRequestError(test.resp, test.err), test.sent, sent)
+		}
+		if resp != test.resp || err != test.err {
+			t.Errorf("Expected the last response to be returned, got %v, %v.", resp, err)
+		}
+	}
+
+	sent := 0
+	r := VaultRequest{Request: goreq.Request{Uri: "http://retry.vault.example.com:8200/v1/secret/web", Method: "GET"}}
+	r.retry(func() (*goreq.Response, error) {
+		sent++
+		if sent < 2 {
+			return nil, refused
+		}
+		return &goreq.Response{Response: &http.Response{StatusCode: 200}}, nil
+	})
+	if sent != 2 {
+		t.Errorf("Expected retries to stop after the request succeeded, was sent %d times.", sent)
+	}
+}
+
+func TestCancelledTrialRequest(t *testing.T) {
+	retries, threshold, timeout := config.Vault.Retries, config.Vault.BreakerThreshold, config.Vault.BreakerTimeout
+	defer func() {
+		config.Vault.Retries, config.Vault.BreakerThreshold, config.Vault.BreakerTimeout = retries, threshold, timeout
+	}()
+	config.Vault.Retries, config.Vault.BreakerThreshold, config.Vault.BreakerTimeout = 0, 1, 10*time.Millisecond
+
+	uri := "http://trial.vault.example.com:8200/v1/auth/token/create"
+	refused := &goreq.Error{Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	VaultRequest{Request: goreq.Request{Uri: uri, Method: "POST"}}.retry(func() (*goreq.Response, error) {
+		return nil, refused
+	})
+	if state := vaultBreaker(uri).State(time.Now()); state != circuitOpen {
+		t.Fatalf("Expected the breaker to open, got %s.", state)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// the trial request is cancelled along with its token request
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := VaultRequest{Request: goreq.Request{Uri: uri, Method: "POST"}, Context: ctx}.retry(func() (*goreq.Response, error) {
+		cancel()
+		return nil, context.Canceled
+	})
+	if err != context.Canceled {
+		t.Fatalf("Expected the trial request to be cancelled, got %v.", err)
+	}
+
+	sent := false
+	_, err = VaultRequest{Request: goreq.Request{Uri: uri, Method: "POST"}}.retry(func() (*goreq.Response, error) {
+		sent = true
+		return &goreq.Response{Response: &http.Response{StatusCode: 200}}, nil
+	})
+	if err != nil || !sent {
+		t.Fatalf("Expected the next request to be let through as the trial, got %v.", err)
+	}
+	if state := vaultBreaker(uri).State(time.Now()); state != circuitClosed {
+		t.Errorf("Expected the successful trial to close the breaker, got %s.", state)
+	}
+}
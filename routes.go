@@ -17,6 +17,7 @@ func Status(c *gin.Context) {
 		Started        time.Time   `json:"started"`
 		Ok             bool        `json:"ok"`
 		Version        string      `json:"version"`
+		AdminAuth      bool        `json:"-"`
 	}
 	opts.Stats = state.Stats
 	opts.Uptime = time.Now().Sub(state.Started).String()
@@ -24,6 +25,7 @@ func Status(c *gin.Context) {
 	opts.Started = state.Started
 	opts.Ok = true
 	opts.Version = gitNearestTag
+	opts.AdminAuth = adminEnabled()
 	switch state.Status {
 	case StatusSealed:
 		opts.StatusSealed = "block"
@@ -42,23 +44,7 @@ func Status(c *gin.Context) {
 }
 
 func Unseal(c *gin.Context) {
-	var request struct {
-		Type string `json:"type"`
-
-		AppId           string `json:"app_id"`
-		UserIdMethod    string `json:"user_id_method"`
-		UserIdInterface string `json:"user_id_interface"`
-		UserIdPath      string `json:"user_id_path"`
-		UserIdHash      string `json:"user_id_hash"`
-		UserIdSalt      string `json:"user_id_salt"`
-
-		Token string `json:"token"`
-
-		Username string `json:"username"`
-		Password string `json:"password"`
-
-		CubbyPath string `json:"cubby_path"`
-	}
+	var request unsealRequest
 	switch c.Request.Header.Get("Content-Type") {
 	case "application/x-www-form-urlencoded", "multipart/form-data":
 		c.Request.ParseForm()
@@ -72,6 +58,9 @@ func Unseal(c *gin.Context) {
 				request.UserIdInterface = c.Request.FormValue("app-id_userid_data")
 			case "file":
 				request.UserIdPath = c.Request.FormValue("app-id_userid_data")
+			case "instance-id":
+				request.UserIdCloud = c.Request.FormValue("app-id_userid_data")
+			case "dmi", "hostname":
 			default:
 				c.JSON(400, struct {
 					Status string `json:"status"`
@@ -81,9 +70,14 @@ func Unseal(c *gin.Context) {
 			}
 			request.UserIdHash = c.Request.FormValue("app-id_userid_hash")
 			request.UserIdSalt = c.Request.FormValue("app-id_userid_salt")
+			request.UserIdHmacKey = c.Request.FormValue("app-id_userid_hmac_key")
 		case "userpass":
 			request.Username = c.Request.FormValue("userpass_username")
 			request.Password = c.Request.FormValue("userpass_password")
+		case "ldap", "okta":
+			request.Username = c.Request.FormValue(request.Type + "_username")
+			request.Password = c.Request.FormValue(request.Type + "_password")
+			request.MountPath = c.Request.FormValue(request.Type + "_mount_path")
 		case "github":
 			request.Token = c.Request.FormValue("github_token")
 		case "token":
@@ -113,45 +107,13 @@ func Unseal(c *gin.Context) {
 		}
 	}
 
-	var unsealer Unsealer
-	switch request.Type {
-	case "app-id":
-		unsealer = AppIdUnsealer{
-			AppId:           request.AppId,
-			UserIdMethod:    request.UserIdMethod,
-			UserIdInterface: request.UserIdInterface,
-			UserIdPath:      request.UserIdPath,
-			UserIdHash:      request.UserIdHash,
-			UserIdSalt:      request.UserIdSalt,
-		}
-	case "userpass":
-		unsealer = UserpassUnsealer{
-			Username: request.Username,
-			Password: request.Password,
-		}
-	case "github":
-		unsealer = GithubUnsealer{
-			PersonalToken: request.Token,
-		}
-	case "token":
-		unsealer = TokenUnsealer{
-			AuthToken: request.Token,
-		}
-	case "cubby":
-		unsealer = CubbyUnsealer{
-			TempToken: request.Token,
-			Path:      request.CubbyPath,
-		}
-	case "wrapped-token":
-		unsealer = WrappedTokenUnsealer{
-			TempToken: request.Token,
-		}
-	default:
+	unsealer, err := request.unsealer(nil)
+	if err != nil {
 		c.JSON(400, struct {
 			Status string `json:"status"`
 			Ok     bool   `json:"ok"`
 			Error  string `json:"error"`
-		}{string(state.Status), false, errUnknownAuthMethod.Error()})
+		}{string(state.Status), false, err.Error()})
 		return
 	}
 
@@ -195,23 +157,56 @@ func ReloadPolicies(c *gin.Context) {
 			Status string `json:"status"`
 			Ok     bool   `json:"ok"`
 			Error  string `json:"error"`
-		}{string(state.Status), false, "Gatekeeper is sealed."})
+		}{string(status), false, "Gatekeeper is sealed."})
 		return
 	}
 
-	state.Lock()
-	if err := activePolicies.Load(token); err == nil {
-		state.Unlock()
+	store := requestPolicies(c)
+	if err := store.Load(token); err == nil {
 		c.JSON(200, struct {
 			Status string `json:"status"`
 			Ok     bool   `json:"ok"`
-		}{string(state.Status), true})
+		}{string(status), true})
 	} else {
-		state.Unlock()
+		hooks.NotifyPolicyReloadFailed(policySourceName(store.location()), err)
 		c.JSON(500, struct {
 			Status string `json:"status"`
 			Ok     bool   `json:"ok"`
 			Error  string `json:"error"`
-		}{string(state.Status), false, err.Error()})
+		}{string(status), false, err.Error()})
 	}
 }
+
+func ListPolicies(c *gin.Context) {
+	state.RLock()
+	status := state.Status
+	state.RUnlock()
+	c.JSON(200, struct {
+		Status   string   `json:"status"`
+		Ok       bool     `json:"ok"`
+		Policies policies `json:"policies"`
+	}{string(status), true, requestPolicies(c).Get()})
+}
+
+func InspectPolicy(c *gin.Context) {
+	taskName := strings.TrimPrefix(c.Param("key"), "/")
+	state.RLock()
+	key, pol, err := requestPolicies(c).Get().Required(taskName, c.Query("framework"))
+	resp := struct {
+		Status   string        `json:"status"`
+		Ok       bool          `json:"ok"`
+		Error    string        `json:"error,omitempty"`
+		TaskName string        `json:"task_name"`
+		Matched  string        `json:"matched"`
+		Policy   *policy       `json:"policy"`
+		Token    *tokenOptions `json:"token,omitempty"`
+	}{Status: string(state.Status), Ok: true, TaskName: taskName, Matched: key, Policy: pol}
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		opts := pol.tokenOptions()
+		resp.Token = &opts
+	}
+	c.JSON(200, resp)
+	state.RUnlock()
+}
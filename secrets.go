@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/franela/goreq"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errNoWrappedResponse = errors.New("Request for wrapped secrets did not return wrapped response")
+
+type vaultSecret struct {
+	LeaseId       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+}
+
+func readSecret(ctx context.Context, backend *vaultBackend, token string, namespace string, secretPath string) (vaultSecret, error) {
+	var secret vaultSecret
+	r, err := VaultRequest{
+		Request: goreq.Request{
+			Uri:             backend.path(path.Join("/v1", secretPath), ""),
+			MaxRedirects:    10,
+			RedirectHeaders: true,
+		}.WithHeader("X-Vault-Token", token),
+		Namespace: namespace,
+		Context:   ctx,
+	}.Do()
+	if err != nil {
+		return secret, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != 200 {
+		var e vaultError
+		e.Code = r.StatusCode
+		if err := r.Body.FromJsonTo(&e); err != nil {
+			e.Errors = []string{"communication error."}
+		}
+		return secret, e
+	}
+
+	err = r.Body.FromJsonTo(&secret)
+	return secret, err
+}
+
+// Wraps arbitrary data in a single use response wrapping token.
+func wrapData(ctx context.Context, backend *vaultBackend, token string, namespace string, data interface{}, wrapTTL time.Duration) (vaultWrapInfo, error) {
+	r, err := VaultRequest{
+		Request: goreq.Request{
+			Uri:             backend.path("/v1/sys/wrapping/wrap", ""),
+			Method:          "POST",
+			Body:            data,
+			MaxRedirects:    10,
+			RedirectHeaders: true,
+		}.WithHeader("X-Vault-Token", token).WithHeader("X-Vault-Wrap-TTL", strconv.Itoa(int(wrapTTL.Seconds()))),
+		Namespace: namespace,
+		Context:   ctx,
+	}.Do()
+	if err != nil {
+		return vaultWrapInfo{}, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != 200 {
+		var e vaultError
+		e.Code = r.StatusCode
+		if err := r.Body.FromJsonTo(&e); err != nil {
+			e.Errors = []string{"communication error."}
+		}
+		return vaultWrapInfo{}, e
+	}
+
+	t := &vaultTokenResp{}
+	if err := r.Body.FromJsonTo(t); err != nil {
+		return vaultWrapInfo{}, err
+	}
+	if t.WrapInfo.Token == "" {
+		return vaultWrapInfo{}, errNoWrappedResponse
+	}
+	return t.WrapInfo, nil
+}
+
+// Revokes the lease of a dynamic secret. It isn't bound to the token request,
+// so that secrets read for a cancelled request are revoked as well.
+func revokeLease(backend *vaultBackend, token string, namespace string, leaseId string) error {
+	r, err := VaultRequest{
+		Request: goreq.Request{
+			Uri:             backend.path("/v1/sys/leases/revoke", ""),
+			Method:          "PUT",
+			Body:            map[string]string{"lease_id": leaseId},
+			ContentType:     "application/json",
+			MaxRedirects:    10,
+			RedirectHeaders: true,
+		}.WithHeader("X-Vault-Token", token),
+		Namespace: namespace,
+	}.Do()
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	switch r.StatusCode {
+	case 200, 204:
+		return nil
+	default:
+		var e vaultError
+		e.Code = r.StatusCode
+		if err := r.Body.FromJsonTo(&e); err != nil {
+			e.Errors = []string{"communication error."}
+		}
+		return e
+	}
+}
+
+// createWrappedSecrets reads each of the policy's secret paths with gatekeeper's
+// token and wraps them together, keyed by path, instead of creating a token for
+// the task. The leases of the secrets belong to gatekeeper's token, and are
+// revoked if the secrets can't all be read and wrapped.
+func createWrappedSecrets(ctx context.Context, token string, p *policy) (vaultWrapInfo, error) {
+	var wrap vaultWrapInfo
+	_, err := p.withVault(token, func(backend *vaultBackend, token string, namespace string) (string, error) {
+		secrets := make(map[string]vaultSecret, len(p.SecretPaths))
+		revoke := func() {
+			for secretPath, secret := range secrets {
+				if secret.LeaseId == "" {
+					continue
+				}
+				if err := revokeLease(backend, token, namespace, secret.LeaseId); err != nil {
+					log.Printf("Failed to revoke the lease of secret '%s' (Lease Id: %s). Reason: %v", secretPath, secret.LeaseId, err)
+				}
+			}
+		}
+		for _, secretPath := range p.SecretPaths {
+			secretPath = strings.Trim(secretPath, "/")
+			secret, err := readSecret(ctx, backend, token, namespace, secretPath)
+			if err != nil {
+				revoke()
+				return "", err
+			}
+			secrets[secretPath] = secret
+		}
+		var err error
+		if wrap, err = wrapData(ctx, backend, token, namespace, secrets, 10*time.Minute); err != nil {
+			revoke()
+		}
+		return wrap.Token, err
+	})
+	return wrap, err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSecretsVault serves dynamic secrets with a lease for each read, failing
+// the reads and wraps it is told to, and records the leases that are revoked.
+type fakeSecretsVault struct {
+	sync.Mutex
+	token    string
+	failRead map[string]int // secret path to the status code of the failed read
+	failWrap bool
+	leases   int
+	wrapped  map[string]json.RawMessage
+	revoked  []string
+}
+
+func (f *fakeSecretsVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if r.Header.Get("X-Vault-Token") != f.token {
+		w.WriteHeader(403)
+		w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+	switch {
+	case r.URL.Path == "/v1/auth/token/lookup-self":
+		w.Write([]byte(`{"data":{"ttl":0,"creation_ttl":0}}`))
+	case r.URL.Path == "/v1/sys/leases/revoke":
+		var body struct {
+			LeaseId string `json:"lease_id"`
+		}
+		if r.Method != "PUT" || json.NewDecoder(r.Body).Decode(&body) != nil {
+			w.WriteHeader(400)
+			return
+		}
+		f.revoked = append(f.revoked, body.LeaseId)
+		w.WriteHeader(204)
+	case r.URL.Path == "/v1/sys/wrapping/wrap":
+		if f.failWrap {
+			w.WriteHeader(500)
+			w.Write([]byte(`{"errors":["internal error"]}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&f.wrapped)
+		w.Write([]byte(`{"wrap_info":{"token":"wrapping-token","ttl":600}}`))
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/v1/"):
+		secretPath := strings.TrimPrefix(r.URL.Path, "/v1/")
+		if code, ok := f.failRead[secretPath]; ok {
+			// a rejected token is only rejected until gatekeeper logs in again
+			if code == 403 {
+				delete(f.failRead, secretPath)
+			}
+			w.WriteHeader(code)
+			w.Write([]byte(`{"errors":["failed to read the secret"]}`))
+			return
+		}
+		f.leases++
+		json.NewEncoder(w).Encode(vaultSecret{LeaseId: fmt.Sprintf("%s/lease-%d", secretPath, f.leases), LeaseDuration: 3600, Data: map[string]interface{}{"username": "web"}})
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func (f *fakeSecretsVault) revokedLeases() []string {
+	f.Lock()
+	defer f.Unlock()
+	revoked := append([]string(nil), f.revoked...)
+	sort.Strings(revoked)
+	return revoked
+}
+
+func TestCreateWrappedSecrets(t *testing.T) {
+	vault := &fakeSecretsVault{token: "gatekeeper-token"}
+	ts := httptest.NewServer(vault)
+	defer ts.Close()
+	defer func(backends map[string]*vaultBackend) { vaultBackends = backends }(vaultBackends)
+	vaultBackends = map[string]*vaultBackend{"secrets": {Name: "secrets", Address: ts.URL, Auth: unsealRequest{Type: "token", Token: "gatekeeper-token"}}}
+	defer vaultBackends["secrets"].reset()
+
+	p := &policy{Vault: "secrets", SecretPaths: []string{"/database/creds/web", "aws/creds/web/"}}
+	wrap, err := createWrappedSecrets(context.Background(), "", p)
+	if err != nil || wrap.Token != "wrapping-token" {
+		t.Fatalf("Expected the secrets to be wrapped, got %+v, %v.", wrap, err)
+	}
+	if _, ok := vault.wrapped["database/creds/web"]; !ok || len(vault.wrapped) != 2 {
+		t.Errorf("Expected the secrets to be wrapped keyed by path, got %v.", vault.wrapped)
+	}
+	if revoked := vault.revokedLeases(); len(revoked) != 0 {
+		t.Errorf("Expected no leases to be revoked, got %v.", revoked)
+	}
+}
+
+func TestCreateWrappedSecretsRevokesLeases(t *testing.T) {
+	defer func(backends map[string]*vaultBackend) { vaultBackends = backends }(vaultBackends)
+
+	for _, test := range []struct {
+		name     string
+		vault    *fakeSecretsVault
+		fails    bool
+		expected []string
+	}{
+		// the second secret can't be read
+		{"read", &fakeSecretsVault{failRead: map[string]int{"aws/creds/web": 500}}, true, []string{"database/creds/web/lease-1"}},
+		// the secrets can't be wrapped
+		{"wrap", &fakeSecretsVault{failWrap: true}, true, []string{"aws/creds/web/lease-2", "database/creds/web/lease-1"}},
+		// gatekeeper's token for the backend is rejected once, and the secrets are read again after logging in again
+		{"forbidden", &fakeSecretsVault{failRead: map[string]int{"aws/creds/web": 403}}, false, []string{"database/creds/web/lease-1"}},
+	} {
+		test.vault.token = "gatekeeper-token"
+		ts := httptest.NewServer(test.vault)
+		backend := &vaultBackend{Name: "secrets", Address: ts.URL, Auth: unsealRequest{Type: "token", Token: "gatekeeper-token"}}
+		vaultBackends = map[string]*vaultBackend{"secrets": backend}
+
+		p := &policy{Vault: "secrets", SecretPaths: []string{"database/creds/web", "aws/creds/web"}}
+		_, err := createWrappedSecrets(context.Background(), "", p)
+		if (err != nil) != test.fails {
+			t.Errorf("%s: Expected the secrets to fail: %v, got %v.", test.name, test.fails, err)
+		}
+		revoked := test.vault.revokedLeases()
+		if strings.Join(revoked, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("%s: Expected the leases %v to be revoked, got %v.", test.name, test.expected, revoked)
+		}
+		backend.reset()
+		ts.Close()
+	}
+}
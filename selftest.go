@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeper"
+	"github.com/franela/goreq"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var errSelftestFailed = errors.New("The self test failed.")
+var errSelftestNoTaskName = errors.New("No task name given. Set -task-name to the name of a task one of the policies matches.")
+var errNoUnsealMethod = errors.New("No unseal method is configured.")
+
+// A selftestStage is a step of the path a token request takes. It returns
+// what it checked, or why it failed.
+type selftestStage struct {
+	Name string
+	Run  func(ctx context.Context) (string, error)
+}
+
+// runSelftest implements the selftest subcommand, which takes the same
+// configuration as gatekeeper and exercises the path of a token request end
+// to end, without serving requests or creating a token, so that a
+// misconfiguration is caught before a deployment takes traffic.
+func runSelftest(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s selftest -task-name <name> [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	taskName := fs.String("task-name", "", "The name of the synthetic task the self test requests a token for, for example 'web.production'.")
+	framework := fs.String("framework", "marathon", "The name of the framework that launches the synthetic task, which framework specific policies are matched by.")
+	agent := fs.String("agent", "", "The mesos agent the synthetic task runs on, by id or hostname. The token request is signed with its secret from AGENT_SECRETS, if it has one.")
+	remoteAddr := fs.String("remote-addr", "127.0.0.1", "The address the synthetic task requests its token from, which allowed_cidrs are checked against.")
+	mesosStub := fs.Bool("mesos-stub", false, "Verify the synthetic task against a stub of the mesos master, instead of only checking that MESOS_MASTER can be queried.")
+	fs.Parse(args)
+	if *taskName == "" {
+		fs.Usage()
+		return errSelftestNoTaskName
+	}
+	if err := configureSelftest(); err != nil {
+		return err
+	}
+
+	var token string
+	task := synthesizeTask(*taskName, *agent, time.Now())
+	passed := runSelftestStages(os.Stdout, []selftestStage{
+		{"unseal", func(ctx context.Context) (string, error) {
+			var name string
+			var err error
+			token, name, err = selftestUnseal()
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Logged in to vault with method '%s'.", name), nil
+		}},
+		{"policies", func(ctx context.Context) (string, error) {
+			return selftestPolicies(token)
+		}},
+		{"mesos", func(ctx context.Context) (string, error) {
+			if *mesosStub {
+				return selftestStubbedTask(ctx, task, *framework)
+			}
+			return selftestMesosMaster(ctx, task)
+		}},
+		{"token", func(ctx context.Context) (string, error) {
+			return selftestToken(ctx, token, task, *framework, *remoteAddr)
+		}},
+	})
+	if !passed {
+		return errSelftestFailed
+	}
+	return nil
+}
+
+// configureSelftest applies the configuration the way main does, up to the
+// point where gatekeeper would unseal.
+func configureSelftest() error {
+	if config.ConfigFile != "" {
+		if err := applyConfigFile(config.ConfigFile, nil); err != nil {
+			return err
+		}
+	}
+	defaultAuthMounts()
+	if err := configureVaultTransport(); err != nil {
+		return err
+	}
+	if err := vaultAddresses.Set(config.Vault.Server); err != nil {
+		return err
+	}
+	client, err := newMesosClient()
+	if err != nil {
+		return err
+	}
+	mesosClient = client
+	if config.RequestSigning == signingOptional || config.RequestSigning == signingRequired {
+		secrets, err := loadAgentSecrets(config.AgentSecrets)
+		if err != nil {
+			return err
+		}
+		agentSecrets = secrets
+	}
+	if _, err := newPolicySource(config.Vault.GkPolicies); err != nil {
+		return err
+	}
+	if config.Vault.Backends != "" {
+		if err := loadVaultBackends(config.Vault.Backends); err != nil {
+			return err
+		}
+	}
+	if config.Vault.Tenants != "" {
+		if err := loadTenants(config.Vault.Tenants); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSelftestStages runs the stages in order, and prints whether each passed.
+// The stages after a failed stage depend on it, and are skipped.
+func runSelftestStages(out io.Writer, stages []selftestStage) bool {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "STAGE\tRESULT\tDURATION\tDETAIL")
+	passed := true
+	for _, stage := range stages {
+		if !passed {
+			fmt.Fprintf(w, "%s\tSKIP\t\t\n", stage.Name)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), config.RequestTimeout)
+		start := time.Now()
+		detail, err := stage.Run(ctx)
+		cancel()
+		elapsed := time.Now().Sub(start).Round(time.Millisecond)
+		if err != nil {
+			passed = false
+			fmt.Fprintf(w, "%s\tFAIL\t%v\t%v\n", stage.Name, elapsed, err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\tPASS\t%v\t%s\n", stage.Name, elapsed, detail)
+	}
+	return passed
+}
+
+// selftestUnsealer picks the unsealer the way main does when it starts.
+func selftestUnsealer() (Unsealer, error) {
+	switch {
+	case config.UnsealChain != "":
+		return newUnsealerChain(config.UnsealChain)
+	case os.Getenv("VAULT_TOKEN") != "":
+		return TokenUnsealer{AuthToken: os.Getenv("VAULT_TOKEN")}, nil
+	case config.CubbyAuth.TempToken != "":
+		return config.CubbyAuth, nil
+	case config.WrappedTokenAuth.TempToken != "" || config.WrappedTokenAuth.TempTokenPath != "":
+		return config.WrappedTokenAuth, nil
+	case config.AppIdAuth.AppId != "":
+		return config.AppIdAuth, nil
+	case config.AppRoleAuth.RoleId != "":
+		return config.AppRoleAuth, nil
+	case config.KubernetesAuth.Role != "":
+		return config.KubernetesAuth, nil
+	}
+	return nil, errNoUnsealMethod
+}
+
+// selftestUnseal logs in to vault, and to the additional vault backends.
+func selftestUnseal() (string, string, error) {
+	unsealer, err := selftestUnsealer()
+	if err != nil {
+		return "", "", err
+	}
+	token, err := unsealer.Token()
+	if err != nil {
+		return "", "", err
+	}
+	for _, backend := range vaultBackends {
+		if _, err := backend.Token(); err != nil {
+			return "", "", fmt.Errorf("Failed to log in to vault backend '%s': %v", backend.Name, err)
+		}
+	}
+	return token, unsealer.Name(), nil
+}
+
+// selftestPolicies loads the policies of GATE_POLICIES and of the tenants.
+func selftestPolicies(token string) (string, error) {
+	if err := activePolicies.Load(token); err != nil {
+		return "", err
+	}
+	for _, t := range tenants {
+		if err := t.store.Load(token); err != nil {
+			return "", fmt.Errorf("Failed to load the policies of tenant '%s': %v", t.Name, err)
+		}
+	}
+	return fmt.Sprintf("Loaded %d policies from %s, and the policies of %d tenants.", len(activePolicies.Get()), policySourceName(config.Vault.GkPolicies), len(tenants)), nil
+}
+
+// synthesizeTask makes up a task of the given name that was just started on
+// the agent, with an id no real task has.
+func synthesizeTask(name string, agent string, now time.Time) mesosTask {
+	task := mesosTask{
+		Id:          fmt.Sprintf("%s.selftest-%d", name, now.UnixNano()),
+		Name:        name,
+		State:       "TASK_RUNNING",
+		SlaveId:     agent,
+		FrameworkId: selftestFrameworkId,
+	}
+	task.Statuses = append(task.Statuses, struct {
+		State     string  `json:"state"`
+		Timestamp float64 `json:"timestamp"`
+	}{"TASK_RUNNING", float64(now.UnixNano()) / float64(1000000000)})
+	return task
+}
+
+// selftestMesosMaster looks the synthetic task up on the mesos master. As
+// the master doesn't know it, this passes once the master could be queried
+// and the task was rejected.
+func selftestMesosMaster(ctx context.Context, task mesosTask) (string, error) {
+	_, err := getMesosTask(ctx, task.Id)
+	if err == nil {
+		return "", fmt.Errorf("The mesos master knows the synthetic task '%s'.", task.Id)
+	}
+	if err != errNoSuchTask {
+		return "", err
+	}
+	return fmt.Sprintf("Queried the mesos master at '%s', which rejected the synthetic task.", config.Mesos), nil
+}
+
+// selftestStubbedTask verifies the synthetic task against a stub of the mesos
+// master that runs it, through the same lookup as a token request.
+func selftestStubbedTask(ctx context.Context, task mesosTask, framework string) (string, error) {
+	var verified mesosTask
+	err := withMesosStub(task, framework, func() error {
+		var err error
+		verified, err = mesosAttestor{}.VerifyTask(attestationRequest{TaskId: task.Id, Context: ctx})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if verified.Name != task.Name {
+		return "", fmt.Errorf("The stub verified task '%s', expected '%s'.", verified.Name, task.Name)
+	}
+	return fmt.Sprintf("Verified the synthetic task '%s' against a stub of the mesos master.", task.Id), nil
+}
+
+// The id of the framework the stub of the mesos master runs the synthetic task
+// under.
+const selftestFrameworkId = "selftest"
+
+// withMesosStub points gatekeeper at a stub of the mesos master that runs just
+// the task while fn runs, and restores the mesos configuration afterwards.
+func withMesosStub(task mesosTask, framework string, fn func() error) error {
+	stub, address, err := serveMesosStub(task, framework)
+	if err != nil {
+		return err
+	}
+	defer stub.Close()
+	defer func(master, api string, tls bool, principal string, cache *mesosTaskCache, frameworks *mesosFrameworkCache) {
+		config.Mesos, config.MesosApi, config.MesosTls, config.MesosPrincipal = master, api, tls, principal
+		mesosTasks, mesosFrameworks = cache, frameworks
+	}(config.Mesos, config.MesosApi, config.MesosTls, config.MesosPrincipal, mesosTasks, mesosFrameworks)
+	config.Mesos, config.MesosApi, config.MesosTls, config.MesosPrincipal = "http://"+address, "state", false, ""
+	mesosTasks, mesosFrameworks = nil, newMesosFrameworkCache()
+	return fn()
+}
+
+// serveMesosStub serves the endpoints of a mesos master that runs just the
+// task, launched by the framework on its agent.
+func serveMesosStub(task mesosTask, framework string) (*http.Server, string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	s := mesosState{Pid: "master@" + l.Addr().String(), Leader: "master@" + l.Addr().String()}
+	s.Frameworks = append(s.Frameworks, struct {
+		Id     string      `json:"id"`
+		Name   string      `json:"name"`
+		Active bool        `json:"active"`
+		Tasks  []mesosTask `json:"tasks"`
+	}{task.FrameworkId, framework, true, []mesosTask{task}})
+	serve := func(v interface{}) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(v)
+		}
+	}
+	type entry struct {
+		Id       string `json:"id"`
+		Name     string `json:"name,omitempty"`
+		Hostname string `json:"hostname,omitempty"`
+	}
+	// the agent is named by the same id or hostname it signs with
+	agents := []entry{}
+	if task.SlaveId != "" {
+		agents = append(agents, entry{Id: task.SlaveId, Hostname: task.SlaveId})
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state.json", serve(s))
+	mux.HandleFunc("/master/frameworks", serve(map[string][]entry{"frameworks": {{Id: task.FrameworkId, Name: framework}}}))
+	mux.HandleFunc("/master/slaves", serve(map[string][]entry{"slaves": agents}))
+	server := &http.Server{Handler: mux}
+	go server.Serve(l)
+	return server, l.Addr().String(), nil
+}
+
+// selftestDryRun makes a dry run token request for the synthetic task, with
+// gatekeeper unsealed with the token, through the same checks as a token
+// request of a real task: the signature of its agent, the lookup of the task
+// on the mesos master, the match of its policy, its task life, the addresses
+// and agents the policy allows, its templates and entity alias, and its
+// max_instances. The mesos master has to be the stub that runs the task.
+func selftestDryRun(ctx context.Context, token string, task mesosTask, remoteAddr string) (tokenGrant, error) {
+	state.Lock()
+	defer func(status GkStatus, token string) {
+		state.Lock()
+		state.Status, state.Token = status, token
+		state.Unlock()
+	}(state.Status, state.Token)
+	state.Status, state.Token = StatusUnsealed, token
+	state.Unlock()
+	// the synthetic task only exists on the stub, whatever ATTESTORS says
+	defer func(list []Attestor) {
+		attestors = list
+	}(attestors)
+	attestors = []Attestor{mesosAttestor{}}
+
+	request := attestationRequest{TaskId: task.Id, RemoteAddr: remoteAddr, Context: ctx}
+	if secret, ok := agentSecrets[task.SlaveId]; ok {
+		timestamp := time.Now().Unix()
+		request.Signature = requestSignature{
+			Agent:     task.SlaveId,
+			Timestamp: strconv.FormatInt(timestamp, 10),
+			Signature: gatekeeper.SignTokenRequest(secret, task.SlaveId, timestamp, task.Id),
+		}
+	}
+	return requestToken(request, true)
+}
+
+// selftestToken makes a dry run token request for the synthetic task, and
+// checks that gatekeeper's token may create its credentials, without creating
+// them.
+func selftestToken(ctx context.Context, token string, task mesosTask, framework string, remoteAddr string) (string, error) {
+	var grant tokenGrant
+	var pol *policy
+	err := withMesosStub(task, framework, func() error {
+		var err error
+		if grant, err = selftestDryRun(ctx, token, task, remoteAddr); err != nil {
+			return err
+		}
+		_, pol = activePolicies.Get().Match(grant.PolicyKey)
+		pol, err = pol.withTask(ctx, task)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	_, err = pol.withVault(token, func(backend *vaultBackend, token string, namespace string) (string, error) {
+		for capPath, capability := range pol.requiredCapabilities() {
+			capabilities, err := vaultCapabilities(ctx, backend, token, namespace, capPath)
+			if err != nil {
+				return "", err
+			}
+			if !hasCapability(capabilities, capability) {
+				return "", fmt.Errorf("The token of gatekeeper lacks the '%s' capability on '%s', it has %v.", capability, capPath, capabilities)
+			}
+		}
+		return "", nil
+	})
+	if err != nil {
+		return "", err
+	}
+	matched := fmt.Sprintf("Policy '%s' matched", grant.PolicyKey)
+	if grant.PolicyKey == "" {
+		matched = "The default policy applies"
+	}
+	if len(grant.SecretPaths) > 0 {
+		return fmt.Sprintf("%s, the secrets %v can be read and wrapped.", matched, grant.SecretPaths), nil
+	}
+	return fmt.Sprintf("%s, a token with policies %v can be created.", matched, grant.Options.Policies), nil
+}
+
+// requiredCapabilities are the capabilities gatekeeper's token needs, by
+// path, to create the credentials of the policy.
+func (p *policy) requiredCapabilities() map[string]string {
+	if len(p.SecretPaths) > 0 {
+		required := map[string]string{"sys/wrapping/wrap": "update"}
+		for _, secretPath := range p.SecretPaths {
+			required[strings.Trim(secretPath, "/")] = "read"
+		}
+		return required
+	}
+	opts := p.tokenOptions()
+	if opts.Role != "" {
+		return map[string]string{path.Join("auth/token/create", opts.Role): "update"}
+	}
+	// creating a token without a parent takes sudo
+	return map[string]string{"auth/token/create": "sudo"}
+}
+
+// vaultCapabilities returns the capabilities of the token on the path.
+func vaultCapabilities(ctx context.Context, backend *vaultBackend, token string, namespace string, capPath string) ([]string, error) {
+	r, err := VaultRequest{
+		Request: goreq.Request{
+			Uri:             backend.path("/v1/sys/capabilities-self", ""),
+			Method:          "POST",
+			Body:            map[string][]string{"paths": {capPath}},
+			MaxRedirects:    10,
+			RedirectHeaders: true,
+		}.WithHeader("X-Vault-Token", token),
+		Namespace: namespace,
+		Context:   ctx,
+	}.Do()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != 200 {
+		var e vaultError
+		e.Code = r.StatusCode
+		if err := r.Body.FromJsonTo(&e); err != nil {
+			e.Errors = []string{"communication error."}
+		}
+		return nil, e
+	}
+	var resp struct {
+		Capabilities []string `json:"capabilities"`
+	}
+	if err := r.Body.FromJsonTo(&resp); err != nil {
+		return nil, err
+	}
+	return resp.Capabilities, nil
+}
+
+func hasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability || c == "root" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSelftestStages(t *testing.T) {
+	var ran []string
+	stage := func(name string, err error) selftestStage {
+		return selftestStage{name, func(ctx context.Context) (string, error) {
+			ran = append(ran, name)
+			return name + " checked", err
+		}}
+	}
+
+	var out bytes.Buffer
+	if !runSelftestStages(&out, []selftestStage{stage("unseal", nil), stage("policies", nil)}) {
+		t.Fatalf("Expected the self test to pass, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	ran = nil
+	passed := runSelftestStages(&out, []selftestStage{stage("unseal", nil), stage("policies", errors.New("no policies")), stage("token", nil)})
+	if passed || len(ran) != 2 {
+		t.Fatalf("Expected the self test to stop at the failed stage, ran %v.", ran)
+	}
+	for _, expected := range []string{"unseal    PASS", "policies  FAIL", "no policies", "token     SKIP"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected the report to contain '%s', got:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestSelftestStubbedTask(t *testing.T) {
+	defer func(mesos, api string, tls bool) {
+		config.Mesos, config.MesosApi, config.MesosTls = mesos, api, tls
+	}(config.Mesos, config.MesosApi, config.MesosTls)
+	config.Mesos, config.MesosApi, config.MesosTls = "zk://10.0.0.1:2181/mesos", "v1", true
+
+	task := synthesizeTask("web.production", "", time.Now())
+	if _, err := selftestStubbedTask(context.Background(), task, "marathon"); err != nil {
+		t.Fatalf("Expected the synthetic task to be verified against the stub, got %v.", err)
+	}
+	if config.Mesos != "zk://10.0.0.1:2181/mesos" || config.MesosApi != "v1" || !config.MesosTls {
+		t.Errorf("Expected the mesos configuration to be restored, got '%s', '%s', %v.", config.Mesos, config.MesosApi, config.MesosTls)
+	}
+}
+
+func TestSelftestDryRun(t *testing.T) {
+	defer func(signing string, secrets map[string][]byte, required bool) {
+		config.RequestSigning, agentSecrets, config.PolicyRequired = signing, secrets, required
+	}(config.RequestSigning, agentSecrets, config.PolicyRequired)
+	defer func(p policies) {
+		activePolicies.Set(p)
+	}(activePolicies.Get())
+	config.RequestSigning, agentSecrets, config.PolicyRequired = signingRequired, map[string][]byte{"agent-1": []byte("secret")}, true
+	activePolicies.Set(policies{
+		"marathon:web.production": &policy{Policies: []string{"web"}, AllowedCidrs: []string{"10.0.0.0/8"}, AllowedAgents: []string{"agent-1"}, MaxInstances: 2},
+	})
+
+	dryRun := func(task mesosTask, remoteAddr string) (tokenGrant, error) {
+		var grant tokenGrant
+		err := withMesosStub(task, "marathon", func() error {
+			var err error
+			grant, err = selftestDryRun(context.Background(), "token", task, remoteAddr)
+			return err
+		})
+		return grant, err
+	}
+
+	grant, err := dryRun(synthesizeTask("web.production", "agent-1", time.Now()), "10.1.2.3:0")
+	if err != nil {
+		t.Fatalf("Expected the dry run to pass, got %v.", err)
+	}
+	if grant.PolicyKey != "marathon:web.production" || grant.Token != "" {
+		t.Errorf("Expected the framework policy to match without a token, got %+v.", grant)
+	}
+	state.RLock()
+	status := state.Status
+	state.RUnlock()
+	if status == StatusUnsealed {
+		t.Error("Expected the status to be restored after the dry run.")
+	}
+
+	for _, test := range []struct {
+		task       mesosTask
+		remoteAddr string
+		err        error
+	}{
+		{synthesizeTask("web.production", "agent-1", time.Now()), "127.0.0.1:0", errSourceNotAllowed},
+		{synthesizeTask("web.production", "", time.Now()), "10.1.2.3:0", errUnsignedRequest},
+		{synthesizeTask("web.production", "agent-1", time.Now().Add(-time.Hour)), "10.1.2.3:0", errTaskNotFresh},
+		{synthesizeTask("db.production", "agent-1", time.Now()), "10.1.2.3:0", errNoPolicy},
+	} {
+		if _, err := dryRun(test.task, test.remoteAddr); err == nil || err.Error() != test.err.Error() {
+			t.Errorf("Expected the dry run for %s from %s to fail with '%v', got %v.", test.task.Name, test.remoteAddr, test.err, err)
+		}
+	}
+}
+
+func TestRequiredCapabilities(t *testing.T) {
+	defer func(role string) {
+		config.EntityAliasRole = role
+	}(config.EntityAliasRole)
+	config.EntityAliasRole = "gatekeeper"
+
+	for _, test := range []struct {
+		policy   *policy
+		required map[string]string
+	}{
+		{&policy{Policies: []string{"web"}}, map[string]string{"auth/token/create": "sudo"}},
+		{&policy{Policies: []string{"web"}, EntityAlias: "mesos-web"}, map[string]string{"auth/token/create/gatekeeper": "update"}},
+		{&policy{SecretPaths: []string{"/secret/web/"}}, map[string]string{"secret/web": "read", "sys/wrapping/wrap": "update"}},
+	} {
+		if required := test.policy.requiredCapabilities(); !reflect.DeepEqual(required, test.required) {
+			t.Errorf("Expected the capabilities %v, got %v.", test.required, required)
+		}
+	}
+
+	if !hasCapability([]string{"root"}, "sudo") || hasCapability([]string{"read", "update"}, "sudo") {
+		t.Error("Expected only root or the capability itself to count.")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A stateStore persists the state gatekeeper keeps in memory, so that it
+// survives restarts.
+type stateStore interface {
+	LoadUsedTaskIds() (map[string]time.Time, error)
+	SaveUsedTaskIds(ids map[string]time.Time) error
+	ExpireUsedTaskIds(now time.Time) error
+	LoadIssuedTokens(since time.Time) ([]issuedToken, error)
+	SaveIssuedToken(t issuedToken) error
+	DeleteIssuedTokens(accessors []string) error
+	Close() error
+}
+
+// nil when no state store is configured.
+var store stateStore
+
+// Wait for SIGTERM or SIGINT, then stop accepting requests on all the servers
+// and give the requests in flight up to DRAIN_TIMEOUT to finish before
+// persisting state. done is closed once gatekeeper can exit.
+func watchShutdown(servers []*http.Server, done chan<- struct{}) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	s := <-sig
+	log.Printf("Received %v, draining requests for up to %v...", s, config.DrainTimeout)
+	shutdown(servers)
+	close(done)
+}
+
+// shutdown stops the servers, waiting up to DRAIN_TIMEOUT for the requests in
+// flight, and only then persists the used task ids, so that the task ids of
+// the tokens provided while draining are persisted too.
+func shutdown(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DrainTimeout)
+	defer cancel()
+	grpcStopped := make(chan struct{})
+	if grpcServer != nil {
+		go func() {
+			grpcServer.GracefulStop()
+			close(grpcStopped)
+		}()
+	}
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("Not all requests finished within the drain timeout. Error: %v", err)
+			}
+		}(server)
+	}
+	wg.Wait()
+	if grpcServer != nil {
+		select {
+		case <-grpcStopped:
+		case <-ctx.Done():
+			log.Println("Not all gRPC requests finished within the drain timeout.")
+			grpcServer.Stop()
+		}
+	}
+
+	if store != nil {
+		if err := store.SaveUsedTaskIds(usedTaskIds.Snapshot()); err != nil {
+			log.Printf("Failed to persist used task ids: %v", err)
+		}
+		if err := store.Close(); err != nil {
+			log.Printf("Failed to close state store: %v", err)
+		}
+	}
+	shutdownTracing()
+	audit.Close()
+	hooks.Close()
+	log.Println("Shut down.")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore records the calls made to it, in order.
+type fakeStore struct {
+	sync.Mutex
+	calls []string
+	saved map[string]time.Time
+}
+
+func (s *fakeStore) record(call string) {
+	s.Lock()
+	defer s.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+func (s *fakeStore) LoadUsedTaskIds() (map[string]time.Time, error) {
+	s.record("load used task ids")
+	return nil, nil
+}
+
+func (s *fakeStore) SaveUsedTaskIds(ids map[string]time.Time) error {
+	s.record("save used task ids")
+	s.saved = ids
+	return nil
+}
+
+func (s *fakeStore) ExpireUsedTaskIds(now time.Time) error {
+	s.record("expire used task ids")
+	return nil
+}
+
+func (s *fakeStore) LoadIssuedTokens(since time.Time) ([]issuedToken, error) {
+	s.record("load issued tokens")
+	return nil, nil
+}
+
+func (s *fakeStore) SaveIssuedToken(t issuedToken) error {
+	s.record("save issued token")
+	return nil
+}
+
+func (s *fakeStore) DeleteIssuedTokens(accessors []string) error {
+	s.record("delete issued tokens")
+	return nil
+}
+
+func (s *fakeStore) Close() error {
+	s.record("close")
+	return nil
+}
+
+func TestShutdownDrainsBeforePersisting(t *testing.T) {
+	defer func(s stateStore, h *hookDispatcher, timeout time.Duration) {
+		store, hooks, config.DrainTimeout = s, h, timeout
+	}(store, hooks, config.DrainTimeout)
+	fake := &fakeStore{}
+	store, hooks, config.DrainTimeout = fake, nil, 5*time.Second
+
+	// the request in flight provides a token while gatekeeper is draining
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		usedTaskIds.Put("draining.1", time.Hour)
+		fake.record("request finished")
+	})}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	go http.Get("http://" + l.Addr().String() + "/token")
+	<-started
+
+	shutdown([]*http.Server{server})
+
+	expected := []string{"request finished", "save used task ids", "close"}
+	if len(fake.calls) != len(expected) {
+		t.Fatalf("Expected the calls %v, got %v.", expected, fake.calls)
+	}
+	for i := range expected {
+		if fake.calls[i] != expected[i] {
+			t.Fatalf("Expected the calls %v, got %v.", expected, fake.calls)
+		}
+	}
+	if _, ok := fake.saved["draining.1"]; !ok {
+		t.Errorf("Expected the task id used while draining to be persisted, got %v.", fake.saved)
+	}
+}
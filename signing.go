@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeper"
+	"io/ioutil"
+	"strconv"
+	"time"
+)
+
+var (
+	errUnsignedRequest   = errors.New("Token requests must be signed by the agent of the task.")
+	errUnknownAgent      = errors.New("The token request was signed by an unknown agent.")
+	errSignatureExpired  = errors.New("The signature of the token request has expired.")
+	errInvalidSignature  = errors.New("The signature of the token request is invalid.")
+	errWrongSigningAgent = errors.New("The task does not run on the agent that signed the token request.")
+)
+
+// How far the timestamp of a signed token request may be off from the time
+// gatekeeper receives it, to allow for clock skew between the agents and
+// gatekeeper.
+const maxSignatureSkew = 5 * time.Minute
+
+// The REQUEST_SIGNING modes.
+const (
+	signingOff      = "off"
+	signingOptional = "optional"
+	signingRequired = "required"
+)
+
+// A requestSignature signs a token request with the secret of the mesos agent
+// the task runs on, so that a container on another agent can't request the
+// token of a task by guessing its id.
+type requestSignature struct {
+	Agent     string
+	Timestamp string
+	Signature string
+}
+
+// The secrets of the agents, by agent id or hostname, read from AGENT_SECRETS.
+var agentSecrets map[string][]byte
+
+// loadAgentSecrets reads a json object of agent ids or hostnames to their
+// secrets.
+func loadAgentSecrets(path string) (map[string][]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal(b, &secrets); err != nil {
+		return nil, fmt.Errorf("Failed to parse the agent secrets: %v", err)
+	}
+	if len(secrets) == 0 {
+		return nil, errors.New("No agent secrets are configured.")
+	}
+	m := make(map[string][]byte, len(secrets))
+	for agent, secret := range secrets {
+		if secret == "" {
+			return nil, fmt.Errorf("The secret of agent '%s' is empty.", agent)
+		}
+		m[agent] = []byte(secret)
+	}
+	return m, nil
+}
+
+// verifySignature checks the signature of the token request, before the task
+// is looked up, and returns the agent that signed it. Unsigned requests are
+// only accepted when REQUEST_SIGNING is optional, and then no agent is
+// returned.
+func verifySignature(request attestationRequest, now time.Time) (string, error) {
+	sig := request.Signature
+	switch {
+	case config.RequestSigning == signingOff || config.RequestSigning == "":
+		return "", nil
+	case sig.Signature == "" && config.RequestSigning == signingOptional:
+		return "", nil
+	case sig.Signature == "":
+		return "", errUnsignedRequest
+	}
+	secret, ok := agentSecrets[sig.Agent]
+	if !ok {
+		return "", errUnknownAgent
+	}
+	timestamp, err := strconv.ParseInt(sig.Timestamp, 10, 64)
+	if err != nil {
+		return "", errInvalidSignature
+	}
+	if skew := now.Sub(time.Unix(timestamp, 0)); skew > maxSignatureSkew || skew < -maxSignatureSkew {
+		return "", errSignatureExpired
+	}
+	expected := gatekeeper.SignTokenRequest(secret, sig.Agent, timestamp, request.TaskId)
+	if !hmac.Equal([]byte(expected), []byte(sig.Signature)) {
+		return "", errInvalidSignature
+	}
+	return sig.Agent, nil
+}
+
+// checkSigningAgent checks that the task runs on the agent that signed the
+// request, named by its id or hostname.
+func checkSigningAgent(ctx context.Context, agent string, task mesosTask) error {
+	if agent == "" || agent == task.SlaveId {
+		return nil
+	}
+	if task.SlaveId == "" {
+		return errWrongSigningAgent
+	}
+	hostname, err := getMesosAgentHostname(ctx, task.SlaveId)
+	if err != nil {
+		return err
+	}
+	if hostname != agent {
+		return errWrongSigningAgent
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	mode, secrets := config.RequestSigning, agentSecrets
+	defer func() { config.RequestSigning, agentSecrets = mode, secrets }()
+	agentSecrets = map[string][]byte{"agent-1": []byte("secret")}
+
+	now := time.Now()
+	signed := func(agent string, secret string, at time.Time, taskId string) attestationRequest {
+		return attestationRequest{TaskId: "web.1234", Signature: requestSignature{
+			Agent:     agent,
+			Timestamp: strconv.FormatInt(at.Unix(), 10),
+			Signature: gatekeeper.SignTokenRequest([]byte(secret), agent, at.Unix(), taskId),
+		}}
+	}
+
+	config.RequestSigning = signingRequired
+	for _, test := range []struct {
+		request attestationRequest
+		err     error
+	}{
+		{signed("agent-1", "secret", now, "web.1234"), nil},
+		{signed("agent-1", "secret", now.Add(-time.Minute), "web.1234"), nil},
+		{attestationRequest{TaskId: "web.1234"}, errUnsignedRequest},
+		{signed("agent-2", "secret", now, "web.1234"), errUnknownAgent},
+		{signed("agent-1", "wrong", now, "web.1234"), errInvalidSignature},
+		// a signature can't be reused for another task
+		{signed("agent-1", "secret", now, "web.5678"), errInvalidSignature},
+		{signed("agent-1", "secret", now.Add(-time.Hour), "web.1234"), errSignatureExpired},
+	} {
+		agent, err := verifySignature(test.request, now)
+		if err != test.err {
+			t.Errorf("Expected %v for %+v, got %v.", test.err, test.request.Signature, err)
+		}
+		if err == nil && agent != "agent-1" {
+			t.Errorf("Expected the request to be signed by agent-1, got '%s'.", agent)
+		}
+	}
+
+	config.RequestSigning = signingOptional
+	if agent, err := verifySignature(attestationRequest{TaskId: "web.1234"}, now); err != nil || agent != "" {
+		t.Errorf("Expected unsigned requests to be accepted when signing is optional, got %v.", err)
+	}
+	if _, err := verifySignature(signed("agent-1", "wrong", now, "web.1234"), now); err != errInvalidSignature {
+		t.Errorf("Expected signed requests to be verified when signing is optional, got %v.", err)
+	}
+}
+
+func TestCheckSigningAgent(t *testing.T) {
+	ctx := context.Background()
+	if err := checkSigningAgent(ctx, "", mesosTask{SlaveId: "agent-2"}); err != nil {
+		t.Errorf("Expected unsigned requests to pass, got %v.", err)
+	}
+	if err := checkSigningAgent(ctx, "agent-1", mesosTask{SlaveId: "agent-1"}); err != nil {
+		t.Errorf("Expected the agent of the task to pass, got %v.", err)
+	}
+	if err := checkSigningAgent(ctx, "agent-1", mesosTask{}); err != errWrongSigningAgent {
+		t.Errorf("Expected a task without an agent to be rejected, got %v.", err)
+	}
+}
+
+func TestLoadAgentSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agents.json")
+
+	ioutil.WriteFile(path, []byte(`{"agent-1": "secret", "agent2.example.com": "other"}`), 0600)
+	secrets, err := loadAgentSecrets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secrets["agent2.example.com"]) != "other" {
+		t.Errorf("Expected the secret of agent2.example.com, got %v.", secrets)
+	}
+	for _, contents := range []string{`{}`, `{"agent-1": ""}`, `[]`} {
+		ioutil.WriteFile(path, []byte(contents), 0600)
+		if _, err := loadAgentSecrets(path); err == nil {
+			t.Errorf("Expected %s to be rejected.", contents)
+		}
+	}
+}
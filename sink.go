@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeper"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A tokenSink is a file the token is written to, like the file sinks of vault
+// agent. Uid and Gid are -1 to keep the owner of the file.
+type tokenSink struct {
+	Path string
+	Mode os.FileMode
+	Uid  int
+	Gid  int
+}
+
+// tokenSinks collects repeated -sink path[,mode=0640][,owner=user][,group=group]
+// flags.
+type tokenSinks []tokenSink
+
+func (s *tokenSinks) String() string {
+	paths := make([]string, len(*s))
+	for i, sink := range *s {
+		paths[i] = sink.Path
+	}
+	return strings.Join(paths, ",")
+}
+
+func (s *tokenSinks) Set(value string) error {
+	parts := strings.Split(value, ",")
+	sink := tokenSink{Path: parts[0], Mode: 0600, Uid: -1, Gid: -1}
+	if sink.Path == "" {
+		return fmt.Errorf("Invalid sink '%s', expected path[,mode=0640][,owner=user][,group=group].", value)
+	}
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return fmt.Errorf("Invalid sink option '%s', expected key=value.", opt)
+		}
+		var err error
+		switch kv[0] {
+		case "mode":
+			var mode uint64
+			if mode, err = strconv.ParseUint(kv[1], 8, 32); err == nil && mode > 0777 {
+				err = fmt.Errorf("Invalid sink mode '%s'.", kv[1])
+			}
+			sink.Mode = os.FileMode(mode)
+		case "owner":
+			sink.Uid, err = lookupSinkId(kv[1], func(name string) (string, error) {
+				u, err := user.Lookup(name)
+				if err != nil {
+					return "", err
+				}
+				return u.Uid, nil
+			})
+		case "group":
+			sink.Gid, err = lookupSinkId(kv[1], func(name string) (string, error) {
+				g, err := user.LookupGroup(name)
+				if err != nil {
+					return "", err
+				}
+				return g.Gid, nil
+			})
+		default:
+			err = fmt.Errorf("Unknown sink option '%s', expected mode, owner or group.", kv[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	*s = append(*s, sink)
+	return nil
+}
+
+// Owners and groups can be given by name or by numeric id.
+func lookupSinkId(value string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, nil
+	}
+	id, err := lookup(value)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// Write replaces the contents of the sink with the token. The token is written
+// to a temporary file next to the sink which is then renamed over it, so that
+// readers never see a partially written token.
+func (s tokenSink) Write(token string) error {
+	f, err := ioutil.TempFile(filepath.Dir(s.Path), "."+filepath.Base(s.Path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(token)
+	if err == nil {
+		err = f.Chmod(s.Mode)
+	}
+	if err == nil && (s.Uid >= 0 || s.Gid >= 0) {
+		err = f.Chown(s.Uid, s.Gid)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.Path)
+}
+
+func (s tokenSinks) Write(token string) error {
+	for _, sink := range s {
+		if err := sink.Write(token); err != nil {
+			return fmt.Errorf("Failed to write the token to sink '%s': %v", sink.Path, err)
+		}
+	}
+	return nil
+}
+
+// The longest wait between the attempts to renew a token that failed to renew.
+const maxRenewBackoff = time.Minute
+
+// renewSinks renews the token at half of its time to live and rewrites the
+// sinks after every renewal, until stop is closed or the token can no longer
+// be renewed. Failed renewals are retried with backoff for as long as the
+// token is valid. Until the first renewal the time to live of the token is
+// only known from version 2 responses, otherwise the renewal is retried as
+// often as the client retries token requests.
+func renewSinks(client *gatekeeper.Client, resp *gatekeeper.TokenResponse, token string, sinks tokenSinks, stop <-chan struct{}) {
+	var expires time.Time
+	if resp.LeaseDuration > 0 {
+		expires = time.Now().Add(time.Duration(resp.LeaseDuration) * time.Second)
+	}
+	failures := 0
+	for {
+		var wait time.Duration
+		ttl, renewable, err := client.RenewToken(resp, token)
+		if err != nil {
+			failures++
+			wait = renewBackoff(client.RetryBackoff, failures)
+			if (expires.IsZero() && failures > client.Retries) || (!expires.IsZero() && time.Now().Add(wait).After(expires)) {
+				log.Printf("Failed to renew the token, it will no longer be renewed: %v", err)
+				return
+			}
+			log.Printf("Failed to renew the token, retrying in %v: %v", wait, err)
+		} else {
+			failures = 0
+			expires = time.Now().Add(ttl)
+			if err := sinks.Write(token); err != nil {
+				log.Println(err)
+			}
+			if !renewable || ttl <= 0 {
+				log.Println("The token is not renewable, it will no longer be renewed.")
+				return
+			}
+			wait = ttl / 2
+		}
+		select {
+		case <-time.After(wait):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// The wait before the given attempt to renew the token, doubling from base.
+func renewBackoff(base time.Duration, failures int) time.Duration {
+	if base <= 0 {
+		base = gatekeeper.DefaultRetryBackoff
+	}
+	backoff := base << uint(failures-1)
+	if backoff > maxRenewBackoff || backoff <= 0 {
+		backoff = maxRenewBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeper"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenSinks(t *testing.T) {
+	var sinks tokenSinks
+	for _, value := range []string{"/run/secrets/token", "/run/secrets/agent-token,mode=0640,owner=0,group=0"} {
+		if err := sinks.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sinks[0] != (tokenSink{"/run/secrets/token", 0600, -1, -1}) {
+		t.Errorf("Unexpected sink %+v.", sinks[0])
+	}
+	if sinks[1] != (tokenSink{"/run/secrets/agent-token", 0640, 0, 0}) {
+		t.Errorf("Unexpected sink %+v.", sinks[1])
+	}
+	for _, value := range []string{"", ",mode=0600", "/token,mode=999", "/token,mode=1777", "/token,mode", "/token,perms=0600", "/token,owner=no-such-user-here"} {
+		if err := sinks.Set(value); err == nil {
+			t.Errorf("Expected '%s' to be invalid.", value)
+		}
+	}
+}
+
+func TestTokenSinkWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := tokenSink{Path: filepath.Join(dir, "token"), Mode: 0640, Uid: -1, Gid: -1}
+	for _, token := range []string{"first-token", "second"} {
+		if err := (tokenSinks{sink}).Write(token); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := ioutil.ReadFile(sink.Path); err != nil || string(b) != token {
+			t.Errorf("Expected the sink to contain '%s', got '%s' (%v).", token, b, err)
+		}
+	}
+	if info, err := os.Stat(sink.Path); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0640 {
+		t.Errorf("Expected the sink to have mode 0640, got %v.", info.Mode())
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected only the sink in the directory, got %d files.", len(files))
+	}
+
+	if err := (tokenSinks{{Path: filepath.Join(dir, "missing", "token"), Mode: 0600, Uid: -1, Gid: -1}}).Write("token"); err == nil {
+		t.Error("Expected writing to a missing directory to fail.")
+	}
+}
+
+func TestRenewSinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sink := tokenSink{Path: filepath.Join(dir, "token"), Mode: 0600, Uid: -1, Gid: -1}
+
+	// vault fails twice, then renews the token a last time
+	var renewals int32
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&renewals, 1) <= 2 {
+			w.WriteHeader(500)
+			w.Write([]byte(`{"errors":["internal error"]}`))
+			return
+		}
+		w.Write([]byte(`{"auth":{"client_token":"perm","lease_duration":60,"renewable":false}}`))
+	}))
+	defer vault.Close()
+
+	client, err := gatekeeper.NewClient("http://127.0.0.1:1", "http://127.0.0.1:1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.RetryBackoff = 10 * time.Millisecond
+	client.Retries = 0
+	stop := make(chan struct{})
+	defer close(stop)
+	done := make(chan struct{})
+	go func() {
+		renewSinks(client, &gatekeeper.TokenResponse{VaultAddr: vault.URL, LeaseDuration: 60}, "perm", tokenSinks{sink}, stop)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the renewal to stop once the token is no longer renewable.")
+	}
+	if n := atomic.LoadInt32(&renewals); n != 3 {
+		t.Errorf("Expected the failed renewals to be retried while the token is valid, got %d renewals.", n)
+	}
+	if b, err := ioutil.ReadFile(sink.Path); err != nil || string(b) != "perm" {
+		t.Errorf("Expected the renewed token in the sink, got '%s' (%v).", b, err)
+	}
+
+	// without a known ttl the renewal gives up after the retries of the client
+	atomic.StoreInt32(&renewals, 0)
+	renewSinks(client, &gatekeeper.TokenResponse{VaultAddr: vault.URL}, "perm", tokenSinks{sink}, stop)
+	if n := atomic.LoadInt32(&renewals); n != 1 {
+		t.Errorf("Expected a single renewal without retries, got %d.", n)
+	}
+}
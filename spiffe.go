@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+var errSpiffeNoTrustDomain = errors.New("The spiffe attestor requires SPIFFE_TRUST_DOMAIN to be set.")
+var errSpiffeTrustDomain = errors.New("The SVID is not of the trusted SPIFFE trust domain.")
+
+// The data available to the SPIFFE_TASK_NAME template. Params holds the
+// segments of the path as key/value pairs, so that for
+// spiffe://cluster/ns/prod/app/web {{.Params.app}} is "web".
+type spiffeId struct {
+	ID          string
+	TrustDomain string
+	Path        string
+	Params      map[string]string
+}
+
+func parseSpiffeId(u *url.URL) spiffeId {
+	id := spiffeId{ID: u.String(), TrustDomain: u.Host, Path: u.Path, Params: make(map[string]string)}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 0; i+1 < len(segments); i += 2 {
+		id.Params[segments[i]] = segments[i+1]
+	}
+	return id
+}
+
+// spiffeAttestor verifies tasks by the X.509 SVID they present as their client
+// certificate, instead of looking them up on the mesos master. The certificate
+// must be verified with TLS_CLIENT_CA, which should hold the SPIRE trust bundle.
+// Every SVID can be used to get one token.
+type spiffeAttestor struct {
+	trustDomain string
+	taskName    *template.Template
+}
+
+func newSpiffeAttestor(trustDomain string, taskName string) (spiffeAttestor, error) {
+	if trustDomain == "" {
+		return spiffeAttestor{}, errSpiffeNoTrustDomain
+	}
+	tmpl, err := template.New("spiffe task name").Option("missingkey=error").Parse(taskName)
+	if err != nil {
+		return spiffeAttestor{}, fmt.Errorf("Invalid SPIFFE task name template: %v", err)
+	}
+	return spiffeAttestor{strings.TrimPrefix(trustDomain, "spiffe://"), tmpl}, nil
+}
+
+func (spiffeAttestor) Name() string {
+	return "spiffe"
+}
+
+func (a spiffeAttestor) VerifyTask(request attestationRequest) (mesosTask, error) {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 {
+		return mesosTask{}, errNotAttested
+	}
+	svid := request.TLS.VerifiedChains[0][0]
+	var uri *url.URL
+	for _, u := range svid.URIs {
+		if u.Scheme == "spiffe" {
+			uri = u
+			break
+		}
+	}
+	if uri == nil {
+		return mesosTask{}, errNotAttested
+	}
+	if uri.Host != a.trustDomain {
+		return mesosTask{}, errSpiffeTrustDomain
+	}
+
+	id := parseSpiffeId(uri)
+	var name bytes.Buffer
+	if err := a.taskName.Execute(&name, id); err != nil {
+		return mesosTask{}, fmt.Errorf("Failed to derive the task name of '%s': %v", id.ID, err)
+	}
+	return mesosTask{
+		Id:       fmt.Sprintf("%s#%x", id.ID, svid.SerialNumber),
+		Name:     name.String(),
+		NotAfter: svid.NotAfter,
+	}, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+)
+
+var svidNotAfter = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func svidConnection(id string) *tls.ConnectionState {
+	u, _ := url.Parse(id)
+	cert := &x509.Certificate{SerialNumber: big.NewInt(42), URIs: []*url.URL{u}, NotAfter: svidNotAfter}
+	return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+}
+
+func TestSpiffeAttestor(t *testing.T) {
+	a, err := newSpiffeAttestor("cluster", "{{.Params.app}}.{{.Params.ns}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := a.VerifyTask(attestationRequest{TLS: svidConnection("spiffe://cluster/ns/prod/app/web")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Name != "web.prod" || task.Id != "spiffe://cluster/ns/prod/app/web#2a" {
+		t.Errorf("Expected task 'web.prod' identified by the SVID, got '%s' (%s).", task.Name, task.Id)
+	}
+	// the task id is used until the SVID expires, not just for the task life
+	now := svidNotAfter.Add(-24 * time.Hour)
+	if ttl := usedTaskIdTtl(task, 5*time.Minute, now); ttl != 24*time.Hour+time.Minute {
+		t.Errorf("Expected the SVID to stay used until it expires, got %v.", ttl)
+	}
+	if ttl := usedTaskIdTtl(mesosTask{Id: "web.1"}, 5*time.Minute, now); ttl != 6*time.Minute {
+		t.Errorf("Expected a mesos task to stay used for the task life, got %v.", ttl)
+	}
+
+	if _, err := a.VerifyTask(attestationRequest{TaskId: "web.1"}); err != errNotAttested {
+		t.Errorf("Expected a request without an SVID to be left to the next attestor, got %v.", err)
+	}
+	if _, err := a.VerifyTask(attestationRequest{TLS: svidConnection("spiffe://other/ns/prod/app/web")}); err != errSpiffeTrustDomain {
+		t.Errorf("Expected an SVID of another trust domain to be rejected, got %v.", err)
+	}
+	if _, err := a.VerifyTask(attestationRequest{TLS: svidConnection("spiffe://cluster/ns/prod")}); err == nil {
+		t.Error("Expected a SPIFFE ID without the params of the task name to be rejected.")
+	}
+
+	if _, err := newSpiffeAttestor("", "{{.ID}}"); err != errSpiffeNoTrustDomain {
+		t.Errorf("Expected the trust domain to be required, got %v.", err)
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errStandby             = errors.New("This gatekeeper is a standby, token requests go to the active gatekeeper.")
+	errFenced              = errors.New("The standby has been promoted, this gatekeeper no longer provides tokens.")
+	errPromoted            = errors.New("The standby has been promoted.")
+	errNotReplicated       = errors.New("The task id could not be replicated to the standby, so no token was provided.")
+	errReplicationAuth     = errors.New("Invalid or missing replication token.")
+	errReplicationDisabled = errors.New("Replication is disabled. Set REPLICATION_TOKEN to enable it.")
+	errNotStandby          = errors.New("This gatekeeper is not a standby.")
+	errNotSynced           = errors.New("The standby has not received the state of the active gatekeeper yet, and doesn't know which task ids were used. Promote it with force=true to do so anyway.")
+)
+
+// The header the active and standby gatekeepers authenticate to each other
+// with.
+const replicationTokenHeader = "X-Gatekeeper-Replication-Token"
+
+// How long a single request to the standby may take.
+const replicationTimeout = 5 * time.Second
+
+// The state of warm standby replication. The active gatekeeper replicates the
+// id of every task to the standby before it provides the task's token, and
+// its used task ids and policies with every heartbeat. The standby doesn't
+// provide tokens until it is promoted, after which the claims of the former
+// active gatekeeper are refused, so that it can't provide tokens anymore
+// either. Between them, every task id a token was provided for is known to
+// whichever gatekeeper provides tokens.
+var replication struct {
+	sync.Mutex
+	// serving as the standby, until promoted
+	standby bool
+	// whether the standby received a snapshot from the active gatekeeper
+	synced    bool
+	lastHeard time.Time
+	// the active gatekeeper learned that its standby was promoted
+	fenced bool
+}
+
+var replicationClient = &http.Client{}
+
+// A replicationSnapshot is the state the active gatekeeper sends the standby
+// with every heartbeat.
+type replicationSnapshot struct {
+	UsedTaskIds map[string]time.Time `json:"used_task_ids"`
+	Policies    policies             `json:"policies,omitempty"`
+	Tenants     map[string]policies  `json:"tenants,omitempty"`
+}
+
+// A replicationClaim is a task id the active gatekeeper is about to provide a
+// token for.
+type replicationClaim struct {
+	TaskId  string    `json:"task_id"`
+	Expires time.Time `json:"expires"`
+}
+
+// replicationRole returns why gatekeeper doesn't provide tokens because of
+// replication, if it doesn't.
+func replicationRole() error {
+	replication.Lock()
+	defer replication.Unlock()
+	switch {
+	case replication.standby:
+		return errStandby
+	case replication.fenced:
+		return errFenced
+	}
+	return nil
+}
+
+// claimTaskId replicates the used task id to the standby, if there is one,
+// before its token is provided. The token must not be provided if the claim
+// fails, as a promoted standby wouldn't know the task already got its token.
+func claimTaskId(ctx context.Context, taskId string, ttl time.Duration) error {
+	if config.StandbyAddress == "" {
+		return nil
+	}
+	if err := replicationRole(); err != nil {
+		return err
+	}
+	err := replicationPost(ctx, "/replication/claim", replicationClaim{taskId, time.Now().Add(ttl)})
+	switch err {
+	case nil:
+		return nil
+	case errPromoted:
+		fenceReplication()
+		return errFenced
+	default:
+		log.Printf("Failed to replicate used task id %s to the standby: %v", taskId, err)
+		return errNotReplicated
+	}
+}
+
+func fenceReplication() {
+	replication.Lock()
+	defer replication.Unlock()
+	if !replication.fenced {
+		log.Println("The standby has been promoted, no longer providing tokens.")
+		replication.fenced = true
+	}
+}
+
+func replicationPost(ctx context.Context, path string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, replicationTimeout)
+	defer cancel()
+	req, err := http.NewRequest("POST", strings.TrimSuffix(config.StandbyAddress, "/")+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(replicationTokenHeader, config.ReplicationToken)
+	resp, err := replicationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case 200:
+		return nil
+	case 409:
+		return errPromoted
+	default:
+		return fmt.Errorf("The standby responded with status code %d.", resp.StatusCode)
+	}
+}
+
+// currentSnapshot is the state of the active gatekeeper the standby mirrors.
+func currentSnapshot() replicationSnapshot {
+	s := replicationSnapshot{
+		UsedTaskIds: usedTaskIds.Snapshot(),
+		Policies:    activePolicies.Get(),
+		Tenants:     make(map[string]policies, len(tenants)),
+	}
+	for name, t := range tenants {
+		if p := t.store.Get(); p != nil {
+			s.Tenants[name] = p
+		}
+	}
+	return s
+}
+
+// heartbeatInterval is how often the active gatekeeper sends the standby a
+// snapshot, often enough for a few heartbeats to be missed before the standby
+// promotes itself.
+func heartbeatInterval() time.Duration {
+	if config.FailoverTimeout <= 0 {
+		return 5 * time.Second
+	}
+	interval := config.FailoverTimeout / 3
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+// replicateToStandby sends the standby a snapshot of the used task ids and the
+// policies with every heartbeat, until the standby is promoted.
+func replicateToStandby() {
+	failing := false
+	for {
+		err := replicationPost(context.Background(), "/replication/sync", currentSnapshot())
+		if err == errPromoted {
+			fenceReplication()
+			return
+		}
+		if err != nil && !failing {
+			log.Printf("Failed to replicate to the standby at '%s', token requests fail until it is reachable: %v", config.StandbyAddress, err)
+		} else if err == nil && failing {
+			log.Printf("Replicating to the standby at '%s' again.", config.StandbyAddress)
+		}
+		failing = err != nil
+		time.Sleep(heartbeatInterval())
+	}
+}
+
+// applyUsedTaskIds marks the replicated task ids as used.
+func applyUsedTaskIds(ids map[string]time.Time, now time.Time) {
+	added := make(map[string]time.Time)
+	for id, expires := range ids {
+		if expires.After(now) && !usedTaskIds.Has(id) {
+			usedTaskIds.Put(id, expires.Sub(now))
+			added[id] = expires
+		}
+	}
+	if store != nil && len(added) > 0 {
+		if err := store.SaveUsedTaskIds(added); err != nil {
+			log.Printf("Failed to persist replicated used task ids: %v", err)
+		}
+	}
+}
+
+// applySnapshot mirrors the snapshot of the active gatekeeper. It must be
+// called with replication locked.
+func applySnapshot(s replicationSnapshot, now time.Time) error {
+	if err := s.Policies.validate(); err != nil {
+		return err
+	}
+	for _, p := range s.Tenants {
+		if err := p.validate(); err != nil {
+			return err
+		}
+	}
+	applyUsedTaskIds(s.UsedTaskIds, now)
+	if s.Policies != nil {
+		activePolicies.Set(s.Policies)
+	}
+	for name, p := range s.Tenants {
+		if t, ok := tenants[name]; ok {
+			t.store.Set(p)
+		}
+	}
+	return nil
+}
+
+// promote makes the standby the active gatekeeper. It must be called with
+// replication locked.
+func promote(reason string) {
+	replication.standby = false
+	log.Printf("This standby has been promoted to the active gatekeeper: %s", reason)
+}
+
+// checkActive promotes the standby once the active gatekeeper hasn't been
+// heard from for FAILOVER_TIMEOUT. A standby that never received a snapshot
+// is not promoted automatically, as it doesn't know which tasks already got
+// their token.
+func checkActive(now time.Time) {
+	replication.Lock()
+	defer replication.Unlock()
+	if replication.standby && replication.synced && config.FailoverTimeout > 0 && now.Sub(replication.lastHeard) > config.FailoverTimeout {
+		promote(fmt.Sprintf("the active gatekeeper hasn't been heard from for %v.", config.FailoverTimeout))
+	}
+}
+
+func watchActive() {
+	for now := range time.Tick(time.Second) {
+		checkActive(now)
+	}
+}
+
+// ReplicationAuth guards the endpoints the active gatekeeper replicates to.
+func ReplicationAuth(c *gin.Context) {
+	code, err := 0, error(nil)
+	if config.ReplicationToken == "" {
+		code, err = 403, errReplicationDisabled
+	} else if subtle.ConstantTimeCompare([]byte(c.GetHeader(replicationTokenHeader)), []byte(config.ReplicationToken)) != 1 {
+		code, err = 401, errReplicationAuth
+	}
+	if err != nil {
+		log.Printf("Rejected replication request to %s from %s. Reason: %v", c.Request.URL.Path, c.Request.RemoteAddr, err)
+		c.JSON(code, struct {
+			Status string `json:"status"`
+			Ok     bool   `json:"ok"`
+			Error  string `json:"error"`
+		}{string(state.Status), false, err.Error()})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// replicationResponse responds to the active gatekeeper. Once promoted, the
+// standby refuses with a 409, which fences the former active gatekeeper.
+func replicationResponse(c *gin.Context, apply func() error) {
+	replication.Lock()
+	defer replication.Unlock()
+	if !replication.standby {
+		c.JSON(409, struct {
+			Status string `json:"status"`
+			Ok     bool   `json:"ok"`
+			Error  string `json:"error"`
+		}{string(state.Status), false, errPromoted.Error()})
+		return
+	}
+	if err := apply(); err != nil {
+		c.JSON(400, struct {
+			Status string `json:"status"`
+			Ok     bool   `json:"ok"`
+			Error  string `json:"error"`
+		}{string(state.Status), false, err.Error()})
+		return
+	}
+	replication.lastHeard = time.Now()
+	c.JSON(200, struct {
+		Status string `json:"status"`
+		Ok     bool   `json:"ok"`
+	}{string(state.Status), true})
+}
+
+// ReplicationSync receives the heartbeat snapshot of the active gatekeeper.
+func ReplicationSync(c *gin.Context) {
+	replicationResponse(c, func() error {
+		var s replicationSnapshot
+		if err := json.NewDecoder(c.Request.Body).Decode(&s); err != nil {
+			return err
+		}
+		if err := applySnapshot(s, time.Now()); err != nil {
+			return err
+		}
+		if !replication.synced {
+			log.Printf("Received the first snapshot from the active gatekeeper, with %d used task ids.", len(s.UsedTaskIds))
+		}
+		replication.synced = true
+		return nil
+	})
+}
+
+// ReplicationClaim receives a task id the active gatekeeper is about to
+// provide a token for.
+func ReplicationClaim(c *gin.Context) {
+	replicationResponse(c, func() error {
+		var claim replicationClaim
+		if err := json.NewDecoder(c.Request.Body).Decode(&claim); err != nil {
+			return err
+		}
+		if claim.TaskId == "" {
+			return errors.New("No task id was claimed.")
+		}
+		applyUsedTaskIds(map[string]time.Time{claim.TaskId: claim.Expires}, time.Now())
+		return nil
+	})
+}
+
+// PromoteStandby promotes the standby manually, for example before the active
+// gatekeeper is taken down for maintenance. Like the automatic promotion, a
+// standby that never received the used task ids of the active gatekeeper is
+// only promoted with force=true.
+func PromoteStandby(c *gin.Context) {
+	force, _ := strconv.ParseBool(c.Query("force"))
+	var err error
+	replication.Lock()
+	switch {
+	case !replication.standby:
+		err = errNotStandby
+	case !replication.synced && !force:
+		err = errNotSynced
+	default:
+		promote("promoted through the admin API.")
+	}
+	replication.Unlock()
+
+	state.RLock()
+	status := state.Status
+	state.RUnlock()
+	if err != nil {
+		c.JSON(409, struct {
+			Status string `json:"status"`
+			Ok     bool   `json:"ok"`
+			Error  string `json:"error"`
+		}{string(status), false, err.Error()})
+		return
+	}
+	c.JSON(200, struct {
+		Status string `json:"status"`
+		Ok     bool   `json:"ok"`
+	}{string(status), true})
+}
+
+// ReplicationStatus reports the replication role of gatekeeper.
+func ReplicationStatus(c *gin.Context) {
+	replication.Lock()
+	defer replication.Unlock()
+	role := "active"
+	switch {
+	case replication.standby:
+		role = "standby"
+	case replication.fenced:
+		role = "fenced"
+	}
+	c.JSON(200, struct {
+		Status    string    `json:"status"`
+		Ok        bool      `json:"ok"`
+		Role      string    `json:"role"`
+		Standby   string    `json:"standby_addr,omitempty"`
+		Synced    bool      `json:"synced"`
+		LastHeard time.Time `json:"last_heard"`
+	}{string(state.Status), true, role, config.StandbyAddress, replication.synced, replication.lastHeard})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func resetReplication() {
+	replication.standby, replication.synced, replication.fenced = false, false, false
+	replication.lastHeard = time.Time{}
+}
+
+func TestReplicationClaim(t *testing.T) {
+	defer func(token string) {
+		config.ReplicationToken = token
+		resetReplication()
+	}(config.ReplicationToken)
+	resetReplication()
+	replication.standby = true
+	config.ReplicationToken = "replicate"
+
+	r := gin.New()
+	r.POST("/replication/claim", ReplicationAuth, ReplicationClaim)
+	r.POST("/replication/promote", PromoteStandby)
+	claim := func(token, body string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/replication/claim", strings.NewReader(body))
+		req.Header.Set(replicationTokenHeader, token)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	expires := time.Now().Add(time.Minute).Format(time.RFC3339)
+	if code := claim("wrong", `{"task_id":"claimed-task","expires":"`+expires+`"}`); code != 401 {
+		t.Errorf("Expected a claim with the wrong token to be rejected, got status code %d.", code)
+	}
+	if usedTaskIds.Has("claimed-task") {
+		t.Fatal("Expected the rejected claim not to mark the task id as used.")
+	}
+	if code := claim("replicate", `{"task_id":"claimed-task","expires":"`+expires+`"}`); code != 200 {
+		t.Fatalf("Expected the claim to be accepted, got status code %d.", code)
+	}
+	if !usedTaskIds.Has("claimed-task") {
+		t.Error("Expected the claimed task id to be marked as used.")
+	}
+	if replicationRole() != errStandby {
+		t.Error("Expected the standby not to provide tokens.")
+	}
+
+	// the standby never got the state of the active gatekeeper
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/replication/promote", nil))
+	if w.Code != 409 || replicationRole() != errStandby {
+		t.Fatalf("Expected the standby not to be promoted before it is synced, got status code %d.", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/replication/promote?force=true", nil))
+	if w.Code != 200 || replicationRole() != nil {
+		t.Fatalf("Expected the standby to be promoted, got status code %d.", w.Code)
+	}
+	if code := claim("replicate", `{"task_id":"other-task","expires":"`+expires+`"}`); code != 409 {
+		t.Errorf("Expected the promoted standby to refuse claims, got status code %d.", code)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/replication/promote", nil))
+	if w.Code != 409 {
+		t.Errorf("Expected promoting an active gatekeeper to fail, got status code %d.", w.Code)
+	}
+}
+
+func TestClaimTaskId(t *testing.T) {
+	defer func(address, token string) {
+		config.StandbyAddress, config.ReplicationToken = address, token
+		resetReplication()
+	}(config.StandbyAddress, config.ReplicationToken)
+	resetReplication()
+
+	code := 200
+	var claimed []string
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/replication/claim" || r.Header.Get(replicationTokenHeader) != "replicate" {
+			w.WriteHeader(400)
+			return
+		}
+		claimed = append(claimed, r.URL.Path)
+		w.WriteHeader(code)
+	}))
+	config.StandbyAddress, config.ReplicationToken = standby.URL+"/", "replicate"
+
+	if err := claimTaskId(context.Background(), "web.1", time.Minute); err != nil || len(claimed) != 1 {
+		t.Fatalf("Expected the task id to be replicated, got %v.", err)
+	}
+
+	code = 409
+	if err := claimTaskId(context.Background(), "web.2", time.Minute); err != errFenced {
+		t.Fatalf("Expected %v once the standby was promoted, got %v.", errFenced, err)
+	}
+	code = 200
+	if err := claimTaskId(context.Background(), "web.3", time.Minute); err != errFenced || len(claimed) != 2 {
+		t.Errorf("Expected a fenced gatekeeper to stop claiming task ids, got %v.", err)
+	}
+
+	resetReplication()
+	standby.Close()
+	if err := claimTaskId(context.Background(), "web.4", time.Minute); err != errNotReplicated {
+		t.Errorf("Expected %v while the standby is unreachable, got %v.", errNotReplicated, err)
+	}
+
+	config.StandbyAddress = ""
+	if err := claimTaskId(context.Background(), "web.5", time.Minute); err != nil {
+		t.Errorf("Expected no claim without a standby, got %v.", err)
+	}
+}
+
+func TestApplySnapshot(t *testing.T) {
+	defer activePolicies.Set(activePolicies.Get())
+	now := time.Now()
+
+	err := applySnapshot(replicationSnapshot{
+		UsedTaskIds: map[string]time.Time{"snapshot-live": now.Add(time.Minute), "snapshot-expired": now.Add(-time.Minute)},
+		Policies:    policies{"web": &policy{Policies: []string{"web"}, Ttl: 60}},
+	}, now)
+	if err != nil {
+		t.Fatalf("Expected the snapshot to apply, got %v.", err)
+	}
+	if !usedTaskIds.Has("snapshot-live") || usedTaskIds.Has("snapshot-expired") {
+		t.Error("Expected only the task ids that haven't expired to be marked as used.")
+	}
+	if pol := activePolicies.Get().Get("web"); pol == nil || pol.Ttl != 60 {
+		t.Errorf("Expected the replicated policies to be active, got %v.", pol)
+	}
+
+	err = applySnapshot(replicationSnapshot{Policies: policies{"web": &policy{MaxTaskLife: -1}}}, now)
+	if err == nil {
+		t.Error("Expected invalid policies to be rejected.")
+	}
+	if pol := activePolicies.Get().Get("web"); pol == nil || pol.Ttl != 60 {
+		t.Error("Expected the policies to be kept when the snapshot is rejected.")
+	}
+}
+
+func TestCheckActive(t *testing.T) {
+	defer func(timeout time.Duration) {
+		config.FailoverTimeout = timeout
+		resetReplication()
+	}(config.FailoverTimeout)
+	resetReplication()
+	config.FailoverTimeout = 15 * time.Second
+	now := time.Now()
+
+	replication.standby = true
+	checkActive(now.Add(time.Hour))
+	if !replication.standby {
+		t.Fatal("Expected a standby that was never synced not to be promoted.")
+	}
+
+	replication.synced, replication.lastHeard = true, now
+	checkActive(now.Add(10 * time.Second))
+	if !replication.standby {
+		t.Fatal("Expected the standby not to be promoted within the failover timeout.")
+	}
+	checkActive(now.Add(20 * time.Second))
+	if replication.standby {
+		t.Error("Expected the standby to be promoted after the failover timeout.")
+	}
+
+	replication.standby = true
+	config.FailoverTimeout = 0
+	checkActive(now.Add(time.Hour))
+	if !replication.standby {
+		t.Error("Expected no automatic promotion with a failover timeout of 0.")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+	"log"
+	"time"
+)
+
+var usedTaskIdsBucket = []byte("used_task_ids")
+var issuedTokensBucket = []byte("issued_tokens")
+
+// A boltStore persists gatekeeper's state in a bolt database, see STATE_FILE.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	// Fail instead of waiting forever if another gatekeeper holds the lock.
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{usedTaskIdsBucket, issuedTokensBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db}, nil
+}
+
+// expireUsedTaskIds deletes the task ids that expired before now. They no
+// longer need to be remembered, as their tasks are older than the task life.
+func expireUsedTaskIds(b *bolt.Bucket, now time.Time) error {
+	var expired [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		var expires time.Time
+		if err := expires.UnmarshalBinary(v); err != nil || now.After(expires) {
+			expired = append(expired, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range expired {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadUsedTaskIds returns the task ids that have not expired yet, along with
+// their expiry.
+func (s *boltStore) LoadUsedTaskIds() (map[string]time.Time, error) {
+	ids := make(map[string]time.Time)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usedTaskIdsBucket)
+		if err := expireUsedTaskIds(b, time.Now()); err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var expires time.Time
+			if err := expires.UnmarshalBinary(v); err != nil {
+				return err
+			}
+			ids[string(k)] = expires
+			return nil
+		})
+	})
+	return ids, err
+}
+
+// SaveUsedTaskIds adds the given task ids to the ones already stored. The ones
+// that expired are garbage collected by ExpireUsedTaskIds, which scans all of
+// them, rather than for each token.
+func (s *boltStore) SaveUsedTaskIds(ids map[string]time.Time) error {
+	now := time.Now()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usedTaskIdsBucket)
+		for id, expires := range ids {
+			if now.After(expires) {
+				continue
+			}
+			v, err := expires.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(id), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ExpireUsedTaskIds deletes the task ids that expired before now.
+func (s *boltStore) ExpireUsedTaskIds(now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return expireUsedTaskIds(tx.Bucket(usedTaskIdsBucket), now)
+	})
+}
+
+// LoadIssuedTokens returns the tokens issued since the given time, and deletes
+// the older ones.
+func (s *boltStore) LoadIssuedTokens(since time.Time) ([]issuedToken, error) {
+	var tokens []issuedToken
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(issuedTokensBucket)
+		var expired [][]byte
+		if err := b.ForEach(func(k, v []byte) error {
+			var t issuedToken
+			if err := json.Unmarshal(v, &t); err != nil || t.Issued.Before(since) {
+				expired = append(expired, k)
+				return nil
+			}
+			tokens = append(tokens, t)
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return tokens, err
+}
+
+func (s *boltStore) SaveIssuedToken(t issuedToken) error {
+	v, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(issuedTokensBucket).Put([]byte(t.Accessor), v)
+	})
+}
+
+func (s *boltStore) DeleteIssuedTokens(accessors []string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(issuedTokensBucket)
+		for _, accessor := range accessors {
+			if err := b.Delete([]byte(accessor)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// restoreUsedTaskIds loads the task ids persisted by a previous run of
+// gatekeeper, so that their tasks cannot get another token after a restart.
+func restoreUsedTaskIds(s stateStore) (int, error) {
+	ids, err := s.LoadUsedTaskIds()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	for id, expires := range ids {
+		usedTaskIds.Put(id, expires.Sub(now))
+	}
+	return len(ids), nil
+}
+
+// How often the expired task ids are deleted from the state store. They are
+// deleted on startup as well, when they are loaded.
+const usedTaskIdExpiryInterval = 10 * time.Minute
+
+func expireStoredTaskIds(s stateStore) {
+	for now := range time.Tick(usedTaskIdExpiryInterval) {
+		if err := s.ExpireUsedTaskIds(now); err != nil {
+			log.Printf("Failed to expire used task ids in the state store: %v", err)
+		}
+	}
+}
+
+// markTaskIdUsed records that a task got its token. The task id is written to
+// the state store right away, rather than only on shutdown, so that it is not
+// forgotten if gatekeeper crashes.
+func markTaskIdUsed(taskId string, ttl time.Duration) {
+	usedTaskIds.Put(taskId, ttl)
+	if store != nil {
+		if err := store.SaveUsedTaskIds(map[string]time.Time{taskId: time.Now().Add(ttl)}); err != nil {
+			log.Printf("Failed to persist used task id %s: %v", taskId, err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	bolt "go.etcd.io/bbolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.db")
+
+	s, err := openBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := s.SaveUsedTaskIds(map[string]time.Time{
+		"web.1":   now.Add(time.Minute),
+		"stale.1": now.Add(-time.Minute),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveUsedTaskIds(map[string]time.Time{"web.2": now.Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err = openBoltStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ids, err := s.LoadUsedTaskIds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || !ids["web.1"].Equal(now.Add(time.Minute)) || ids["web.2"].IsZero() {
+		t.Errorf("Expected the unexpired task ids to be restored, got %v.", ids)
+	}
+}
+
+func TestExpireUsedTaskIds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := openBoltStore(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	if err := s.SaveUsedTaskIds(map[string]time.Time{"web.1": now.Add(time.Minute), "web.2": now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	stored := func() []string {
+		var ids []string
+		s.db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket(usedTaskIdsBucket).ForEach(func(k, v []byte) error {
+				ids = append(ids, string(k))
+				return nil
+			})
+		})
+		return ids
+	}
+	// saving a task id leaves the others alone
+	if err := s.SaveUsedTaskIds(map[string]time.Time{"web.3": now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if ids := stored(); len(ids) != 3 {
+		t.Errorf("Expected 3 stored task ids, got %v.", ids)
+	}
+	if err := s.ExpireUsedTaskIds(now.Add(10 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := stored(); len(ids) != 2 || ids[0] != "web.2" || ids[1] != "web.3" {
+		t.Errorf("Expected the expired task id to be deleted, got %v.", ids)
+	}
+}
+
+func TestRestoreUsedTaskIds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := openBoltStore(filepath.Join(dir, "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	defer func(prev stateStore) { store = prev }(store)
+	store = s
+	markTaskIdUsed("restored.1", time.Minute)
+	usedTaskIds.Destroy()
+	usedTaskIds = NewTtlSet()
+
+	if n, err := restoreUsedTaskIds(s); err != nil || n != 1 {
+		t.Fatalf("Expected 1 restored task id, got %d (%v).", n, err)
+	}
+	if !usedTaskIds.Has("restored.1") {
+		t.Error("Expected the task id to be used after restoring.")
+	}
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"text/template"
+)
+
+// The OpenAPI (swagger 2.0) description of the http api, served at
+// /swagger.json. Keep it in sync with the routes registered in main.
+const swaggerTemplateVal = `{
+	"swagger": "2.0",
+	"info": {
+		"title": "Vault Gatekeeper Mesos",
+		"description": "Provides vault tokens to Mesos tasks.",
+		"version": {{printf "%q" .Version}}
+	},
+	"consumes": ["application/json"],
+	"produces": ["application/json"],
+	"securityDefinitions": {
+		"adminToken": {"type": "apiKey", "in": "header", "name": "X-Gatekeeper-Token", "description": "The ADMIN_TOKEN, which can also be sent as a bearer Authorization header. Clients with a certificate listed in ADMIN_CLIENT_NAMES don't need it."}
+	},
+	"paths": {
+		"/status.json": {
+			"get": {
+				"summary": "Gets the status of gatekeeper.",
+				"operationId": "getStatus",
+				"responses": {
+					"200": {"description": "The status.", "schema": {"$ref": "#/definitions/Status"}}
+				}
+			}
+		},
+		"/health": {
+			"get": {
+				"summary": "Liveness check.",
+				"operationId": "getHealth",
+				"responses": {
+					"200": {"description": "Gatekeeper is serving requests.", "schema": {"$ref": "#/definitions/Health"}}
+				}
+			}
+		},
+		"/ready": {
+			"get": {
+				"summary": "Readiness check, whether gatekeeper can provide tokens.",
+				"operationId": "getReady",
+				"responses": {
+					"200": {"description": "Gatekeeper is ready.", "schema": {"$ref": "#/definitions/Ready"}},
+					"503": {"description": "Gatekeeper is not ready.", "schema": {"$ref": "#/definitions/Ready"}}
+				}
+			}
+		},
+		"/health/policies": {
+			"get": {
+				"summary": "Reports the marathon apps without a matching policy.",
+				"operationId": "getPolicyHealth",
+				"responses": {
+					"200": {"description": "Every marathon app has a policy.", "schema": {"$ref": "#/definitions/PolicyHealth"}},
+					"404": {"description": "The marathon integration is not enabled.", "schema": {"$ref": "#/definitions/Error"}},
+					"503": {"description": "Some marathon apps have no policy.", "schema": {"$ref": "#/definitions/PolicyHealth"}}
+				}
+			}
+		},
+		"/status": {
+			"get": {
+				"summary": "Reports the seal state, loaded policies, uptime and connectivity of gatekeeper.",
+				"operationId": "getAdminStatus",
+				"security": [{"adminToken": []}],
+				"responses": {
+					"200": {"description": "The status.", "schema": {"$ref": "#/definitions/AdminStatus"}},
+					"401": {"description": "Invalid or missing admin token.", "schema": {"$ref": "#/definitions/Error"}},
+					"403": {"description": "The admin api is disabled.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/replication": {
+			"get": {
+				"summary": "Reports the warm standby replication role of gatekeeper.",
+				"operationId": "getReplicationStatus",
+				"security": [{"adminToken": []}],
+				"responses": {
+					"200": {"description": "The replication status.", "schema": {"$ref": "#/definitions/ReplicationStatus"}},
+					"401": {"description": "Invalid or missing admin token.", "schema": {"$ref": "#/definitions/Error"}},
+					"403": {"description": "The admin api is disabled.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/replication/promote": {
+			"post": {
+				"summary": "Promotes the standby to the active gatekeeper.",
+				"operationId": "promoteStandby",
+				"security": [{"adminToken": []}],
+				"parameters": [
+					{"name": "force", "in": "query", "type": "boolean", "description": "Promotes a standby that never received the state of the active gatekeeper."}
+				],
+				"responses": {
+					"200": {"description": "The standby was promoted.", "schema": {"$ref": "#/definitions/Error"}},
+					"401": {"description": "Invalid or missing admin token.", "schema": {"$ref": "#/definitions/Error"}},
+					"403": {"description": "The admin api is disabled.", "schema": {"$ref": "#/definitions/Error"}},
+					"409": {"description": "Gatekeeper is not a standby, or the standby never received the state of the active gatekeeper.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/seal": {
+			"post": {
+				"summary": "Seals gatekeeper. Requires admin authentication when the admin api is enabled.",
+				"operationId": "seal",
+				"security": [{"adminToken": []}],
+				"responses": {
+					"200": {"description": "Gatekeeper is sealed.", "schema": {"$ref": "#/definitions/Error"}},
+					"401": {"description": "Invalid or missing admin token.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/unseal": {
+			"post": {
+				"summary": "Unseals gatekeeper. Requires admin authentication when the admin api is enabled.",
+				"operationId": "unseal",
+				"security": [{"adminToken": []}],
+				"parameters": [
+					{"name": "body", "in": "body", "required": true, "schema": {"$ref": "#/definitions/UnsealRequest"}}
+				],
+				"responses": {
+					"200": {"description": "Gatekeeper is unsealed.", "schema": {"$ref": "#/definitions/Error"}},
+					"400": {"description": "Invalid unseal request.", "schema": {"$ref": "#/definitions/Error"}},
+					"401": {"description": "Invalid or missing admin token.", "schema": {"$ref": "#/definitions/Error"}},
+					"500": {"description": "Failed to unseal.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/policies/reload": {
+			"post": {
+				"summary": "Reloads the policies from vault.",
+				"operationId": "reloadPolicies",
+				"responses": {
+					"200": {"description": "The policies were reloaded.", "schema": {"$ref": "#/definitions/Error"}},
+					"500": {"description": "Failed to load the policies.", "schema": {"$ref": "#/definitions/Error"}},
+					"503": {"description": "Gatekeeper is sealed.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/policies": {
+			"get": {
+				"summary": "Returns the loaded policies.",
+				"operationId": "listPolicies",
+				"security": [{"adminToken": []}],
+				"responses": {
+					"200": {"description": "The policies.", "schema": {"$ref": "#/definitions/Policies"}},
+					"401": {"description": "Invalid or missing admin token.", "schema": {"$ref": "#/definitions/Error"}},
+					"403": {"description": "The admin api is disabled.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/policies/{task_name}": {
+			"get": {
+				"summary": "Shows the policy a task with the given name matches, and the token it would be given.",
+				"operationId": "inspectPolicy",
+				"security": [{"adminToken": []}],
+				"parameters": [
+					{"name": "task_name", "in": "path", "required": true, "type": "string"},
+					{"name": "framework", "in": "query", "type": "string", "description": "The name or id of the framework of the task."}
+				],
+				"responses": {
+					"200": {"description": "The matching policy.", "schema": {"$ref": "#/definitions/PolicyMatch"}},
+					"401": {"description": "Invalid or missing admin token.", "schema": {"$ref": "#/definitions/Error"}},
+					"403": {"description": "The admin api is disabled.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/tokens": {
+			"get": {
+				"summary": "Lists the tokens issued to the tasks of an app, on an agent, or to a task.",
+				"operationId": "listTokens",
+				"security": [{"adminToken": []}],
+				"parameters": [
+					{"name": "app", "in": "query", "type": "string", "description": "The marathon app id of the tasks."},
+					{"name": "agent", "in": "query", "type": "string", "description": "The id of the mesos agent of the tasks."},
+					{"name": "task", "in": "query", "type": "string", "description": "The id of the task."}
+				],
+				"responses": {
+					"200": {"description": "The issued tokens.", "schema": {"$ref": "#/definitions/IssuedTokens"}},
+					"400": {"description": "Neither an app, agent nor task was given.", "schema": {"$ref": "#/definitions/Error"}},
+					"401": {"description": "Invalid or missing admin token.", "schema": {"$ref": "#/definitions/Error"}},
+					"403": {"description": "The admin api is disabled.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/tokens/revoke": {
+			"post": {
+				"summary": "Revokes the tokens issued to the tasks of an app, on an agent, or to a task.",
+				"operationId": "revokeTokens",
+				"security": [{"adminToken": []}],
+				"parameters": [
+					{"name": "app", "in": "query", "type": "string", "description": "The marathon app id of the tasks."},
+					{"name": "agent", "in": "query", "type": "string", "description": "The id of the mesos agent of the tasks."},
+					{"name": "task", "in": "query", "type": "string", "description": "The id of the task."}
+				],
+				"responses": {
+					"200": {"description": "The tokens were revoked.", "schema": {"$ref": "#/definitions/Revocation"}},
+					"400": {"description": "Neither an app, agent nor task was given.", "schema": {"$ref": "#/definitions/Error"}},
+					"401": {"description": "Invalid or missing admin token.", "schema": {"$ref": "#/definitions/Error"}},
+					"403": {"description": "The admin api is disabled.", "schema": {"$ref": "#/definitions/Error"}},
+					"500": {"description": "Some of the tokens could not be revoked.", "schema": {"$ref": "#/definitions/Revocation"}}
+				}
+			}
+		},
+		"/token": {
+			"post": {
+				"summary": "Requests the token of a task.",
+				"operationId": "requestToken",
+				"parameters": [
+					{"name": "dry_run", "in": "query", "type": "boolean", "description": "Only validate the request, like /token/check."},
+					{"name": "version", "in": "query", "type": "integer", "enum": [1, 2], "description": "The version of the response. Defaults to the version of an application/vnd.gatekeeper.v{version}+json Accept header, else TOKEN_RESPONSE_VERSION."},
+					{"name": "body", "in": "body", "required": true, "schema": {"$ref": "#/definitions/TokenRequest"}}
+				],
+				"responses": {
+					"200": {"description": "A response wrapping token for the task's token or secrets.", "schema": {"$ref": "#/definitions/TokenResponse"}},
+					"400": {"description": "Invalid token request.", "schema": {"$ref": "#/definitions/Error"}},
+					"403": {"description": "The task may not get a token.", "schema": {"$ref": "#/definitions/Error"}},
+					"429": {"description": "The request was rate limited.", "headers": {"Retry-After": {"type": "integer"}}, "schema": {"$ref": "#/definitions/Error"}},
+					"500": {"description": "Failed to create the token.", "schema": {"$ref": "#/definitions/Error"}},
+					"503": {"description": "Gatekeeper is sealed.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/token/check": {
+			"post": {
+				"summary": "Validates the token request of a task without creating a token.",
+				"operationId": "checkToken",
+				"parameters": [
+					{"name": "body", "in": "body", "required": true, "schema": {"$ref": "#/definitions/TokenRequest"}}
+				],
+				"responses": {
+					"200": {"description": "The task may get a token.", "schema": {"$ref": "#/definitions/TokenCheck"}},
+					"400": {"description": "Invalid token request.", "schema": {"$ref": "#/definitions/Error"}},
+					"403": {"description": "The task may not get a token.", "schema": {"$ref": "#/definitions/Error"}},
+					"429": {"description": "The request was rate limited.", "headers": {"Retry-After": {"type": "integer"}}, "schema": {"$ref": "#/definitions/Error"}},
+					"500": {"description": "Failed to look up the task.", "schema": {"$ref": "#/definitions/Error"}},
+					"503": {"description": "Gatekeeper is sealed.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/t/{tenant}/token": {
+			"post": {
+				"summary": "Requests the token of a task from the policies of a tenant.",
+				"operationId": "requestTenantToken",
+				"parameters": [
+					{"name": "tenant", "in": "path", "required": true, "type": "string"},
+					{"name": "dry_run", "in": "query", "type": "boolean", "description": "Only validate the request, like /t/{tenant}/token/check."},
+					{"name": "version", "in": "query", "type": "integer", "enum": [1, 2], "description": "The version of the response. Defaults to the version of an application/vnd.gatekeeper.v{version}+json Accept header, else TOKEN_RESPONSE_VERSION."},
+					{"name": "body", "in": "body", "required": true, "schema": {"$ref": "#/definitions/TokenRequest"}}
+				],
+				"responses": {
+					"200": {"description": "A response wrapping token for the task's token or secrets.", "schema": {"$ref": "#/definitions/TokenResponse"}},
+					"400": {"description": "Invalid token request.", "schema": {"$ref": "#/definitions/Error"}},
+					"403": {"description": "The task may not get a token.", "schema": {"$ref": "#/definitions/Error"}},
+					"404": {"description": "Unknown tenant.", "schema": {"$ref": "#/definitions/Error"}},
+					"429": {"description": "The request was rate limited.", "headers": {"Retry-After": {"type": "integer"}}, "schema": {"$ref": "#/definitions/Error"}},
+					"500": {"description": "Failed to create the token.", "schema": {"$ref": "#/definitions/Error"}},
+					"503": {"description": "Gatekeeper is sealed, or the policies of the tenant are not loaded.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/t/{tenant}/token/check": {
+			"post": {
+				"summary": "Validates the token request of a task against the policies of a tenant without creating a token.",
+				"operationId": "checkTenantToken",
+				"parameters": [
+					{"name": "tenant", "in": "path", "required": true, "type": "string"},
+					{"name": "body", "in": "body", "required": true, "schema": {"$ref": "#/definitions/TokenRequest"}}
+				],
+				"responses": {
+					"200": {"description": "The task may get a token.", "schema": {"$ref": "#/definitions/TokenCheck"}},
+					"400": {"description": "Invalid token request.", "schema": {"$ref": "#/definitions/Error"}},
+					"403": {"description": "The task may not get a token.", "schema": {"$ref": "#/definitions/Error"}},
+					"404": {"description": "Unknown tenant.", "schema": {"$ref": "#/definitions/Error"}},
+					"429": {"description": "The request was rate limited.", "headers": {"Retry-After": {"type": "integer"}}, "schema": {"$ref": "#/definitions/Error"}},
+					"500": {"description": "Failed to look up the task.", "schema": {"$ref": "#/definitions/Error"}},
+					"503": {"description": "Gatekeeper is sealed, or the policies of the tenant are not loaded.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/t/{tenant}/policies/reload": {
+			"post": {
+				"summary": "Reloads the policies of a tenant.",
+				"operationId": "reloadTenantPolicies",
+				"parameters": [
+					{"name": "tenant", "in": "path", "required": true, "type": "string"}
+				],
+				"responses": {
+					"200": {"description": "The policies were reloaded.", "schema": {"$ref": "#/definitions/Error"}},
+					"404": {"description": "Unknown tenant.", "schema": {"$ref": "#/definitions/Error"}},
+					"500": {"description": "Failed to load the policies.", "schema": {"$ref": "#/definitions/Error"}},
+					"503": {"description": "Gatekeeper is sealed.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/t/{tenant}/policies": {
+			"get": {
+				"summary": "Returns the loaded policies of a tenant.",
+				"operationId": "listTenantPolicies",
+				"security": [{"adminToken": []}],
+				"parameters": [
+					{"name": "tenant", "in": "path", "required": true, "type": "string"}
+				],
+				"responses": {
+					"200": {"description": "The policies.", "schema": {"$ref": "#/definitions/Policies"}},
+					"401": {"description": "Invalid or missing admin token of gatekeeper or the tenant.", "schema": {"$ref": "#/definitions/Error"}},
+					"403": {"description": "The admin api is disabled.", "schema": {"$ref": "#/definitions/Error"}},
+					"404": {"description": "Unknown tenant.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		},
+		"/t/{tenant}/policies/{task_name}": {
+			"get": {
+				"summary": "Shows the policy of a tenant a task with the given name matches, and the token it would be given.",
+				"operationId": "inspectTenantPolicy",
+				"security": [{"adminToken": []}],
+				"parameters": [
+					{"name": "tenant", "in": "path", "required": true, "type": "string"},
+					{"name": "task_name", "in": "path", "required": true, "type": "string"},
+					{"name": "framework", "in": "query", "type": "string", "description": "The name or id of the framework of the task."}
+				],
+				"responses": {
+					"200": {"description": "The matching policy.", "schema": {"$ref": "#/definitions/PolicyMatch"}},
+					"401": {"description": "Invalid or missing admin token of gatekeeper or the tenant.", "schema": {"$ref": "#/definitions/Error"}},
+					"403": {"description": "The admin api is disabled.", "schema": {"$ref": "#/definitions/Error"}},
+					"404": {"description": "Unknown tenant.", "schema": {"$ref": "#/definitions/Error"}}
+				}
+			}
+		}
+	},
+	"definitions": {
+		"Error": {
+			"type": "object",
+			"properties": {
+				"status": {"type": "string", "enum": ["Sealed", "Unsealed"]},
+				"ok": {"type": "boolean"},
+				"error": {"type": "string"}
+			}
+		},
+		"Status": {
+			"type": "object",
+			"properties": {
+				"status": {"type": "string", "enum": ["Sealed", "Unsealed"]},
+				"ok": {"type": "boolean"},
+				"started": {"type": "string", "format": "date-time"},
+				"uptime": {"type": "string"},
+				"version": {"type": "string"},
+				"stats": {
+					"type": "object",
+					"properties": {
+						"requests": {"type": "integer"},
+						"successful": {"type": "integer"},
+						"denied": {"type": "integer"},
+						"rate_limited": {"type": "integer"}
+					}
+				}
+			}
+		},
+		"Check": {
+			"type": "object",
+			"properties": {
+				"ok": {"type": "boolean"},
+				"error": {"type": "string"}
+			}
+		},
+		"Health": {
+			"type": "object",
+			"properties": {
+				"status": {"type": "string", "enum": ["Sealed", "Unsealed"]},
+				"ok": {"type": "boolean"},
+				"vault_circuits": {"type": "object", "additionalProperties": {"type": "string", "enum": ["closed", "open", "half-open"]}}
+			}
+		},
+		"Ready": {
+			"type": "object",
+			"properties": {
+				"status": {"type": "string", "enum": ["Sealed", "Unsealed"]},
+				"ok": {"type": "boolean"},
+				"policies": {"type": "integer"},
+				"checks": {"type": "object", "additionalProperties": {"$ref": "#/definitions/Check"}}
+			}
+		},
+		"PolicyHealth": {
+			"type": "object",
+			"properties": {
+				"ok": {"type": "boolean"},
+				"synced": {"type": "string", "format": "date-time"},
+				"unmatched": {"type": "array", "items": {"type": "string"}}
+			}
+		},
+		"ReplicationStatus": {
+			"type": "object",
+			"properties": {
+				"status": {"type": "string", "enum": ["Sealed", "Unsealed"]},
+				"ok": {"type": "boolean"},
+				"role": {"type": "string", "enum": ["active", "standby", "fenced"]},
+				"standby_addr": {"type": "string"},
+				"synced": {"type": "boolean"},
+				"last_heard": {"type": "string", "format": "date-time"}
+			}
+		},
+		"AdminStatus": {
+			"type": "object",
+			"properties": {
+				"status": {"type": "string", "enum": ["Sealed", "Unsealed"]},
+				"ok": {"type": "boolean"},
+				"policies": {"type": "integer"},
+				"started": {"type": "string", "format": "date-time"},
+				"uptime": {"type": "string"},
+				"version": {"type": "string"},
+				"checks": {
+					"type": "object",
+					"properties": {
+						"vault": {"$ref": "#/definitions/Check"},
+						"mesos": {"$ref": "#/definitions/Check"}
+					}
+				}
+			}
+		},
+		"UnsealRequest": {
+			"type": "object",
+			"required": ["type"],
+			"properties": {
+				"type": {"type": "string", "enum": ["token", "userpass", "ldap", "okta", "app-id", "github", "cubby", "wrapped-token", "approle", "kubernetes"]},
+				"token": {"type": "string"},
+				"cubby_path": {"type": "string"},
+				"username": {"type": "string"},
+				"password": {"type": "string"},
+				"mount_path": {"type": "string"},
+				"app_id": {"type": "string"},
+				"user_id_method": {"type": "string"},
+				"user_id_interface": {"type": "string"},
+				"user_id_path": {"type": "string"},
+				"user_id_hash": {"type": "string"},
+				"user_id_salt": {"type": "string"},
+				"user_id_cloud": {"type": "string"},
+				"user_id_hmac_key": {"type": "string"},
+				"role_id": {"type": "string"},
+				"secret_id": {"type": "string"},
+				"role": {"type": "string"},
+				"jwt_path": {"type": "string"}
+			}
+		},
+		"Policy": {
+			"type": "object",
+			"properties": {
+				"policies": {"type": "array", "items": {"type": "string"}},
+				"meta": {"type": "object", "additionalProperties": {"type": "string"}},
+				"ttl": {"type": "integer"},
+				"num_uses": {"type": "integer"},
+				"namespace": {"type": "string"},
+				"vault": {"type": "string"},
+				"secret_paths": {"type": "array", "items": {"type": "string"}},
+				"token_type": {"type": "string", "enum": ["service", "batch"]},
+				"entity_alias": {"type": "string"},
+				"max_task_life": {"type": "integer"},
+				"max_instances": {"type": "integer"},
+				"allowed_cidrs": {"type": "array", "items": {"type": "string"}},
+				"allowed_agents": {"type": "array", "items": {"type": "string"}},
+				"bound_cidrs": {"type": "array", "items": {"type": "string"}},
+				"bind_to_requestor": {"type": "boolean"}
+			}
+		},
+		"Policies": {
+			"type": "object",
+			"properties": {
+				"status": {"type": "string", "enum": ["Sealed", "Unsealed"]},
+				"ok": {"type": "boolean"},
+				"policies": {"type": "object", "additionalProperties": {"$ref": "#/definitions/Policy"}}
+			}
+		},
+		"IssuedToken": {
+			"type": "object",
+			"properties": {
+				"accessor": {"type": "string"},
+				"task_id": {"type": "string"},
+				"task_name": {"type": "string"},
+				"app_id": {"type": "string"},
+				"agent_id": {"type": "string"},
+				"vault": {"type": "string"},
+				"namespace": {"type": "string"},
+				"issued": {"type": "string", "format": "date-time"},
+				"error": {"type": "string"}
+			}
+		},
+		"IssuedTokens": {
+			"type": "object",
+			"properties": {
+				"status": {"type": "string", "enum": ["Sealed", "Unsealed"]},
+				"ok": {"type": "boolean"},
+				"tokens": {"type": "array", "items": {"$ref": "#/definitions/IssuedToken"}}
+			}
+		},
+		"Revocation": {
+			"type": "object",
+			"properties": {
+				"status": {"type": "string", "enum": ["Sealed", "Unsealed"]},
+				"ok": {"type": "boolean"},
+				"error": {"type": "string"},
+				"revoked": {"type": "array", "items": {"$ref": "#/definitions/IssuedToken"}},
+				"failed": {"type": "array", "items": {"$ref": "#/definitions/IssuedToken"}}
+			}
+		},
+		"TokenOptions": {
+			"type": "object",
+			"properties": {
+				"ttl": {"type": "string"},
+				"policies": {"type": "array", "items": {"type": "string"}},
+				"meta": {"type": "object", "additionalProperties": {"type": "string"}},
+				"num_uses": {"type": "integer"},
+				"no_parent": {"type": "boolean"},
+				"renewable": {"type": "boolean"},
+				"type": {"type": "string"},
+				"bound_cidrs": {"type": "array", "items": {"type": "string"}},
+				"entity_alias": {"type": "string"}
+			}
+		},
+		"PolicyMatch": {
+			"type": "object",
+			"properties": {
+				"status": {"type": "string", "enum": ["Sealed", "Unsealed"]},
+				"ok": {"type": "boolean"},
+				"error": {"type": "string"},
+				"task_name": {"type": "string"},
+				"matched": {"type": "string"},
+				"policy": {"$ref": "#/definitions/Policy"},
+				"token": {"$ref": "#/definitions/TokenOptions"}
+			}
+		},
+		"TokenRequest": {
+			"type": "object",
+			"required": ["task_id"],
+			"properties": {
+				"task_id": {"type": "string"}
+			}
+		},
+		"TokenResponse": {
+			"type": "object",
+			"properties": {
+				"status": {"type": "string", "enum": ["Sealed", "Unsealed"]},
+				"ok": {"type": "boolean"},
+				"token": {"type": "string"},
+				"vault_addr": {"type": "string"},
+				"secret_paths": {"type": "array", "items": {"type": "string"}},
+				"version": {"type": "integer", "description": "Only in version 2 and up."},
+				"policy_key": {"type": "string", "description": "Only in version 2 and up."},
+				"lease_duration": {"type": "integer", "description": "Only in version 2 and up."},
+				"renewable": {"type": "boolean", "description": "Only in version 2 and up."},
+				"wrap_info": {"$ref": "#/definitions/WrapInfo"}
+			}
+		},
+		"WrapInfo": {
+			"type": "object",
+			"properties": {
+				"token": {"type": "string"},
+				"accessor": {"type": "string"},
+				"ttl": {"type": "integer"},
+				"creation_time": {"type": "string", "format": "date-time"},
+				"wrapped_accessor": {"type": "string"}
+			}
+		},
+		"TokenCheck": {
+			"type": "object",
+			"properties": {
+				"status": {"type": "string", "enum": ["Sealed", "Unsealed"]},
+				"ok": {"type": "boolean"},
+				"dry_run": {"type": "boolean"},
+				"task_id": {"type": "string"},
+				"task_name": {"type": "string"},
+				"policy_key": {"type": "string"},
+				"token": {"$ref": "#/definitions/TokenOptions"},
+				"secret_paths": {"type": "array", "items": {"type": "string"}}
+			}
+		}
+	}
+}
+`
+
+var swaggerSpec = template.Must(template.New("swagger").Parse(swaggerTemplateVal))
+
+func Swagger(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(200)
+	swaggerSpec.Execute(c.Writer, struct{ Version string }{gitNearestTag})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSwaggerSpec(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	Swagger(c)
+
+	var spec struct {
+		Info struct {
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("Expected the spec to be valid json, got %v.", err)
+	}
+	if spec.Info.Version != gitNearestTag {
+		t.Errorf("Expected version '%s', got '%s'.", gitNearestTag, spec.Info.Version)
+	}
+	for path, method := range map[string]string{
+		"/status.json":                     "get",
+		"/health":                          "get",
+		"/ready":                           "get",
+		"/health/policies":                 "get",
+		"/status":                          "get",
+		"/replication":                     "get",
+		"/replication/promote":             "post",
+		"/seal":                            "post",
+		"/unseal":                          "post",
+		"/policies/reload":                 "post",
+		"/policies":                        "get",
+		"/policies/{task_name}":            "get",
+		"/tokens":                          "get",
+		"/tokens/revoke":                   "post",
+		"/token":                           "post",
+		"/token/check":                     "post",
+		"/t/{tenant}/token":                "post",
+		"/t/{tenant}/token/check":          "post",
+		"/t/{tenant}/policies/reload":      "post",
+		"/t/{tenant}/policies":             "get",
+		"/t/{tenant}/policies/{task_name}": "get",
+	} {
+		if _, ok := spec.Paths[path][method]; !ok {
+			t.Errorf("Expected the spec to describe %s %s.", method, path)
+		}
+	}
+}
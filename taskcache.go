@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errMesosStreamClosed = errors.New("Mesos master closed the event stream.")
+
+// A mesosTaskCache indexes the tasks running on the cluster, kept up to date by
+// subscribing to the event stream of the leading mesos master. This spares a
+// request to the master, which has to serialize every task, for each token.
+type mesosTaskCache struct {
+	sync.RWMutex
+	tasks  map[string]mesosTask
+	synced bool
+}
+
+// nil when the task cache is disabled.
+var mesosTasks *mesosTaskCache
+
+func newMesosTaskCache() *mesosTaskCache {
+	return &mesosTaskCache{tasks: make(map[string]mesosTask)}
+}
+
+// Get returns the cached task. Tasks which haven't reported a status yet are
+// treated as a miss, so that they are looked up on the master instead.
+func (c *mesosTaskCache) Get(taskId string) (mesosTask, bool) {
+	if c == nil {
+		return mesosTask{}, false
+	}
+	c.RLock()
+	defer c.RUnlock()
+	if !c.synced {
+		return mesosTask{}, false
+	}
+	task, ok := c.tasks[taskId]
+	if !ok || len(task.Statuses) == 0 {
+		return mesosTask{}, false
+	}
+	return task, true
+}
+
+// Count returns the number of cached instances of the task. Terminated tasks
+// are removed from the cache, so these are the tasks that are still running.
+func (c *mesosTaskCache) Count(task mesosTask) (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.RLock()
+	defer c.RUnlock()
+	if !c.synced {
+		return 0, false
+	}
+	n := 0
+	for _, t := range c.tasks {
+		if sameApp(t, task) {
+			n++
+		}
+	}
+	return n, true
+}
+
+func (c *mesosTaskCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.RLock()
+	defer c.RUnlock()
+	return len(c.tasks)
+}
+
+type mesosEvent struct {
+	Type       string `json:"type"`
+	Subscribed struct {
+		GetState struct {
+			GetTasks struct {
+				Tasks []mesosV1Task `json:"tasks"`
+			} `json:"get_tasks"`
+		} `json:"get_state"`
+	} `json:"subscribed"`
+	TaskAdded struct {
+		Task mesosV1Task `json:"task"`
+	} `json:"task_added"`
+	TaskUpdated struct {
+		Status struct {
+			TaskId struct {
+				Value string `json:"value"`
+			} `json:"task_id"`
+			State     string  `json:"state"`
+			Timestamp float64 `json:"timestamp"`
+		} `json:"status"`
+		State string `json:"state"`
+	} `json:"task_updated"`
+}
+
+func mesosTaskTerminal(state string) bool {
+	switch state {
+	case "TASK_FINISHED", "TASK_FAILED", "TASK_KILLED", "TASK_LOST", "TASK_ERROR",
+		"TASK_DROPPED", "TASK_GONE", "TASK_GONE_BY_OPERATOR":
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *mesosTaskCache) apply(event mesosEvent) {
+	c.Lock()
+	defer c.Unlock()
+	switch event.Type {
+	case "SUBSCRIBED":
+		c.tasks = make(map[string]mesosTask)
+		for _, task := range event.Subscribed.GetState.GetTasks.Tasks {
+			if !mesosTaskTerminal(task.State) {
+				c.tasks[task.TaskId.Value] = task.mesosTask()
+			}
+		}
+		c.synced = true
+	case "TASK_ADDED":
+		task := event.TaskAdded.Task
+		c.tasks[task.TaskId.Value] = task.mesosTask()
+	case "TASK_UPDATED":
+		update := event.TaskUpdated
+		taskId := update.Status.TaskId.Value
+		if mesosTaskTerminal(update.State) {
+			delete(c.tasks, taskId)
+			return
+		}
+		if task, ok := c.tasks[taskId]; ok {
+			task.State = update.State
+			task.Statuses = append(task.Statuses, struct {
+				State     string  `json:"state"`
+				Timestamp float64 `json:"timestamp"`
+			}{update.Status.State, update.Status.Timestamp})
+			c.tasks[taskId] = task
+		}
+	}
+}
+
+// Stop serving from the cache until the stream has been resubscribed, as
+// events may have been missed.
+func (c *mesosTaskCache) invalidate() {
+	c.Lock()
+	c.tasks = make(map[string]mesosTask)
+	c.synced = false
+	c.Unlock()
+}
+
+// Reads a single record of the RecordIO format used by the mesos streaming
+// apis, which is the length of the record in bytes, a newline, and the record.
+func readRecordIO(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseUint(strings.TrimSpace(line), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid RecordIO record length: %v", err)
+	}
+	record := make([]byte, size)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Subscribe to the event stream of the master, and apply each event to the
+// cache until the stream ends.
+func (c *mesosTaskCache) subscribe() error {
+	masterHosts, err := getMesosMaster()
+	if err != nil {
+		return err
+	}
+	var subscribeErr error
+	for _, host := range masterHosts {
+		resp, err := mesosRequest(context.Background(), "POST", host, "/api/v1", struct {
+			Type string `json:"type"`
+		}{"SUBSCRIBE"})
+		if err != nil {
+			subscribeErr = err
+			continue
+		}
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			subscribeErr = fmt.Errorf("Mesos master '%s' responded with status code %d.", host, resp.StatusCode)
+			continue
+		}
+		defer resp.Body.Close()
+		defer c.invalidate()
+		reader := bufio.NewReader(resp.Body)
+		for {
+			record, err := readRecordIO(reader)
+			if err == io.EOF {
+				return errMesosStreamClosed
+			} else if err != nil {
+				return err
+			}
+			var event mesosEvent
+			if err := json.Unmarshal(record, &event); err != nil {
+				return err
+			}
+			c.apply(event)
+			if event.Type == "SUBSCRIBED" {
+				log.Printf("Subscribed to mesos master %s, caching %d tasks.", host, c.Len())
+			}
+		}
+	}
+	return subscribeErr
+}
+
+func (c *mesosTaskCache) watch() {
+	for {
+		if err := c.subscribe(); err != nil {
+			log.Printf("Lost mesos event stream, reconnecting in 5s. Error: %v", err)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
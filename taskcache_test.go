@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReadRecordIO(t *testing.T) {
+	records := []string{`{"type":"HEARTBEAT"}`, `{"type":"SUBSCRIBED"}`}
+	stream := ""
+	for _, record := range records {
+		stream += strconv.Itoa(len(record)) + "\n" + record
+	}
+	reader := bufio.NewReader(strings.NewReader(stream))
+	for _, expected := range records {
+		if record, err := readRecordIO(reader); err != nil {
+			t.Fatalf("Failed to read record: %v", err)
+		} else if string(record) != expected {
+			t.Fatalf("Expected record %s, got %s.", expected, record)
+		}
+	}
+	if _, err := readRecordIO(reader); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v.", err)
+	}
+}
+
+func TestMesosTaskCache(t *testing.T) {
+	cache := newMesosTaskCache()
+	apply := func(event string) {
+		var e mesosEvent
+		if err := json.Unmarshal([]byte(event), &e); err != nil {
+			t.Fatal(err)
+		}
+		cache.apply(e)
+	}
+
+	if _, ok := cache.Get("running"); ok {
+		t.Fatal("Cache should miss before subscribing.")
+	}
+	apply(`{"type":"SUBSCRIBED","subscribed":{"get_state":{"get_tasks":{"tasks":[
+		{"task_id":{"value":"running"},"name":"running","state":"TASK_RUNNING","statuses":[{"state":"TASK_RUNNING","timestamp":1}]},
+		{"task_id":{"value":"finished"},"name":"finished","state":"TASK_FINISHED","statuses":[{"state":"TASK_FINISHED","timestamp":1}]}
+	]}}}}`)
+	if task, ok := cache.Get("running"); !ok || task.Name != "running" {
+		t.Fatal("Expected running task to be cached.")
+	}
+	if _, ok := cache.Get("finished"); ok {
+		t.Fatal("Finished task should not be cached.")
+	}
+
+	apply(`{"type":"TASK_ADDED","task_added":{"task":{"task_id":{"value":"new"},"name":"new","state":"TASK_STAGING"}}}`)
+	if _, ok := cache.Get("new"); ok {
+		t.Fatal("Task without statuses should miss.")
+	}
+	apply(`{"type":"TASK_UPDATED","task_updated":{"status":{"task_id":{"value":"new"},"state":"TASK_RUNNING","timestamp":2},"state":"TASK_RUNNING"}}`)
+	if task, ok := cache.Get("new"); !ok || task.State != "TASK_RUNNING" || task.Statuses[0].Timestamp != 2 {
+		t.Fatal("Expected updated task to be cached.")
+	}
+	if n, ok := cache.Count(mesosTask{Name: "new"}); !ok || n != 1 {
+		t.Fatalf("Expected 1 running instance of new, got %d.", n)
+	}
+
+	apply(`{"type":"TASK_UPDATED","task_updated":{"status":{"task_id":{"value":"running"},"state":"TASK_KILLED","timestamp":3},"state":"TASK_KILLED"}}`)
+	if _, ok := cache.Get("running"); ok {
+		t.Fatal("Killed task should be removed.")
+	}
+
+	cache.invalidate()
+	if _, ok := cache.Get("new"); ok {
+		t.Fatal("Cache should miss after being invalidated.")
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var errUnknownTenant = errors.New("Unknown tenant.")
+var errTenantNotLoaded = errors.New("The policies of the tenant have not been loaded.")
+var errNotTenantTask = errors.New("This task does not belong to the tenant.")
+
+// A tenant is a team with its own policy document, which it administers
+// independently of GATE_POLICIES and of the other tenants. The tokens of a
+// tenant are always created with its vault backend and in its namespace.
+type tenant struct {
+	Name       string `json:"-"`
+	Policies   string `json:"policies"`
+	Vault      string `json:"vault,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Listen     string `json:"listen,omitempty"`
+	AdminToken string `json:"admin_token,omitempty"`
+
+	// The frameworks (by name or id) and the marathon apps of the tasks of the
+	// tenant. An app ending in a slash, like /team-a/, covers the apps under it.
+	// A tenant bound to neither must set PolicyRequired, so that it only gives
+	// tokens to the tasks it has a policy entry for.
+	Frameworks     []string `json:"frameworks,omitempty"`
+	Apps           []string `json:"apps,omitempty"`
+	PolicyRequired bool     `json:"policy_required,omitempty"`
+
+	store *policyStore
+}
+
+var tenants = make(map[string]*tenant)
+
+// The key the tenant of a request is kept under in the gin context.
+const tenantKey = "gatekeeper.tenant"
+
+// Loads the tenants from a json file in the format of
+// {"name":{"policies":"/teams/name","vault":"backend","namespace":"team"}}
+// The vault backends must be loaded first.
+func loadTenants(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	loaded := make(map[string]*tenant)
+	if err := json.NewDecoder(f).Decode(&loaded); err != nil {
+		return fmt.Errorf("Failed to decode tenants: %v", err)
+	}
+	for name, t := range loaded {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("Tenant '%s' has an invalid name.", name)
+		}
+		if t.Policies == "" {
+			return fmt.Errorf("Tenant '%s' has no policies.", name)
+		}
+		if _, err := newPolicySource(t.Policies); err != nil {
+			return fmt.Errorf("Tenant '%s': %v", name, err)
+		}
+		if _, err := getVaultBackend(t.Vault); err != nil {
+			return fmt.Errorf("Tenant '%s': %v (%s)", name, err, t.Vault)
+		}
+		// otherwise any task could be given a token by the catch all policy of the tenant
+		if len(t.Frameworks) == 0 && len(t.Apps) == 0 && !t.PolicyRequired {
+			return fmt.Errorf("Tenant '%s' must be bound to its frameworks or apps, or require a policy for each task.", name)
+		}
+		t.Name = name
+		t.store = &policyStore{tenant: t}
+	}
+	tenants = loaded
+	return nil
+}
+
+// loadTenantPolicies loads the policies of every tenant. A tenant whose
+// policies fail to load keeps its previous policies, and doesn't affect the
+// other tenants.
+func loadTenantPolicies(authToken string) {
+	for _, t := range tenants {
+		if err := t.store.Load(authToken); err != nil {
+			log.Printf("Failed to load the policies of tenant '%s': %v", t.Name, err)
+			hooks.NotifyPolicyReloadFailed(policySourceName(t.Policies), err)
+		}
+	}
+}
+
+// admits checks that the task, launched by the named framework, belongs to the
+// tenant, and that it has a policy entry of its own if the tenant requires one.
+// A tenant bound to both frameworks and apps requires the task to match both.
+func (t *tenant) admits(task mesosTask, framework string, policyKey string) error {
+	if len(t.Frameworks) > 0 {
+		found := false
+		for _, f := range t.Frameworks {
+			if f == task.FrameworkId || (framework != "" && f == framework) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errNotTenantTask
+		}
+	}
+	if len(t.Apps) > 0 {
+		appId, found := marathonAppId(task.Name), false
+		for _, app := range t.Apps {
+			if appId == app || (strings.HasSuffix(app, "/") && strings.HasPrefix(appId, app)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errNotTenantTask
+		}
+	}
+	if t.PolicyRequired && (policyKey == "*" || policyKey == "") {
+		return errNoPolicy
+	}
+	return nil
+}
+
+// namespace is the vault namespace the tokens of the tenant are created in, if
+// it has one.
+func (t *tenant) namespace() string {
+	if t.Namespace != "" {
+		return t.Namespace
+	}
+	backend, err := getVaultBackend(t.Vault)
+	if err != nil {
+		return ""
+	}
+	return backend.namespace()
+}
+
+func (t *tenant) loadPolicies(authToken string) (policies, error) {
+	source, err := newPolicySource(t.Policies)
+	if err != nil {
+		return nil, policyLoadError{err}
+	}
+	// a policy document in vault is read from the vault of the tenant
+	if v, ok := source.(vaultPolicySource); ok {
+		v.Vault, v.Namespace = t.Vault, t.Namespace
+		source = v
+	}
+	p, err := source.Load(authToken)
+	if err != nil {
+		return nil, err
+	}
+	return t.scope(p)
+}
+
+// scope pins the policies of the tenant to its vault backend and namespace.
+// Policies may choose a child namespace of the tenant's, but a policy that
+// would create tokens elsewhere fails the whole document.
+func (t *tenant) scope(p policies) (policies, error) {
+	namespace := t.namespace()
+	scoped := make(policies, len(p))
+	for name, pol := range p {
+		if pol.Vault != "" && pol.Vault != t.Vault {
+			return nil, policyLoadError{fmt.Errorf("Policy '%s' uses vault backend '%s', which is not the vault of tenant '%s'.", name, pol.Vault, t.Name)}
+		}
+		if namespace != "" && pol.Namespace != "" && pol.Namespace != namespace && !strings.HasPrefix(pol.Namespace, namespace+"/") {
+			return nil, policyLoadError{fmt.Errorf("Policy '%s' uses namespace '%s', which is outside the namespace of tenant '%s'.", name, pol.Namespace, t.Name)}
+		}
+		pinned := *pol
+		pinned.Vault = t.Vault
+		if pinned.Namespace == "" {
+			pinned.Namespace = t.Namespace
+		}
+		scoped[name] = &pinned
+	}
+	return scoped, nil
+}
+
+// addTenantRoutes adds the endpoints a tenant is served, both under
+// /t/:tenant and at the root of the tenant's own listener.
+func addTenantRoutes(g *gin.RouterGroup) {
+	g.POST("/token", RateLimit, Provide)
+	g.POST("/token/check", RateLimit, CheckToken)
+	g.POST("/policies/reload", ReloadPolicies)
+	g.GET("/policies", TenantAdminAuth, ListPolicies)
+	g.GET("/policies/*key", TenantAdminAuth, InspectPolicy)
+}
+
+// TenantRoute resolves the tenant named in the url of the request.
+func TenantRoute(c *gin.Context) {
+	t, ok := tenants[c.Param("tenant")]
+	if !ok {
+		c.JSON(404, struct {
+			Status string `json:"status"`
+			Ok     bool   `json:"ok"`
+			Error  string `json:"error"`
+		}{string(state.Status), false, errUnknownTenant.Error()})
+		c.Abort()
+		return
+	}
+	c.Set(tenantKey, t)
+	c.Next()
+}
+
+// requestTenant returns the tenant of the request, or nil outside of a tenant.
+func requestTenant(c *gin.Context) *tenant {
+	if t, ok := c.Get(tenantKey); ok {
+		return t.(*tenant)
+	}
+	return nil
+}
+
+// requestPolicies returns the policies of the tenant of the request, or the
+// policies of GATE_POLICIES outside of a tenant.
+func requestPolicies(c *gin.Context) *policyStore {
+	if t := requestTenant(c); t != nil {
+		return t.store
+	}
+	return activePolicies
+}
+
+// TenantAdminAuth lets the admins of a tenant inspect its policies with the
+// admin token of the tenant. The admin token of gatekeeper is accepted too.
+func TenantAdminAuth(c *gin.Context) {
+	t := requestTenant(c)
+	if t == nil || t.AdminToken == "" {
+		AdminAuth(c)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(adminToken(c)), []byte(t.AdminToken)) == 1 {
+		c.Next()
+		return
+	}
+	if !adminEnabled() {
+		log.Printf("Rejected admin request to %s from %s. Reason: %v", c.Request.URL.Path, c.Request.RemoteAddr, errAdminUnauthorized)
+		c.JSON(401, struct {
+			Status string `json:"status"`
+			Ok     bool   `json:"ok"`
+			Error  string `json:"error"`
+		}{string(state.Status), false, errAdminUnauthorized.Error()})
+		c.Abort()
+		return
+	}
+	AdminAuth(c)
+}
+
+// serveTenant listens on the listen address of the tenant, and serves the
+// endpoints of the tenant at its root.
+func serveTenant(t *tenant, tlsConfig *tls.Config, errs chan<- error) (*http.Server, error) {
+	listeners, err := listen(t.Listen)
+	if err != nil {
+		return nil, err
+	}
+	r := gin.New()
+	r.Use(accessLog(), gin.Recovery())
+	addTenantRoutes(r.Group("/", func(c *gin.Context) {
+		c.Set(tenantKey, t)
+		c.Next()
+	}))
+	server := &http.Server{
+		Handler:   r,
+		TLSConfig: tlsConfig,
+	}
+	serve := server.Serve
+	if tlsConfig != nil {
+		serve = func(l net.Listener) error {
+			return server.ServeTLS(l, "", "")
+		}
+	}
+	for _, l := range listeners {
+		log.Printf("Listening and serving tenant '%s' on '%s'...", t.Name, l.Addr())
+	}
+	go func() {
+		if err := serveAll(listeners, serve); err != nil && err != http.ErrServerClosed {
+			errs <- fmt.Errorf("Failed to serve tenant '%s'. Error: %v", t.Name, err)
+		}
+	}()
+	return server, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadTenants(t *testing.T) {
+	defer func(loaded map[string]*tenant, backends map[string]*vaultBackend) {
+		tenants, vaultBackends = loaded, backends
+	}(tenants, vaultBackends)
+	vaultBackends = map[string]*vaultBackend{"teams": {Name: "teams", Address: "https://vault-teams:8200"}}
+
+	dir, err := ioutil.TempDir("", "gatekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "tenants.json")
+
+	ioutil.WriteFile(file, []byte(`{
+		"web": {"policies": "/teams/web", "vault": "teams", "namespace": "web", "apps": ["/web/"]},
+		"data": {"policies": "file:///etc/gatekeeper/data.json", "listen": ":9301", "policy_required": true}
+	}`), 0600)
+	if err := loadTenants(file); err != nil {
+		t.Fatalf("Expected the tenants to load, got %v.", err)
+	}
+	if web := tenants["web"]; web == nil || web.Name != "web" || web.store == nil || web.store.tenant != web {
+		t.Fatalf("Expected tenant 'web' to have its own policy store, got %#v.", web)
+	}
+	if data := tenants["data"]; data == nil || data.Listen != ":9301" {
+		t.Fatalf("Expected tenant 'data' to listen on :9301, got %#v.", data)
+	}
+
+	for _, invalid := range []string{
+		`{"web": {}}`,
+		`{"web": {"policies": "s3://bucket/web.json"}}`,
+		`{"web": {"policies": "/teams/web", "vault": "unknown"}}`,
+		`{"a/b": {"policies": "/teams/web", "apps": ["/web/"]}}`,
+		// a tenant that isn't bound to its tasks
+		`{"web": {"policies": "/teams/web"}}`,
+		`[]`,
+	} {
+		ioutil.WriteFile(file, []byte(invalid), 0600)
+		if err := loadTenants(file); err == nil {
+			t.Errorf("Expected %s to be rejected.", invalid)
+		}
+	}
+	if len(tenants) != 2 {
+		t.Errorf("Expected the tenants to be kept when loading fails, got %d.", len(tenants))
+	}
+}
+
+func TestTenantAdmits(t *testing.T) {
+	for _, test := range []struct {
+		tenant    *tenant
+		task      mesosTask
+		framework string
+		policyKey string
+		expected  error
+	}{
+		{&tenant{Frameworks: []string{"marathon"}}, mesosTask{Name: "frontend.web", FrameworkId: "f-1"}, "marathon", "*", nil},
+		{&tenant{Frameworks: []string{"f-1"}}, mesosTask{Name: "frontend.web", FrameworkId: "f-1"}, "", "*", nil},
+		{&tenant{Frameworks: []string{"marathon"}}, mesosTask{Name: "frontend.web", FrameworkId: "f-2"}, "chronos", "*", errNotTenantTask},
+		{&tenant{Apps: []string{"/web/"}}, mesosTask{Name: "frontend.web"}, "", "*", nil},
+		{&tenant{Apps: []string{"/web/frontend"}}, mesosTask{Name: "frontend.web"}, "", "*", nil},
+		{&tenant{Apps: []string{"/web"}}, mesosTask{Name: "frontend.web"}, "", "*", errNotTenantTask},
+		{&tenant{Apps: []string{"/web/"}}, mesosTask{Name: "frontend.webx"}, "", "*", errNotTenantTask},
+		// both the framework and the app must match
+		{&tenant{Frameworks: []string{"marathon"}, Apps: []string{"/web/"}}, mesosTask{Name: "frontend.data"}, "marathon", "*", errNotTenantTask},
+		{&tenant{Frameworks: []string{"marathon"}, Apps: []string{"/web/"}}, mesosTask{Name: "frontend.web"}, "chronos", "*", errNotTenantTask},
+		{&tenant{PolicyRequired: true}, mesosTask{Name: "frontend.web"}, "", "frontend.web", nil},
+		{&tenant{PolicyRequired: true}, mesosTask{Name: "frontend.web"}, "", "*", errNoPolicy},
+		{&tenant{PolicyRequired: true}, mesosTask{Name: "frontend.web"}, "", "", errNoPolicy},
+	} {
+		if err := test.tenant.admits(test.task, test.framework, test.policyKey); err != test.expected {
+			t.Errorf("Expected task '%s' of framework '%s' with policy '%s' to give %v for %+v, got %v.", test.task.Name, test.framework, test.policyKey, test.expected, *test.tenant, err)
+		}
+	}
+	if code := verifyErrorCode(errNotTenantTask); code != 403 {
+		t.Errorf("Expected a task of another tenant to be refused with a 403, got %d.", code)
+	}
+}
+
+func TestTenantScope(t *testing.T) {
+	defer func(backends map[string]*vaultBackend) {
+		vaultBackends = backends
+	}(vaultBackends)
+	vaultBackends = map[string]*vaultBackend{"teams": {Name: "teams", Address: "https://vault-teams:8200", Namespace: "teams"}}
+
+	web := &tenant{Name: "web", Vault: "teams"}
+	scoped, err := web.scope(policies{
+		"web":     &policy{Policies: []string{"web"}},
+		"web-sub": &policy{Policies: []string{"web"}, Namespace: "teams/web"},
+		"*":       &policy{Policies: []string{"default"}, Vault: "teams"},
+	})
+	if err != nil {
+		t.Fatalf("Expected the policies to be in scope, got %v.", err)
+	}
+	for name, pol := range scoped {
+		if pol.Vault != "teams" {
+			t.Errorf("Expected policy '%s' to be pinned to the vault of the tenant, got '%s'.", name, pol.Vault)
+		}
+	}
+	if ns := scoped["web-sub"].Namespace; ns != "teams/web" {
+		t.Errorf("Expected a child namespace to be kept, got '%s'.", ns)
+	}
+
+	for _, p := range []policies{
+		{"web": &policy{Vault: "other"}},
+		{"web": &policy{Namespace: "finance"}},
+		{"web": &policy{Namespace: "teamsx"}},
+	} {
+		if _, err := web.scope(p); err == nil {
+			t.Errorf("Expected %#v to be rejected.", p["web"])
+		}
+	}
+
+	ns := &tenant{Name: "ns", Namespace: "ns"}
+	scoped, err = ns.scope(policies{"web": &policy{}})
+	if err != nil || scoped["web"].Namespace != "ns" {
+		t.Errorf("Expected the policies to be created in the namespace of the tenant, got %v, %v.", scoped, err)
+	}
+}
+
+func TestTenantRoutes(t *testing.T) {
+	defer func(loaded map[string]*tenant, token, names string, status GkStatus) {
+		tenants, config.AdminToken, config.AdminClientNames, state.Status = loaded, token, names, status
+	}(tenants, config.AdminToken, config.AdminClientNames, state.Status)
+
+	dir, err := ioutil.TempDir("", "gatekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "web.json")
+	ioutil.WriteFile(file, []byte(`{"web": {"policies": ["web"], "ttl": 60}}`), 0600)
+
+	web := &tenant{Name: "web", Policies: "file://" + file, AdminToken: "web-secret"}
+	web.store = &policyStore{tenant: web}
+	tenants = map[string]*tenant{"web": web}
+	config.AdminToken, config.AdminClientNames, state.Status = "secret", "", StatusUnsealed
+
+	r := gin.New()
+	addTenantRoutes(r.Group("/t/:tenant", TenantRoute))
+	do := func(method, url, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, nil)
+		if token != "" {
+			req.Header.Set("X-Gatekeeper-Token", token)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("POST", "/t/api/token", ""); w.Code != 404 {
+		t.Errorf("Expected an unknown tenant to be rejected, got status code %d.", w.Code)
+	}
+	if w := do("POST", "/t/web/policies/reload", ""); w.Code != 200 {
+		t.Fatalf("Expected the policies of the tenant to reload, got status code %d: %s", w.Code, w.Body.String())
+	}
+	if pol := web.store.Get().Get("web"); pol == nil || pol.Ttl != 60 {
+		t.Fatalf("Expected the policies of the tenant to be loaded, got %v.", pol)
+	}
+
+	for token, expected := range map[string]int{"": 401, "wrong": 401, "web-secret": 200, "secret": 200} {
+		if w := do("GET", "/t/web/policies", token); w.Code != expected {
+			t.Errorf("Expected status code %d listing the policies with token '%s', got %d.", expected, token, w.Code)
+		}
+	}
+	w := do("GET", "/t/web/policies", "web-secret")
+	var resp struct {
+		Policies policies `json:"policies"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Policies["web"] == nil {
+		t.Errorf("Expected the policies of the tenant, got %s.", w.Body.String())
+	}
+}
+
+func TestServeTenantErrors(t *testing.T) {
+	errs := make(chan error, 1)
+	web := &tenant{Name: "web", Listen: "127.0.0.1:0"}
+	server, err := serveTenant(web, nil, errs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// closing the server isn't an error
+	server.Close()
+
+	// a TLS configuration without a certificate can't be served
+	data := &tenant{Name: "data", Listen: "127.0.0.1:0"}
+	server, err = serveTenant(data, &tls.Config{}, errs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "tenant 'data'") {
+			t.Errorf("Expected the error to name the tenant, got %v.", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the listener error of the tenant to be returned.")
+	}
+	select {
+	case err := <-errs:
+		t.Errorf("Expected only the listener error of the tenant, got %v.", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/channelmeter/vault-gatekeeper-mesos/gatekeeper"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+)
+
+var errTokensCommand = errors.New("Unknown tokens command. Valid commands are 'list' and 'revoke'.")
+
+// runTokens implements the tokens subcommand, which lists or revokes the tokens
+// gatekeeper issued through its admin api.
+func runTokens(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s tokens list|revoke [flags]\n", os.Args[0])
+		return errTokensCommand
+	}
+	var method, path string
+	switch args[0] {
+	case "list":
+		method, path = "GET", "/tokens"
+	case "revoke":
+		method, path = "POST", "/tokens/revoke"
+	default:
+		return errTokensCommand
+	}
+
+	fs := flag.NewFlagSet("tokens "+args[0], flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s tokens %s [flags]\n", os.Args[0], args[0])
+		fs.PrintDefaults()
+	}
+	gatekeeperAddr := fs.String("gatekeeper", os.Getenv("GATEKEEPER_ADDR"), "Address of gatekeeper.")
+	adminToken := fs.String("admin-token", os.Getenv("ADMIN_TOKEN"), "The admin token of gatekeeper. Not needed with a client certificate listed in ADMIN_CLIENT_NAMES.")
+	var filter tokenFilter
+	fs.StringVar(&filter.AppId, "app", "", "Select the tokens issued to the tasks of the marathon app with this id.")
+	fs.StringVar(&filter.AgentId, "agent", "", "Select the tokens issued to the tasks on the mesos agent with this id.")
+	fs.StringVar(&filter.TaskId, "task", "", "Select the token issued to the task with this id.")
+	fs.Parse(args[1:])
+	if filter.empty() {
+		fs.Usage()
+		return errNoTokenFilter
+	}
+
+	u, err := url.Parse(*gatekeeperAddr)
+	if err != nil {
+		return err
+	}
+	u.Path = path
+	u.RawQuery = url.Values{"app": {filter.AppId}, "agent": {filter.AgentId}, "task": {filter.TaskId}}.Encode()
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if *adminToken != "" {
+		req.Header.Set("X-Gatekeeper-Token", *adminToken)
+	}
+	client := http.DefaultClient
+	if gatekeeper.DefaultClient != nil && gatekeeper.DefaultClient.HttpClient != nil {
+		// carries the CA and client certificate configured for the client library
+		client = gatekeeper.DefaultClient.HttpClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Ok      bool               `json:"ok"`
+		Error   string             `json:"error"`
+		Tokens  []issuedToken      `json:"tokens"`
+		Revoked []issuedToken      `json:"revoked"`
+		Failed  []failedRevocation `json:"failed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("Unexpected response from gatekeeper (%s): %v", resp.Status, err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCESSOR\tTASK\tAGENT\tISSUED\tRESULT")
+	for _, t := range result.Tokens {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", t.Accessor, t.TaskId, t.AgentId, t.Issued.Format("2006-01-02 15:04:05"))
+	}
+	for _, t := range result.Revoked {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\trevoked\n", t.Accessor, t.TaskId, t.AgentId, t.Issued.Format("2006-01-02 15:04:05"))
+	}
+	for _, t := range result.Failed {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.Accessor, t.TaskId, t.AgentId, t.Issued.Format("2006-01-02 15:04:05"), t.Error)
+	}
+	w.Flush()
+
+	if !result.Ok {
+		return errors.New(result.Error)
+	}
+	return nil
+}
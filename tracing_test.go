@@ -0,0 +1,73 @@
+package main
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc/metadata"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenRequestSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	state.Lock()
+	prev := state.Status
+	state.Status = StatusSealed
+	state.Unlock()
+	defer func() {
+		state.Lock()
+		state.Status = prev
+		state.Unlock()
+	}()
+
+	req := httptest.NewRequest("POST", "/token", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if _, err := requestToken(attestationRequest{TaskId: "web.1234", Context: httpTraceContext(req)}, false); err == nil {
+		t.Fatal("Expected the token request of a sealed gatekeeper to fail.")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "gatekeeper.token_request" {
+		t.Fatalf("Expected a single token request span, got %d spans.", len(spans))
+	}
+	span := spans[0]
+	if span.Parent().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || !span.Parent().IsRemote() {
+		t.Errorf("Expected the span to continue the trace of the client, got parent %v.", span.Parent())
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("Expected the span of a failed request to be an error, got %v.", span.Status())
+	}
+	for _, attr := range span.Attributes() {
+		if attr.Key == "gatekeeper.outcome" && attr.Value.AsString() != auditSealed {
+			t.Errorf("Expected outcome '%s', got '%s'.", auditSealed, attr.Value.AsString())
+		}
+	}
+}
+
+func TestMetadataCarrier(t *testing.T) {
+	md := metadata.MD{}
+	carrier := metadataCarrier(md)
+	carrier.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if carrier.Get("Traceparent") != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("Expected the trace context to be carried, got '%s'.", carrier.Get("traceparent"))
+	}
+	if keys := carrier.Keys(); len(keys) != 1 || keys[0] != "traceparent" {
+		t.Errorf("Unexpected keys %v.", keys)
+	}
+	if carrier.Get("tracestate") != "" {
+		t.Error("Expected a missing key to be empty.")
+	}
+}
+
+func TestSetupTracingInvalidEndpoint(t *testing.T) {
+	if err := setupTracing("otel-collector:4318"); err == nil {
+		t.Error("Expected an endpoint without a http or https scheme to be invalid.")
+	}
+}
@@ -32,6 +32,17 @@ func (t *TtlSet) Put(key string, ttl time.Duration) {
 	t.Unlock()
 }
 
+// Snapshot returns a copy of the keys in the set along with their expiry.
+func (t *TtlSet) Snapshot() map[string]time.Time {
+	t.RLock()
+	defer t.RUnlock()
+	s := make(map[string]time.Time, len(t.s))
+	for k, v := range t.s {
+		s[k] = v
+	}
+	return s
+}
+
 func (t *TtlSet) Destroy() {
 	t.Lock()
 	close(t.quit)
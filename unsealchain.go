@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var errUnsealChainFailed = errors.New("None of the unsealers in the chain succeeded.")
+
+// An unsealerChain tries each of its unsealers in turn, and unseals with the
+// first that succeeds.
+type unsealerChain struct {
+	unsealers []Unsealer
+	used      string
+}
+
+func (c *unsealerChain) Token() (string, error) {
+	for _, unsealer := range c.unsealers {
+		token, err := unsealer.Token()
+		if err == nil {
+			c.used = unsealer.Name()
+			return token, nil
+		}
+		log.Printf("Failed to unseal with method '%s', trying the next method. Error: %v", unsealer.Name(), err)
+	}
+	return "", errUnsealChainFailed
+}
+
+func (c *unsealerChain) Name() string {
+	return c.used
+}
+
+// The unsealer for a method of the unseal chain, configured by its own flags
+// and environment variables.
+func configuredUnsealer(method string) (Unsealer, error) {
+	switch method {
+	case "token":
+		return TokenUnsealer{AuthToken: os.Getenv("VAULT_TOKEN")}, nil
+	case "cubby":
+		return config.CubbyAuth, nil
+	case "wrapped-token":
+		return config.WrappedTokenAuth, nil
+	case "app-id":
+		return config.AppIdAuth, nil
+	case "approle":
+		return config.AppRoleAuth, nil
+	case "kubernetes":
+		return config.KubernetesAuth, nil
+	default:
+		return nil, fmt.Errorf("Unknown unseal method '%s' in unseal chain.", method)
+	}
+}
+
+// Builds the unseal chain from a comma separated list of methods, in the order
+// they should be tried.
+func newUnsealerChain(methods string) (*unsealerChain, error) {
+	chain := &unsealerChain{}
+	for _, method := range strings.Split(methods, ",") {
+		method = strings.TrimSpace(method)
+		if method == "" {
+			continue
+		}
+		unsealer, err := configuredUnsealer(method)
+		if err != nil {
+			return nil, err
+		}
+		chain.unsealers = append(chain.unsealers, unsealer)
+	}
+	if len(chain.unsealers) == 0 {
+		return nil, errors.New("The unseal chain is empty.")
+	}
+	return chain, nil
+}
+
+// nil unless UNSEAL_CHAIN is set.
+var startupUnsealChain *unsealerChain
+
+// After gatekeeper has been sealed because its token expired, unseal it again
+// with the unseal chain, retrying until it succeeds.
+func unsealAgain() {
+	if startupUnsealChain == nil {
+		return
+	}
+	for {
+		err := unseal(startupUnsealChain)
+		if err == nil || err == errAlreadyUnsealed {
+			return
+		}
+		log.Printf("Failed to unseal again with the unseal chain, retrying in 30s. Error: %v", err)
+		time.Sleep(30 * time.Second)
+	}
+}
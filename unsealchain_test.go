@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+type testUnsealer struct {
+	name  string
+	token string
+	err   error
+}
+
+func (t testUnsealer) Token() (string, error) {
+	return t.token, t.err
+}
+
+func (t testUnsealer) Name() string {
+	return t.name
+}
+
+func TestUnsealerChain(t *testing.T) {
+	chain := &unsealerChain{unsealers: []Unsealer{
+		testUnsealer{name: "kubernetes", err: errors.New("no service account")},
+		testUnsealer{name: "approle", token: "approle-token"},
+		testUnsealer{name: "wrapped-token", token: "wrapped-token"},
+	}}
+	if token, err := chain.Token(); err != nil || token != "approle-token" {
+		t.Fatalf("Expected the approle token, got '%s' (%v).", token, err)
+	}
+	if chain.Name() != "approle" {
+		t.Fatalf("Expected the chain to report approle, got '%s'.", chain.Name())
+	}
+
+	chain = &unsealerChain{unsealers: []Unsealer{
+		testUnsealer{name: "kubernetes", err: errors.New("no service account")},
+	}}
+	if _, err := chain.Token(); err != errUnsealChainFailed {
+		t.Fatalf("Expected the chain to fail, got %v.", err)
+	}
+}
+
+func TestNewUnsealerChain(t *testing.T) {
+	if chain, err := newUnsealerChain("kubernetes, approle,wrapped-token"); err != nil || len(chain.unsealers) != 3 {
+		t.Fatalf("Expected a chain of 3 unsealers, got %v.", err)
+	}
+	if _, err := newUnsealerChain("kubernetes,ldap2"); err == nil {
+		t.Fatal("Expected an unknown method to be rejected.")
+	}
+	if _, err := newUnsealerChain(" , "); err == nil {
+		t.Fatal("Expected an empty chain to be rejected.")
+	}
+}
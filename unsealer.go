@@ -1,18 +1,13 @@
 package main
 
 import (
-	"crypto/md5"
-	"crypto/sha1"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/franela/goreq"
-	"hash"
 	"io/ioutil"
-	"net"
 	"path"
 	"strings"
+	"time"
 )
 
 type vaultError struct {
@@ -30,11 +25,16 @@ type vaultTokenResp struct {
 		LeaseDuration int    `json:"lease_duration"`
 		TTL           int    `json:"ttl"`
 	} `json:"auth"`
-	WrapInfo struct {
-		Token           string `json:"token"`
-		TTL             int    `json:"ttl"`
-		WrappedAccessor string `json:"wrapped_accessor"`
-	} `json:"wrap_info"`
+	WrapInfo vaultWrapInfo `json:"wrap_info"`
+}
+
+// The wrap info of a response wrapped vault response.
+type vaultWrapInfo struct {
+	Token           string    `json:"token"`
+	Accessor        string    `json:"accessor"`
+	TTL             int       `json:"ttl"`
+	CreationTime    time.Time `json:"creation_time"`
+	WrappedAccessor string    `json:"wrapped_accessor"`
 }
 
 type Unsealer interface {
@@ -42,16 +42,136 @@ type Unsealer interface {
 	Name() string
 }
 
+// unsealRequest describes an unsealer, as submitted to the /unseal API or
+// configured for a vault backend.
+type unsealRequest struct {
+	Type string `json:"type"`
+
+	AppId           string `json:"app_id"`
+	UserIdMethod    string `json:"user_id_method"`
+	UserIdInterface string `json:"user_id_interface"`
+	UserIdPath      string `json:"user_id_path"`
+	UserIdHash      string `json:"user_id_hash"`
+	UserIdSalt      string `json:"user_id_salt"`
+	UserIdCloud     string `json:"user_id_cloud"`
+	UserIdHmacKey   string `json:"user_id_hmac_key"`
+
+	Token string `json:"token"`
+
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	MountPath string `json:"mount_path"`
+
+	CubbyPath string `json:"cubby_path"`
+
+	RoleId   string `json:"role_id"`
+	SecretId string `json:"secret_id"`
+
+	Role    string `json:"role"`
+	JwtPath string `json:"jwt_path"`
+}
+
+// Builds the unsealer described by the request that will log in to the given
+// vault backend (or the default vault server if nil).
+func (request unsealRequest) unsealer(backend *vaultBackend) (Unsealer, error) {
+	switch request.Type {
+	case "app-id":
+		return AppIdUnsealer{
+			AppId:           request.AppId,
+			UserIdMethod:    request.UserIdMethod,
+			UserIdInterface: request.UserIdInterface,
+			UserIdPath:      request.UserIdPath,
+			UserIdHash:      request.UserIdHash,
+			UserIdSalt:      request.UserIdSalt,
+			UserIdCloud:     request.UserIdCloud,
+			UserIdHmacKey:   request.UserIdHmacKey,
+			MountPath:       request.MountPath,
+			Backend:         backend,
+		}, nil
+	case "userpass":
+		return UserpassUnsealer{
+			Username:  request.Username,
+			Password:  request.Password,
+			MountPath: request.MountPath,
+			Backend:   backend,
+		}, nil
+	case "ldap":
+		return LdapUnsealer{
+			Username:  request.Username,
+			Password:  request.Password,
+			MountPath: request.MountPath,
+			Backend:   backend,
+		}, nil
+	case "okta":
+		return OktaUnsealer{
+			Username:  request.Username,
+			Password:  request.Password,
+			MountPath: request.MountPath,
+			Backend:   backend,
+		}, nil
+	case "github":
+		return GithubUnsealer{
+			PersonalToken: request.Token,
+			MountPath:     request.MountPath,
+			Backend:       backend,
+		}, nil
+	case "token":
+		return TokenUnsealer{
+			AuthToken: request.Token,
+			Backend:   backend,
+		}, nil
+	case "cubby":
+		return CubbyUnsealer{
+			TempToken: request.Token,
+			Path:      request.CubbyPath,
+			Backend:   backend,
+		}, nil
+	case "wrapped-token":
+		return WrappedTokenUnsealer{
+			TempToken: request.Token,
+			Backend:   backend,
+		}, nil
+	case "approle":
+		return AppRoleUnsealer{
+			RoleId:    request.RoleId,
+			SecretId:  request.SecretId,
+			MountPath: request.MountPath,
+			Backend:   backend,
+		}, nil
+	case "kubernetes":
+		return KubernetesUnsealer{
+			Role:      request.Role,
+			JwtPath:   request.JwtPath,
+			MountPath: request.MountPath,
+			Backend:   backend,
+		}, nil
+	default:
+		return nil, errUnknownAuthMethod
+	}
+}
+
+// The login path of an auth backend mounted at mountPath, or at its default
+// mount if mountPath is empty. Both "github-corp" and "auth/github-corp" are
+// accepted.
+func authLoginPath(mountPath string, defaultMount string, elem ...string) string {
+	mountPath = strings.TrimPrefix(strings.Trim(mountPath, "/"), "auth/")
+	if mountPath == "" {
+		mountPath = defaultMount
+	}
+	return path.Join(append([]string{"/v1/auth", mountPath, "login"}, elem...)...)
+}
+
 type TokenUnsealer struct {
 	AuthToken string
+	Backend   *vaultBackend
 }
 
 func (t TokenUnsealer) Token() (string, error) {
-	r, err := VaultRequest{goreq.Request{
-		Uri:             vaultPath("/v1/auth/token/lookup-self", ""),
+	r, err := VaultRequest{Request: goreq.Request{
+		Uri:             t.Backend.path("/v1/auth/token/lookup-self", ""),
 		MaxRedirects:    10,
 		RedirectHeaders: true,
-	}.WithHeader("X-Vault-Token", t.AuthToken)}.Do()
+	}.WithHeader("X-Vault-Token", t.AuthToken), Namespace: t.Backend.namespace()}.Do()
 	if err == nil {
 		defer r.Body.Close()
 		switch r.StatusCode {
@@ -78,8 +198,8 @@ func (t TokenUnsealer) Name() string {
 
 type genericUnsealer struct{}
 
-func (g genericUnsealer) Token(req goreq.Request) (string, error) {
-	r, err := VaultRequest{req}.Do()
+func (g genericUnsealer) Token(backend *vaultBackend, req goreq.Request) (string, error) {
+	r, err := VaultRequest{Request: req, Namespace: backend.namespace()}.Do()
 	if err == nil {
 		defer r.Body.Close()
 		switch r.StatusCode {
@@ -112,6 +232,10 @@ type AppIdUnsealer struct {
 	UserIdPath      string
 	UserIdHash      string
 	UserIdSalt      string
+	UserIdCloud     string
+	UserIdHmacKey   string
+	MountPath       string
+	Backend         *vaultBackend
 	genericUnsealer
 }
 
@@ -122,48 +246,15 @@ func (a AppIdUnsealer) Token() (string, error) {
 	body := struct {
 		UserId string `json:"user_id"`
 	}{}
-	switch a.UserIdMethod {
-	case "mac":
-		if iface, err := net.InterfaceByName(a.UserIdInterface); err == nil {
-			body.UserId = iface.HardwareAddr.String()
-		} else {
-			return "", err
-		}
-	case "file":
-		if b, err := ioutil.ReadFile(a.UserIdPath); err == nil {
-			body.UserId = string(b)
-		} else {
-			return "", err
-		}
-	default:
-		return "", errUnknownUserIdMethod
-	}
-	var hasher hash.Hash
-	switch a.UserIdHash {
-	case "md5":
-		hasher = md5.New()
-	case "sha1":
-		hasher = sha1.New()
-	case "sha256":
-		hasher = sha256.New()
-	case "":
-
-	default:
-		return "", errUnknownHashMethod
+	userId, err := a.userId()
+	if err != nil {
+		return "", err
 	}
-	if hasher != nil {
-		h := body.UserId
-		if a.UserIdSalt != "" {
-			h = a.UserIdSalt + "$" + h
-		}
-		if _, err := hasher.Write([]byte(h)); err == nil {
-			body.UserId = hex.EncodeToString(hasher.Sum(nil))
-		} else {
-			return "", err
-		}
+	if body.UserId, err = a.hashUserId(userId); err != nil {
+		return "", err
 	}
-	return a.genericUnsealer.Token(goreq.Request{
-		Uri:             vaultPath("/v1/auth/app-id/login/"+a.AppId, ""),
+	return a.genericUnsealer.Token(a.Backend, goreq.Request{
+		Uri:             a.Backend.path(authLoginPath(a.MountPath, "app-id", a.AppId), ""),
 		Method:          "POST",
 		Body:            body,
 		MaxRedirects:    10,
@@ -177,12 +268,14 @@ func (a AppIdUnsealer) Name() string {
 
 type GithubUnsealer struct {
 	PersonalToken string
+	MountPath     string
+	Backend       *vaultBackend
 	genericUnsealer
 }
 
 func (gh GithubUnsealer) Token() (string, error) {
-	return gh.genericUnsealer.Token(goreq.Request{
-		Uri:    vaultPath("/v1/auth/github/login", ""),
+	return gh.genericUnsealer.Token(gh.Backend, goreq.Request{
+		Uri:    gh.Backend.path(authLoginPath(gh.MountPath, "github"), ""),
 		Method: "POST",
 		Body: struct {
 			Token string `json:"token"`
@@ -197,14 +290,16 @@ func (gh GithubUnsealer) Name() string {
 }
 
 type UserpassUnsealer struct {
-	Username string
-	Password string
+	Username  string
+	Password  string
+	MountPath string
+	Backend   *vaultBackend
 	genericUnsealer
 }
 
 func (u UserpassUnsealer) Token() (string, error) {
-	return u.genericUnsealer.Token(goreq.Request{
-		Uri:    vaultPath("/v1/auth/userpass/login/"+u.Username, ""),
+	return u.genericUnsealer.Token(u.Backend, goreq.Request{
+		Uri:    u.Backend.path(authLoginPath(u.MountPath, "userpass", u.Username), ""),
 		Method: "POST",
 		Body: struct {
 			Password string `json:"password"`
@@ -218,9 +313,119 @@ func (u UserpassUnsealer) Name() string {
 	return "userpass"
 }
 
+type LdapUnsealer struct {
+	Username  string
+	Password  string
+	MountPath string
+	Backend   *vaultBackend
+	genericUnsealer
+}
+
+func (l LdapUnsealer) Token() (string, error) {
+	return l.genericUnsealer.Token(l.Backend, goreq.Request{
+		Uri:    l.Backend.path(authLoginPath(l.MountPath, "ldap", l.Username), ""),
+		Method: "POST",
+		Body: struct {
+			Password string `json:"password"`
+		}{l.Password},
+		MaxRedirects:    10,
+		RedirectHeaders: true,
+	})
+}
+
+func (l LdapUnsealer) Name() string {
+	return "ldap"
+}
+
+type OktaUnsealer struct {
+	Username  string
+	Password  string
+	MountPath string
+	Backend   *vaultBackend
+	genericUnsealer
+}
+
+func (o OktaUnsealer) Token() (string, error) {
+	return o.genericUnsealer.Token(o.Backend, goreq.Request{
+		Uri:    o.Backend.path(authLoginPath(o.MountPath, "okta", o.Username), ""),
+		Method: "POST",
+		Body: struct {
+			Password string `json:"password"`
+		}{o.Password},
+		MaxRedirects:    10,
+		RedirectHeaders: true,
+	})
+}
+
+func (o OktaUnsealer) Name() string {
+	return "okta"
+}
+
+type AppRoleUnsealer struct {
+	RoleId    string
+	SecretId  string
+	MountPath string
+	Backend   *vaultBackend
+	genericUnsealer
+}
+
+func (a AppRoleUnsealer) Token() (string, error) {
+	return a.genericUnsealer.Token(a.Backend, goreq.Request{
+		Uri:    a.Backend.path(authLoginPath(a.MountPath, "approle"), ""),
+		Method: "POST",
+		Body: struct {
+			RoleId   string `json:"role_id"`
+			SecretId string `json:"secret_id,omitempty"`
+		}{a.RoleId, a.SecretId},
+		MaxRedirects:    10,
+		RedirectHeaders: true,
+	})
+}
+
+func (a AppRoleUnsealer) Name() string {
+	return "approle"
+}
+
+const defaultKubernetesJwtPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// KubernetesUnsealer logs in with the service account token of the pod
+// gatekeeper runs in.
+type KubernetesUnsealer struct {
+	Role      string
+	JwtPath   string
+	MountPath string
+	Backend   *vaultBackend
+	genericUnsealer
+}
+
+func (k KubernetesUnsealer) Token() (string, error) {
+	if k.JwtPath == "" {
+		k.JwtPath = defaultKubernetesJwtPath
+	}
+	jwt, err := ioutil.ReadFile(k.JwtPath)
+	if err != nil {
+		return "", err
+	}
+	return k.genericUnsealer.Token(k.Backend, goreq.Request{
+		Uri:    k.Backend.path(authLoginPath(k.MountPath, "kubernetes"), ""),
+		Method: "POST",
+		Body: struct {
+			Role string `json:"role"`
+			Jwt  string `json:"jwt"`
+		}{k.Role, strings.TrimSpace(string(jwt))},
+		MaxRedirects:    10,
+		RedirectHeaders: true,
+	})
+}
+
+func (k KubernetesUnsealer) Name() string {
+	return "kubernetes"
+}
+
 type CubbyUnsealer struct {
 	TempToken string
 	Path      string
+	Backend   *vaultBackend
 }
 
 var errInvalidTokenCubby = errors.New("Invalid token in cubby.")
@@ -229,11 +434,11 @@ func (t CubbyUnsealer) Token() (string, error) {
 	if t.Path == "" {
 		t.Path = "/vault-token"
 	}
-	r, err := VaultRequest{goreq.Request{
-		Uri:             vaultPath(path.Join("/v1/cubbyhole", t.Path), ""),
+	r, err := VaultRequest{Request: goreq.Request{
+		Uri:             t.Backend.path(path.Join("/v1/cubbyhole", t.Path), ""),
 		MaxRedirects:    10,
 		RedirectHeaders: true,
-	}.WithHeader("X-Vault-Token", t.TempToken)}.Do()
+	}.WithHeader("X-Vault-Token", t.TempToken), Namespace: t.Backend.namespace()}.Do()
 	if err == nil {
 		defer r.Body.Close()
 		switch r.StatusCode {
@@ -247,7 +452,7 @@ func (t CubbyUnsealer) Token() (string, error) {
 				if vaultResp.Data.Token == "" {
 					return "", errInvalidTokenCubby
 				} else {
-					return TokenUnsealer{vaultResp.Data.Token}.Token()
+					return TokenUnsealer{AuthToken: vaultResp.Data.Token, Backend: t.Backend}.Token()
 				}
 			} else {
 				return "", err
@@ -271,19 +476,37 @@ func (t CubbyUnsealer) Name() string {
 	return "cubby"
 }
 
+// WrappedTokenUnsealer unwraps a single use wrapping token, given directly or
+// read from a file, to obtain gatekeeper's token.
 type WrappedTokenUnsealer struct {
-	TempToken string
+	TempToken     string
+	TempTokenPath string
+	Backend       *vaultBackend
 }
 
 var errInvalidWrappedToken = errors.New("Invalid wrapped token.")
 
 func (t WrappedTokenUnsealer) Token() (string, error) {
+	tempToken := t.TempToken
+	if tempToken == "" && t.TempTokenPath != "" {
+		b, err := ioutil.ReadFile(t.TempTokenPath)
+		if err != nil {
+			return "", err
+		}
+		tempToken = strings.TrimSpace(string(b))
+	}
+	if tempToken == "" {
+		return "", errInvalidWrappedToken
+	}
+
 	resp, err := VaultRequest{
-		goreq.Request{
-			Uri:             vaultPath("/v1/cubbyhole/response", ""),
+		Request: goreq.Request{
+			Uri:             t.Backend.path("/v1/sys/wrapping/unwrap", ""),
+			Method:          "POST",
 			MaxRedirects:    10,
 			RedirectHeaders: true,
-		}.WithHeader("X-Vault-Token", t.TempToken),
+		}.WithHeader("X-Vault-Token", tempToken),
+		Namespace: t.Backend.namespace(),
 	}.Do()
 	if err != nil {
 		return "", err
@@ -303,19 +526,13 @@ func (t WrappedTokenUnsealer) Token() (string, error) {
 		return "", e
 	}
 
-	var vaultWrappedResp VaultWrappedResponse
-
-	if err := resp.Body.FromJsonTo(&vaultWrappedResp); err != nil {
-		return "", err
-	}
-
 	secretResp := struct {
 		Auth struct {
 			ClientToken string `json:"client_token"`
 		} `json:"auth"`
 	}{}
 
-	if err := vaultWrappedResp.Unwrap(&secretResp); err != nil {
+	if err := resp.Body.FromJsonTo(&secretResp); err != nil {
 		return "", err
 	}
 
@@ -323,7 +540,7 @@ func (t WrappedTokenUnsealer) Token() (string, error) {
 		return "", errInvalidWrappedToken
 	}
 
-	return TokenUnsealer{secretResp.Auth.ClientToken}.Token()
+	return TokenUnsealer{AuthToken: secretResp.Auth.ClientToken, Backend: t.Backend}.Token()
 }
 
 func (t WrappedTokenUnsealer) Name() string {
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAuthLoginPath(t *testing.T) {
+	for _, test := range []struct {
+		mountPath string
+		expected  string
+	}{
+		{"", "/v1/auth/github/login"},
+		{"github-corp", "/v1/auth/github-corp/login"},
+		{"auth/github-corp", "/v1/auth/github-corp/login"},
+		{"/auth/github-corp/", "/v1/auth/github-corp/login"},
+		{"teams/github", "/v1/auth/teams/github/login"},
+	} {
+		if loginPath := authLoginPath(test.mountPath, "github"); loginPath != test.expected {
+			t.Errorf("Expected mount path '%s' to log in at '%s', got '%s'.", test.mountPath, test.expected, loginPath)
+		}
+	}
+	if loginPath := authLoginPath("ldap-corp", "ldap", "jdoe"); loginPath != "/v1/auth/ldap-corp/login/jdoe" {
+		t.Errorf("Expected the username to be appended, got '%s'.", loginPath)
+	}
+}
+
+func TestAppIdUserId(t *testing.T) {
+	ec2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/latest/api/token":
+			w.Write([]byte("session"))
+		case r.URL.Path == "/latest/meta-data/instance-id" && r.Header.Get("X-aws-ec2-metadata-token") == "session":
+			w.Write([]byte("i-0abc123\n"))
+		default:
+			w.WriteHeader(401)
+		}
+	}))
+	defer ec2.Close()
+	gce := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/computeMetadata/v1/instance/id" || r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(403)
+			return
+		}
+		w.Write([]byte("4520031799277581759"))
+	}))
+	defer gce.Close()
+	defer func(ec2Url, gceUrl, dmiPath string) {
+		ec2MetadataUrl, gceMetadataUrl, dmiProductUuidPath = ec2Url, gceUrl, dmiPath
+	}(ec2MetadataUrl, gceMetadataUrl, dmiProductUuidPath)
+	ec2MetadataUrl, gceMetadataUrl = ec2.URL+"/latest", gce.URL+"/computeMetadata/v1"
+
+	dir, err := ioutil.TempDir("", "gatekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dmiProductUuidPath = filepath.Join(dir, "product_uuid")
+	ioutil.WriteFile(dmiProductUuidPath, []byte("EC2A1B2C-3D4E-5F60-7182-93A4B5C6D7E8\n"), 0400)
+	hostname, _ := os.Hostname()
+
+	for _, test := range []struct {
+		unsealer AppIdUnsealer
+		expected string
+	}{
+		{AppIdUnsealer{UserIdMethod: "instance-id", UserIdCloud: "ec2"}, "i-0abc123"},
+		{AppIdUnsealer{UserIdMethod: "instance-id", UserIdCloud: "gce"}, "4520031799277581759"},
+		{AppIdUnsealer{UserIdMethod: "dmi"}, "ec2a1b2c-3d4e-5f60-7182-93a4b5c6d7e8"},
+		{AppIdUnsealer{UserIdMethod: "hostname"}, hostname},
+	} {
+		if userId, err := test.unsealer.userId(); err != nil || userId != test.expected {
+			t.Errorf("Expected user id '%s' from method '%s', got '%s', %v.", test.expected, test.unsealer.UserIdMethod, userId, err)
+		}
+	}
+
+	for _, invalid := range []AppIdUnsealer{
+		{UserIdMethod: "instance-id", UserIdCloud: "azure"},
+		{UserIdMethod: "serial"},
+	} {
+		if _, err := invalid.userId(); err == nil {
+			t.Errorf("Expected %+v to be rejected.", invalid)
+		}
+	}
+}
+
+func TestAppIdHashUserId(t *testing.T) {
+	sha512Sum := sha512.Sum512([]byte("salt$i-0abc123"))
+	if userId, err := (AppIdUnsealer{UserIdHash: "sha512", UserIdSalt: "salt"}).hashUserId("i-0abc123"); err != nil || userId != hex.EncodeToString(sha512Sum[:]) {
+		t.Errorf("Expected the salted sha512 of the user id, got '%s', %v.", userId, err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("i-0abc123"))
+	if userId, err := (AppIdUnsealer{UserIdHash: "hmac-sha256", UserIdHmacKey: "key"}).hashUserId("i-0abc123"); err != nil || userId != hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("Expected the hmac of the user id, got '%s', %v.", userId, err)
+	}
+	if _, err := (AppIdUnsealer{UserIdHash: "hmac-sha512"}).hashUserId("i-0abc123"); err != errNoUserIdHmacKey {
+		t.Errorf("Expected the hmac without a key to be rejected, got %v.", err)
+	}
+	if userId, err := (AppIdUnsealer{}).hashUserId("i-0abc123"); err != nil || userId != "i-0abc123" {
+		t.Errorf("Expected the user id to be left alone without a hash, got '%s', %v.", userId, err)
+	}
+	if _, err := (AppIdUnsealer{UserIdHash: "crc32"}).hashUserId("i-0abc123"); err != errUnknownHashMethod {
+		t.Errorf("Expected an unknown hash to be rejected, got %v.", err)
+	}
+}
+
+// vaultLogin is a request a fake vault received.
+type vaultLogin struct {
+	method    string
+	path      string
+	token     string
+	namespace string
+	body      map[string]string
+}
+
+// fakeUnsealVault unwraps the wrapping token "wrapping-token" once, logs in
+// the user "jdoe" with the password "secret", and accepts the token
+// "gatekeeper-token", recording the requests it receives.
+type fakeUnsealVault struct {
+	sync.Mutex
+	unwrapped bool
+	requests  []vaultLogin
+}
+
+func (f *fakeUnsealVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	request := vaultLogin{method: r.Method, path: r.URL.Path, token: r.Header.Get("X-Vault-Token"), namespace: r.Header.Get("X-Vault-Namespace")}
+	json.NewDecoder(r.Body).Decode(&request.body)
+	f.requests = append(f.requests, request)
+	switch {
+	case r.URL.Path == "/v1/sys/wrapping/unwrap":
+		if request.token != "wrapping-token" || f.unwrapped {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"errors":["wrapping token is not valid or does not exist"]}`))
+			return
+		}
+		f.unwrapped = true
+		w.Write([]byte(`{"auth":{"client_token":"gatekeeper-token","lease_duration":3600}}`))
+	case r.URL.Path == "/v1/auth/token/lookup-self":
+		if request.token != "gatekeeper-token" {
+			w.WriteHeader(403)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"ttl":3600}}`))
+	case strings.HasSuffix(r.URL.Path, "/login/jdoe"):
+		if request.body["password"] != "secret" {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"errors":["invalid username or password"]}`))
+			return
+		}
+		w.Write([]byte(`{"auth":{"client_token":"gatekeeper-token","lease_duration":3600}}`))
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func (f *fakeUnsealVault) received() []vaultLogin {
+	f.Lock()
+	defer f.Unlock()
+	requests := f.requests
+	f.requests = nil
+	return requests
+}
+
+func isVaultError(err error, code int) bool {
+	e, ok := err.(vaultError)
+	return ok && e.Code == code
+}
+
+func TestWrappedTokenUnsealer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gatekeeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "wrapped-token")
+	ioutil.WriteFile(tokenFile, []byte("wrapping-token\n"), 0400)
+
+	for _, unsealer := range []WrappedTokenUnsealer{
+		{TempToken: "wrapping-token"},
+		{TempTokenPath: tokenFile},
+	} {
+		vault := &fakeUnsealVault{}
+		ts := httptest.NewServer(vault)
+		unsealer.Backend = &vaultBackend{Name: "teams", Address: ts.URL, Namespace: "teams"}
+
+		if token, err := unsealer.Token(); err != nil || token != "gatekeeper-token" {
+			t.Errorf("Expected the wrapped token to be unwrapped, got '%s', %v.", token, err)
+		}
+		requests := vault.received()
+		if len(requests) != 2 {
+			t.Fatalf("Expected the token to be unwrapped and looked up, got %+v.", requests)
+		}
+		if unwrap := requests[0]; unwrap.method != "POST" || unwrap.path != "/v1/sys/wrapping/unwrap" || unwrap.token != "wrapping-token" || unwrap.namespace != "teams" {
+			t.Errorf("Expected the wrapping token to be unwrapped with a POST to sys/wrapping/unwrap in namespace 'teams', got %+v.", unwrap)
+		}
+		if lookup := requests[1]; lookup.path != "/v1/auth/token/lookup-self" || lookup.token != "gatekeeper-token" {
+			t.Errorf("Expected the unwrapped token to be looked up, got %+v.", lookup)
+		}
+
+		// the wrapping token can only be used once
+		if _, err := unsealer.Token(); !isVaultError(err, 400) {
+			t.Errorf("Expected the wrapping token to be rejected the second time, got %v.", err)
+		}
+		ts.Close()
+	}
+
+	if _, err := (WrappedTokenUnsealer{}).Token(); err != errInvalidWrappedToken {
+		t.Errorf("Expected an unsealer without a token to be rejected, got %v.", err)
+	}
+	if _, err := (WrappedTokenUnsealer{TempTokenPath: filepath.Join(dir, "missing")}).Token(); err == nil {
+		t.Error("Expected a missing token file to fail.")
+	}
+}
+
+func TestLoginUnsealers(t *testing.T) {
+	vault := &fakeUnsealVault{}
+	ts := httptest.NewServer(vault)
+	defer ts.Close()
+	backend := &vaultBackend{Name: "corp", Address: ts.URL}
+
+	for _, test := range []struct {
+		request unsealRequest
+		path    string
+	}{
+		{unsealRequest{Type: "ldap", Username: "jdoe", Password: "secret"}, "/v1/auth/ldap/login/jdoe"},
+		{unsealRequest{Type: "ldap", Username: "jdoe", Password: "secret", MountPath: "auth/ldap-corp"}, "/v1/auth/ldap-corp/login/jdoe"},
+		{unsealRequest{Type: "okta", Username: "jdoe", Password: "secret"}, "/v1/auth/okta/login/jdoe"},
+		{unsealRequest{Type: "okta", Username: "jdoe", Password: "secret", MountPath: "okta-corp"}, "/v1/auth/okta-corp/login/jdoe"},
+	} {
+		unsealer, err := test.request.unsealer(backend)
+		if err != nil || unsealer.Name() != test.request.Type {
+			t.Fatalf("Expected a %s unsealer, got %v, %v.", test.request.Type, unsealer, err)
+		}
+		if token, err := unsealer.Token(); err != nil || token != "gatekeeper-token" {
+			t.Errorf("Expected the %s unsealer to log in, got '%s', %v.", test.request.Type, token, err)
+		}
+		requests := vault.received()
+		if len(requests) != 1 || requests[0].method != "POST" || requests[0].path != test.path || requests[0].body["password"] != "secret" || len(requests[0].body) != 1 {
+			t.Errorf("Expected the %s unsealer to POST the password to %s, got %+v.", test.request.Type, test.path, requests)
+		}
+
+		// a wrong password
+		test.request.Password = "wrong"
+		unsealer, _ = test.request.unsealer(backend)
+		if _, err := unsealer.Token(); !isVaultError(err, 400) {
+			t.Errorf("Expected the %s unsealer to fail with a wrong password, got %v.", test.request.Type, err)
+		}
+		vault.received()
+	}
+}
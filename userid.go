@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var errUnknownUserIdCloud = errors.New("Unknown cloud specified for the instance id. Valid clouds are 'ec2' and 'gce'.")
+var errNoUserIdHmacKey = errors.New("The hmac user id hashes need USER_ID_HMAC_KEY.")
+
+// The instance metadata services of the clouds, which the instance id is read
+// from. Both are only reachable from the instance itself.
+var (
+	ec2MetadataUrl = "http://169.254.169.254/latest"
+	gceMetadataUrl = "http://metadata.google.internal/computeMetadata/v1"
+)
+
+// The product uuid the firmware reports through DMI, which outlives
+// reinstalls of the machine.
+var dmiProductUuidPath = "/sys/class/dmi/id/product_uuid"
+
+// Metadata services answer right away, or not at all on other clouds.
+var metadataClient = &http.Client{Timeout: 5 * time.Second}
+
+// userId derives the user id of the machine with USER_ID_METHOD.
+func (a AppIdUnsealer) userId() (string, error) {
+	switch a.UserIdMethod {
+	case "mac":
+		iface, err := net.InterfaceByName(a.UserIdInterface)
+		if err != nil {
+			return "", err
+		}
+		return iface.HardwareAddr.String(), nil
+	case "file":
+		b, err := ioutil.ReadFile(a.UserIdPath)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	case "instance-id":
+		return cloudInstanceId(a.UserIdCloud)
+	case "dmi":
+		b, err := ioutil.ReadFile(dmiProductUuidPath)
+		if err != nil {
+			return "", err
+		}
+		// the uuid is upper case on some firmware, and lower case on others
+		return strings.ToLower(strings.TrimSpace(string(b))), nil
+	case "hostname":
+		return os.Hostname()
+	default:
+		return "", errUnknownUserIdMethod
+	}
+}
+
+// hashUserId hashes the user id with USER_ID_HASH, salted with USER_ID_SALT.
+// The hex representation of the hash is used.
+func (a AppIdUnsealer) hashUserId(userId string) (string, error) {
+	var hasher hash.Hash
+	switch a.UserIdHash {
+	case "md5":
+		hasher = md5.New()
+	case "sha1":
+		hasher = sha1.New()
+	case "sha256":
+		hasher = sha256.New()
+	case "sha512":
+		hasher = sha512.New()
+	case "hmac-sha256", "hmac-sha512":
+		if a.UserIdHmacKey == "" {
+			return "", errNoUserIdHmacKey
+		}
+		if a.UserIdHash == "hmac-sha256" {
+			hasher = hmac.New(sha256.New, []byte(a.UserIdHmacKey))
+		} else {
+			hasher = hmac.New(sha512.New, []byte(a.UserIdHmacKey))
+		}
+	case "":
+		return userId, nil
+	default:
+		return "", errUnknownHashMethod
+	}
+	if a.UserIdSalt != "" {
+		userId = a.UserIdSalt + "$" + userId
+	}
+	if _, err := hasher.Write([]byte(userId)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// cloudInstanceId reads the id of the instance from the metadata service of
+// the cloud.
+func cloudInstanceId(cloud string) (string, error) {
+	switch cloud {
+	case "ec2":
+		// IMDSv2 needs a session token, instances that only offer IMDSv1 don't
+		// hand one out
+		headers := map[string]string{}
+		if token, err := readMetadata("PUT", ec2MetadataUrl+"/api/token", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"}); err == nil {
+			headers["X-aws-ec2-metadata-token"] = token
+		}
+		return readMetadata("GET", ec2MetadataUrl+"/meta-data/instance-id", headers)
+	case "gce":
+		return readMetadata("GET", gceMetadataUrl+"/instance/id", map[string]string{"Metadata-Flavor": "Google"})
+	default:
+		return "", errUnknownUserIdCloud
+	}
+}
+
+func readMetadata(method string, url string, headers map[string]string) (string, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("The metadata service responded to %s with status code %d.", url, resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	id := strings.TrimSpace(string(b))
+	if id == "" {
+		return "", fmt.Errorf("The metadata service returned nothing for %s.", url)
+	}
+	return id, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/franela/goreq"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var errNoVaultAddress = errors.New("No vault address configured.")
+
+// The addresses of the default vault server, which can be the nodes of a vault
+// HA cluster. Requests go to the current address, and move on to the next one
+// when the current node can't be reached, is sealed or is a standby that
+// doesn't redirect.
+type vaultAddressList struct {
+	sync.RWMutex
+	entries   []string
+	addresses []string
+	current   int
+}
+
+var vaultAddresses = &vaultAddressList{}
+
+// Sets the addresses from a comma separated list of vault addresses. An entry
+// of the form srv+https://_vault._tcp.example.com is looked up as a DNS SRV
+// record, and expands to the address of every target of the record.
+func (l *vaultAddressList) Set(list string) error {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	addresses, err := resolveVaultAddresses(entries)
+	if err != nil {
+		return err
+	}
+	l.Lock()
+	l.entries = entries
+	l.addresses = addresses
+	l.current = 0
+	l.Unlock()
+	return nil
+}
+
+func resolveVaultAddresses(entries []string) ([]string, error) {
+	var addresses []string
+	for _, entry := range entries {
+		u, err := url.Parse(entry)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid vault address '%s': %v", entry, err)
+		}
+		if !strings.HasPrefix(u.Scheme, "srv+") {
+			addresses = append(addresses, entry)
+			continue
+		}
+		_, srvs, err := net.LookupSRV("", "", u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to look up vault SRV record '%s': %v", u.Host, err)
+		}
+		for _, srv := range srvs {
+			addresses = append(addresses, (&url.URL{
+				Scheme: strings.TrimPrefix(u.Scheme, "srv+"),
+				Host:   net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), fmt.Sprint(srv.Port)),
+			}).String())
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, errNoVaultAddress
+	}
+	return addresses, nil
+}
+
+// The address requests to the default vault server go to.
+func (l *vaultAddressList) Current() string {
+	l.RLock()
+	defer l.RUnlock()
+	if len(l.addresses) == 0 {
+		return ""
+	}
+	return l.addresses[l.current]
+}
+
+func (l *vaultAddressList) Len() int {
+	l.RLock()
+	defer l.RUnlock()
+	return len(l.addresses)
+}
+
+// Moves on from failed, the address of a failed request, to the next address
+// and returns it. Once every address has been tried the SRV records are looked
+// up again, in case the cluster has changed.
+func (l *vaultAddressList) Failover(failed string) string {
+	l.Lock()
+	defer l.Unlock()
+	if len(l.addresses) == 0 {
+		return failed
+	}
+	// another request has already moved on
+	if l.addresses[l.current] != failed {
+		return l.addresses[l.current]
+	}
+	l.current++
+	if l.current == len(l.addresses) {
+		l.current = 0
+		if addresses, err := resolveVaultAddresses(l.entries); err == nil {
+			l.addresses = addresses
+		} else {
+			log.Printf("Failed to resolve vault addresses again, continuing with the previous addresses. Error: %v", err)
+		}
+	}
+	log.Printf("Vault at %s is unavailable, failing over to %s.", failed, l.addresses[l.current])
+	return l.addresses[l.current]
+}
+
+// Returns the address of the default vault server uri refers to, if any.
+func (l *vaultAddressList) match(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", false
+	}
+	l.RLock()
+	defer l.RUnlock()
+	for _, address := range l.addresses {
+		if a, err := url.Parse(address); err == nil && a.Scheme == u.Scheme && a.Host == u.Host {
+			return address, true
+		}
+	}
+	return "", false
+}
+
+// A node that can't be reached, is sealed or a standby without an active node
+// (503) or is a performance standby that won't serve the request (473) can't be
+// used, another node of the cluster might. Vault gives those responses before
+// handling the request. Writes that failed after they were sent may have been
+// acted on, so only reads are sent to another node then.
+func vaultNodeUnavailable(method string, resp *goreq.Response, err error) bool {
+	if err != nil {
+		return idempotentVaultMethod(method) || vaultRequestUnsent(err)
+	}
+	return resp.StatusCode == 503 || resp.StatusCode == 473
+}
+
+// Moves the request on to the given node of the default vault server.
+func rebaseVaultUri(uri string, address string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	b, err := url.Parse(address)
+	if err != nil {
+		return uri
+	}
+	u.Scheme = b.Scheme
+	u.Host = b.Host
+	return u.String()
+}
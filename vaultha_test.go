@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"github.com/franela/goreq"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestVaultAddressFailover(t *testing.T) {
+	l := &vaultAddressList{}
+	if err := l.Set("https://vault1:8200, https://vault2:8200,"); err != nil {
+		t.Fatal(err)
+	}
+	if l.Len() != 2 || l.Current() != "https://vault1:8200" {
+		t.Fatalf("Unexpected addresses %v.", l.addresses)
+	}
+
+	address, ok := l.match("https://vault1:8200/v1/auth/token/create")
+	if !ok || address != "https://vault1:8200" {
+		t.Fatalf("Expected request to match the first address, got '%s'.", address)
+	}
+	if _, ok := l.match("https://other:8200/v1/auth/token/create"); ok {
+		t.Fatal("Expected request to another vault server not to match.")
+	}
+
+	if next := l.Failover("https://vault1:8200"); next != "https://vault2:8200" {
+		t.Fatalf("Expected failover to the second address, got '%s'.", next)
+	}
+	// a request that failed on the old address doesn't move on again
+	if next := l.Failover("https://vault1:8200"); next != "https://vault2:8200" {
+		t.Fatalf("Expected to stay on the second address, got '%s'.", next)
+	}
+	if next := l.Failover("https://vault2:8200"); next != "https://vault1:8200" {
+		t.Fatalf("Expected failover to wrap around to the first address, got '%s'.", next)
+	}
+
+	if uri := rebaseVaultUri("https://vault1:8200/v1/auth/token/create?x=1", "http://vault2:8300"); uri != "http://vault2:8300/v1/auth/token/create?x=1" {
+		t.Fatalf("Unexpected rebased uri '%s'.", uri)
+	}
+}
+
+func TestVaultAddressEmpty(t *testing.T) {
+	if err := (&vaultAddressList{}).Set(" , "); err != errNoVaultAddress {
+		t.Fatalf("Expected %v, got %v.", errNoVaultAddress, err)
+	}
+}
+
+func TestVaultNodeUnavailable(t *testing.T) {
+	refused := &goreq.Error{Err: &url.Error{Op: "Post", URL: "http://vault1:8200", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}}
+	timeout := &goreq.Error{Err: &url.Error{Op: "Post", URL: "http://vault1:8200", Err: errors.New("timeout awaiting response headers")}}
+	status := func(code int) *goreq.Response {
+		return &goreq.Response{Response: &http.Response{StatusCode: code}}
+	}
+
+	for _, test := range []struct {
+		method      string
+		resp        *goreq.Response
+		err         error
+		unavailable bool
+	}{
+		{"POST", nil, refused, true},
+		{"POST", nil, timeout, false},
+		{"GET", nil, timeout, true},
+		{"POST", status(473), nil, true},
+		{"POST", status(503), nil, true},
+		{"POST", status(500), nil, false},
+		{"POST", status(200), nil, false},
+	} {
+		if unavailable := vaultNodeUnavailable(test.method, test.resp, test.err); unavailable != test.unavailable {
+			t.Errorf("Expected a %s answered with %v to fail over: %v, got %v.", test.method, vaultRequestError(test.resp, test.err), test.unavailable, unavailable)
+		}
+	}
+}